@@ -20,6 +20,9 @@ import (
 	// "net/http"
 
 	"time"
+
+	"bitAuction/events"
+	"bitAuction/money"
 )
 
 type SmartContract struct {
@@ -28,56 +31,166 @@ type SmartContract struct {
 
 // Auction data
 type Auction struct {
-	AuctionID   string    `json:"auctionID"`
-	Type        string    `json:"objectType"`
-	ItemSold    string    `json:"item"`
-	Seller      string    `json:"seller"`
-	Orgs        []string  `json:"organizations"`
-	Winner      string    `json:"winner"`
-	Price       int       `json:"price"`
-	Status      string    `json:"status"`
-	Timelimit   time.Time `json:"timelimit"`
-	Description string    `json:"description"`
-	PictureURL  string    `json:"pictureUrl"`
-	Bids        []FullBid `json:"bids"`
+	AuctionID   string      `json:"auctionID"`
+	Type        string      `json:"objectType"`
+	ItemSold    string      `json:"item"`
+	Seller      string      `json:"seller"`
+	Orgs        []string    `json:"organizations"`
+	Winner      string      `json:"winner"`
+	Price       money.Money `json:"price"`
+	Status      string      `json:"status"`
+	Timelimit   time.Time   `json:"timelimit"`
+	Description string      `json:"description"`
+	PictureURL  string      `json:"pictureUrl"`
+	Bids        []FullBid   `json:"bids"`
+	// RetractWindow is how many seconds after submission a bid may be retracted,
+	// RetractCutoff how many seconds before the time limit retractions stop
+	RetractWindow int           `json:"retractWindow"`
+	RetractCutoff int           `json:"retractCutoff"`
+	Cancellation  *Cancellation `json:"cancellation,omitempty"`
+	// soft close: bids within ExtensionWindow seconds of the time limit
+	// extend it to ExtensionTime seconds after the bid
+	ExtensionWindow int `json:"extensionWindow"`
+	ExtensionTime   int `json:"extensionTime"`
+	// Restricted auctions only accept bids from organizations in Orgs. With
+	// JoinApproval set, organizations wait in PendingOrgs until the seller approves.
+	Restricted   bool     `json:"restricted"`
+	JoinApproval bool     `json:"joinApproval"`
+	PendingOrgs  []string `json:"pendingOrgs"`
+	// AllowedBidders lists the common names that may bid, any bidder when empty.
+	// VerifiedOnly requires the bitauction.verified=true attribute.
+	AllowedBidders []string `json:"allowedBidders"`
+	VerifiedOnly   bool     `json:"verifiedOnly"`
+	// ShillRule decides which bidders are too close to the seller to bid
+	ShillRule string `json:"shillRule"`
+	// seconds the winner has to pay and the seller has to deliver after payment
+	PaymentPeriod  int         `json:"paymentPeriod"`
+	DeliveryPeriod int         `json:"deliveryPeriod"`
+	Settlement     *Settlement `json:"settlement,omitempty"`
+	// ItemID is the registered item sold by the auction, empty for free text items
+	ItemID string `json:"itemID,omitempty"`
+	// EscrowMode decides whether bids lock a deposit or their full amount
+	EscrowMode string      `json:"escrowMode"`
+	Deposit    money.Money `json:"deposit"`
+	// Currency of the prices of the auction
+	Currency string `json:"currency"`
+	// Fees replaces the fee schedule of the seller's organization when set
+	Fees *FeeSchedule `json:"fees,omitempty"`
+	// BuyNowPrice closes the auction at once when a bidder accepts it, until
+	// the first bid or, with a BuyNowThreshold, until bids reach the threshold
+	BuyNowPrice     money.Money `json:"buyNowPrice"`
+	BuyNowThreshold money.Money `json:"buyNowThreshold"`
+	// Outcome is how the auction ended: "bidding", "buy-now" or "no-sale"
+	Outcome string `json:"outcome,omitempty"`
+	// ClosedAt is the Time Oracle time the auction actually ended, which can
+	// be well after its time limit
+	ClosedAt time.Time `json:"closedAt"`
+	// Direction is "forward" when the highest bid wins and "reverse" when
+	// suppliers bid down and the lowest bid wins. In reverse auctions Seller
+	// holds the buying identity. Auctions created before directions are forward.
+	Direction string `json:"direction"`
+	// Ceiling is the highest price a reverse auction accepts, any when zero
+	Ceiling money.Money `json:"ceiling"`
+	// a bid on a reverse auction must undercut the leading bid by at least
+	// MinDecrement and DecrementBasisPoints of the leading price
+	MinDecrement         money.Money `json:"minDecrement"`
+	DecrementBasisPoints int64       `json:"decrementBasisPoints"`
+	// Quantity is the number of identical units sold, 0 for single items.
+	// Multi-unit auctions end with Allocations instead of a single Winner,
+	// priced by the Pricing rule: "uniform" or "pay-as-bid".
+	Quantity    int           `json:"quantity,omitempty"`
+	Pricing     string        `json:"pricing,omitempty"`
+	Allocations []*Allocation `json:"allocations,omitempty"`
+	// Lots are the lots of a package auction, whose bids name sets of lots.
+	// It ends with an award per lot instead of a single Winner.
+	Lots      []string    `json:"lots,omitempty"`
+	LotAwards []*LotAward `json:"lotAwards,omitempty"`
+	// Clock makes it a clock auction, in which bidders confirm rounds of a
+	// rising price instead of bidding
+	Clock *Clock `json:"clock,omitempty"`
+	// Session is the live session selling the auction by open outcry
+	Session *Session `json:"session,omitempty"`
+	// SaleID is the sale the auction is a lot of, empty for single auctions
+	SaleID string `json:"saleID,omitempty"`
+	// Collection is the private data collection holding a private auction,
+	// shared by the organization of the seller and the Invited organizations.
+	// It is empty for public auctions.
+	Collection string   `json:"collection,omitempty"`
+	Invited    []string `json:"invited,omitempty"`
+	// Sealed makes it a sealed-bid auction, in which bidders commit to hidden
+	// amounts until the time limit and open them afterwards
+	Sealed *SealedBidding `json:"sealed,omitempty"`
 }
 
 // FullBid is the structure of a revealed bid
 type FullBid struct {
-	Type      string    `json:"objectType"`
-	Price     int       `json:"price"`
-	Org       string    `json:"org"`
-	Bidder    string    `json:"bidder"`
-	Valid     bool      `json:"valid"`
-	Timestamp time.Time `json:"timestamp"`
+	Type      string      `json:"objectType"`
+	TxID      string      `json:"txID"`
+	Price     money.Money `json:"price"`
+	Org       string      `json:"org"`
+	Bidder    string      `json:"bidder"`
+	Valid     bool        `json:"valid"`
+	Timestamp time.Time   `json:"timestamp"`
+	// Invalidation records why a bid is no longer valid
+	Invalidation *Invalidation `json:"invalidation,omitempty"`
+	// Quantity is the number of units the bid is for at Price each, 0 for
+	// bids placed before multi-unit auctions, which are for one unit
+	Quantity int `json:"quantity,omitempty"`
+	// Lots is the package of lots a bid on a package auction is for
+	Lots []string `json:"lots,omitempty"`
+	// Attestation is what an anonymous bidder proved with their Idemix
+	// credential, such as "verified bidder of Org1MSP"
+	Attestation string `json:"attestation,omitempty"`
+	// LinkCommitment is the commitment of an anonymous bidder to the identity
+	// that settles in their place, see LinkCommitment
+	LinkCommitment string `json:"linkCommitment,omitempty"`
 }
 
 type Winner struct {
-	HighestBidder string `json:"highestbidder"`
-	HighestBid    int    `json:"highestbid"`
+	HighestBidder string      `json:"highestbidder"`
+	HighestBid    money.Money `json:"highestbid"`
 }
 
-const bidKeyType = "bid"
+const (
+	bidKeyType     = "bid"
+	fullBidKeyType = "fullbid"
+)
 
 // CreateAuction creates on auction on the public channel. The identity that
 // submits the transaction becomes the seller of the auction
 func (s *SmartContract) CreateAuction(ctx contractapi.TransactionContextInterface, auctionID string, itemsold string, timelimit string, description string, pictureUrl string) error {
+	t, err := time.Parse(time.RFC3339Nano, timelimit)
+	if err != nil {
+		return fmt.Errorf("invalid datetime format: %v", err)
+	}
 
+	auction, err := s.createAuction(ctx, auctionID, itemsold, t, description, pictureUrl, directionForward)
+	if err != nil {
+		return err
+	}
+	return emitEvents(ctx, auctionCreatedEvent(auction))
+}
+
+// createAuction stores a new open auction of the submitting client. Forward
+// auctions are created by sellers, reverse auctions by procurement.
+func (s *SmartContract) createAuction(ctx contractapi.TransactionContextInterface, auctionID string, itemsold string, t time.Time, description string, pictureUrl string, direction string) (*Auction, error) {
 	// get ID of submitting client
 	clientID, err := s.GetSubmittingClientIdentity(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get client identity %v", err)
+		return nil, fmt.Errorf("failed to get client identity %v", err)
+	}
+	role := roleSeller
+	if direction == directionReverse {
+		role = roleProcurement
+	}
+	if err = requireRole(ctx, role); err != nil {
+		return nil, err
 	}
 
 	// get org of submitting client
 	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
-		return fmt.Errorf("failed to get client identity %v", err)
-	}
-
-	t, err := time.Parse(time.RFC3339Nano, timelimit)
-	if err != nil {
-		return fmt.Errorf("invalid datetime format: %v", err)
+		return nil, fmt.Errorf("failed to get client identity %v", err)
 	}
 
 	// Create auction
@@ -85,7 +198,7 @@ func (s *SmartContract) CreateAuction(ctx contractapi.TransactionContextInterfac
 		AuctionID:   auctionID,
 		Type:        "auction",
 		ItemSold:    itemsold,
-		Price:       0,
+		Price:       money.Zero(money.DefaultCurrency),
 		Seller:      clientID,
 		Orgs:        []string{clientOrgID},
 		Winner:      "",
@@ -94,17 +207,32 @@ func (s *SmartContract) CreateAuction(ctx contractapi.TransactionContextInterfac
 		Description: description,
 		PictureURL:  pictureUrl,
 		Bids:        []FullBid{},
+
+		RetractWindow: defaultRetractWindow,
+		RetractCutoff: defaultRetractCutoff,
+		ShillRule:     shillRuleSeller,
+
+		PaymentPeriod:  defaultPaymentPeriod,
+		DeliveryPeriod: defaultDeliveryPeriod,
+		Currency:       money.DefaultCurrency,
+		Direction:      direction,
 	}
 
 	auctionJSON, err := json.Marshal(auction)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// put auction into state
 	err = ctx.GetStub().PutState(auctionID, auctionJSON)
 	if err != nil {
-		return fmt.Errorf("failed to put auction in public data: %v", err)
+		return nil, fmt.Errorf("failed to put auction in public data: %v", err)
+	}
+
+	// start tracking the leader of the auction
+	err = putLeaderRecord(ctx, auction.Collection, &Leader{AuctionID: auctionID})
+	if err != nil {
+		return nil, err
 	}
 
 	// set the seller of the auction as an endorser
@@ -113,15 +241,28 @@ func (s *SmartContract) CreateAuction(ctx contractapi.TransactionContextInterfac
 	// This allows any organization to endorse transactions
 	// err = ctx.GetStub().SetStateValidationParameter(auctionID, nil)
 	if err != nil {
-		return fmt.Errorf("failed setting state based endorsement for new organization: %v", err)
+		return nil, fmt.Errorf("failed setting state based endorsement for new organization: %v", err)
 	}
 
-	return nil
+	return &auction, nil
+}
+
+// auctionCreatedEvent describes a newly created auction
+func auctionCreatedEvent(auction *Auction) events.AuctionCreated {
+	return events.AuctionCreated{
+		AuctionID: auction.AuctionID,
+		Seller:    auction.Seller,
+		Org:       sellerOrg(auction),
+		Item:      auction.ItemSold,
+		Timelimit: auction.Timelimit,
+		Direction: auction.Direction,
+	}
 }
 
 // Bid is used to add a user's bid to the auction. The bid is stored in the public
-// storage. The function returns the transaction ID so that users can identify and query their bid
-func (s *SmartContract) Bid(ctx contractapi.TransactionContextInterface, auctionID string, price int) (string, error) {
+// storage. The function returns the transaction ID so that users can identify and query their bid.
+// price is an amount such as "12.50", in the currency of the auction unless it names one.
+func (s *SmartContract) Bid(ctx contractapi.TransactionContextInterface, auctionID string, price string) (string, error) {
 	auction, err := s.QueryAuction(ctx, auctionID)
 	if err != nil {
 		return "", fmt.Errorf("failed to get auction: %v", err)
@@ -129,6 +270,13 @@ func (s *SmartContract) Bid(ctx contractapi.TransactionContextInterface, auction
 	if err = isAuctionOpenForBidding(auction); err != nil {
 		return "", err
 	}
+	if err = s.checkBidder(ctx, auction); err != nil {
+		return "", err
+	}
+	bidPrice, err := parseBidPrice(auction, price)
+	if err != nil {
+		return "", err
+	}
 
 	// the transaction ID is used as a unique index for the bid
 	txID := ctx.GetStub().GetTxID()
@@ -139,8 +287,16 @@ func (s *SmartContract) Bid(ctx contractapi.TransactionContextInterface, auction
 		return "", fmt.Errorf("failed to create composite key: %v", err)
 	}
 
-	priceJSON, _ := json.Marshal(price)
-	err = ctx.GetStub().PutState(bidKey, priceJSON)
+	priceJSON, _ := json.Marshal(bidPrice)
+	err = putAuctionData(ctx, auction.Collection, bidKey, priceJSON)
+	if err != nil {
+		return "", fmt.Errorf("failed to put bid in state: %v", err)
+	}
+
+	err = emitAuctionEvents(ctx, auction, events.BidPlaced{AuctionID: auctionID, TxID: txID, Price: bidPrice})
+	if err != nil {
+		return "", err
+	}
 
 	// return the transaction ID so that the user can identify their bid
 	return txID, nil
@@ -159,86 +315,294 @@ func (s *SmartContract) SubmitBid(ctx contractapi.TransactionContextInterface, a
 		return err
 	}
 
-	bidder, err := s.GetSubmittingClientIdentity(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to get client identity: %v", err)
-	}
-	org, err := ctx.GetClientIdentity().GetMSPID()
-	if err != nil {
-		return fmt.Errorf("failed to get org: %v", err)
-	}
-
-	// get the bid from public state
+	// get the bid stored with Bid
 	bidKey, err := ctx.GetStub().CreateCompositeKey(bidKeyType, []string{auctionID, txID})
 	if err != nil {
 		return fmt.Errorf("failed to create composite key: %v", err)
 	}
-	priceBytes, err := ctx.GetStub().GetState(bidKey)
+	priceBytes, err := getAuctionData(ctx, auction.Collection, bidKey)
 	if err != nil {
-		return fmt.Errorf("failed to get bid from public state: %v", err)
+		return fmt.Errorf("failed to get bid from state: %v", err)
 	}
 	if priceBytes == nil {
-		return fmt.Errorf("bid not found in public state")
+		return fmt.Errorf("bid not found in state")
 	}
-	var price int
+	var price money.Money
 	err = json.Unmarshal(priceBytes, &price)
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal bid: %v", err)
 	}
-	// check if the bid is valid
-	if price <= 0 {
-		return fmt.Errorf("invalid bid amount: %v", err)
+
+	return s.recordBid(ctx, auction, txID, price, 1, nil)
+}
+
+// PlaceBid validates, timestamps and records a bid in a single transaction,
+// without the intermediate bid key used by Bid and SubmitBid. The function
+// returns the transaction ID that identifies the bid.
+func (s *SmartContract) PlaceBid(ctx contractapi.TransactionContextInterface, auctionID string, price string) (string, error) {
+	return s.placeBid(ctx, auctionID, price, 1, nil)
+}
+
+// placeBid validates, timestamps and records a bid for quantity units or for
+// a package of lots
+func (s *SmartContract) placeBid(ctx contractapi.TransactionContextInterface, auctionID string, price string, quantity int, lots []string) (string, error) {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = isAuctionOpenForBidding(auction); err != nil {
+		return "", err
 	}
 
-	body, err := s.RecordTimeFromOracle(ctx, txID)
+	bidPrice, err := parseBidPrice(auction, price)
 	if err != nil {
-		return fmt.Errorf("failed to read timestamp from state: %v", err)
+		return "", err
 	}
-	if len(body) == 0 {
-		return fmt.Errorf("no timestamp found for transaction ID: %s", txID)
+
+	txID := ctx.GetStub().GetTxID()
+	err = s.recordBid(ctx, auction, txID, bidPrice, quantity, lots)
+	if err != nil {
+		return "", err
 	}
-	log.Printf("Successfully retrieved timestamp from state: %v", string(body))
+	return txID, nil
+}
 
-	// Deserialize the JSON response into a TimestampResponse struct
-	// var timestamps []string
-	var timestamps string = body
-	// err = json.Unmarshal(body, &timestamps)
-	// if err != nil {
-	// 	return fmt.Errorf("failed to parse API response: %v with body: %v", err, string(body))
-	// }
+// recordBid timestamps a bid for quantity units at price each, or for the
+// package of lots at price, through the Time Oracle, stores it as a full bid
+// of the auction submitted by the client and emits the bid events
+func (s *SmartContract) recordBid(ctx contractapi.TransactionContextInterface, auction *Auction, txID string, price money.Money, quantity int, lots []string) error {
+	auctionID := auction.AuctionID
 
-	encodedValue := encodeValue(txID)
-	shuffledTimestamps := shuffleTimestamps([]string{timestamps}, encodedValue)
+	bidder, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	org, err := clientOrg(ctx)
+	if err != nil {
+		return err
+	}
+	attestation, err := bidderAttestation(ctx)
+	if err != nil {
+		return err
+	}
+	linkCommitment, err := bidLinkCommitment(ctx)
+	if err != nil {
+		return err
+	}
 
-	Timestamp, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", shuffledTimestamps)
+	if err = s.checkBidder(ctx, auction); err != nil {
+		return err
+	}
+	if err = checkShillRule(auction, bidder, org); err != nil {
+		return err
+	}
+
+	// check if the bid is valid
+	if err = checkBidPrice(auction, price); err != nil {
+		return err
+	}
+	if quantity < 1 || quantity > auctionUnits(auction) {
+		return fmt.Errorf("bids on this auction must be for 1 to %d units, not %d", auctionUnits(auction), quantity)
+	}
+	if lots, err = checkBidLots(auction, lots); err != nil {
+		return err
+	}
+	if auction.Clock != nil {
+		return fmt.Errorf("bidders on a clock auction confirm rounds with ConfirmClockRound")
+	}
+	if auction.Session != nil {
+		return fmt.Errorf("bids in a live session are recorded by the auctioneer")
+	}
+	if auction.Sealed != nil {
+		return fmt.Errorf("bids on a sealed-bid auction are committed with SubmitSealedBid")
+	}
+	if err = checkSaleRunning(ctx, auction); err != nil {
+		return err
+	}
+	fullBidKey, err := ctx.GetStub().CreateCompositeKey(fullBidKeyType, []string{auctionID, txID})
+	if err != nil {
+		return fmt.Errorf("failed to create full bid key: %v", err)
+	}
+	existing, err := getAuctionData(ctx, auction.Collection, fullBidKey)
+	if err != nil {
+		return fmt.Errorf("failed to get full bid: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("bid %v has already been submitted", txID)
+	}
+	if err = lockBidFunds(ctx, auction, bidder, price); err != nil {
+		return err
+	}
+
+	Timestamp, err := s.oracleTimestamp(ctx, txID)
 	if err != nil {
-		return fmt.Errorf("failed to parse timestamp: %v", err)
+		return err
 	}
 
 	fullBid := FullBid{
 		Type:      "bid",
+		TxID:      txID,
 		Price:     price,
+		Quantity:  quantity,
+		Lots:      lots,
 		Org:       org,
 		Bidder:    bidder,
 		Valid:     true,
 		Timestamp: Timestamp,
+
+		Attestation:    attestation,
+		LinkCommitment: linkCommitment,
 	}
 
-	fullBidKey, err := ctx.GetStub().CreateCompositeKey("fullbid", []string{auctionID, txID})
+	// only the consolidated leader is read so that concurrent bids do not conflict
+	leader, err := s.consolidatedLeader(ctx, auctionID)
 	if err != nil {
-		return fmt.Errorf("failed to create full bid key: %v", err)
+		return fmt.Errorf("failed to get highest bid: %v", err)
+	}
+	if err = s.checkDecrement(ctx, auction, price); err != nil {
+		return err
 	}
 
-	fullBidJSON, err := json.Marshal(fullBid)
+	err = putFullBid(ctx, auctionID, txID, &fullBid)
 	if err != nil {
-		return fmt.Errorf("failed to marshal full bid: %v", err)
+		return err
+	}
+	err = putLeaderDelta(ctx, auctionID, &fullBid)
+	if err != nil {
+		return err
 	}
 
-	err = ctx.GetStub().PutState(fullBidKey, fullBidJSON)
+	evts := []events.Event{events.BidSubmitted{
+		AuctionID: auctionID,
+		TxID:      txID,
+		Bidder:    bidder,
+		Org:       org,
+		Price:     price,
+		Timestamp: Timestamp,
+	}}
+	better, err := s.isBetterBid(auction.Direction, &fullBid, leader)
 	if err != nil {
-		return fmt.Errorf("failed to put full bid in state: %v", err)
+		return err
 	}
+	if better {
+		newLeader := events.NewLeader{AuctionID: auctionID, TxID: txID, Bidder: bidder, Price: price}
+		if leader != nil {
+			newLeader.PreviousPrice = leader.Price
+		}
+		evts = append(evts, newLeader)
+	}
+
+	// a bid close to the time limit extends the auction when soft close is enabled
+	extendUntil := Timestamp.Add(time.Duration(auction.ExtensionTime) * time.Second)
+	if auction.ExtensionWindow > 0 && auction.Timelimit.Sub(Timestamp) < time.Duration(auction.ExtensionWindow)*time.Second && extendUntil.After(auction.Timelimit) {
+		evts = append(evts, events.DeadlineExtended{
+			AuctionID: auctionID,
+			Previous:  auction.Timelimit,
+			Timelimit: extendUntil,
+			Reason:    "soft-close",
+		})
+		auction.Timelimit = extendUntil
+		err = putAuction(ctx, auction)
+		if err != nil {
+			return err
+		}
+	}
+
+	return emitAuctionEvents(ctx, auction, evts...)
+}
 
+// SetSoftClose lets the seller enable soft close. A bid submitted less than
+// window seconds before the time limit moves the time limit to extension
+// seconds after the bid. A window of 0 disables soft close. The setting can
+// only change before the first bid.
+func (s *SmartContract) SetSoftClose(ctx contractapi.TransactionContextInterface, auctionID string, window int, extension int) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = s.checkSellerBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+	if window < 0 || extension < 0 {
+		return fmt.Errorf("soft close settings cannot be negative")
+	}
+
+	auction.ExtensionWindow = window
+	auction.ExtensionTime = extension
+	return putAuction(ctx, auction)
+}
+
+// SetCurrency lets the seller choose the ISO 4217 currency of the prices of
+// the auction. The currency can only change before the first bid, and not
+// while other prices of the auction are set in the old one.
+func (s *SmartContract) SetCurrency(ctx contractapi.TransactionContextInterface, auctionID string, currency string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = s.checkSellerBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+	if _, err = money.Exponent(currency); err != nil {
+		return err
+	}
+	if auction.EscrowMode == escrowDeposit {
+		return fmt.Errorf("the deposit of the auction is in %v, change the escrow settings first", auction.Deposit.Currency)
+	}
+	if auction.Fees != nil && auction.Fees.Currency != currency {
+		return fmt.Errorf("the fee schedule of the auction is in %v, change it first", auction.Fees.Currency)
+	}
+	if auction.BuyNowPrice.IsPositive() {
+		return fmt.Errorf("the buy-now price of the auction is in %v, change it first", auction.BuyNowPrice.Currency)
+	}
+	if auction.Clock != nil {
+		return fmt.Errorf("the clock of the auction is in %v, change it first", auction.Clock.StartPrice.Currency)
+	}
+	if auction.Sealed != nil {
+		return fmt.Errorf("the reserve of the auction is in %v, change it first", auction.Sealed.Reserve.Currency)
+	}
+	if auction.Ceiling.IsPositive() {
+		return fmt.Errorf("the ceiling of the auction is in %v and cannot change", auction.Ceiling.Currency)
+	}
+	if auction.MinDecrement.IsPositive() {
+		return fmt.Errorf("the decrement rule of the auction is in %v, change it first", auction.MinDecrement.Currency)
+	}
+
+	auction.Currency = currency
+	auction.Price = money.Zero(currency)
+	auction.BuyNowPrice = money.Zero(currency)
+	auction.BuyNowThreshold = money.Zero(currency)
+	auction.Ceiling = money.Zero(currency)
+	auction.MinDecrement = money.Zero(currency)
+	return putAuction(ctx, auction)
+}
+
+// parseBidPrice reads the price argument of a bid on the auction
+func parseBidPrice(auction *Auction, price string) (money.Money, error) {
+	bidPrice, err := money.Parse(price, auction.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return bidPrice, checkBidPrice(auction, bidPrice)
+}
+
+// checkBidPrice refuses bids that are not positive or not in the currency of the auction
+func checkBidPrice(auction *Auction, price money.Money) error {
+	if price.Currency != auction.Currency {
+		return fmt.Errorf("bids on this auction must be in %v, not %v", auction.Currency, price.Currency)
+	}
+	if !price.IsPositive() {
+		return fmt.Errorf("invalid bid amount: %v", price)
+	}
+	if auction.Direction == directionReverse && auction.Ceiling.IsPositive() {
+		cmp, err := price.Cmp(auction.Ceiling)
+		if err != nil {
+			return err
+		}
+		if cmp > 0 {
+			return fmt.Errorf("bids on this auction cannot exceed the ceiling of %v", auction.Ceiling)
+		}
+	}
 	return nil
 }
 
@@ -257,37 +621,127 @@ func (s *SmartContract) EndAuction(ctx contractapi.TransactionContextInterface,
 	if Seller != clientID {
 		return fmt.Errorf("Auction can only be ended by the seller")
 	}
+	if auction.Clock != nil {
+		return fmt.Errorf("clock auctions end when AdvanceClock closes a round with at most one bidder left")
+	}
+	if auction.Session != nil {
+		return fmt.Errorf("auctions in a live session end when the auctioneer calls sold")
+	}
 
 	if auction.Timelimit.After(time.Now().UTC()) {
 		return fmt.Errorf("Cannot end auction before time limit has passed")
 	}
+	// the reveal period of sealed bids is checked against the oracle time
+	// the auction closes at
+	now, err := s.oracleTimestamp(ctx, ctx.GetStub().GetTxID())
+	if err != nil {
+		return err
+	}
+	if auction.Sealed != nil && sealedRevealEnd(auction).After(now) {
+		return fmt.Errorf("sealed bids can be opened until %v", sealedRevealEnd(auction))
+	}
 
 	Status := auction.Status
 	if Status == "ended" {
 		return fmt.Errorf("auction has already been ended")
 	}
+	if Status != "open" {
+		return fmt.Errorf("auction cannot be ended, status is %v", Status)
+	}
+	if err = checkSaleRunning(ctx, auction); err != nil {
+		return err
+	}
+	if err = s.checkSaleOrder(ctx, auction); err != nil {
+		return err
+	}
+	auction.ClosedAt = now
+	if auctionUnits(auction) > 1 {
+		return s.allocateUnits(ctx, auction)
+	}
+	if len(auction.Lots) > 0 {
+		return s.awardLots(ctx, auction)
+	}
 
-	HighestBid, err := s.GetHb(ctx, auctionID)
+	HighestBid, err := s.consolidateLeader(ctx, auctionID, auction.Direction)
 	if err != nil {
 		return fmt.Errorf("failed to get highest bid: %v", err)
 	}
+	outcome := outcomeBidding
 	if HighestBid == nil {
+		outcome = outcomeNoSale
+	}
+	return s.closeAuction(ctx, auction, HighestBid, outcome, clientID)
+}
+
+// closeAuction ends the auction with the winning bid, nil when nobody won,
+// starts its settlement and hands the item over. The events of the caller are
+// emitted after AuctionEnded.
+func (s *SmartContract) closeAuction(ctx contractapi.TransactionContextInterface, auction *Auction, winning *FullBid, outcome string, clientID string, extra ...events.Event) error {
+	var err error
+	if auction.ClosedAt.IsZero() {
+		auction.ClosedAt, err = s.oracleTimestamp(ctx, ctx.GetStub().GetTxID())
+		if err != nil {
+			return err
+		}
+	}
+	if winning == nil {
 		// No bids were placed, so we can end the auction without a winner
 		auction.Winner = ""
-		auction.Price = 0
+		auction.Price = money.Zero(auction.Currency)
 	} else {
 		// There were bids, so we set the winner and price
-		auction.Winner = HighestBid.Bidder
-		auction.Price = HighestBid.Price
+		auction.Winner = winning.Bidder
+		auction.Price = winning.Price
+	}
+	// reverse auctions are settled off-chain between the buyer and the supplier
+	if winning != nil && auction.Direction != directionReverse {
+		auction.Settlement = newSettlement(auction, winning)
+		auction.Settlement.Fees, err = feeBreakdown(ctx, auction, winning.Price)
+		if err != nil {
+			return fmt.Errorf("failed to compute fees: %v", err)
+		}
+	}
+	if auction.Settlement != nil {
+		err = moveItem(ctx, auction, auction.Winner, true)
+	} else {
+		err = moveItem(ctx, auction, auction.Seller, false)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to transfer item: %v", err)
+	}
+
+	if auction.EscrowMode == escrowDeposit || auction.EscrowMode == escrowFull {
+		paid, err := endEscrow(ctx, auction)
+		if err != nil {
+			return fmt.Errorf("failed to settle escrow: %v", err)
+		}
+		if paid {
+			now := auction.ClosedAt
+			if err = recordFeeRevenue(ctx, auction, now); err != nil {
+				return err
+			}
+			auction.Settlement.Deadline = deadlineAfter(now, auction.DeliveryPeriod)
+			recordSettlementStep(auction, settlementPaid, "escrow", now)
+		}
 	}
 
 	auction.Status = string("ended")
-	endedAuctionJSON, _ := json.Marshal(auction)
-	err = ctx.GetStub().PutState(auctionID, endedAuctionJSON)
+	auction.Outcome = outcome
+	err = putAuction(ctx, auction)
 	if err != nil {
 		return fmt.Errorf("failed to end auction: %v", err)
 	}
-	return nil
+	evts := []events.Event{events.AuctionEnded{AuctionID: auction.AuctionID, Winner: auction.Winner, Price: auction.Price, Outcome: outcome}}
+	evts = append(evts, extra...)
+	moved, err := s.lotClosed(ctx, auction)
+	if err != nil {
+		return err
+	}
+	evts = append(evts, moved...)
+	if auction.Settlement != nil {
+		evts = append(evts, settlementEvent(auction, clientID))
+	}
+	return emitAuctionEvents(ctx, auction, evts...)
 }
 
 // GetTimeFromOracle calls the Time Oracle chaincode and returns the current time
@@ -311,3 +765,65 @@ func (c *SmartContract) RecordTimeFromOracle(ctx contractapi.TransactionContextI
 	return string(response.Payload), nil
 }
 
+// oracleTimestamp records the time for txID through the Time Oracle and
+// returns it as the agreed timestamp of the transaction
+func (s *SmartContract) oracleTimestamp(ctx contractapi.TransactionContextInterface, txID string) (time.Time, error) {
+	body, err := s.RecordTimeFromOracle(ctx, txID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read timestamp from state: %v", err)
+	}
+	if len(body) == 0 {
+		return time.Time{}, fmt.Errorf("no timestamp found for transaction ID: %s", txID)
+	}
+	log.Printf("Successfully retrieved timestamp from state: %v", body)
+
+	encodedValue := encodeValue(txID)
+	shuffledTimestamps := shuffleTimestamps([]string{body}, encodedValue)
+
+	timestamp, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", shuffledTimestamps)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse timestamp: %v", err)
+	}
+	return timestamp, nil
+}
+
+// putAuction writes the auction to public state, or to its collection for
+// private auctions
+func putAuction(ctx contractapi.TransactionContextInterface, auction *Auction) error {
+	if auction.Collection != "" {
+		return putPrivateAuction(ctx, auction)
+	}
+	auctionJSON, err := json.Marshal(auction)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(auction.AuctionID, auctionJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put auction in public data: %v", err)
+	}
+	return nil
+}
+
+// putFullBid writes a full bid under its composite key to public state, or to
+// the collection of a private auction
+func putFullBid(ctx contractapi.TransactionContextInterface, auctionID string, txID string, fullBid *FullBid) error {
+	collection, err := auctionCollection(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+	fullBidKey, err := ctx.GetStub().CreateCompositeKey(fullBidKeyType, []string{auctionID, txID})
+	if err != nil {
+		return fmt.Errorf("failed to create full bid key: %v", err)
+	}
+
+	fullBidJSON, err := json.Marshal(fullBid)
+	if err != nil {
+		return fmt.Errorf("failed to marshal full bid: %v", err)
+	}
+
+	err = putAuctionData(ctx, collection, fullBidKey, fullBidJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put full bid in state: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Clients are authorized with attributes of their enrollment certificate.
+// bitauction.role holds a comma separated list of roles, for example
+// "bidder" or "seller,bidder". An organization can further limit the roles its
+// members may use with SetOrgCapabilities.
+const (
+	roleAttribute     = "bitauction.role"
+	verifiedAttribute = "bitauction.verified"
+
+	roleSeller     = "seller"
+	roleBidder     = "bidder"
+	roleAuctioneer = "auctioneer"
+	roleAuditor    = "auditor"
+	roleBank       = "bank"
+	// procurement identities create reverse auctions to buy from suppliers
+	roleProcurement = "procurement"
+	// admins manage the settings of their organization. The capabilities of
+	// the organization do not limit the role, so admins cannot lock themselves out.
+	roleAdmin = "admin"
+
+	orgCapabilitiesKeyType = "orgcaps"
+)
+
+var knownRoles = []string{roleSeller, roleBidder, roleAuctioneer, roleAuditor, roleBank, roleProcurement}
+
+// OrgCapabilities lists the roles the members of an organization may use
+type OrgCapabilities struct {
+	Org   string   `json:"org"`
+	Roles []string `json:"roles"`
+}
+
+// SetOrgCapabilities lets an admin of an organization limit the roles that
+// identities of the organization can use in auctions
+func (s *SmartContract) SetOrgCapabilities(ctx contractapi.TransactionContextInterface, roles []string) error {
+	err := requireOrgAdmin(ctx)
+	if err != nil {
+		return fmt.Errorf("only an admin of the organization can set its capabilities: %v", err)
+	}
+	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	for _, role := range roles {
+		if !contains(knownRoles, role) {
+			return fmt.Errorf("unknown role %v", role)
+		}
+	}
+
+	capsKey, err := ctx.GetStub().CreateCompositeKey(orgCapabilitiesKeyType, []string{clientOrgID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	capsJSON, err := json.Marshal(OrgCapabilities{Org: clientOrgID, Roles: roles})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(capsKey, capsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put capabilities in public state: %v", err)
+	}
+	return nil
+}
+
+// GetOrgCapabilities returns the capability rule of an organization, or nil
+// when the organization does not limit the roles of its members
+func (s *SmartContract) GetOrgCapabilities(ctx contractapi.TransactionContextInterface, org string) (*OrgCapabilities, error) {
+	return getOrgCapabilities(ctx, org)
+}
+
+func getOrgCapabilities(ctx contractapi.TransactionContextInterface, org string) (*OrgCapabilities, error) {
+	capsKey, err := ctx.GetStub().CreateCompositeKey(orgCapabilitiesKeyType, []string{org})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	capsJSON, err := ctx.GetStub().GetState(capsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get capabilities of %v: %v", org, err)
+	}
+	if capsJSON == nil {
+		return nil, nil
+	}
+
+	var caps *OrgCapabilities
+	err = json.Unmarshal(capsJSON, &caps)
+	if err != nil {
+		return nil, err
+	}
+	return caps, nil
+}
+
+// SetBidderRequirements lets the seller limit who can bid on the auction.
+// allowedBidders holds the common names of the identities that may bid, and
+// an empty list allows every bidder. With verifiedOnly set, bidders need the
+// bitauction.verified=true attribute. The requirements can only change before
+// the first bid, so that they never exclude bidders who already bid.
+func (s *SmartContract) SetBidderRequirements(ctx contractapi.TransactionContextInterface, auctionID string, verifiedOnly bool, allowedBidders []string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = s.checkSellerBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+
+	auction.VerifiedOnly = verifiedOnly
+	auction.AllowedBidders = allowedBidders
+	return putAuction(ctx, auction)
+}
+
+// requireRole checks that the client holds one of the roles and that its
+// organization allows the role. Idemix clients can only hold the bidder role,
+// granted by the policy of their MSP.
+func requireRole(ctx contractapi.TransactionContextInterface, roles ...string) error {
+	policy, ou, err := idemixClient(ctx)
+	if err != nil {
+		return err
+	}
+	held := []string{}
+	clientOrgID := ""
+	if policy != nil {
+		if contains(policy.BidderOUs, ou) || contains(policy.VerifiedOUs, ou) {
+			held = append(held, roleBidder)
+		}
+		clientOrgID = policy.Org
+	} else {
+		held, err = clientRoles(ctx)
+		if err != nil {
+			return err
+		}
+
+		clientOrgID, err = ctx.GetClientIdentity().GetMSPID()
+		if err != nil {
+			return fmt.Errorf("failed to get client identity %v", err)
+		}
+	}
+	caps, err := getOrgCapabilities(ctx, clientOrgID)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		if contains(held, role) && (caps == nil || contains(caps.Roles, role)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("client identity is not allowed the %v role", strings.Join(roles, " or "))
+}
+
+// requireOrgAdmin checks that the client holds the admin role of its
+// organization. Idemix clients are never admins.
+func requireOrgAdmin(ctx contractapi.TransactionContextInterface) error {
+	policy, _, err := idemixClient(ctx)
+	if err != nil {
+		return err
+	}
+	if policy != nil {
+		return fmt.Errorf("anonymous clients cannot be admins")
+	}
+	held, err := clientRoles(ctx)
+	if err != nil {
+		return err
+	}
+	if !contains(held, roleAdmin) {
+		return fmt.Errorf("client identity is not allowed the %v role", roleAdmin)
+	}
+	return nil
+}
+
+// clientRoles returns the roles of the bitauction.role attribute of the client
+func clientRoles(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	value, found, err := ctx.GetClientIdentity().GetAttributeValue(roleAttribute)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %v attribute: %v", roleAttribute, err)
+	}
+	if !found {
+		return nil, fmt.Errorf("client identity has no %v attribute", roleAttribute)
+	}
+	held := []string{}
+	for _, role := range strings.Split(value, ",") {
+		held = append(held, strings.TrimSpace(role))
+	}
+	return held, nil
+}
+
+// checkBidder verifies that the client may bid on the auction
+func (s *SmartContract) checkBidder(ctx contractapi.TransactionContextInterface, auction *Auction) error {
+	if err := requireRole(ctx, roleBidder); err != nil {
+		return err
+	}
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if feature := stableIdentityFeature(auction); feature != "" && isPseudonym(clientID) {
+		return fmt.Errorf("anonymous bidders cannot take part in %v, their pseudonym changes with every transaction", feature)
+	}
+	member, err := clientOrg(ctx)
+	if err != nil {
+		return err
+	}
+	verified, err := clientVerified(ctx)
+	if err != nil {
+		return err
+	}
+	return s.checkBidderStanding(auction, clientID, member, verified)
+}
+
+// checkBidderStanding verifies that bidder, taking part for the organization
+// member and verified or not, meets the current requirements of the auction.
+// It also checks bidders who are not the client, like the holder of a paddle.
+func (s *SmartContract) checkBidderStanding(auction *Auction, bidder string, member string, verified bool) error {
+	if err := checkBidderOrg(auction, member); err != nil {
+		return err
+	}
+	if auction.VerifiedOnly && !verified {
+		return fmt.Errorf("auction only accepts verified bidders")
+	}
+
+	if len(auction.AllowedBidders) > 0 {
+		name, err := s.ParseClientID(bidder)
+		if err != nil {
+			return err
+		}
+		if !contains(auction.AllowedBidders, name) {
+			return fmt.Errorf("bidder %v is not on the allow-list of the auction", name)
+		}
+	}
+	return nil
+}
+
+// clientVerified reports whether the client is a verified bidder: an Idemix
+// client of a verified unit, or an X.509 client with the verified attribute
+func clientVerified(ctx contractapi.TransactionContextInterface) (bool, error) {
+	policy, ou, err := idemixClient(ctx)
+	if err != nil {
+		return false, err
+	}
+	if policy != nil {
+		return contains(policy.VerifiedOUs, ou), nil
+	}
+	return ctx.GetClientIdentity().AssertAttributeValue(verifiedAttribute, "true") == nil, nil
+}
@@ -0,0 +1,112 @@
+package auction_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateAuctionRequiresSellerRole(t *testing.T) {
+	contract, ctx := setup()
+	timelimit := time.Now().Add(1 * time.Hour).Format(time.RFC3339Nano)
+
+	ctx.Identity.Attrs = map[string]string{}
+	err := contract.CreateAuction(ctx, "auction1", "Laptop", timelimit, "Desc", "http://img")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no bitauction.role attribute")
+
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "bidder"}
+	err = contract.CreateAuction(ctx, "auction1", "Laptop", timelimit, "Desc", "http://img")
+	assert.Error(t, err)
+
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "bidder, seller"}
+	err = contract.CreateAuction(ctx, "auction1", "Laptop", timelimit, "Desc", "http://img")
+	assert.NoError(t, err)
+}
+
+func TestBidRequiresBidderRole(t *testing.T) {
+	contract, ctx := setup()
+	putOpenAuction(ctx, time.Now().Add(1*time.Hour))
+
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "seller"}
+	_, err := contract.Bid(ctx, "auction1", "100")
+	assert.Error(t, err)
+
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "bidder"}
+	_, err = contract.Bid(ctx, "auction1", "100")
+	assert.NoError(t, err)
+}
+
+func TestOrgCapabilities(t *testing.T) {
+	contract, ctx := setup()
+	putOpenAuction(ctx, time.Now().Add(1*time.Hour))
+
+	// only an admin of the organization sets its capabilities
+	err := contract.SetOrgCapabilities(ctx, []string{"seller"})
+	assert.Error(t, err)
+
+	ctx.Identity.Attrs["hf.Type"] = "admin"
+	err = contract.SetOrgCapabilities(ctx, []string{"seller"})
+	assert.Error(t, err)
+	ctx.Identity.Attrs["bitauction.role"] = "seller,bidder,admin"
+	err = contract.SetOrgCapabilities(ctx, []string{"superuser"})
+	assert.Error(t, err)
+	err = contract.SetOrgCapabilities(ctx, []string{"seller"})
+	assert.NoError(t, err)
+
+	caps, err := contract.GetOrgCapabilities(ctx, "Org1MSP")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"seller"}, caps.Roles)
+
+	// the identity holds the bidder role, but its organization does not allow it
+	_, err = contract.Bid(ctx, "auction1", "100")
+	assert.Error(t, err)
+
+	// the capabilities never lock the admin out
+	err = contract.SetOrgCapabilities(ctx, []string{"seller", "bidder"})
+	assert.NoError(t, err)
+}
+
+func TestBidderRequirements(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	putOpenAuction(ctx, now.Add(1*time.Hour))
+
+	err := contract.SetBidderRequirements(ctx, "auction1", true, []string{"alice"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, getAuction(ctx).AllowedBidders)
+
+	ctx.Identity.ID = base64.StdEncoding.EncodeToString([]byte("x509::CN=alice,OU=client::CN=ca.org1.example.com"))
+	priceJSON, _ := json.Marshal(100)
+	ctx.Stub.State["bid:auction1:tx1"] = priceJSON
+
+	// alice is on the allow-list but not verified yet
+	err = contract.SubmitBid(ctx, "auction1", "tx1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "verified")
+
+	ctx.Identity.Attrs["bitauction.verified"] = "true"
+	err = contract.SubmitBid(ctx, "auction1", "tx1")
+	assert.NoError(t, err)
+
+	ctx.Identity.ID = base64.StdEncoding.EncodeToString([]byte("x509::CN=bob,OU=client::CN=ca.org1.example.com"))
+	ctx.Stub.State["bid:auction1:tx2"] = priceJSON
+	err = contract.SubmitBid(ctx, "auction1", "tx2")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "allow-list")
+
+	// the requirements cannot change once bids exist
+	actAs(ctx, "user1")
+	err = contract.SetBidderRequirements(ctx, "auction1", false, []string{"bob"})
+	assert.Error(t, err)
+
+	var bid auction.FullBid
+	_ = json.Unmarshal(ctx.Stub.State["fullbid:auction1:tx1"], &bid)
+	assert.Contains(t, bid.Bidder, "CN=alice")
+}
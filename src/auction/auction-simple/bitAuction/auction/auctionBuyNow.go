@@ -0,0 +1,185 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"fmt"
+
+	"bitAuction/money"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// outcomes of an ended auction. Auctions ended before outcomes were recorded
+// have an empty outcome.
+const (
+	outcomeBidding = "bidding"
+	outcomeBuyNow  = "buy-now"
+	outcomeNoSale  = "no-sale"
+)
+
+// buyNowBidType is the object type of the full bid recording a purchase at
+// the buy-now price
+const buyNowBidType = "buy-now"
+
+// SetBuyNow lets the seller offer the item at a fixed price. Without a
+// threshold the offer lasts until the first bid, with one until a bid reaches
+// the threshold. An empty price removes the offer. The offer can only change
+// before the first bid.
+func (s *SmartContract) SetBuyNow(ctx contractapi.TransactionContextInterface, auctionID string, price string, threshold string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = s.checkSellerBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+	if settledOffChain(auction) {
+		return fmt.Errorf("reverse, multi-unit and package auctions have no buy-now price")
+	}
+	if auction.Clock != nil {
+		return fmt.Errorf("clock auctions have no buy-now price")
+	}
+	if auction.Sealed != nil {
+		return fmt.Errorf("sealed-bid auctions have no buy-now price")
+	}
+
+	buyNowPrice := money.Zero(auction.Currency)
+	buyNowThreshold := money.Zero(auction.Currency)
+	if price != "" {
+		if buyNowPrice, err = parseBidPrice(auction, price); err != nil {
+			return err
+		}
+	}
+	if threshold != "" {
+		if price == "" {
+			return fmt.Errorf("a threshold needs a buy-now price")
+		}
+		if buyNowThreshold, err = parseBidPrice(auction, threshold); err != nil {
+			return err
+		}
+		cmp, err := buyNowThreshold.Cmp(buyNowPrice)
+		if err != nil {
+			return err
+		}
+		if cmp >= 0 {
+			return fmt.Errorf("the threshold must be below the buy-now price %v", buyNowPrice)
+		}
+	}
+
+	auction.BuyNowPrice = buyNowPrice
+	auction.BuyNowThreshold = buyNowThreshold
+	return putAuction(ctx, auction)
+}
+
+// BuyNow closes an open auction with the client as the winner at the buy-now
+// price. The purchase is timestamped through the Time Oracle like a bid and
+// recorded as a full bid of type "buy-now". On escrow auctions the buyer pays
+// from their escrow balance right away, and the purchase is refused when it
+// does not cover the price. The function returns the transaction ID that
+// identifies the purchase.
+func (s *SmartContract) BuyNow(ctx contractapi.TransactionContextInterface, auctionID string) (string, error) {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = isAuctionOpenForBidding(auction); err != nil {
+		return "", err
+	}
+	if !auction.BuyNowPrice.IsPositive() {
+		return "", fmt.Errorf("auction %v has no buy-now price", auctionID)
+	}
+	if auction.Session != nil {
+		return "", fmt.Errorf("the buy-now price is no longer available, the auction is in a live session")
+	}
+	if err = checkSaleRunning(ctx, auction); err != nil {
+		return "", err
+	}
+
+	buyer, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get client identity: %v", err)
+	}
+	org, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get org: %v", err)
+	}
+	if err = s.checkBidder(ctx, auction); err != nil {
+		return "", err
+	}
+	if err = checkShillRule(auction, buyer, org); err != nil {
+		return "", err
+	}
+	linkCommitment, err := bidLinkCommitment(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// the offer ends once a valid bid reaches the threshold, any bid without one
+	bids, err := s.QueryBids(ctx, auctionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get bids: %v", err)
+	}
+	for _, bid := range bids {
+		if !bid.Valid {
+			continue
+		}
+		reached := !auction.BuyNowThreshold.IsPositive()
+		if !reached {
+			cmp, err := bid.Price.Cmp(auction.BuyNowThreshold)
+			if err != nil {
+				return "", err
+			}
+			reached = cmp >= 0
+		}
+		if reached {
+			return "", fmt.Errorf("the buy-now price is no longer available, bids reached %v", bid.Price)
+		}
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	Timestamp, err := s.oracleTimestamp(ctx, txID)
+	if err != nil {
+		return "", err
+	}
+	if Timestamp.After(auction.Timelimit) {
+		return "", fmt.Errorf("auction has already ended")
+	}
+
+	purchase := FullBid{
+		Type:      buyNowBidType,
+		TxID:      txID,
+		Price:     auction.BuyNowPrice,
+		Org:       org,
+		Bidder:    buyer,
+		Valid:     true,
+		Timestamp: Timestamp,
+
+		LinkCommitment: linkCommitment,
+	}
+	err = putFullBid(ctx, auctionID, txID, &purchase)
+	if err != nil {
+		return "", err
+	}
+	err = putLeaderDelta(ctx, auctionID, &purchase)
+	if err != nil {
+		return "", err
+	}
+
+	// the auction ends at the time of the purchase, which starts the settlement.
+	// No funds are locked for the purchase, the escrow settlement pays from
+	// the buyer's committed balance and any lock of their earlier bids.
+	auction.Timelimit = Timestamp
+	err = s.closeAuction(ctx, auction, &purchase, outcomeBuyNow, buyer)
+	if err != nil {
+		return "", err
+	}
+	if auction.EscrowMode == escrowDeposit || auction.EscrowMode == escrowFull {
+		if auction.Settlement.State != settlementPaid {
+			return "", fmt.Errorf("insufficient escrow balance to pay %v", settlementFees(auction).BuyerPays)
+		}
+	}
+	return txID, nil
+}
@@ -0,0 +1,122 @@
+package auction_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuyNow(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+
+	_, err := contract.BuyNow(ctx, "auction1")
+	assert.Error(t, err)
+	err = contract.SetBuyNow(ctx, "auction1", "500", "500")
+	assert.Error(t, err)
+	err = contract.SetBuyNow(ctx, "auction1", "500", "300")
+	assert.NoError(t, err)
+
+	// the seller cannot buy their own item
+	_, err = contract.BuyNow(ctx, "auction1")
+	assert.Error(t, err)
+
+	// bids below the threshold keep the offer open
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 200)
+	actAs(ctx, "bidder2")
+	ctx.Stub.TxID = "buy"
+	txID, err := contract.BuyNow(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "buy", txID)
+	assert.Equal(t, "AuctionEnded", ctx.Stub.EventName)
+
+	a := getAuction(ctx)
+	assert.Equal(t, "ended", a.Status)
+	assert.Equal(t, "buy-now", a.Outcome)
+	assert.Equal(t, "bidder2", a.Winner)
+	assert.Equal(t, usd(500), a.Price)
+	assert.Equal(t, "buy", a.Settlement.BidTxID)
+	// payment is due three days after the purchase
+	assert.WithinDuration(t, now.Add(72*time.Hour), a.Settlement.Deadline, time.Second)
+
+	bids, err := contract.QueryBids(ctx, "auction1")
+	assert.NoError(t, err)
+	types := map[string]string{}
+	for _, bid := range bids {
+		types[bid.TxID] = bid.Type
+	}
+	assert.Equal(t, map[string]string{"tx1": "bid", "buy": "buy-now"}, types)
+
+	ctx.Stub.TxID = "buy2"
+	_, err = contract.BuyNow(ctx, "auction1")
+	assert.Error(t, err)
+}
+
+func TestBuyNowEndsWithBids(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	err := contract.SetBuyNow(ctx, "auction1", "500", "")
+	assert.NoError(t, err)
+
+	// without a threshold the first bid ends the offer
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 100)
+	actAs(ctx, "bidder2")
+	ctx.Stub.TxID = "buy"
+	_, err = contract.BuyNow(ctx, "auction1")
+	assert.Error(t, err)
+	assert.Equal(t, "open", getAuction(ctx).Status)
+}
+
+func TestBuyNowFromEscrow(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	err := contract.SetEscrow(ctx, "auction1", "full", "")
+	assert.NoError(t, err)
+	err = contract.SetBuyNow(ctx, "auction1", "300", "250")
+	assert.NoError(t, err)
+
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "bidder,bank"}
+	ctx.Stub.TxID = "credit"
+	err = contract.CreditBalance(ctx, "bidder1", "500")
+	assert.NoError(t, err)
+	err = contract.CreditBalance(ctx, "bidder2", "100")
+	assert.NoError(t, err)
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 200)
+
+	// the purchase reads committed state only, as on a peer
+	ctx.Stub.Writes = map[string][]byte{}
+	actAs(ctx, "bidder2")
+	ctx.Stub.TxID = "buy1"
+	_, err = contract.BuyNow(ctx, "auction1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "insufficient escrow balance")
+	ctx.Stub.Writes = map[string][]byte{}
+
+	// the lock of the buyer's earlier bid counts towards the price
+	actAs(ctx, "bidder1")
+	ctx.Stub.TxID = "buy2"
+	_, err = contract.BuyNow(ctx, "auction1")
+	assert.NoError(t, err)
+	ctx.Stub.Commit()
+
+	assert.Equal(t, "paid", getAuction(ctx).Settlement.State)
+	assert.Equal(t, auction.Balance{Owner: "bidder1", Available: usd(200), Locked: usd(0)}, balance(t, contract, ctx, "bidder1"))
+	assert.Equal(t, auction.Balance{Owner: "bidder2", Available: usd(100), Locked: usd(0)}, balance(t, contract, ctx, "bidder2"))
+	assert.Equal(t, auction.Balance{Owner: "user1", Available: usd(300), Locked: usd(0)}, balance(t, contract, ctx, "user1"))
+	for key := range ctx.Stub.State {
+		assert.False(t, strings.HasPrefix(key, "escrowlock:"), key)
+	}
+}
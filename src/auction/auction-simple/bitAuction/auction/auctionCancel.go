@@ -0,0 +1,390 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"fmt"
+	"time"
+
+	"bitAuction/events"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// default retraction windows of new auctions, in seconds
+const (
+	defaultRetractWindow = 300
+	defaultRetractCutoff = 600
+)
+
+// Cancellation records a seller's request to cancel an auction that has bids
+// and the organizations that approved it
+type Cancellation struct {
+	Reason      string    `json:"reason"`
+	RequestedBy string    `json:"requestedBy"`
+	RequestedAt time.Time `json:"requestedAt"`
+	Approvals   []string  `json:"approvals"`
+}
+
+// Invalidation records who made a bid invalid, when and why
+type Invalidation struct {
+	Kind   string    `json:"kind"`
+	Reason string    `json:"reason"`
+	Note   string    `json:"note,omitempty"`
+	By     string    `json:"by"`
+	At     time.Time `json:"at"`
+}
+
+// SetRetractionWindows lets the seller change how long bids may be retracted
+// after they are placed and how close to the time limit retractions stop.
+// A window of 0 disables retractions. The windows can only change before the first bid.
+func (s *SmartContract) SetRetractionWindows(ctx contractapi.TransactionContextInterface, auctionID string, window int, cutoff int) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = s.checkSellerBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+	if window < 0 || cutoff < 0 {
+		return fmt.Errorf("retraction windows cannot be negative")
+	}
+
+	auction.RetractWindow = window
+	auction.RetractCutoff = cutoff
+	return putAuction(ctx, auction)
+}
+
+// CancelAuction cancels an open auction. Before the first bid the seller can
+// cancel on their own; once bids exist a reason is required and every
+// organization of the auction has to approve with ApproveCancellation.
+func (s *SmartContract) CancelAuction(ctx contractapi.TransactionContextInterface, auctionID string, reason string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if auction.Seller != clientID {
+		return fmt.Errorf("auction can only be cancelled by the seller")
+	}
+	if auction.Status != "open" {
+		return fmt.Errorf("auction is not open, status is %v", auction.Status)
+	}
+
+	bids, err := s.QueryBids(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get bids: %v", err)
+	}
+
+	if len(bids) == 0 {
+		auction.Status = "cancelled"
+		if err = moveItem(ctx, auction, auction.Seller, false); err != nil {
+			return err
+		}
+		auction.Cancellation = &Cancellation{
+			Reason:      reason,
+			RequestedBy: clientID,
+			Approvals:   []string{},
+		}
+	} else {
+		if reason == "" {
+			return fmt.Errorf("a reason is required to cancel an auction that has bids")
+		}
+		now, err := s.oracleTimestamp(ctx, ctx.GetStub().GetTxID())
+		if err != nil {
+			return err
+		}
+		auction.Status = "pending-cancel"
+		auction.Cancellation = &Cancellation{
+			Reason:      reason,
+			RequestedBy: clientID,
+			RequestedAt: now,
+			Approvals:   []string{},
+		}
+	}
+
+	err = putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+	return emitAuctionEvents(ctx, auction, events.AuctionCancelled{
+		AuctionID: auctionID,
+		Status:    auction.Status,
+		Reason:    reason,
+		Approvals: auction.Cancellation.Approvals,
+	})
+}
+
+// ApproveCancellation is submitted by a member of each organization of the
+// auction to approve a pending cancellation. The seller cannot approve their
+// own request. Once all organizations approved, the auction is cancelled.
+func (s *SmartContract) ApproveCancellation(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if auction.Status != "pending-cancel" || auction.Cancellation == nil {
+		return fmt.Errorf("auction has no pending cancellation")
+	}
+
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if clientID == auction.Seller {
+		return fmt.Errorf("the seller cannot approve their own cancellation")
+	}
+	if err = requireRole(ctx, roleAuctioneer); err != nil {
+		return err
+	}
+	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if !contains(auction.Orgs, clientOrgID) {
+		return fmt.Errorf("organization %v does not participate in the auction", clientOrgID)
+	}
+	if contains(auction.Cancellation.Approvals, clientOrgID) {
+		return fmt.Errorf("organization %v already approved the cancellation", clientOrgID)
+	}
+
+	auction.Cancellation.Approvals = append(auction.Cancellation.Approvals, clientOrgID)
+	approved := true
+	for _, org := range auction.Orgs {
+		if !contains(auction.Cancellation.Approvals, org) {
+			approved = false
+		}
+	}
+	if approved {
+		auction.Status = "cancelled"
+		if err = releaseEscrowLocks(ctx, auction, "", nil); err != nil {
+			return err
+		}
+		if err = moveItem(ctx, auction, auction.Seller, false); err != nil {
+			return err
+		}
+	}
+
+	err = putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+	return emitAuctionEvents(ctx, auction, events.AuctionCancelled{
+		AuctionID: auctionID,
+		Status:    auction.Status,
+		Reason:    auction.Cancellation.Reason,
+		Approvals: auction.Cancellation.Approvals,
+	})
+}
+
+// WithdrawCancellation lets the seller withdraw their pending cancellation,
+// which opens the auction for bidding again
+func (s *SmartContract) WithdrawCancellation(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if auction.Status != "pending-cancel" || auction.Cancellation == nil {
+		return fmt.Errorf("auction has no pending cancellation")
+	}
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if clientID != auction.Seller {
+		return fmt.Errorf("only the seller can withdraw the cancellation")
+	}
+	return s.reopenAuction(ctx, auction)
+}
+
+// RejectCancellation is submitted by an auctioneer of an organization of the
+// auction to reject a pending cancellation, which opens the auction for
+// bidding again
+func (s *SmartContract) RejectCancellation(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if auction.Status != "pending-cancel" || auction.Cancellation == nil {
+		return fmt.Errorf("auction has no pending cancellation")
+	}
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if clientID == auction.Seller {
+		return fmt.Errorf("the seller withdraws their cancellation with WithdrawCancellation")
+	}
+	if err = requireRole(ctx, roleAuctioneer); err != nil {
+		return err
+	}
+	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if !contains(auction.Orgs, clientOrgID) {
+		return fmt.Errorf("organization %v does not participate in the auction", clientOrgID)
+	}
+	return s.reopenAuction(ctx, auction)
+}
+
+// reopenAuction drops the pending cancellation of an auction and opens it again
+func (s *SmartContract) reopenAuction(ctx contractapi.TransactionContextInterface, auction *Auction) error {
+	reason := auction.Cancellation.Reason
+	auction.Status = "open"
+	auction.Cancellation = nil
+	err := putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+	return emitAuctionEvents(ctx, auction, events.AuctionCancelled{
+		AuctionID: auction.AuctionID,
+		Status:    auction.Status,
+		Reason:    reason,
+		Approvals: []string{},
+	})
+}
+
+// RetractBid lets a bidder withdraw one of their bids. The bid is kept on the
+// ledger and marked invalid together with the reason. Retractions are only
+// possible within the retraction window of the auction. An anonymous bidder,
+// whose pseudonym changed since the bid, opens its link commitment with the
+// transient fields linkIdentity and linkSecret.
+func (s *SmartContract) RetractBid(ctx contractapi.TransactionContextInterface, auctionID string, txID string, reason string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = isAuctionOpenForBidding(auction); err != nil {
+		return err
+	}
+	if reason == "" {
+		return fmt.Errorf("a reason is required to retract a bid")
+	}
+	if auction.RetractWindow <= 0 {
+		return fmt.Errorf("bid retraction is disabled for this auction")
+	}
+
+	bid, err := s.getFullBid(ctx, auctionID, txID)
+	if err != nil {
+		return err
+	}
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	// the pseudonym of an anonymous bidder changes, they open the link instead
+	if bid.Bidder != clientID {
+		if err = openBidLink(ctx, bid, clientID); err != nil {
+			return err
+		}
+	}
+	if !bid.Valid {
+		return fmt.Errorf("bid is already invalid")
+	}
+
+	now, err := s.oracleTimestamp(ctx, ctx.GetStub().GetTxID())
+	if err != nil {
+		return err
+	}
+	if now.Sub(bid.Timestamp) > time.Duration(auction.RetractWindow)*time.Second {
+		return fmt.Errorf("retraction window of %d seconds has passed", auction.RetractWindow)
+	}
+	if auction.Timelimit.Sub(now) < time.Duration(auction.RetractCutoff)*time.Second {
+		return fmt.Errorf("bids cannot be retracted within %d seconds of the time limit", auction.RetractCutoff)
+	}
+
+	err = s.invalidateBid(ctx, auctionID, txID, bid, &Invalidation{
+		Kind:   "retracted",
+		Reason: reason,
+		By:     clientID,
+		At:     now,
+	})
+	if err != nil {
+		return err
+	}
+	return emitAuctionEvents(ctx, auction, events.BidRetracted{
+		AuctionID: auctionID,
+		TxID:      txID,
+		Reason:    reason,
+	})
+}
+
+// DeleteOrphanedBids lets the seller remove the bids that were never submitted
+// once the auction no longer accepts bids. It returns the number of deleted bids.
+func (s *SmartContract) DeleteOrphanedBids(ctx contractapi.TransactionContextInterface, auctionID string) (int, error) {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get auction: %v", err)
+	}
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get client identity %v", err)
+	}
+	if auction.Seller != clientID {
+		return 0, fmt.Errorf("orphaned bids can only be deleted by the seller")
+	}
+	if auction.Status == "open" {
+		return 0, fmt.Errorf("orphaned bids can only be deleted after the auction closed")
+	}
+
+	orphans, err := s.GetOrphanedBids(ctx, auctionID)
+	if err != nil {
+		return 0, err
+	}
+	for _, orphan := range orphans {
+		bidKey, err := ctx.GetStub().CreateCompositeKey(bidKeyType, []string{auctionID, orphan.TxID})
+		if err != nil {
+			return 0, fmt.Errorf("failed to create composite key: %v", err)
+		}
+		err = delAuctionData(ctx, auction.Collection, bidKey)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete bid: %v", err)
+		}
+	}
+	return len(orphans), nil
+}
+
+// checkSellerBeforeFirstBid verifies that the client is the seller and that
+// the auction is open and has not received any bids yet
+func (s *SmartContract) checkSellerBeforeFirstBid(ctx contractapi.TransactionContextInterface, auction *Auction) error {
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if auction.Seller != clientID {
+		return fmt.Errorf("auction settings can only be changed by the seller")
+	}
+	return s.checkBeforeFirstBid(ctx, auction)
+}
+
+// checkBeforeFirstBid verifies that the auction is open and has not received
+// any bids yet
+func (s *SmartContract) checkBeforeFirstBid(ctx contractapi.TransactionContextInterface, auction *Auction) error {
+	if auction.Status != "open" {
+		return fmt.Errorf("auction is not open, status is %v", auction.Status)
+	}
+	if auction.Session != nil {
+		return fmt.Errorf("auction settings cannot change once a live session has opened")
+	}
+	bids, err := s.QueryBids(ctx, auction.AuctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get bids: %v", err)
+	}
+	if len(bids) > 0 {
+		return fmt.Errorf("auction settings cannot change after the first bid")
+	}
+	sealedBids, err := getSealedBids(ctx, auction.AuctionID)
+	if err != nil {
+		return err
+	}
+	if len(sealedBids) > 0 {
+		return fmt.Errorf("auction settings cannot change after the first bid")
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package auction_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// formatOracleTime formats t the way the timeoracle chaincode returns it
+func formatOracleTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.999999999 -0700 MST")
+}
+
+func putOpenAuction(ctx *MockContext, timelimit time.Time) {
+	auctionJSON, _ := json.Marshal(auction.Auction{
+		AuctionID:     "auction1",
+		Type:          "auction",
+		ItemSold:      "Laptop",
+		Seller:        "user1",
+		Orgs:          []string{"Org1MSP"},
+		Status:        "open",
+		Timelimit:     timelimit,
+		Bids:          []auction.FullBid{},
+		RetractWindow: 300,
+		RetractCutoff: 600,
+	})
+	ctx.Stub.State["auction1"] = auctionJSON
+}
+
+func putAuctionState(ctx *MockContext, a auction.Auction) {
+	auctionJSON, _ := json.Marshal(a)
+	ctx.Stub.State[a.AuctionID] = auctionJSON
+}
+
+func putFullBid(ctx *MockContext, txID string, bid auction.FullBid) {
+	key, _ := ctx.Stub.CreateCompositeKey("fullbid", []string{"auction1", txID})
+	bidJSON, _ := json.Marshal(bid)
+	ctx.Stub.State[key] = bidJSON
+}
+
+func getAuction(ctx *MockContext) auction.Auction {
+	var a auction.Auction
+	_ = json.Unmarshal(ctx.Stub.State["auction1"], &a)
+	return a
+}
+
+func actAs(ctx *MockContext, user string) {
+	ctx.Identity.ID = base64.StdEncoding.EncodeToString([]byte(user))
+}
+
+func TestCancelAuctionBeforeFirstBid(t *testing.T) {
+	contract, ctx := setup()
+	putOpenAuction(ctx, time.Now().Add(1*time.Hour))
+
+	err := contract.CancelAuction(ctx, "auction1", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "cancelled", getAuction(ctx).Status)
+	assert.Equal(t, "AuctionCancelled", ctx.Stub.EventName)
+
+	// a cancelled auction can neither take bids nor be ended
+	_, err = contract.Bid(ctx, "auction1", "100")
+	assert.Error(t, err)
+	err = contract.EndAuction(ctx, "auction1")
+	assert.Error(t, err)
+}
+
+func TestCancelAuctionWithBidsNeedsReasonAndApproval(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	putOpenAuction(ctx, now.Add(1*time.Hour))
+	putFullBid(ctx, "tx0", auction.FullBid{Type: "bid", Price: usd(100), Bidder: "userA", Valid: true, Timestamp: now})
+
+	err := contract.CancelAuction(ctx, "auction1", "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "reason is required")
+
+	err = contract.CancelAuction(ctx, "auction1", "item was damaged")
+	assert.NoError(t, err)
+	assert.Equal(t, "pending-cancel", getAuction(ctx).Status)
+
+	// the seller cannot approve their own cancellation
+	err = contract.ApproveCancellation(ctx, "auction1")
+	assert.Error(t, err)
+
+	actAs(ctx, "approver")
+	err = contract.ApproveCancellation(ctx, "auction1")
+	assert.NoError(t, err)
+
+	cancelled := getAuction(ctx)
+	assert.Equal(t, "cancelled", cancelled.Status)
+	assert.Equal(t, "item was damaged", cancelled.Cancellation.Reason)
+	assert.Equal(t, []string{"Org1MSP"}, cancelled.Cancellation.Approvals)
+}
+
+func TestWithdrawAndRejectCancellation(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	putOpenAuction(ctx, now.Add(1*time.Hour))
+	putFullBid(ctx, "tx0", auction.FullBid{Type: "bid", Price: usd(100), Bidder: "userA", Valid: true, Timestamp: now})
+
+	err := contract.WithdrawCancellation(ctx, "auction1")
+	assert.Error(t, err)
+	err = contract.CancelAuction(ctx, "auction1", "item was damaged")
+	assert.NoError(t, err)
+	actAs(ctx, "approver")
+	err = contract.WithdrawCancellation(ctx, "auction1")
+	assert.Error(t, err)
+	actAs(ctx, "user1")
+	err = contract.WithdrawCancellation(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "open", getAuction(ctx).Status)
+	assert.Nil(t, getAuction(ctx).Cancellation)
+
+	// an auctioneer of the auction can reject a cancellation, the seller cannot
+	err = contract.CancelAuction(ctx, "auction1", "item was damaged")
+	assert.NoError(t, err)
+	err = contract.RejectCancellation(ctx, "auction1")
+	assert.Error(t, err)
+	actAs(ctx, "approver")
+	ctx.Identity.MSPID = "Org2MSP"
+	err = contract.RejectCancellation(ctx, "auction1")
+	assert.Error(t, err)
+	ctx.Identity.MSPID = "Org1MSP"
+	err = contract.RejectCancellation(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "open", getAuction(ctx).Status)
+
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 150)
+}
+
+func TestRetractBid(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	putOpenAuction(ctx, now.Add(1*time.Hour))
+	putFullBid(ctx, "tx0", auction.FullBid{Type: "bid", Price: usd(10000), Bidder: "user1", Valid: true, Timestamp: now.Add(-1 * time.Minute)})
+
+	err := contract.RetractBid(ctx, "auction1", "tx0", "")
+	assert.Error(t, err)
+
+	err = contract.RetractBid(ctx, "auction1", "tx0", "typo, meant 1000")
+	assert.NoError(t, err)
+	assert.Equal(t, "BidRetracted", ctx.Stub.EventName)
+
+	var bid auction.FullBid
+	_ = json.Unmarshal(ctx.Stub.State["fullbid:auction1:tx0"], &bid)
+	assert.False(t, bid.Valid)
+	assert.Equal(t, "retracted", bid.Invalidation.Kind)
+	assert.Equal(t, "typo, meant 1000", bid.Invalidation.Reason)
+	assert.Equal(t, "user1", bid.Invalidation.By)
+}
+
+func TestRetractBidOutsideWindow(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	putOpenAuction(ctx, now.Add(1*time.Hour))
+	putFullBid(ctx, "tx0", auction.FullBid{Type: "bid", Price: usd(100), Bidder: "user1", Valid: true, Timestamp: now.Add(-10 * time.Minute)})
+
+	err := contract.RetractBid(ctx, "auction1", "tx0", "changed my mind")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "retraction window")
+
+	// close to the time limit retractions are refused even for fresh bids
+	putOpenAuction(ctx, now.Add(5*time.Minute))
+	putFullBid(ctx, "tx1", auction.FullBid{Type: "bid", Price: usd(100), Bidder: "user1", Valid: true, Timestamp: now})
+	err = contract.RetractBid(ctx, "auction1", "tx1", "changed my mind")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "time limit")
+}
+
+func TestRetractedBidDoesNotWin(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	putOpenAuction(ctx, now.Add(-1*time.Hour))
+	putFullBid(ctx, "tx0", auction.FullBid{Type: "bid", Price: usd(10000), Bidder: "userA", Valid: false, Timestamp: now})
+	putFullBid(ctx, "tx1", auction.FullBid{Type: "bid", Price: usd(1000), Bidder: "userB", Valid: true, Timestamp: now})
+
+	err := contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	ended := getAuction(ctx)
+	assert.Equal(t, "userB", ended.Winner)
+	assert.Equal(t, usd(1000), ended.Price)
+}
@@ -0,0 +1,444 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"bitAuction/events"
+	"bitAuction/money"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// In a clock auction the price rises every round on a schedule derived from
+// oracle time. Bidders stay in by confirming each round with
+// ConfirmClockRound, which writes a clockround key of their own, so that
+// confirmations of different bidders never conflict. AdvanceClock closes the
+// rounds that are over and ends the auction once at most one bidder is left.
+const clockRoundKeyType = "clockround"
+
+// clockBidType is the object type of the full bid recording the winner of a
+// clock auction
+const clockBidType = "clock"
+
+// tie-breaks of a round in which every remaining bidder dropped out
+const (
+	tieBreakTimestamp = "timestamp"
+	tieBreakRandom    = "random"
+)
+
+// Clock is the price schedule of a clock auction. Round n starts n round
+// lengths after Start and its price is StartPrice plus n increments.
+type Clock struct {
+	Start       time.Time   `json:"start"`
+	StartPrice  money.Money `json:"startPrice"`
+	Increment   money.Money `json:"increment"`
+	RoundLength int         `json:"roundLength"`
+	// Round is the first round not closed yet, Active the bidders who stayed
+	// in the round before it
+	Round  int      `json:"round"`
+	Active []string `json:"active"`
+}
+
+// ClockConfirmation records that a bidder stays in a round of a clock auction
+// at the price of the round
+type ClockConfirmation struct {
+	AuctionID string      `json:"auctionID"`
+	Round     int         `json:"round"`
+	Bidder    string      `json:"bidder"`
+	Org       string      `json:"org"`
+	TxID      string      `json:"txID"`
+	Price     money.Money `json:"price"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// SetClock lets the seller run the auction as a Japanese ascending clock
+// auction. From start, every round lasts roundLength seconds and raises the
+// price by increment, beginning at startPrice. An empty start price makes it
+// a sealed deadline auction again. The clock can only change before the first
+// confirmation.
+func (s *SmartContract) SetClock(ctx contractapi.TransactionContextInterface, auctionID string, start string, startPrice string, increment string, roundLength int) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = s.checkSellerBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+	confirmations, err := getClockConfirmations(ctx, auctionID, 0)
+	if err != nil {
+		return err
+	}
+	if len(confirmations) > 0 {
+		return fmt.Errorf("the clock cannot change after the first confirmation")
+	}
+	if startPrice == "" {
+		auction.Clock = nil
+		return putAuction(ctx, auction)
+	}
+	if err = requirePublicAuction(auction, "clock auctions"); err != nil {
+		return err
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, start)
+	if err != nil {
+		return fmt.Errorf("invalid datetime format: %v", err)
+	}
+	if roundLength < 1 {
+		return fmt.Errorf("rounds last at least one second")
+	}
+	clockStart, err := parseBidPrice(auction, startPrice)
+	if err != nil {
+		return err
+	}
+	clockIncrement, err := parseBidPrice(auction, increment)
+	if err != nil {
+		return err
+	}
+	if settledOffChain(auction) {
+		return fmt.Errorf("reverse, multi-unit and package auctions cannot run a clock")
+	}
+	if auction.EscrowMode == escrowDeposit || auction.EscrowMode == escrowFull {
+		return fmt.Errorf("clock auctions do not lock funds, disable escrow first")
+	}
+	if auction.BuyNowPrice.IsPositive() {
+		return fmt.Errorf("clock auctions have no buy-now price, remove it first")
+	}
+
+	auction.Clock = &Clock{
+		Start:       t,
+		StartPrice:  clockStart,
+		Increment:   clockIncrement,
+		RoundLength: roundLength,
+		Active:      []string{},
+	}
+	return putAuction(ctx, auction)
+}
+
+// ConfirmClockRound confirms that the client stays in the current round of a
+// clock auction at the price of the round. The round is the one the Time
+// Oracle timestamp falls into. Any bidder can enter the first round, later
+// rounds only those who confirmed the round before. The function returns the
+// transaction ID that identifies the confirmation.
+func (s *SmartContract) ConfirmClockRound(ctx contractapi.TransactionContextInterface, auctionID string) (string, error) {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get auction: %v", err)
+	}
+	if auction.Clock == nil {
+		return "", fmt.Errorf("auction %v has no clock", auctionID)
+	}
+	if auction.Status != "open" {
+		return "", fmt.Errorf("auction is not open for bidding")
+	}
+
+	bidder, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get client identity: %v", err)
+	}
+	org, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get org: %v", err)
+	}
+	if err = s.checkBidder(ctx, auction); err != nil {
+		return "", err
+	}
+	if err = checkShillRule(auction, bidder, org); err != nil {
+		return "", err
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	Timestamp, err := s.oracleTimestamp(ctx, txID)
+	if err != nil {
+		return "", err
+	}
+	if Timestamp.Before(auction.Clock.Start) {
+		return "", fmt.Errorf("the clock starts at %v", auction.Clock.Start)
+	}
+	round := clockRound(auction.Clock, Timestamp)
+	if round < auction.Clock.Round {
+		return "", fmt.Errorf("round %d has already been closed", round)
+	}
+	existing, err := getClockConfirmation(ctx, auctionID, round, bidder)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		return "", fmt.Errorf("round %d has already been confirmed", round)
+	}
+	if round > 0 {
+		previous, err := getClockConfirmation(ctx, auctionID, round-1, bidder)
+		if err != nil {
+			return "", err
+		}
+		if previous == nil {
+			return "", fmt.Errorf("only bidders who confirmed round %d can stay in round %d", round-1, round)
+		}
+	}
+	price, err := clockPrice(auction.Clock, round)
+	if err != nil {
+		return "", err
+	}
+
+	confirmation := ClockConfirmation{
+		AuctionID: auctionID,
+		Round:     round,
+		Bidder:    bidder,
+		Org:       org,
+		TxID:      txID,
+		Price:     price,
+		Timestamp: Timestamp,
+	}
+	if err = putClockConfirmation(ctx, &confirmation); err != nil {
+		return "", err
+	}
+	err = emitEvents(ctx, events.ClockConfirmed{
+		AuctionID: auctionID,
+		Round:     round,
+		TxID:      txID,
+		Bidder:    bidder,
+		Price:     price,
+		Timestamp: Timestamp,
+	})
+	if err != nil {
+		return "", err
+	}
+	return txID, nil
+}
+
+// AdvanceClock closes every round of a clock auction that is over by the
+// Time Oracle timestamp. When a single bidder stays in a round, they win at
+// the price of the round. When every remaining bidder drops out in the same
+// round, the one who confirmed the round before first wins at its price, and
+// bidders who confirmed at the same time are drawn at random. Anyone can
+// submit it.
+func (s *SmartContract) AdvanceClock(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if auction.Clock == nil {
+		return fmt.Errorf("auction %v has no clock", auctionID)
+	}
+	if auction.Status != "open" {
+		return fmt.Errorf("auction is not open, status is %v", auction.Status)
+	}
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	now, err := s.oracleTimestamp(ctx, ctx.GetStub().GetTxID())
+	if err != nil {
+		return err
+	}
+
+	clock := auction.Clock
+	if now.Before(clockRoundEnd(clock, clock.Round)) {
+		return fmt.Errorf("round %d runs until %v", clock.Round, clockRoundEnd(clock, clock.Round))
+	}
+	evts := []events.Event{}
+	for !now.Before(clockRoundEnd(clock, clock.Round)) {
+		round := clock.Round
+		price, err := clockPrice(clock, round)
+		if err != nil {
+			return err
+		}
+		stayed, err := getClockConfirmations(ctx, auctionID, round)
+		if err != nil {
+			return err
+		}
+		closed := events.ClockRoundClosed{AuctionID: auctionID, Round: round, Price: price, Remaining: len(stayed), Dropped: []string{}}
+		bidders := []string{}
+		for _, confirmation := range stayed {
+			bidders = append(bidders, confirmation.Bidder)
+		}
+		for _, bidder := range clock.Active {
+			if !contains(bidders, bidder) {
+				closed.Dropped = append(closed.Dropped, bidder)
+			}
+		}
+
+		if len(stayed) > 1 {
+			clock.Active = bidders
+			clock.Round++
+			evts = append(evts, closed)
+			continue
+		}
+
+		// at most one bidder is left, the auction ends with this round
+		var winning *ClockConfirmation
+		if len(stayed) == 1 {
+			winning = stayed[0]
+		} else if round > 0 {
+			previous, err := getClockConfirmations(ctx, auctionID, round-1)
+			if err != nil {
+				return err
+			}
+			winning, closed.TieBreak = clockTieBreak(previous)
+		}
+		evts = append(evts, closed)
+		clock.Active = bidders
+		auction.Timelimit = clockRoundEnd(clock, round)
+		if winning == nil {
+			return s.closeAuction(ctx, auction, nil, outcomeNoSale, clientID, evts...)
+		}
+
+		winningBid := FullBid{
+			Type:      clockBidType,
+			TxID:      winning.TxID,
+			Price:     winning.Price,
+			Org:       winning.Org,
+			Bidder:    winning.Bidder,
+			Valid:     true,
+			Timestamp: winning.Timestamp,
+		}
+		if err = putFullBid(ctx, auctionID, winning.TxID, &winningBid); err != nil {
+			return err
+		}
+		return s.closeAuction(ctx, auction, &winningBid, outcomeBidding, clientID, evts...)
+	}
+
+	if err = putAuction(ctx, auction); err != nil {
+		return err
+	}
+	return emitEvents(ctx, evts...)
+}
+
+// QueryClockRound returns the confirmations of a round of a clock auction
+func (s *SmartContract) QueryClockRound(ctx contractapi.TransactionContextInterface, auctionID string, round int) ([]*ClockConfirmation, error) {
+	return getClockConfirmations(ctx, auctionID, round)
+}
+
+// clockTieBreak picks the winner among bidders who all dropped out in the
+// same round from their confirmations of the round before. The earliest
+// confirmation wins. Confirmations with the same timestamp are drawn from
+// with a seed hashed from their transaction IDs, which no single bidder
+// controls and every endorser computes alike.
+func clockTieBreak(previous []*ClockConfirmation) (*ClockConfirmation, string) {
+	if len(previous) == 0 {
+		return nil, ""
+	}
+	tied := []*ClockConfirmation{}
+	for _, confirmation := range previous {
+		if len(tied) > 0 && confirmation.Timestamp.After(tied[0].Timestamp) {
+			continue
+		}
+		if len(tied) > 0 && confirmation.Timestamp.Before(tied[0].Timestamp) {
+			tied = tied[:0]
+		}
+		tied = append(tied, confirmation)
+	}
+	if len(tied) == 1 {
+		return tied[0], tieBreakTimestamp
+	}
+
+	sort.Slice(tied, func(i, j int) bool { return tied[i].TxID < tied[j].TxID })
+	txIDs := []string{}
+	for _, confirmation := range tied {
+		txIDs = append(txIDs, confirmation.TxID)
+	}
+	hash := sha256.Sum256([]byte(strings.Join(txIDs, ",")))
+	draw := rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(hash[:8]))))
+	return tied[draw.Intn(len(tied))], tieBreakRandom
+}
+
+// clockRound returns the round of the clock at time t
+func clockRound(clock *Clock, t time.Time) int {
+	return int(t.Sub(clock.Start) / (time.Duration(clock.RoundLength) * time.Second))
+}
+
+// clockRoundEnd returns when a round of the clock is over
+func clockRoundEnd(clock *Clock, round int) time.Time {
+	return clock.Start.Add(time.Duration(round+1) * time.Duration(clock.RoundLength) * time.Second)
+}
+
+// clockPrice returns the price of a round of the clock
+func clockPrice(clock *Clock, round int) (money.Money, error) {
+	raise, err := clock.Increment.Times(int64(round))
+	if err != nil {
+		return money.Money{}, err
+	}
+	return clock.StartPrice.Add(raise)
+}
+
+// clockRoundKey returns the key of a bidder's confirmation of a round. Rounds
+// are zero padded so that the confirmations of a round share a key prefix.
+func clockRoundKey(ctx contractapi.TransactionContextInterface, auctionID string, round int, bidder string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(clockRoundKeyType, []string{auctionID, fmt.Sprintf("%010d", round), bidder})
+	if err != nil {
+		return "", fmt.Errorf("failed to create clock round key: %v", err)
+	}
+	return key, nil
+}
+
+func putClockConfirmation(ctx contractapi.TransactionContextInterface, confirmation *ClockConfirmation) error {
+	key, err := clockRoundKey(ctx, confirmation.AuctionID, confirmation.Round, confirmation.Bidder)
+	if err != nil {
+		return err
+	}
+	confirmationJSON, err := json.Marshal(confirmation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal clock confirmation: %v", err)
+	}
+	err = ctx.GetStub().PutState(key, confirmationJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put clock confirmation in state: %v", err)
+	}
+	return nil
+}
+
+// getClockConfirmation returns the bidder's confirmation of a round, nil
+// when they did not confirm it
+func getClockConfirmation(ctx contractapi.TransactionContextInterface, auctionID string, round int, bidder string) (*ClockConfirmation, error) {
+	key, err := clockRoundKey(ctx, auctionID, round, bidder)
+	if err != nil {
+		return nil, err
+	}
+	confirmationJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clock confirmation: %v", err)
+	}
+	if confirmationJSON == nil {
+		return nil, nil
+	}
+	var confirmation ClockConfirmation
+	err = json.Unmarshal(confirmationJSON, &confirmation)
+	if err != nil {
+		return nil, err
+	}
+	return &confirmation, nil
+}
+
+// getClockConfirmations returns the confirmations of a round ordered by bidder
+func getClockConfirmations(ctx contractapi.TransactionContextInterface, auctionID string, round int) ([]*ClockConfirmation, error) {
+	iter, err := ctx.GetStub().GetStateByPartialCompositeKey(clockRoundKeyType, []string{auctionID, fmt.Sprintf("%010d", round)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get confirmations of round %d: %v", round, err)
+	}
+	defer iter.Close()
+
+	confirmations := []*ClockConfirmation{}
+	for iter.HasNext() {
+		queryResponse, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		var confirmation ClockConfirmation
+		err = json.Unmarshal(queryResponse.Value, &confirmation)
+		if err != nil {
+			return nil, err
+		}
+		confirmations = append(confirmations, &confirmation)
+	}
+	return confirmations, nil
+}
@@ -0,0 +1,136 @@
+package auction_test
+
+import (
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+	"bitAuction/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// clockAuction creates an auction whose clock starts at start at a price of
+// 100, rising by 10 every minute
+func clockAuction(t *testing.T, contract *auction.SmartContract, ctx *MockContext, start time.Time) {
+	ctx.Stub.OracleTime = formatOracleTime(start)
+	createAuction(t, contract, ctx, start.Add(1*time.Hour))
+	err := contract.SetClock(ctx, "auction1", start.Format(time.RFC3339Nano), "100", "10", 60)
+	assert.NoError(t, err)
+}
+
+func confirmRound(t *testing.T, contract *auction.SmartContract, ctx *MockContext, bidder string, txID string, at time.Time) error {
+	actAs(ctx, bidder)
+	ctx.Stub.TxID = txID
+	ctx.Stub.OracleTime = formatOracleTime(at)
+	_, err := contract.ConfirmClockRound(ctx, "auction1")
+	return err
+}
+
+func advanceClock(ctx *MockContext, contract *auction.SmartContract, at time.Time) error {
+	actAs(ctx, "user1")
+	ctx.Stub.TxID = "advance" + at.Format("150405")
+	ctx.Stub.OracleTime = formatOracleTime(at)
+	return contract.AdvanceClock(ctx, "auction1")
+}
+
+func TestClockAuctionLastBidderWins(t *testing.T) {
+	contract, ctx := setup()
+	start := time.Now()
+	clockAuction(t, contract, ctx, start)
+
+	actAs(ctx, "bidder1")
+	_, err := contract.PlaceBid(ctx, "auction1", "500")
+	assert.Error(t, err)
+
+	assert.NoError(t, confirmRound(t, contract, ctx, "bidder1", "tx1", start.Add(1*time.Second)))
+	assert.NoError(t, confirmRound(t, contract, ctx, "bidder2", "tx2", start.Add(2*time.Second)))
+	assert.NoError(t, confirmRound(t, contract, ctx, "bidder3", "tx3", start.Add(3*time.Second)))
+	assert.Error(t, confirmRound(t, contract, ctx, "bidder3", "tx4", start.Add(4*time.Second)))
+	assert.Error(t, advanceClock(ctx, contract, start.Add(30*time.Second)))
+
+	// round 1 at 110, bidder3 drops out
+	assert.NoError(t, confirmRound(t, contract, ctx, "bidder1", "tx5", start.Add(61*time.Second)))
+	assert.NoError(t, confirmRound(t, contract, ctx, "bidder2", "tx6", start.Add(62*time.Second)))
+	assert.NoError(t, advanceClock(ctx, contract, start.Add(65*time.Second)))
+	a := getAuction(ctx)
+	assert.Equal(t, 1, a.Clock.Round)
+	assert.Equal(t, "ClockRoundClosed", ctx.Stub.EventName)
+
+	// round 2 at 120, only bidder2 stays in
+	assert.NoError(t, confirmRound(t, contract, ctx, "bidder2", "tx7", start.Add(125*time.Second)))
+	assert.Error(t, confirmRound(t, contract, ctx, "bidder3", "tx8", start.Add(126*time.Second)))
+	assert.NoError(t, advanceClock(ctx, contract, start.Add(190*time.Second)))
+
+	a = getAuction(ctx)
+	assert.Equal(t, "ended", a.Status)
+	assert.Equal(t, "bidder2", a.Winner)
+	assert.Equal(t, usd(120), a.Price)
+	assert.NotNil(t, a.Settlement)
+	evts := decodeEvents(t, ctx)
+	assert.Equal(t, "AuctionEnded", ctx.Stub.EventName)
+	closed, ok := evts[1].(*events.ClockRoundClosed)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"bidder3"}, closed.Dropped)
+	closed, ok = evts[2].(*events.ClockRoundClosed)
+	assert.True(t, ok)
+	assert.Equal(t, 2, closed.Round)
+	assert.Equal(t, []string{"bidder1"}, closed.Dropped)
+
+	actAs(ctx, "user1")
+	err = contract.EndAuction(ctx, "auction1")
+	assert.Error(t, err)
+}
+
+func TestClockAuctionSimultaneousDropOut(t *testing.T) {
+	contract, ctx := setup()
+	start := time.Now()
+	clockAuction(t, contract, ctx, start)
+
+	// both drop out in round 1, the first to confirm round 0 wins at its price
+	assert.NoError(t, confirmRound(t, contract, ctx, "bidder2", "tx1", start.Add(2*time.Second)))
+	assert.NoError(t, confirmRound(t, contract, ctx, "bidder1", "tx2", start.Add(1*time.Second)))
+	assert.NoError(t, advanceClock(ctx, contract, start.Add(121*time.Second)))
+
+	a := getAuction(ctx)
+	assert.Equal(t, "bidder1", a.Winner)
+	assert.Equal(t, usd(100), a.Price)
+	closed, ok := decodeEvents(t, ctx)[2].(*events.ClockRoundClosed)
+	assert.True(t, ok)
+	assert.Equal(t, "timestamp", closed.TieBreak)
+}
+
+func TestClockAuctionRandomTieBreak(t *testing.T) {
+	winners := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		contract, ctx := setup()
+		start := time.Now()
+		clockAuction(t, contract, ctx, start)
+		assert.NoError(t, confirmRound(t, contract, ctx, "bidder1", "tx1", start.Add(1*time.Second)))
+		assert.NoError(t, confirmRound(t, contract, ctx, "bidder2", "tx2", start.Add(1*time.Second)))
+		assert.NoError(t, advanceClock(ctx, contract, start.Add(121*time.Second)))
+
+		a := getAuction(ctx)
+		assert.Contains(t, []string{"bidder1", "bidder2"}, a.Winner)
+		winners[a.Winner] = true
+		closed, ok := decodeEvents(t, ctx)[2].(*events.ClockRoundClosed)
+		assert.True(t, ok)
+		assert.Equal(t, "random", closed.TieBreak)
+	}
+	// the draw is seeded by the transaction IDs, so it repeats
+	assert.Len(t, winners, 1)
+}
+
+func TestClockAuctionNoSale(t *testing.T) {
+	contract, ctx := setup()
+	start := time.Now()
+	clockAuction(t, contract, ctx, start)
+	err := contract.SetBuyNow(ctx, "auction1", "500", "")
+	assert.Error(t, err)
+
+	assert.NoError(t, advanceClock(ctx, contract, start.Add(61*time.Second)))
+	a := getAuction(ctx)
+	assert.Equal(t, "ended", a.Status)
+	assert.Equal(t, "no-sale", a.Outcome)
+	assert.Equal(t, "", a.Winner)
+}
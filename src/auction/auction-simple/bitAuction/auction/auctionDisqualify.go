@@ -0,0 +1,181 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"bitAuction/events"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const bidAuditKeyType = "bidaudit"
+
+// reason codes for disqualifying a bid
+var disqualificationReasons = []string{"kyc-failed", "duplicate", "timestamp-dispute", "other"}
+
+// BidAuditEntry records a change to the validity of a bid
+type BidAuditEntry struct {
+	AuctionID string    `json:"auctionID"`
+	TxID      string    `json:"txID"`
+	Action    string    `json:"action"`
+	Reason    string    `json:"reason"`
+	Note      string    `json:"note,omitempty"`
+	By        string    `json:"by"`
+	Org       string    `json:"org"`
+	At        time.Time `json:"at"`
+	AuditTxID string    `json:"auditTxID"`
+}
+
+// InvalidateBid lets an auctioneer or auditor disqualify a bid of an auction
+// that is open, or that ended and whose settlement is not paid yet. reasonCode
+// is one of kyc-failed, duplicate, timestamp-dispute or other, and other needs
+// a note explaining it. The leader of the auction is recomputed without the
+// bid, and when it was the bid of the buyer the item goes to the best
+// remaining bid.
+func (s *SmartContract) InvalidateBid(ctx contractapi.TransactionContextInterface, auctionID string, txID string, reasonCode string, note string) error {
+	if err := requireRole(ctx, roleAuctioneer, roleAuditor); err != nil {
+		return err
+	}
+	if !contains(disqualificationReasons, reasonCode) {
+		return fmt.Errorf("unknown reason code %v", reasonCode)
+	}
+	if reasonCode == "other" && note == "" {
+		return fmt.Errorf("a note is required for reason code other")
+	}
+
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if auction.Status != "open" && !awaitingBuyer(auction) {
+		return fmt.Errorf("bids can only be invalidated until the settlement is paid, status is %v", auction.Status)
+	}
+
+	bid, err := s.getFullBid(ctx, auctionID, txID)
+	if err != nil {
+		return err
+	}
+	if !bid.Valid {
+		return fmt.Errorf("bid is already invalid")
+	}
+
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	now, err := s.oracleTimestamp(ctx, ctx.GetStub().GetTxID())
+	if err != nil {
+		return err
+	}
+
+	err = s.invalidateBid(ctx, auctionID, txID, bid, &Invalidation{
+		Kind:   "disqualified",
+		Reason: reasonCode,
+		Note:   note,
+		By:     clientID,
+		At:     now,
+	})
+	if err != nil {
+		return err
+	}
+	evts := []events.Event{events.BidInvalidated{AuctionID: auctionID, TxID: txID, ReasonCode: reasonCode, By: clientID}}
+	if auction.Status == "ended" && auction.Settlement.BidTxID == txID {
+		evt, err := s.reselectBuyer(ctx, auction, txID, clientID, now)
+		if err != nil {
+			return err
+		}
+		evts = append(evts, evt)
+	}
+	return emitAuctionEvents(ctx, auction, evts...)
+}
+
+// GetBidHistory returns who invalidated which bids of an auction and when,
+// oldest first. With txID set only the history of that bid is returned.
+func (s *SmartContract) GetBidHistory(ctx contractapi.TransactionContextInterface, auctionID string, txID string) ([]*BidAuditEntry, error) {
+	collection, err := auctionCollection(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	attributes := []string{auctionID}
+	if txID != "" {
+		attributes = append(attributes, txID)
+	}
+	iter, err := getAuctionDataByPartialKey(ctx, collection, bidAuditKeyType, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bid history of auction %s: %v", auctionID, err)
+	}
+	defer iter.Close()
+
+	entries := []*BidAuditEntry{}
+	for iter.HasNext() {
+		queryResponse, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry BidAuditEntry
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].At.Before(entries[j].At) })
+	return entries, nil
+}
+
+// invalidateBid marks a bid invalid, keeps the leader of the auction correct
+// and records the change in the bid history
+func (s *SmartContract) invalidateBid(ctx contractapi.TransactionContextInterface, auctionID string, txID string, bid *FullBid, invalidation *Invalidation) error {
+	bid.Valid = false
+	bid.Invalidation = invalidation
+	err := putFullBid(ctx, auctionID, txID, bid)
+	if err != nil {
+		return err
+	}
+	err = s.leaderAfterInvalidation(ctx, auctionID, txID)
+	if err != nil {
+		return err
+	}
+
+	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	auditTxID := ctx.GetStub().GetTxID()
+	entry := BidAuditEntry{
+		AuctionID: auctionID,
+		TxID:      txID,
+		Action:    invalidation.Kind,
+		Reason:    invalidation.Reason,
+		Note:      invalidation.Note,
+		By:        invalidation.By,
+		Org:       clientOrgID,
+		At:        invalidation.At,
+		AuditTxID: auditTxID,
+	}
+	entryKey, err := ctx.GetStub().CreateCompositeKey(bidAuditKeyType, []string{auctionID, txID, auditTxID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	collection, err := auctionCollection(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+	err = putAuctionData(ctx, collection, entryKey, entryJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put bid history: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package auction_test
+
+import (
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+	"bitAuction/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvalidateBid(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 100)
+	actAs(ctx, "bidder2")
+	submitBid(t, contract, ctx, "tx2", 500)
+	err := contract.ConsolidateLeader(ctx, "auction1")
+	assert.NoError(t, err)
+
+	// bidders cannot disqualify bids
+	ctx.Stub.TxID = "tx3"
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "bidder"}
+	err = contract.InvalidateBid(ctx, "auction1", "tx2", "kyc-failed", "")
+	assert.Error(t, err)
+
+	actAs(ctx, "auditor")
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "auditor"}
+	err = contract.InvalidateBid(ctx, "auction1", "tx2", "fraud", "")
+	assert.Error(t, err)
+	err = contract.InvalidateBid(ctx, "auction1", "tx2", "other", "")
+	assert.Error(t, err)
+	err = contract.InvalidateBid(ctx, "auction1", "tx2", "kyc-failed", "documents expired")
+	assert.NoError(t, err)
+	assert.Equal(t, "BidInvalidated", ctx.Stub.EventName)
+
+	leader, err := contract.GetCurrentLeader(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "tx1", leader.TxID)
+
+	history, err := contract.GetBidHistory(ctx, "auction1", "")
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, &auction.BidAuditEntry{
+		AuctionID: "auction1",
+		TxID:      "tx2",
+		Action:    "disqualified",
+		Reason:    "kyc-failed",
+		Note:      "documents expired",
+		By:        "auditor",
+		Org:       "Org1MSP",
+		At:        history[0].At,
+		AuditTxID: "tx3",
+	}, history[0])
+}
+
+func TestInvalidatedBidDoesNotWin(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 100)
+	actAs(ctx, "bidder2")
+	submitBid(t, contract, ctx, "tx2", 500)
+
+	ctx.Stub.TxID = "tx3"
+	actAs(ctx, "auctioneer")
+	err := contract.InvalidateBid(ctx, "auction1", "tx2", "duplicate", "")
+	assert.NoError(t, err)
+
+	// the retraction is recorded in the same history
+	ctx.Stub.TxID = "tx4"
+	actAs(ctx, "bidder1")
+	err = contract.RetractBid(ctx, "auction1", "tx1", "mistake")
+	assert.NoError(t, err)
+	history, err := contract.GetBidHistory(ctx, "auction1", "tx1")
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, "retracted", history[0].Action)
+
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-1 * time.Minute)
+	putAuctionState(ctx, a)
+	actAs(ctx, "user1")
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "", getAuction(ctx).Winner)
+}
+
+func TestInvalidateBidAfterEnd(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+	endedAuction(t, contract, ctx, now)
+
+	// the winning bid is disqualified before payment, reading committed state
+	// only, and the item goes to the runner-up
+	ctx.Stub.Writes = map[string][]byte{}
+	ctx.Stub.TxID = "tx3"
+	actAs(ctx, "auditor")
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "auditor"}
+	err := contract.InvalidateBid(ctx, "auction1", "tx2", "kyc-failed", "documents expired")
+	assert.NoError(t, err)
+	ctx.Stub.Commit()
+	evts := decodeEvents(t, ctx)
+	assert.Len(t, evts, 2)
+	changed, ok := evts[1].(*events.SettlementChanged)
+	assert.True(t, ok)
+	assert.Equal(t, "bidder1", changed.Buyer)
+
+	a := getAuction(ctx)
+	assert.Equal(t, "bidder1", a.Winner)
+	assert.Equal(t, usd(100), a.Price)
+	assert.Equal(t, "awaiting-payment", a.Settlement.State)
+	assert.Equal(t, "tx1", a.Settlement.BidTxID)
+	assert.Equal(t, usd(100), a.Settlement.Price)
+
+	// without a remaining bid the auction ends without a sale
+	ctx.Stub.TxID = "tx4"
+	err = contract.InvalidateBid(ctx, "auction1", "tx1", "duplicate", "")
+	assert.NoError(t, err)
+	ctx.Stub.Commit()
+	a = getAuction(ctx)
+	assert.Equal(t, "", a.Winner)
+	assert.Equal(t, "no-sale", a.Outcome)
+	assert.Nil(t, a.Settlement)
+}
+
+func TestInvalidateBidAfterPayment(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+	endedAuction(t, contract, ctx, now)
+	err := contract.ConfirmPayment(ctx, "auction1")
+	assert.NoError(t, err)
+
+	ctx.Stub.TxID = "tx3"
+	actAs(ctx, "auditor")
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "auditor"}
+	err = contract.InvalidateBid(ctx, "auction1", "tx2", "kyc-failed", "documents expired")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "until the settlement is paid")
+}
@@ -0,0 +1,552 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"bitAuction/money"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Escrow balances are kept as entries under their own keys, one per change,
+// so that crediting a balance never conflicts with other transactions. Only
+// changes that take funds away read the balance to keep it from going negative.
+const (
+	escrowEntryKeyType = "escrow"
+	escrowLockKeyType  = "escrowlock"
+)
+
+// parties of an escrow entry. They are part of the entry key, so that the
+// entries one transaction writes for an owner under the same memo, for example
+// a seller paying themselves, do not overwrite each other.
+const (
+	entryHolder = "holder"
+	entryBidder = "bidder"
+	entryBuyer  = "buyer"
+	entrySeller = payoutSeller
+)
+
+// escrow modes of an auction. Auctions created before escrow existed have
+// an empty mode and are settled off-chain.
+const (
+	escrowNone    = "none"
+	escrowDeposit = "deposit"
+	escrowFull    = "full"
+)
+
+// EscrowEntry is one change to the available and locked funds of an owner,
+// both in the same currency
+type EscrowEntry struct {
+	Owner     string      `json:"owner"`
+	Available money.Money `json:"available"`
+	Locked    money.Money `json:"locked"`
+	Memo      string      `json:"memo"`
+	TxID      string      `json:"txID"`
+}
+
+// Balance holds the escrow funds of an owner in one currency. Locked funds
+// back bids on auctions that have not ended yet.
+type Balance struct {
+	Owner     string      `json:"owner"`
+	Available money.Money `json:"available"`
+	Locked    money.Money `json:"locked"`
+}
+
+// EscrowLock records the funds a bidder has locked on an auction
+type EscrowLock struct {
+	AuctionID string      `json:"auctionID"`
+	Bidder    string      `json:"bidder"`
+	Amount    money.Money `json:"amount"`
+}
+
+// CreditBalance adds funds to the escrow balance of owner. It can be
+// submitted by identities with the bank role or by organization admins.
+// amount is in the default currency unless it names one, for example "100.00 EUR".
+func (s *SmartContract) CreditBalance(ctx contractapi.TransactionContextInterface, owner string, amount string) error {
+	if err := requireFundsManager(ctx); err != nil {
+		return err
+	}
+	credit, err := parseEscrowAmount(amount)
+	if err != nil {
+		return err
+	}
+	return addEscrowEntry(ctx, owner, entryHolder, "credit", credit, money.Zero(credit.Currency))
+}
+
+// DebitBalance withdraws available funds from the escrow balance of owner,
+// for example when the bank pays them out. The balance cannot go negative.
+func (s *SmartContract) DebitBalance(ctx contractapi.TransactionContextInterface, owner string, amount string) error {
+	if err := requireFundsManager(ctx); err != nil {
+		return err
+	}
+	debit, err := parseEscrowAmount(amount)
+	if err != nil {
+		return err
+	}
+	debit, err = debit.Neg()
+	if err != nil {
+		return err
+	}
+	return addEscrowEntry(ctx, owner, entryHolder, "debit", debit, money.Zero(debit.Currency))
+}
+
+// GetBalance returns the escrow balance of owner in currency, the default
+// currency when empty
+func (s *SmartContract) GetBalance(ctx contractapi.TransactionContextInterface, owner string, currency string) (*Balance, error) {
+	if currency == "" {
+		currency = money.DefaultCurrency
+	}
+	if _, err := money.Exponent(currency); err != nil {
+		return nil, err
+	}
+	return getBalance(ctx, owner, currency)
+}
+
+// parseEscrowAmount reads a positive amount of funds
+func parseEscrowAmount(amount string) (money.Money, error) {
+	m, err := money.Parse(amount, money.DefaultCurrency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	if !m.IsPositive() {
+		return money.Money{}, fmt.Errorf("invalid amount: %v", m)
+	}
+	return m, nil
+}
+
+// SetEscrow lets the seller require bids to be backed by escrow funds. In
+// "deposit" mode every bidder locks deposit once, in "full" mode the amount of
+// their highest bid. "none" settles the auction off-chain. The deposit is in
+// the currency of the auction and ignored by the other modes. The mode can
+// only change before the first bid.
+func (s *SmartContract) SetEscrow(ctx contractapi.TransactionContextInterface, auctionID string, mode string, deposit string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = s.checkSellerBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+	if settledOffChain(auction) {
+		return fmt.Errorf("reverse, multi-unit and package auctions are settled off-chain")
+	}
+	if auction.Clock != nil && mode != escrowNone {
+		return fmt.Errorf("clock auctions do not lock funds")
+	}
+	if auction.Sealed != nil && mode != escrowFull {
+		return fmt.Errorf("sealed bids are proven against the escrow of the bidder, which needs full escrow")
+	}
+	if mode != escrowNone {
+		if err = requirePublicAuction(auction, "escrow funds"); err != nil {
+			return err
+		}
+	}
+	depositAmount := money.Zero(auction.Currency)
+	switch mode {
+	case escrowNone, escrowFull:
+	case escrowDeposit:
+		depositAmount, err = money.Parse(deposit, auction.Currency)
+		if err != nil {
+			return err
+		}
+		if depositAmount.Currency != auction.Currency {
+			return fmt.Errorf("the deposit must be in %v", auction.Currency)
+		}
+		if !depositAmount.IsPositive() {
+			return fmt.Errorf("deposit mode needs a positive deposit")
+		}
+	default:
+		return fmt.Errorf("unknown escrow mode %v", mode)
+	}
+
+	auction.EscrowMode = mode
+	auction.Deposit = depositAmount
+	return putAuction(ctx, auction)
+}
+
+// PayFromEscrow lets the buyer of an auction awaiting payment pay the price
+// and buyer's premium from their escrow balance, for example a runner-up who accepted an offer
+func (s *SmartContract) PayFromEscrow(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, clientID, now, err := s.settlementStep(ctx, auctionID, settlementAwaitingPayment)
+	if err != nil {
+		return err
+	}
+	if clientID != auction.Settlement.Buyer {
+		return fmt.Errorf("only the buyer can pay for the auction")
+	}
+	if err = requirePublicAuction(auction, "escrow funds"); err != nil {
+		return err
+	}
+
+	paid, err := payFromEscrow(ctx, auction)
+	if err != nil {
+		return err
+	}
+	if !paid {
+		return fmt.Errorf("insufficient escrow balance to pay %v", settlementFees(auction).BuyerPays)
+	}
+	if err = recordFeeRevenue(ctx, auction, now); err != nil {
+		return err
+	}
+	auction.Settlement.Deadline = deadlineAfter(now, auction.DeliveryPeriod)
+	return s.advanceSettlement(ctx, auction, settlementPaid, clientID, now)
+}
+
+// requireFundsManager checks that the client may credit and debit balances
+func requireFundsManager(ctx contractapi.TransactionContextInterface) error {
+	if err := requireOrgAdmin(ctx); err == nil {
+		return nil
+	}
+	if err := requireRole(ctx, roleBank); err != nil {
+		return fmt.Errorf("only banks and organization admins can move funds: %v", err)
+	}
+	return nil
+}
+
+// lockBidFunds locks the funds a bid on an escrow auction needs, topping up
+// the bidder's existing lock on the auction
+func lockBidFunds(ctx contractapi.TransactionContextInterface, auction *Auction, bidder string, price money.Money) error {
+	var needed money.Money
+	switch auction.EscrowMode {
+	case escrowDeposit:
+		needed = auction.Deposit
+	case escrowFull:
+		needed = price
+	default:
+		return nil
+	}
+
+	lock, err := getEscrowLock(ctx, auction.AuctionID, bidder)
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		lock = &EscrowLock{AuctionID: auction.AuctionID, Bidder: bidder, Amount: money.Zero(needed.Currency)}
+	}
+	cmp, err := lock.Amount.Cmp(needed)
+	if err != nil {
+		return err
+	}
+	if cmp >= 0 {
+		return nil
+	}
+
+	topUp, err := needed.Sub(lock.Amount)
+	if err != nil {
+		return err
+	}
+	withdrawn, err := topUp.Neg()
+	if err != nil {
+		return err
+	}
+	err = addEscrowEntry(ctx, bidder, entryBidder, "lock:"+auction.AuctionID, withdrawn, topUp)
+	if err != nil {
+		return fmt.Errorf("failed to lock funds for the bid: %v", err)
+	}
+	lock.Amount = needed
+	return putEscrowLock(ctx, lock)
+}
+
+// endEscrow releases the locks of the bidders that lost an escrow auction, less
+// what bidders who did not open a sealed bid forfeit, and pays the seller from
+// the winner's funds. It reports whether the winner paid.
+func endEscrow(ctx contractapi.TransactionContextInterface, auction *Auction) (bool, error) {
+	buyer := ""
+	if auction.Settlement != nil {
+		buyer = auction.Settlement.Buyer
+	}
+	forfeits, err := unopenedForfeits(ctx, auction)
+	if err != nil {
+		return false, err
+	}
+	err = releaseEscrowLocks(ctx, auction, buyer, forfeits)
+	if err != nil {
+		return false, err
+	}
+	if auction.Settlement == nil {
+		return false, nil
+	}
+	return payFromEscrow(ctx, auction)
+}
+
+// payFromEscrow moves what the buyer pays from their lock and available funds
+// to the seller and the platform organizations as the fee breakdown of the
+// settlement says. It reports false when the buyer does not have enough funds.
+func payFromEscrow(ctx contractapi.TransactionContextInterface, auction *Auction) (bool, error) {
+	fees := settlementFees(auction)
+	price := fees.BuyerPays
+	buyer := auction.Settlement.Buyer
+	lock, err := getEscrowLock(ctx, auction.AuctionID, buyer)
+	if err != nil {
+		return false, err
+	}
+	if lock == nil {
+		lock = &EscrowLock{AuctionID: auction.AuctionID, Bidder: buyer, Amount: money.Zero(price.Currency)}
+	}
+
+	balance, err := getBalance(ctx, buyer, price.Currency)
+	if err != nil {
+		return false, err
+	}
+	funds, err := balance.Available.Add(lock.Amount)
+	if err != nil {
+		return false, err
+	}
+	cmp, err := funds.Cmp(price)
+	if err != nil {
+		return false, err
+	}
+	if cmp < 0 {
+		return false, nil
+	}
+
+	// the lock and whatever the price exceeds it by leave the buyer's balance
+	memo := "payout:" + auction.AuctionID
+	available, err := lock.Amount.Sub(price)
+	if err != nil {
+		return false, err
+	}
+	unlocked, err := lock.Amount.Neg()
+	if err != nil {
+		return false, err
+	}
+	err = addEscrowEntry(ctx, buyer, entryBuyer, memo, available, unlocked)
+	if err != nil {
+		return false, err
+	}
+	err = deleteEscrowLock(ctx, lock)
+	if err != nil {
+		return false, err
+	}
+
+	for _, payout := range fees.Payouts {
+		if !payout.Amount.IsPositive() {
+			continue
+		}
+		owner, payoutMemo := payout.Party, memo
+		if payout.Role == payoutPlatform {
+			owner, payoutMemo = orgAccount(payout.Party), "fee:"+auction.AuctionID
+		}
+		err = addEscrowEntry(ctx, owner, payout.Role, payoutMemo, payout.Amount, money.Zero(price.Currency))
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// forfeitEscrowLock pays the funds a defaulting buyer locked on the auction
+// to the seller
+func forfeitEscrowLock(ctx contractapi.TransactionContextInterface, auction *Auction, buyer string) error {
+	lock, err := getEscrowLock(ctx, auction.AuctionID, buyer)
+	if err != nil || lock == nil {
+		return err
+	}
+	memo := "forfeit:" + auction.AuctionID
+	unlocked, err := lock.Amount.Neg()
+	if err != nil {
+		return err
+	}
+	zero := money.Zero(lock.Amount.Currency)
+	err = addEscrowEntry(ctx, buyer, entryBuyer, memo, zero, unlocked)
+	if err != nil {
+		return err
+	}
+	err = addEscrowEntry(ctx, auction.Seller, entrySeller, memo, lock.Amount, zero)
+	if err != nil {
+		return err
+	}
+	return deleteEscrowLock(ctx, lock)
+}
+
+// releaseEscrowLocks returns the locked funds of every bidder on the auction
+// except keep to their available balance. Bidders in forfeits pay the amount
+// given out of their lock to the seller.
+func releaseEscrowLocks(ctx contractapi.TransactionContextInterface, auction *Auction, keep string, forfeits map[string]money.Money) error {
+	auctionID := auction.AuctionID
+	iter, err := ctx.GetStub().GetStateByPartialCompositeKey(escrowLockKeyType, []string{auctionID})
+	if err != nil {
+		return fmt.Errorf("failed to get escrow locks of auction %v: %v", auctionID, err)
+	}
+	defer iter.Close()
+
+	forfeited := money.Zero(auction.Currency)
+	for iter.HasNext() {
+		queryResponse, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		var lock EscrowLock
+		err = json.Unmarshal(queryResponse.Value, &lock)
+		if err != nil {
+			return err
+		}
+		if lock.Bidder == keep {
+			continue
+		}
+		unlocked, err := lock.Amount.Neg()
+		if err != nil {
+			return err
+		}
+		released := lock.Amount
+		if forfeit, ok := forfeits[lock.Bidder]; ok {
+			cmp, err := forfeit.Cmp(lock.Amount)
+			if err != nil {
+				return err
+			}
+			if cmp > 0 {
+				forfeit = lock.Amount
+			}
+			if released, err = lock.Amount.Sub(forfeit); err != nil {
+				return err
+			}
+			if forfeited, err = forfeited.Add(forfeit); err != nil {
+				return err
+			}
+		}
+		err = addEscrowEntry(ctx, lock.Bidder, entryBidder, "release:"+auctionID, released, unlocked)
+		if err != nil {
+			return err
+		}
+		err = deleteEscrowLock(ctx, &lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !forfeited.IsPositive() {
+		return nil
+	}
+	// all forfeits reach the seller in one entry, entries of the same memo
+	// share a key
+	return addEscrowEntry(ctx, auction.Seller, entrySeller, "forfeit:"+auctionID, forfeited, money.Zero(forfeited.Currency))
+}
+
+// orgAccount is the escrow owner that collects the fees of an organization
+func orgAccount(org string) string {
+	return "msp::" + org
+}
+
+// addEscrowEntry records a change to the balance of owner as party under a key
+// of its own. Changes that reduce the balance check that it does not go negative.
+func addEscrowEntry(ctx contractapi.TransactionContextInterface, owner string, party string, memo string, available money.Money, locked money.Money) error {
+	if available.IsNegative() || locked.IsNegative() {
+		balance, err := getBalance(ctx, owner, available.Currency)
+		if err != nil {
+			return err
+		}
+		newAvailable, err := balance.Available.Add(available)
+		if err != nil {
+			return err
+		}
+		newLocked, err := balance.Locked.Add(locked)
+		if err != nil {
+			return err
+		}
+		if newAvailable.IsNegative() || newLocked.IsNegative() {
+			return fmt.Errorf("insufficient escrow balance: %v available, %v locked", balance.Available, balance.Locked)
+		}
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	entryKey, err := ctx.GetStub().CreateCompositeKey(escrowEntryKeyType, []string{owner, txID, memo, party})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	entryJSON, err := json.Marshal(EscrowEntry{Owner: owner, Available: available, Locked: locked, Memo: memo, TxID: txID})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(entryKey, entryJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put escrow entry in public state: %v", err)
+	}
+	return nil
+}
+
+// getBalance sums the escrow entries of owner in currency
+func getBalance(ctx contractapi.TransactionContextInterface, owner string, currency string) (*Balance, error) {
+	iter, err := ctx.GetStub().GetStateByPartialCompositeKey(escrowEntryKeyType, []string{owner})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get escrow entries of %v: %v", owner, err)
+	}
+	defer iter.Close()
+
+	balance := &Balance{Owner: owner, Available: money.Zero(currency), Locked: money.Zero(currency)}
+	for iter.HasNext() {
+		queryResponse, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		var entry EscrowEntry
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Available.Currency != currency {
+			continue
+		}
+		balance.Available, err = balance.Available.Add(entry.Available)
+		if err != nil {
+			return nil, err
+		}
+		balance.Locked, err = balance.Locked.Add(entry.Locked)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return balance, nil
+}
+
+func getEscrowLock(ctx contractapi.TransactionContextInterface, auctionID string, bidder string) (*EscrowLock, error) {
+	lockKey, err := ctx.GetStub().CreateCompositeKey(escrowLockKeyType, []string{auctionID, bidder})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	lockJSON, err := ctx.GetStub().GetState(lockKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get escrow lock: %v", err)
+	}
+	if lockJSON == nil {
+		return nil, nil
+	}
+	var lock *EscrowLock
+	err = json.Unmarshal(lockJSON, &lock)
+	if err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+func putEscrowLock(ctx contractapi.TransactionContextInterface, lock *EscrowLock) error {
+	lockKey, err := ctx.GetStub().CreateCompositeKey(escrowLockKeyType, []string{lock.AuctionID, lock.Bidder})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	lockJSON, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(lockKey, lockJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put escrow lock in public state: %v", err)
+	}
+	return nil
+}
+
+func deleteEscrowLock(ctx contractapi.TransactionContextInterface, lock *EscrowLock) error {
+	lockKey, err := ctx.GetStub().CreateCompositeKey(escrowLockKeyType, []string{lock.AuctionID, lock.Bidder})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	err = ctx.GetStub().DelState(lockKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete escrow lock: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package auction_test
+
+import (
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func balance(t *testing.T, contract *auction.SmartContract, ctx *MockContext, owner string) auction.Balance {
+	b, err := contract.GetBalance(ctx, owner, "")
+	assert.NoError(t, err)
+	return *b
+}
+
+func TestCreditAndDebitBalance(t *testing.T) {
+	contract, ctx := setup()
+
+	// the setup identity is neither a bank nor an admin
+	err := contract.CreditBalance(ctx, "bidder1", "100")
+	assert.Error(t, err)
+
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "bank"}
+	ctx.Stub.TxID = "tx1"
+	err = contract.CreditBalance(ctx, "bidder1", "100")
+	assert.NoError(t, err)
+	ctx.Stub.TxID = "tx2"
+	err = contract.DebitBalance(ctx, "bidder1", "150")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "insufficient escrow balance")
+	err = contract.DebitBalance(ctx, "bidder1", "40")
+	assert.NoError(t, err)
+
+	assert.Equal(t, auction.Balance{Owner: "bidder1", Available: usd(60), Locked: usd(0)}, balance(t, contract, ctx, "bidder1"))
+}
+
+func TestEscrowFullAmount(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	err := contract.SetEscrow(ctx, "auction1", "full", "")
+	assert.NoError(t, err)
+
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "bidder,admin"}
+	ctx.Stub.TxID = "credit1"
+	err = contract.CreditBalance(ctx, "bidder1", "500")
+	assert.NoError(t, err)
+	err = contract.CreditBalance(ctx, "bidder2", "200")
+	assert.NoError(t, err)
+	err = contract.SetEscrowFee(ctx, 10)
+	assert.NoError(t, err)
+
+	actAs(ctx, "bidder2")
+	ctx.Stub.TxID = "tx1"
+	_, err = contract.PlaceBid(ctx, "auction1", "300")
+	assert.Error(t, err)
+	submitBid(t, contract, ctx, "tx2", 200)
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx3", 100)
+	submitBid(t, contract, ctx, "tx4", 400)
+	assert.Equal(t, auction.Balance{Owner: "bidder1", Available: usd(100), Locked: usd(400)}, balance(t, contract, ctx, "bidder1"))
+
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-1 * time.Minute)
+	putAuctionState(ctx, a)
+	actAs(ctx, "user1")
+	ctx.Stub.TxID = "end"
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "paid", getAuction(ctx).Settlement.State)
+	assert.Equal(t, auction.Balance{Owner: "bidder1", Available: usd(100), Locked: usd(0)}, balance(t, contract, ctx, "bidder1"))
+	assert.Equal(t, auction.Balance{Owner: "bidder2", Available: usd(200), Locked: usd(0)}, balance(t, contract, ctx, "bidder2"))
+	assert.Equal(t, auction.Balance{Owner: "user1", Available: usd(360), Locked: usd(0)}, balance(t, contract, ctx, "user1"))
+	assert.Equal(t, auction.Balance{Owner: "msp::Org1MSP", Available: usd(40), Locked: usd(0)}, balance(t, contract, ctx, "msp::Org1MSP"))
+}
+
+func TestEscrowDepositForfeited(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	err := contract.SetEscrow(ctx, "auction1", "deposit", "0")
+	assert.Error(t, err)
+	err = contract.SetEscrow(ctx, "auction1", "deposit", "50")
+	assert.NoError(t, err)
+
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "bidder,bank"}
+	ctx.Stub.TxID = "credit1"
+	err = contract.CreditBalance(ctx, "bidder1", "50")
+	assert.NoError(t, err)
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 1000)
+
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-1 * time.Minute)
+	putAuctionState(ctx, a)
+	actAs(ctx, "user1")
+	ctx.Stub.TxID = "end"
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+
+	// the winner cannot cover the rest of the price and keeps the deposit locked
+	assert.Equal(t, "awaiting-payment", getAuction(ctx).Settlement.State)
+	assert.Equal(t, auction.Balance{Owner: "bidder1", Available: usd(0), Locked: usd(50)}, balance(t, contract, ctx, "bidder1"))
+
+	ctx.Stub.OracleTime = formatOracleTime(now.Add(73 * time.Hour))
+	ctx.Stub.TxID = "default"
+	err = contract.DeclareDefault(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, auction.Balance{Owner: "bidder1", Available: usd(0), Locked: usd(0)}, balance(t, contract, ctx, "bidder1"))
+	assert.Equal(t, auction.Balance{Owner: "user1", Available: usd(50), Locked: usd(0)}, balance(t, contract, ctx, "user1"))
+}
+
+func TestEscrowSellerBuysOwnItem(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	err := contract.SetEscrow(ctx, "auction1", "full", "")
+	assert.NoError(t, err)
+	err = contract.SetShillRule(ctx, "auction1", "none")
+	assert.NoError(t, err)
+
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "seller,bidder,admin"}
+	ctx.Stub.TxID = "credit1"
+	err = contract.CreditBalance(ctx, "user1", "500")
+	assert.NoError(t, err)
+	submitBid(t, contract, ctx, "tx1", 200)
+
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-1 * time.Minute)
+	putAuctionState(ctx, a)
+	ctx.Stub.TxID = "end"
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+
+	// paying themselves takes the funds out of the lock and back in again
+	assert.Equal(t, "paid", getAuction(ctx).Settlement.State)
+	assert.Equal(t, auction.Balance{Owner: "user1", Available: usd(500), Locked: usd(0)}, balance(t, contract, ctx, "user1"))
+}
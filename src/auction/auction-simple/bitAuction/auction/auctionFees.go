@@ -0,0 +1,623 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"bitAuction/money"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	feeScheduleKeyType = "feeschedule"
+	feeRevenueKeyType  = "feerevenue"
+	escrowFeeKeyType   = "escrowfee"
+)
+
+// kinds of fee rules
+const (
+	feePercentage = "percentage"
+	feeFixed      = "fixed"
+	feeTiered     = "tiered"
+)
+
+// roles of the parties paid out by a settlement
+const (
+	payoutSeller   = "seller"
+	payoutPlatform = "platform"
+)
+
+// FeeSchedule holds the fees charged on the hammer price of an auction. The
+// buyer pays the premium on top of the hammer price, the commission is kept
+// from what the seller receives. Both go to the platform organizations.
+type FeeSchedule struct {
+	Currency         string   `json:"currency"`
+	BuyerPremium     *FeeRule `json:"buyerPremium,omitempty"`
+	SellerCommission *FeeRule `json:"sellerCommission,omitempty"`
+	// RevenueSplit shares the fees between platform organizations. Without it
+	// the seller's organization keeps them all.
+	RevenueSplit []*RevenueShare `json:"revenueSplit"`
+}
+
+// FeeRule computes one fee from the hammer price: a percentage of it, a fixed
+// amount, or percentages of the parts of it falling into each tier
+type FeeRule struct {
+	Kind        string      `json:"kind"`
+	BasisPoints int64       `json:"basisPoints"`
+	Amount      money.Money `json:"amount"`
+	Tiers       []*FeeTier  `json:"tiers"`
+}
+
+// FeeTier charges BasisPoints on the part of the price up to UpTo above the
+// previous tier. The last tier may have a zero UpTo and no upper bound.
+type FeeTier struct {
+	UpTo        money.Money `json:"upTo"`
+	BasisPoints int64       `json:"basisPoints"`
+}
+
+// RevenueShare is the part of the fees, in basis points, an organization receives
+type RevenueShare struct {
+	Org         string `json:"org"`
+	BasisPoints int64  `json:"basisPoints"`
+}
+
+// FeeBreakdown records what every party of a settlement pays and receives
+type FeeBreakdown struct {
+	HammerPrice      money.Money `json:"hammerPrice"`
+	BuyerPremium     money.Money `json:"buyerPremium"`
+	SellerCommission money.Money `json:"sellerCommission"`
+	// BuyerPays is the hammer price plus the premium
+	BuyerPays money.Money `json:"buyerPays"`
+	Payouts   []*Payout   `json:"payouts"`
+}
+
+// Payout is the amount a party receives from a settlement. Role is "seller"
+// or "platform", Party the seller identity or the platform organization.
+type Payout struct {
+	Party  string      `json:"party"`
+	Role   string      `json:"role"`
+	Amount money.Money `json:"amount"`
+}
+
+// FeeRevenueEntry records the fees an organization earned from an auction
+// once the buyer paid
+type FeeRevenueEntry struct {
+	Org       string      `json:"org"`
+	AuctionID string      `json:"auctionID"`
+	Amount    money.Money `json:"amount"`
+	At        time.Time   `json:"at"`
+}
+
+// FeeRevenue summarises the fees an organization earned in a period, with
+// one total per currency
+type FeeRevenue struct {
+	Org      string        `json:"org"`
+	From     time.Time     `json:"from"`
+	To       time.Time     `json:"to"`
+	Auctions int           `json:"auctions"`
+	Totals   []money.Money `json:"totals"`
+}
+
+// EscrowFee is the percentage of the price an organization keeps from the
+// escrow payouts of the auctions its sellers create
+type EscrowFee struct {
+	Org     string `json:"org"`
+	Percent int    `json:"percent"`
+}
+
+// feeRuleArgs and feeScheduleArgs are the JSON arguments of the fee schedule
+// transactions, with amounts written like bid prices, e.g. "25.00"
+type feeRuleArgs struct {
+	Kind        string `json:"kind"`
+	BasisPoints int64  `json:"basisPoints"`
+	Amount      string `json:"amount"`
+	Tiers       []struct {
+		UpTo        string `json:"upTo"`
+		BasisPoints int64  `json:"basisPoints"`
+	} `json:"tiers"`
+}
+
+type feeScheduleArgs struct {
+	Currency         string          `json:"currency"`
+	BuyerPremium     *feeRuleArgs    `json:"buyerPremium"`
+	SellerCommission *feeRuleArgs    `json:"sellerCommission"`
+	RevenueSplit     []*RevenueShare `json:"revenueSplit"`
+}
+
+// SetOrgFeeSchedule lets an admin of an organization set the fees charged on
+// auctions its members create in the currency of the schedule, for example
+// {"currency":"EUR","buyerPremium":{"kind":"percentage","basisPoints":2500}}.
+// The currency defaults to the default currency. On auctions in that currency
+// the schedule replaces the escrow fee of the organization, and a schedule of
+// the auction replaces it in turn.
+func (s *SmartContract) SetOrgFeeSchedule(ctx contractapi.TransactionContextInterface, schedule string) error {
+	err := requireOrgAdmin(ctx)
+	if err != nil {
+		return fmt.Errorf("only an admin of the organization can set its fees: %v", err)
+	}
+	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	fees, err := parseFeeSchedule(schedule, money.DefaultCurrency)
+	if err != nil {
+		return err
+	}
+
+	scheduleKey, err := ctx.GetStub().CreateCompositeKey(feeScheduleKeyType, []string{clientOrgID, fees.Currency})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	scheduleJSON, err := json.Marshal(fees)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(scheduleKey, scheduleJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put fee schedule in public state: %v", err)
+	}
+	return nil
+}
+
+// SetEscrowFee lets an admin of an organization set the percentage it keeps
+// from the escrow payouts of auctions created by its members. It is charged as
+// seller commission and only applies to auctions without a fee schedule of
+// their own and in a currency the organization has no fee schedule for.
+func (s *SmartContract) SetEscrowFee(ctx contractapi.TransactionContextInterface, percent int) error {
+	err := requireOrgAdmin(ctx)
+	if err != nil {
+		return fmt.Errorf("only an admin of the organization can set its fee: %v", err)
+	}
+	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("fee must be between 0 and 100 percent")
+	}
+
+	feeKey, err := ctx.GetStub().CreateCompositeKey(escrowFeeKeyType, []string{clientOrgID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	feeJSON, err := json.Marshal(EscrowFee{Org: clientOrgID, Percent: percent})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(feeKey, feeJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put fee in public state: %v", err)
+	}
+	return nil
+}
+
+// SetAuctionFeeSchedule lets the seller or an auctioneer of the seller's
+// organization give an auction its own fees instead of those of the
+// organization. The schedule replaces both the fee schedule and the escrow
+// fee of the organization. It must be in the currency of the auction and can
+// only change before the first bid.
+func (s *SmartContract) SetAuctionFeeSchedule(ctx contractapi.TransactionContextInterface, auctionID string, schedule string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if clientID != auction.Seller {
+		if err = requireRole(ctx, roleAuctioneer); err != nil {
+			return err
+		}
+		clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+		if err != nil {
+			return fmt.Errorf("failed to get client identity %v", err)
+		}
+		if clientOrgID != sellerOrg(auction) {
+			return fmt.Errorf("only the seller or an auctioneer of the seller's organization can set the fees of the auction")
+		}
+	}
+	if err = s.checkBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+	fees, err := parseFeeSchedule(schedule, auction.Currency)
+	if err != nil {
+		return err
+	}
+	if fees.Currency != auction.Currency {
+		return fmt.Errorf("the fee schedule must be in %v", auction.Currency)
+	}
+
+	auction.Fees = fees
+	return putAuction(ctx, auction)
+}
+
+// GetFeeSchedule returns the fees charged on an auction, its own schedule or
+// that of the seller's organization
+func (s *SmartContract) GetFeeSchedule(ctx contractapi.TransactionContextInterface, auctionID string) (*FeeSchedule, error) {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auction: %v", err)
+	}
+	return feeSchedule(ctx, auction)
+}
+
+// GetFeeRevenue sums the fees org earned from auctions paid between from and
+// to, both RFC 3339 times, including from and excluding to. The fees of
+// private auctions stay in their collections and are not included.
+func (s *SmartContract) GetFeeRevenue(ctx contractapi.TransactionContextInterface, org string, from string, to string) (*FeeRevenue, error) {
+	start, err := time.Parse(time.RFC3339Nano, from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid datetime format: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339Nano, to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid datetime format: %v", err)
+	}
+
+	iter, err := ctx.GetStub().GetStateByPartialCompositeKey(feeRevenueKeyType, []string{org})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fee revenue of %v: %v", org, err)
+	}
+	defer iter.Close()
+
+	totals := map[string]money.Money{}
+	revenue := &FeeRevenue{Org: org, From: start, To: end, Totals: []money.Money{}}
+	for iter.HasNext() {
+		queryResponse, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		var entry FeeRevenueEntry
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Org != org || entry.At.Before(start) || !entry.At.Before(end) {
+			continue
+		}
+		total, ok := totals[entry.Amount.Currency]
+		if !ok {
+			total = money.Zero(entry.Amount.Currency)
+		}
+		totals[entry.Amount.Currency], err = total.Add(entry.Amount)
+		if err != nil {
+			return nil, err
+		}
+		revenue.Auctions++
+	}
+
+	for _, total := range totals {
+		revenue.Totals = append(revenue.Totals, total)
+	}
+	sort.Slice(revenue.Totals, func(i, j int) bool {
+		return revenue.Totals[i].Currency < revenue.Totals[j].Currency
+	})
+	return revenue, nil
+}
+
+// parseFeeSchedule reads and checks the JSON argument of a fee schedule
+// transaction. Amounts without a currency are in the schedule's currency.
+func parseFeeSchedule(schedule string, currency string) (*FeeSchedule, error) {
+	var args feeScheduleArgs
+	decoder := json.NewDecoder(bytes.NewReader([]byte(schedule)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&args); err != nil {
+		return nil, fmt.Errorf("failed to parse fee schedule: %v", err)
+	}
+	if args.Currency != "" {
+		currency = args.Currency
+	}
+	if _, err := money.Exponent(currency); err != nil {
+		return nil, err
+	}
+
+	fees := &FeeSchedule{Currency: currency, RevenueSplit: []*RevenueShare{}}
+	var err error
+	if fees.BuyerPremium, err = parseFeeRule(args.BuyerPremium, currency); err != nil {
+		return nil, fmt.Errorf("invalid buyer premium: %v", err)
+	}
+	if fees.SellerCommission, err = parseFeeRule(args.SellerCommission, currency); err != nil {
+		return nil, fmt.Errorf("invalid seller commission: %v", err)
+	}
+
+	var total int64
+	orgs := []string{}
+	for _, share := range args.RevenueSplit {
+		if share == nil || share.Org == "" || share.BasisPoints <= 0 {
+			return nil, fmt.Errorf("every revenue share needs an organization and positive basis points")
+		}
+		if contains(orgs, share.Org) {
+			return nil, fmt.Errorf("organization %v has more than one revenue share", share.Org)
+		}
+		orgs = append(orgs, share.Org)
+		total += share.BasisPoints
+		fees.RevenueSplit = append(fees.RevenueSplit, share)
+	}
+	if len(fees.RevenueSplit) > 0 && total != 10000 {
+		return nil, fmt.Errorf("revenue shares add up to %d basis points, expected 10000", total)
+	}
+	return fees, nil
+}
+
+// parseFeeRule converts a fee rule argument, nil when the fee is not charged
+func parseFeeRule(args *feeRuleArgs, currency string) (*FeeRule, error) {
+	if args == nil {
+		return nil, nil
+	}
+	rule := &FeeRule{Kind: args.Kind, Amount: money.Zero(currency), Tiers: []*FeeTier{}}
+	switch args.Kind {
+	case feePercentage:
+		if err := checkBasisPoints(args.BasisPoints); err != nil {
+			return nil, err
+		}
+		rule.BasisPoints = args.BasisPoints
+	case feeFixed:
+		amount, err := parseFeeAmount(args.Amount, currency)
+		if err != nil {
+			return nil, err
+		}
+		rule.Amount = amount
+	case feeTiered:
+		if len(args.Tiers) == 0 {
+			return nil, fmt.Errorf("a tiered fee needs tiers")
+		}
+		lower := money.Zero(currency)
+		for i, tier := range args.Tiers {
+			if err := checkBasisPoints(tier.BasisPoints); err != nil {
+				return nil, err
+			}
+			upTo := money.Zero(currency)
+			if tier.UpTo != "" {
+				amount, err := parseFeeAmount(tier.UpTo, currency)
+				if err != nil {
+					return nil, err
+				}
+				cmp, err := amount.Cmp(lower)
+				if err != nil {
+					return nil, err
+				}
+				if cmp <= 0 {
+					return nil, fmt.Errorf("tier bounds must increase, %v follows %v", amount, lower)
+				}
+				upTo, lower = amount, amount
+			} else if i != len(args.Tiers)-1 {
+				return nil, fmt.Errorf("only the last tier can be unbounded")
+			}
+			rule.Tiers = append(rule.Tiers, &FeeTier{UpTo: upTo, BasisPoints: tier.BasisPoints})
+		}
+	default:
+		return nil, fmt.Errorf("unknown fee kind %q", args.Kind)
+	}
+	return rule, nil
+}
+
+func parseFeeAmount(amount string, currency string) (money.Money, error) {
+	m, err := money.Parse(amount, currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	if m.Currency != currency {
+		return money.Money{}, fmt.Errorf("amount %v must be in %v", m, currency)
+	}
+	return m, nil
+}
+
+func checkBasisPoints(basisPoints int64) error {
+	if basisPoints < 0 || basisPoints > 10000 {
+		return fmt.Errorf("basis points must be between 0 and 10000, not %d", basisPoints)
+	}
+	return nil
+}
+
+// feeSchedule returns the fees charged on the auction: its own schedule, else
+// the schedule of the seller's organization in the auction's currency, else
+// the escrow fee of the organization as seller commission
+func feeSchedule(ctx contractapi.TransactionContextInterface, auction *Auction) (*FeeSchedule, error) {
+	if auction.Fees != nil {
+		return auction.Fees, nil
+	}
+	scheduleKey, err := ctx.GetStub().CreateCompositeKey(feeScheduleKeyType, []string{sellerOrg(auction), auction.Currency})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	scheduleJSON, err := ctx.GetStub().GetState(scheduleKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fee schedule: %v", err)
+	}
+	if scheduleJSON == nil {
+		return escrowFeeSchedule(ctx, auction)
+	}
+	var fees *FeeSchedule
+	err = json.Unmarshal(scheduleJSON, &fees)
+	if err != nil {
+		return nil, err
+	}
+	return fees, nil
+}
+
+// escrowFeeSchedule charges the escrow fee of the seller's organization as
+// seller commission, nothing when it has none
+func escrowFeeSchedule(ctx contractapi.TransactionContextInterface, auction *Auction) (*FeeSchedule, error) {
+	fees := &FeeSchedule{Currency: auction.Currency, RevenueSplit: []*RevenueShare{}}
+	feeKey, err := ctx.GetStub().CreateCompositeKey(escrowFeeKeyType, []string{sellerOrg(auction)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	feeJSON, err := ctx.GetStub().GetState(feeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get escrow fee: %v", err)
+	}
+	if feeJSON == nil {
+		return fees, nil
+	}
+	var fee EscrowFee
+	err = json.Unmarshal(feeJSON, &fee)
+	if err != nil {
+		return nil, err
+	}
+	fees.SellerCommission = &FeeRule{Kind: feePercentage, BasisPoints: int64(fee.Percent) * 100}
+	return fees, nil
+}
+
+// feeBreakdown computes the fees of the auction selling at the hammer price
+// and what the buyer pays and every party receives
+func feeBreakdown(ctx contractapi.TransactionContextInterface, auction *Auction, hammer money.Money) (*FeeBreakdown, error) {
+	fees, err := feeSchedule(ctx, auction)
+	if err != nil {
+		return nil, err
+	}
+	if fees.Currency != hammer.Currency {
+		return nil, fmt.Errorf("the fee schedule is in %v, the price in %v", fees.Currency, hammer.Currency)
+	}
+
+	premium, err := fees.BuyerPremium.fee(hammer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute buyer premium: %v", err)
+	}
+	commission, err := fees.SellerCommission.fee(hammer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute seller commission: %v", err)
+	}
+	// the seller never pays more commission than the item sold for
+	cmp, err := commission.Cmp(hammer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute seller commission: %v", err)
+	}
+	if cmp > 0 {
+		commission = hammer
+	}
+
+	breakdown := &FeeBreakdown{HammerPrice: hammer, BuyerPremium: premium, SellerCommission: commission, Payouts: []*Payout{}}
+	if breakdown.BuyerPays, err = hammer.Add(premium); err != nil {
+		return nil, err
+	}
+	proceeds, err := hammer.Sub(commission)
+	if err != nil {
+		return nil, err
+	}
+	breakdown.Payouts = append(breakdown.Payouts, &Payout{Party: auction.Seller, Role: payoutSeller, Amount: proceeds})
+
+	revenue, err := premium.Add(commission)
+	if err != nil {
+		return nil, err
+	}
+	split := fees.RevenueSplit
+	if len(split) == 0 {
+		split = []*RevenueShare{{Org: sellerOrg(auction), BasisPoints: 10000}}
+	}
+	// rounding leftovers go to the first organization of the split
+	remainder := revenue
+	shares := []*Payout{}
+	for _, share := range split {
+		amount, err := revenue.BasisPoints(share.BasisPoints)
+		if err != nil {
+			return nil, err
+		}
+		if remainder, err = remainder.Sub(amount); err != nil {
+			return nil, err
+		}
+		shares = append(shares, &Payout{Party: share.Org, Role: payoutPlatform, Amount: amount})
+	}
+	if shares[0].Amount, err = shares[0].Amount.Add(remainder); err != nil {
+		return nil, err
+	}
+	breakdown.Payouts = append(breakdown.Payouts, shares...)
+	return breakdown, nil
+}
+
+// fee computes the fee the rule charges on price, nothing for a nil rule
+func (r *FeeRule) fee(price money.Money) (money.Money, error) {
+	if r == nil {
+		return money.Zero(price.Currency), nil
+	}
+	switch r.Kind {
+	case feePercentage:
+		return price.BasisPoints(r.BasisPoints)
+	case feeFixed:
+		return r.Amount, nil
+	case feeTiered:
+		total := money.Zero(price.Currency)
+		lower := money.Zero(price.Currency)
+		for _, tier := range r.Tiers {
+			upper := price
+			if tier.UpTo.IsPositive() {
+				cmp, err := tier.UpTo.Cmp(price)
+				if err != nil {
+					return money.Money{}, err
+				}
+				if cmp < 0 {
+					upper = tier.UpTo
+				}
+			}
+			cmp, err := upper.Cmp(lower)
+			if err != nil {
+				return money.Money{}, err
+			}
+			if cmp <= 0 {
+				break
+			}
+			portion, err := upper.Sub(lower)
+			if err != nil {
+				return money.Money{}, err
+			}
+			fee, err := portion.BasisPoints(tier.BasisPoints)
+			if err != nil {
+				return money.Money{}, err
+			}
+			if total, err = total.Add(fee); err != nil {
+				return money.Money{}, err
+			}
+			lower = upper
+		}
+		return total, nil
+	}
+	return money.Money{}, fmt.Errorf("unknown fee kind %q", r.Kind)
+}
+
+// settlementFees returns the fee breakdown of a settlement. Settlements
+// started before fees existed pay the seller the full price.
+func settlementFees(auction *Auction) *FeeBreakdown {
+	if auction.Settlement.Fees != nil {
+		return auction.Settlement.Fees
+	}
+	price := auction.Settlement.Price
+	return &FeeBreakdown{
+		HammerPrice:      price,
+		BuyerPremium:     money.Zero(price.Currency),
+		SellerCommission: money.Zero(price.Currency),
+		BuyerPays:        price,
+		Payouts:          []*Payout{{Party: auction.Seller, Role: payoutSeller, Amount: price}},
+	}
+}
+
+// recordFeeRevenue books the platform payouts of a paid settlement as fee
+// revenue of their organizations at now, in the collection of a private auction
+func recordFeeRevenue(ctx contractapi.TransactionContextInterface, auction *Auction, now time.Time) error {
+	for _, payout := range settlementFees(auction).Payouts {
+		if payout.Role != payoutPlatform || !payout.Amount.IsPositive() {
+			continue
+		}
+		revenueKey, err := ctx.GetStub().CreateCompositeKey(feeRevenueKeyType, []string{payout.Party, auction.AuctionID})
+		if err != nil {
+			return fmt.Errorf("failed to create composite key: %v", err)
+		}
+		revenueJSON, err := json.Marshal(FeeRevenueEntry{Org: payout.Party, AuctionID: auction.AuctionID, Amount: payout.Amount, At: now})
+		if err != nil {
+			return err
+		}
+		err = putAuctionData(ctx, auction.Collection, revenueKey, revenueJSON)
+		if err != nil {
+			return fmt.Errorf("failed to put fee revenue: %v", err)
+		}
+	}
+	return nil
+}
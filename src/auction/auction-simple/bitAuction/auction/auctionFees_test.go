@@ -0,0 +1,172 @@
+package auction_test
+
+import (
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+	"bitAuction/money"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeeBreakdown(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+
+	// only organization admins set the fees of their organization
+	schedule := `{
+		"buyerPremium": {"kind": "percentage", "basisPoints": 2500},
+		"sellerCommission": {"kind": "tiered", "tiers": [{"upTo": "100", "basisPoints": 1000}, {"basisPoints": 500}]},
+		"revenueSplit": [{"org": "Org1MSP", "basisPoints": 7000}, {"org": "Org2MSP", "basisPoints": 3000}]
+	}`
+	err := contract.SetOrgFeeSchedule(ctx, schedule)
+	assert.Error(t, err)
+	ctx.Identity.Attrs["bitauction.role"] = "seller,bidder,auctioneer,admin"
+	err = contract.SetOrgFeeSchedule(ctx, schedule)
+	assert.NoError(t, err)
+	ctx.Identity.Attrs["bitauction.role"] = "seller,bidder,auctioneer"
+
+	endedAuction(t, contract, ctx, now)
+	fees := getAuction(ctx).Settlement.Fees
+	assert.Equal(t, usd(300), fees.HammerPrice)
+	assert.Equal(t, usd(75), fees.BuyerPremium)
+	// 10% of the first 100 and 5% of the remaining 200
+	assert.Equal(t, usd(20), fees.SellerCommission)
+	assert.Equal(t, usd(375), fees.BuyerPays)
+	assert.Equal(t, []*auction.Payout{
+		{Party: "user1", Role: "seller", Amount: usd(280)},
+		{Party: "Org1MSP", Role: "platform", Amount: money.Money{Amount: 6650, Currency: "USD"}},
+		{Party: "Org2MSP", Role: "platform", Amount: money.Money{Amount: 2850, Currency: "USD"}},
+	}, fees.Payouts)
+
+	// revenue is booked when the buyer pays
+	from := now.Add(-1 * time.Hour).Format(time.RFC3339Nano)
+	to := now.Add(1 * time.Hour).Format(time.RFC3339Nano)
+	revenue, err := contract.GetFeeRevenue(ctx, "Org1MSP", from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, revenue.Auctions)
+
+	err = contract.ConfirmPayment(ctx, "auction1")
+	assert.NoError(t, err)
+	revenue, err = contract.GetFeeRevenue(ctx, "Org1MSP", from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, revenue.Auctions)
+	assert.Equal(t, []money.Money{{Amount: 6650, Currency: "USD"}}, revenue.Totals)
+	revenue, err = contract.GetFeeRevenue(ctx, "Org2MSP", from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, []money.Money{{Amount: 2850, Currency: "USD"}}, revenue.Totals)
+
+	revenue, err = contract.GetFeeRevenue(ctx, "Org1MSP", to, now.Add(2*time.Hour).Format(time.RFC3339Nano))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, revenue.Auctions)
+	assert.Empty(t, revenue.Totals)
+}
+
+func TestAuctionFeeSchedule(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+
+	for _, invalid := range []string{
+		`{"buyerPremium": {"kind": "flat", "basisPoints": 100}}`,
+		`{"buyerPremium": {"kind": "percentage", "basisPoints": 10001}}`,
+		`{"buyerPremium": {"kind": "fixed", "amount": "25.00 EUR"}}`,
+		`{"sellerCommission": {"kind": "tiered", "tiers": [{"upTo": "100", "basisPoints": 500}, {"upTo": "50", "basisPoints": 100}]}}`,
+		`{"sellerCommission": {"kind": "tiered", "tiers": [{"basisPoints": 500}, {"upTo": "50", "basisPoints": 100}]}}`,
+		`{"revenueSplit": [{"org": "Org1MSP", "basisPoints": 6000}, {"org": "Org2MSP", "basisPoints": 3000}]}`,
+		`{"currency": "EUR"}`,
+		`{"buyerPremium": {"kind": "percentage", "percent": 10}}`,
+	} {
+		err := contract.SetAuctionFeeSchedule(ctx, "auction1", invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	// only the seller and auctioneers of the seller's organization set its fees
+	actAs(ctx, "auctioneer2")
+	ctx.Identity.MSPID = "Org2MSP"
+	err := contract.SetAuctionFeeSchedule(ctx, "auction1", `{}`)
+	assert.Error(t, err)
+	ctx.Identity.MSPID = "Org1MSP"
+	ctx.Identity.Attrs["bitauction.role"] = "bidder"
+	err = contract.SetAuctionFeeSchedule(ctx, "auction1", `{}`)
+	assert.Error(t, err)
+	ctx.Identity.Attrs["bitauction.role"] = "auctioneer"
+	err = contract.SetAuctionFeeSchedule(ctx, "auction1", `{}`)
+	assert.NoError(t, err)
+	actAs(ctx, "user1")
+
+	// the auction schedule replaces that of the organization
+	ctx.Identity.Attrs["bitauction.role"] = "seller,bidder,auctioneer,admin"
+	err = contract.SetOrgFeeSchedule(ctx, `{"buyerPremium": {"kind": "percentage", "basisPoints": 2000}}`)
+	assert.NoError(t, err)
+	ctx.Identity.Attrs["bitauction.role"] = "seller,bidder,auctioneer"
+	err = contract.SetAuctionFeeSchedule(ctx, "auction1", `{"buyerPremium": {"kind": "fixed", "amount": "25.00"}}`)
+	assert.NoError(t, err)
+	fees, err := contract.GetFeeSchedule(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "fixed", fees.BuyerPremium.Kind)
+	err = contract.SetCurrency(ctx, "auction1", "EUR")
+	assert.Error(t, err)
+
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 100)
+	actAs(ctx, "user1")
+	err = contract.SetAuctionFeeSchedule(ctx, "auction1", `{}`)
+	assert.Error(t, err)
+
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-1 * time.Minute)
+	putAuctionState(ctx, a)
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	settlement := getAuction(ctx).Settlement
+	assert.Equal(t, usd(25), settlement.Fees.BuyerPremium)
+	assert.Equal(t, usd(125), settlement.Fees.BuyerPays)
+	assert.Equal(t, []*auction.Payout{
+		{Party: "user1", Role: "seller", Amount: usd(100)},
+		{Party: "Org1MSP", Role: "platform", Amount: usd(25)},
+	}, settlement.Fees.Payouts)
+}
+
+func TestFeeSchedulePrecedence(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	ctx.Identity.Attrs["bitauction.role"] = "seller,bidder,admin"
+
+	// the escrow fee applies as seller commission without any schedule
+	err := contract.SetEscrowFee(ctx, 10)
+	assert.NoError(t, err)
+	fees, err := contract.GetFeeSchedule(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Nil(t, fees.BuyerPremium)
+	assert.Equal(t, &auction.FeeRule{Kind: "percentage", BasisPoints: 1000}, fees.SellerCommission)
+
+	// a schedule of the organization in another currency leaves it in place
+	err = contract.SetOrgFeeSchedule(ctx, `{"currency": "EUR", "buyerPremium": {"kind": "percentage", "basisPoints": 2000}}`)
+	assert.NoError(t, err)
+	fees, err = contract.GetFeeSchedule(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Nil(t, fees.BuyerPremium)
+	assert.Equal(t, int64(1000), fees.SellerCommission.BasisPoints)
+
+	// one in the currency of the auction replaces it
+	err = contract.SetOrgFeeSchedule(ctx, `{"buyerPremium": {"kind": "percentage", "basisPoints": 2000}}`)
+	assert.NoError(t, err)
+	fees, err = contract.GetFeeSchedule(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2000), fees.BuyerPremium.BasisPoints)
+	assert.Nil(t, fees.SellerCommission)
+
+	// and the schedule of the auction replaces both
+	err = contract.SetAuctionFeeSchedule(ctx, "auction1", `{"sellerCommission": {"kind": "fixed", "amount": "5.00"}}`)
+	assert.NoError(t, err)
+	fees, err = contract.GetFeeSchedule(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Nil(t, fees.BuyerPremium)
+	assert.Equal(t, "fixed", fees.SellerCommission.Kind)
+	assert.Equal(t, usd(5), fees.SellerCommission.Amount)
+}
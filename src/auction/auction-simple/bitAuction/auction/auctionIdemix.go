@@ -0,0 +1,360 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"bitAuction/events"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/runtime/protoimpl"
+)
+
+// Bidders can use Idemix credentials to bid without revealing who they are.
+// An Idemix identity has no certificate: it is known by a pseudonym derived
+// from the Nym of its credential, and only discloses its organizational unit
+// and role. An admin of an Idemix MSP names the organization vouching for its
+// members with an IdemixPolicy, which decides the units that may bid and the
+// units of verified (KYC-passed) bidders, and the policy applies once an admin
+// of that organization accepts it. Bids of Idemix identities carry the
+// pseudonym and an attestation such as "verified bidder of Org1MSP".
+//
+// Pseudonyms cannot be linked across transactions, so an anonymous bidder
+// commits to the X.509 identity that settles in their place when bidding: the
+// transient field linkCommitment holds LinkCommitment of that identity and a
+// secret only the bidder knows. An anonymous winner reveals the identity at
+// settlement by opening the commitment with LinkWinner, and the identity then
+// takes over as buyer with ConfirmWinnerLink. Payment is only confirmed once
+// the winner is revealed.
+//
+// The pseudonym of an anonymous bidder changes with every transaction, so it
+// cannot be matched in a later one. An anonymous bidder retracts a bid by
+// opening its commitment with the transient fields linkIdentity and
+// linkSecret, which stay off the ledger. Clock auctions, sealed bids and
+// escrow follow a bidder across transactions by their client ID and refuse
+// anonymous bidders.
+const (
+	idemixIDPrefix      = "idemix::"
+	idemixPolicyKeyType = "idemixpolicy"
+	linkCommitmentField = "linkCommitment"
+	linkIdentityField   = "linkIdentity"
+	linkSecretField     = "linkSecret"
+)
+
+// IdemixPolicy lists the organizational units of an Idemix MSP that Org
+// accepts as bidders, and those whose bidders are verified. The policy only
+// applies once Org accepted it.
+type IdemixPolicy struct {
+	MSPID       string   `json:"mspID"`
+	Org         string   `json:"org"`
+	BidderOUs   []string `json:"bidderOUs"`
+	VerifiedOUs []string `json:"verifiedOUs"`
+	Accepted    bool     `json:"accepted"`
+}
+
+// SetIdemixPolicy lets an admin of an Idemix MSP name the organization that
+// vouches for its members. Members of units in bidderOUs may bid as bidders
+// of the organization, members of units in verifiedOUs as verified bidders.
+// Every change has to be accepted again by the organization with
+// AcceptIdemixPolicy.
+func (s *SmartContract) SetIdemixPolicy(ctx contractapi.TransactionContextInterface, org string, bidderOUs []string, verifiedOUs []string) error {
+	// only Idemix identities have no ID
+	_, err := ctx.GetClientIdentity().GetID()
+	role, _, roleErr := ctx.GetClientIdentity().GetAttributeValue("role")
+	if err == nil || roleErr != nil || role != "admin" {
+		return fmt.Errorf("only an admin of the Idemix MSP can set its policy")
+	}
+	idemixMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if org == "" || org == idemixMSPID {
+		return fmt.Errorf("an Idemix MSP has to be vouched for by another organization")
+	}
+
+	return putIdemixPolicy(ctx, &IdemixPolicy{MSPID: idemixMSPID, Org: org, BidderOUs: bidderOUs, VerifiedOUs: verifiedOUs})
+}
+
+// AcceptIdemixPolicy lets an admin of the organization named by the policy of
+// an Idemix MSP vouch for its members
+func (s *SmartContract) AcceptIdemixPolicy(ctx contractapi.TransactionContextInterface, idemixMSPID string) error {
+	err := requireOrgAdmin(ctx)
+	if err != nil {
+		return fmt.Errorf("only an admin of the organization can vouch for an Idemix MSP: %v", err)
+	}
+	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	policy, err := getIdemixPolicy(ctx, idemixMSPID)
+	if err != nil {
+		return err
+	}
+	if policy == nil || policy.Org != clientOrgID {
+		return fmt.Errorf("Idemix MSP %v did not ask %v to vouch for it", idemixMSPID, clientOrgID)
+	}
+
+	policy.Accepted = true
+	return putIdemixPolicy(ctx, policy)
+}
+
+// GetIdemixPolicy returns the policy of an Idemix MSP, or nil when no
+// organization vouches for it
+func (s *SmartContract) GetIdemixPolicy(ctx contractapi.TransactionContextInterface, idemixMSPID string) (*IdemixPolicy, error) {
+	return getIdemixPolicy(ctx, idemixMSPID)
+}
+
+// LinkWinner reveals the X.509 identity, as returned by
+// GetSubmittingClientIdentity, that settles the auction in place of an
+// anonymous buyer. identity and secret have to open the link commitment of
+// the winning bid, so any client holding the secret can submit it.
+func (s *SmartContract) LinkWinner(ctx contractapi.TransactionContextInterface, auctionID string, identity string, secret string) error {
+	auction, _, _, err := s.settlementStep(ctx, auctionID, settlementAwaitingPayment)
+	if err != nil {
+		return err
+	}
+	settlement := auction.Settlement
+	if !isPseudonym(settlement.Buyer) {
+		return fmt.Errorf("the buyer of auction %v is not anonymous", auctionID)
+	}
+	if !strings.HasPrefix(identity, "x509::") {
+		return fmt.Errorf("the winner has to be revealed as an X.509 identity")
+	}
+	bid, err := s.getFullBid(ctx, auctionID, settlement.BidTxID)
+	if err != nil {
+		return err
+	}
+	if bid.LinkCommitment == "" || LinkCommitment(identity, secret) != bid.LinkCommitment {
+		return fmt.Errorf("identity and secret do not open the link commitment of the winning bid")
+	}
+
+	settlement.LinkedTo = identity
+	err = putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+	return emitAuctionEvents(ctx, auction, events.WinnerRevealed{AuctionID: auctionID, Pseudonym: settlement.Buyer, Buyer: identity, Status: "pending"})
+}
+
+// ConfirmWinnerLink is submitted by the identity named with LinkWinner, which
+// becomes the buyer and winner of the auction
+func (s *SmartContract) ConfirmWinnerLink(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, clientID, _, err := s.settlementStep(ctx, auctionID, settlementAwaitingPayment)
+	if err != nil {
+		return err
+	}
+	settlement := auction.Settlement
+	if settlement.LinkedTo == "" || clientID != settlement.LinkedTo {
+		return fmt.Errorf("the buyer did not link their pseudonym to the client")
+	}
+
+	settlement.Pseudonym = settlement.Buyer
+	settlement.Buyer = clientID
+	settlement.LinkedTo = ""
+	if auction.Winner == settlement.Pseudonym {
+		auction.Winner = clientID
+	}
+	err = putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+	return emitAuctionEvents(ctx, auction, events.WinnerRevealed{AuctionID: auctionID, Pseudonym: settlement.Pseudonym, Buyer: clientID, Status: "confirmed"})
+}
+
+// LinkCommitment returns the commitment of an anonymous bidder to the X.509
+// identity that settles in their place, hidden by a secret only the bidder
+// knows. Idemix bidders pass it in the transient field linkCommitment.
+func LinkCommitment(identity string, secret string) string {
+	hash := sha256.Sum256([]byte(identity + "\x00" + secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// bidLinkCommitment returns the link commitment an Idemix bidder passed with
+// the transaction, or an empty string for X.509 bidders
+func bidLinkCommitment(ctx contractapi.TransactionContextInterface) (string, error) {
+	policy, _, err := idemixClient(ctx)
+	if err != nil || policy == nil {
+		return "", err
+	}
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("failed to get transient data: %v", err)
+	}
+	commitment := string(transient[linkCommitmentField])
+	if decoded, err := hex.DecodeString(commitment); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("anonymous bidders have to pass the %v of the identity that settles in their place", linkCommitmentField)
+	}
+	return commitment, nil
+}
+
+// idemixPseudonym returns the pseudonym of an Idemix client, or an empty
+// string for other clients. The pseudonym is derived from the Nym of the
+// signature, which Idemix clients usually randomize for every transaction.
+func idemixPseudonym(ctx contractapi.TransactionContextInterface) (string, error) {
+	creator, err := ctx.GetStub().GetCreator()
+	if err != nil {
+		return "", fmt.Errorf("failed to get creator: %v", err)
+	}
+	sid := &msp.SerializedIdentity{}
+	if err = unmarshalProto(creator, sid); err != nil {
+		return "", nil
+	}
+	if block, _ := pem.Decode(sid.IdBytes); block != nil {
+		return "", nil
+	}
+	idemixID := &msp.SerializedIdemixIdentity{}
+	if err = unmarshalProto(sid.IdBytes, idemixID); err != nil || len(idemixID.NymX) == 0 {
+		return "", nil
+	}
+
+	nym := sha256.Sum256(append(append([]byte{}, idemixID.NymX...), idemixID.NymY...))
+	return idemixIDPrefix + sid.Mspid + "::" + hex.EncodeToString(nym[:16]), nil
+}
+
+// idemixClient returns the policy of the Idemix MSP of the client and the
+// organizational unit its credential discloses, or nil for X.509 clients
+func idemixClient(ctx contractapi.TransactionContextInterface) (*IdemixPolicy, string, error) {
+	// only Idemix identities have no ID
+	if _, err := ctx.GetClientIdentity().GetID(); err == nil {
+		return nil, "", nil
+	}
+	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get client identity %v", err)
+	}
+	policy, err := getIdemixPolicy(ctx, clientOrgID)
+	if err != nil {
+		return nil, "", err
+	}
+	if policy == nil || !policy.Accepted {
+		return nil, "", fmt.Errorf("no organization vouches for Idemix MSP %v", clientOrgID)
+	}
+	ou, _, err := ctx.GetClientIdentity().GetAttributeValue("ou")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read organizational unit: %v", err)
+	}
+	return policy, ou, nil
+}
+
+// clientOrg returns the organization of the client, which for Idemix
+// clients is the organization vouching for their MSP
+func clientOrg(ctx contractapi.TransactionContextInterface) (string, error) {
+	policy, _, err := idemixClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	if policy != nil {
+		return policy.Org, nil
+	}
+	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get org: %v", err)
+	}
+	return clientOrgID, nil
+}
+
+// bidderAttestation describes what an Idemix bidder proved about themselves,
+// empty for X.509 bidders
+func bidderAttestation(ctx contractapi.TransactionContextInterface) (string, error) {
+	policy, ou, err := idemixClient(ctx)
+	if err != nil || policy == nil {
+		return "", err
+	}
+	if contains(policy.VerifiedOUs, ou) {
+		return "verified bidder of " + policy.Org, nil
+	}
+	return "bidder of " + policy.Org, nil
+}
+
+// openBidLink checks that an anonymous client of the same MSP as the bidder
+// opens the link commitment of the bid with the transient fields linkIdentity
+// and linkSecret
+func openBidLink(ctx contractapi.TransactionContextInterface, bid *FullBid, clientID string) error {
+	nymMSP := func(id string) string { return id[:strings.LastIndex(id, "::")] }
+	if !isPseudonym(clientID) || !isPseudonym(bid.Bidder) || nymMSP(clientID) != nymMSP(bid.Bidder) {
+		return fmt.Errorf("Permission denied, client id %v is not the owner of the bid", clientID)
+	}
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to get transient data: %v", err)
+	}
+	identity, secret := string(transient[linkIdentityField]), string(transient[linkSecretField])
+	if bid.LinkCommitment == "" || identity == "" || LinkCommitment(identity, secret) != bid.LinkCommitment {
+		return fmt.Errorf("the transient fields %v and %v do not open the link commitment of bid %v", linkIdentityField, linkSecretField, bid.TxID)
+	}
+	return nil
+}
+
+// stableIdentityFeature returns the feature of an auction that follows a
+// bidder across transactions by their client ID, which anonymous bidders
+// cannot take part in, or an empty string
+func stableIdentityFeature(auction *Auction) string {
+	switch {
+	case auction.Clock != nil:
+		return "clock auctions"
+	case auction.Sealed != nil:
+		return "sealed bids"
+	case auction.EscrowMode == escrowDeposit || auction.EscrowMode == escrowFull:
+		return "escrow"
+	}
+	return ""
+}
+
+// isPseudonym tells whether a client ID is the pseudonym of an Idemix client
+func isPseudonym(clientID string) bool {
+	return strings.HasPrefix(clientID, idemixIDPrefix)
+}
+
+// unmarshalProto decodes the messages of fabric-protos-go, which are generated
+// for the first protobuf API
+func unmarshalProto(data []byte, message protoiface.MessageV1) error {
+	return proto.Unmarshal(data, protoimpl.X.ProtoMessageV2Of(message))
+}
+
+func putIdemixPolicy(ctx contractapi.TransactionContextInterface, policy *IdemixPolicy) error {
+	policyKey, err := ctx.GetStub().CreateCompositeKey(idemixPolicyKeyType, []string{policy.MSPID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	policyJSON, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(policyKey, policyJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put Idemix policy in public state: %v", err)
+	}
+	return nil
+}
+
+func getIdemixPolicy(ctx contractapi.TransactionContextInterface, idemixMSPID string) (*IdemixPolicy, error) {
+	policyKey, err := ctx.GetStub().CreateCompositeKey(idemixPolicyKeyType, []string{idemixMSPID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	policyJSON, err := ctx.GetStub().GetState(policyKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Idemix policy of %v: %v", idemixMSPID, err)
+	}
+	if policyJSON == nil {
+		return nil, nil
+	}
+
+	var policy *IdemixPolicy
+	err = json.Unmarshal(policyJSON, &policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
@@ -0,0 +1,259 @@
+package auction_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+	"bitAuction/events"
+
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/runtime/protoimpl"
+)
+
+func actAsIdemix(ctx *MockContext, mspID string, ou string, nym string) {
+	idBytes, _ := proto.Marshal(protoimpl.X.ProtoMessageV2Of(&msp.SerializedIdemixIdentity{NymX: []byte(nym), NymY: []byte("y")}))
+	creator, _ := proto.Marshal(protoimpl.X.ProtoMessageV2Of(&msp.SerializedIdentity{Mspid: mspID, IdBytes: idBytes}))
+	ctx.Stub.Creator = creator
+	ctx.Identity.ID = ""
+	ctx.Identity.MSPID = mspID
+	ctx.Identity.Attrs = map[string]string{"ou": ou, "role": "member"}
+	ctx.Stub.Transient = map[string][]byte{"linkCommitment": []byte(auction.LinkCommitment(alice, "secret-"+nym))}
+}
+
+// alice is the identity anonymous bidders commit to settle in their place
+const alice = "x509::CN=alice,OU=client::CN=ca.org2.example.com"
+
+func actAsX509(ctx *MockContext, mspID string, user string, roles string) {
+	ctx.Stub.Creator = nil
+	actAs(ctx, user)
+	ctx.Identity.MSPID = mspID
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": roles}
+}
+
+func vouchForIdemix(t *testing.T, contract *auction.SmartContract, ctx *MockContext) {
+	actAsIdemix(ctx, "Org2IdemixMSP", "", "admin")
+	ctx.Identity.Attrs["role"] = "admin"
+	err := contract.SetIdemixPolicy(ctx, "Org2MSP", []string{"retail"}, []string{"kyc"})
+	assert.NoError(t, err)
+	actAsX509(ctx, "Org2MSP", "org2admin", "admin")
+	err = contract.AcceptIdemixPolicy(ctx, "Org2IdemixMSP")
+	assert.NoError(t, err)
+}
+
+func TestIdemixPolicy(t *testing.T) {
+	contract, ctx := setup()
+	ctx.Stub.OracleTime = formatOracleTime(time.Now())
+	createAuction(t, contract, ctx, time.Now().Add(1*time.Hour))
+
+	// only an admin of the Idemix MSP names who vouches for it
+	actAsX509(ctx, "Org3MSP", "org3admin", "admin")
+	err := contract.SetIdemixPolicy(ctx, "Org3MSP", []string{"guests"}, []string{})
+	assert.Error(t, err)
+	actAsIdemix(ctx, "Org2IdemixMSP", "", "member")
+	err = contract.SetIdemixPolicy(ctx, "Org3MSP", []string{"guests"}, []string{})
+	assert.Error(t, err)
+	ctx.Identity.Attrs["role"] = "admin"
+	err = contract.SetIdemixPolicy(ctx, "Org2IdemixMSP", []string{"guests"}, []string{})
+	assert.Error(t, err)
+	err = contract.SetIdemixPolicy(ctx, "Org2MSP", []string{"retail"}, []string{"kyc"})
+	assert.NoError(t, err)
+
+	// the policy applies once an admin of the named organization accepts it
+	actAsIdemix(ctx, "Org2IdemixMSP", "kyc", "nym1")
+	ctx.Stub.TxID = "tx1"
+	_, err = contract.PlaceBid(ctx, "auction1", "100")
+	assert.Error(t, err)
+	actAsX509(ctx, "Org3MSP", "org3admin", "admin")
+	err = contract.AcceptIdemixPolicy(ctx, "Org2IdemixMSP")
+	assert.Error(t, err)
+	actAsX509(ctx, "Org2MSP", "org2user", "bidder")
+	err = contract.AcceptIdemixPolicy(ctx, "Org2IdemixMSP")
+	assert.Error(t, err)
+	actAsX509(ctx, "Org2MSP", "org2admin", "admin")
+	err = contract.AcceptIdemixPolicy(ctx, "Org2IdemixMSP")
+	assert.NoError(t, err)
+	policy, err := contract.GetIdemixPolicy(ctx, "Org2IdemixMSP")
+	assert.NoError(t, err)
+	assert.True(t, policy.Accepted)
+	actAsIdemix(ctx, "Org2IdemixMSP", "kyc", "nym1")
+	_, err = contract.PlaceBid(ctx, "auction1", "100")
+	assert.NoError(t, err)
+
+	// a change has to be accepted again
+	actAsIdemix(ctx, "Org2IdemixMSP", "", "admin")
+	ctx.Identity.Attrs["role"] = "admin"
+	err = contract.SetIdemixPolicy(ctx, "Org3MSP", []string{"retail"}, []string{"retail"})
+	assert.NoError(t, err)
+	policy, err = contract.GetIdemixPolicy(ctx, "Org2IdemixMSP")
+	assert.NoError(t, err)
+	assert.False(t, policy.Accepted)
+}
+
+func TestIdemixBidder(t *testing.T) {
+	contract, ctx := setup()
+	ctx.Stub.OracleTime = formatOracleTime(time.Now())
+	createAuction(t, contract, ctx, time.Now().Add(1*time.Hour))
+
+	// nobody vouches for the Idemix MSP yet
+	actAsIdemix(ctx, "Org2IdemixMSP", "kyc", "nym1")
+	_, err := contract.PlaceBid(ctx, "auction1", "100")
+	assert.Error(t, err)
+
+	vouchForIdemix(t, contract, ctx)
+
+	// anonymous bidders commit to the identity that settles in their place
+	actAsIdemix(ctx, "Org2IdemixMSP", "kyc", "nym1")
+	ctx.Stub.Transient = nil
+	_, err = contract.PlaceBid(ctx, "auction1", "100")
+	assert.Error(t, err)
+
+	actAsIdemix(ctx, "Org2IdemixMSP", "kyc", "nym1")
+	clientID, err := contract.GetSubmittingClientIdentity(ctx)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(clientID, "idemix::Org2IdemixMSP::"))
+	name, err := contract.ParseClientID(clientID)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(name, "nym-"))
+	ctx.Stub.TxID = "tx1"
+	_, err = contract.PlaceBid(ctx, "auction1", "100")
+	assert.NoError(t, err)
+	bid, err := contract.GetHb(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, clientID, bid.Bidder)
+	assert.Equal(t, "Org2MSP", bid.Org)
+	assert.Equal(t, "verified bidder of Org2MSP", bid.Attestation)
+
+	// a fresh Nym cannot be linked to the first one
+	actAsIdemix(ctx, "Org2IdemixMSP", "retail", "nym2")
+	other, err := contract.GetSubmittingClientIdentity(ctx)
+	assert.NoError(t, err)
+	assert.NotEqual(t, clientID, other)
+
+	// units outside the policy cannot bid, retail bidders are not verified
+	actAsIdemix(ctx, "Org2IdemixMSP", "guests", "nym3")
+	ctx.Stub.TxID = "tx3"
+	_, err = contract.PlaceBid(ctx, "auction1", "150")
+	assert.Error(t, err)
+
+	a := getAuction(ctx)
+	a.VerifiedOnly = true
+	putAuctionState(ctx, a)
+	actAsIdemix(ctx, "Org2IdemixMSP", "retail", "nym2")
+	ctx.Stub.TxID = "tx2"
+	_, err = contract.PlaceBid(ctx, "auction1", "150")
+	assert.Error(t, err)
+	actAsIdemix(ctx, "Org2IdemixMSP", "kyc", "nym1")
+	_, err = contract.PlaceBid(ctx, "auction1", "150")
+	assert.NoError(t, err)
+}
+
+func TestAnonymousWinnerSettlement(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	vouchForIdemix(t, contract, ctx)
+	actAsIdemix(ctx, "Org2IdemixMSP", "kyc", "nym1")
+	ctx.Stub.TxID = "tx1"
+	_, err := contract.PlaceBid(ctx, "auction1", "100")
+	assert.NoError(t, err)
+	pseudonym, _ := contract.GetSubmittingClientIdentity(ctx)
+
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-1 * time.Hour)
+	putAuctionState(ctx, a)
+	actAsX509(ctx, "Org1MSP", "user1", "seller")
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, pseudonym, getAuction(ctx).Settlement.Buyer)
+
+	// payment waits for the winner to reveal themselves
+	err = contract.ConfirmPayment(ctx, "auction1")
+	assert.Error(t, err)
+	mallory := "x509::CN=mallory,OU=client::CN=ca.org2.example.com"
+	err = contract.LinkWinner(ctx, "auction1", alice, "guess")
+	assert.Error(t, err)
+	err = contract.LinkWinner(ctx, "auction1", mallory, "secret-nym1")
+	assert.Error(t, err)
+	// the winner opens the commitment under a pseudonym unlinkable to the bid
+	actAsIdemix(ctx, "Org2IdemixMSP", "kyc", "nym9")
+	err = contract.LinkWinner(ctx, "auction1", alice, "secret-nym1")
+	assert.NoError(t, err)
+
+	actAsX509(ctx, "Org2MSP", mallory, "bidder")
+	err = contract.ConfirmWinnerLink(ctx, "auction1")
+	assert.Error(t, err)
+	actAsX509(ctx, "Org2MSP", alice, "bidder")
+	err = contract.ConfirmWinnerLink(ctx, "auction1")
+	assert.NoError(t, err)
+	evts := decodeEvents(t, ctx)
+	revealed, ok := evts[0].(*events.WinnerRevealed)
+	assert.True(t, ok)
+	assert.Equal(t, "confirmed", revealed.Status)
+	a = getAuction(ctx)
+	assert.Equal(t, alice, a.Settlement.Buyer)
+	assert.Equal(t, alice, a.Winner)
+	assert.Equal(t, pseudonym, a.Settlement.Pseudonym)
+
+	actAsX509(ctx, "Org1MSP", "user1", "seller")
+	err = contract.ConfirmPayment(ctx, "auction1")
+	assert.NoError(t, err)
+}
+
+func TestIdemixSecondTransaction(t *testing.T) {
+	contract, ctx := setup()
+	ctx.Stub.OracleTime = formatOracleTime(time.Now())
+	createAuction(t, contract, ctx, time.Now().Add(1*time.Hour))
+	a := getAuction(ctx)
+	a.RetractWindow = 300
+	putAuctionState(ctx, a)
+	vouchForIdemix(t, contract, ctx)
+
+	actAsIdemix(ctx, "Org2IdemixMSP", "kyc", "nym1")
+	ctx.Stub.TxID = "tx1"
+	_, err := contract.PlaceBid(ctx, "auction1", "100")
+	assert.NoError(t, err)
+
+	// the next transaction of the same bidder carries a fresh pseudonym, the
+	// bid is theirs only if they open its link commitment
+	actAsIdemix(ctx, "Org2IdemixMSP", "kyc", "nym2")
+	ctx.Stub.TxID = "tx2"
+	err = contract.RetractBid(ctx, "auction1", "tx1", "typo")
+	assert.Error(t, err)
+	ctx.Stub.Transient = map[string][]byte{"linkIdentity": []byte(alice), "linkSecret": []byte("secret-nym2")}
+	err = contract.RetractBid(ctx, "auction1", "tx1", "typo")
+	assert.Error(t, err)
+	actAsX509(ctx, "Org2MSP", "org2user", "bidder")
+	ctx.Stub.Transient = map[string][]byte{"linkIdentity": []byte(alice), "linkSecret": []byte("secret-nym1")}
+	err = contract.RetractBid(ctx, "auction1", "tx1", "typo")
+	assert.Error(t, err)
+	actAsIdemix(ctx, "Org2IdemixMSP", "kyc", "nym2")
+	ctx.Stub.Transient = map[string][]byte{"linkIdentity": []byte(alice), "linkSecret": []byte("secret-nym1")}
+	err = contract.RetractBid(ctx, "auction1", "tx1", "typo")
+	assert.NoError(t, err)
+
+	// clock rounds, sealed bids and escrow follow a bidder by their client ID
+	a = getAuction(ctx)
+	a.EscrowMode = "full"
+	putAuctionState(ctx, a)
+	actAsIdemix(ctx, "Org2IdemixMSP", "kyc", "nym3")
+	ctx.Stub.TxID = "tx3"
+	_, err = contract.PlaceBid(ctx, "auction1", "100")
+	assert.ErrorContains(t, err, "anonymous bidders cannot take part in escrow")
+
+	a.EscrowMode = ""
+	a.Sealed = &auction.SealedBidding{Reserve: a.Price, RevealPeriod: 3600}
+	putAuctionState(ctx, a)
+	_, err = contract.SubmitSealedBid(ctx, "auction1", "commitment", "proof")
+	assert.ErrorContains(t, err, "anonymous bidders cannot take part in sealed bids")
+
+	a.Sealed = nil
+	a.Clock = &auction.Clock{}
+	putAuctionState(ctx, a)
+	_, err = contract.ConfirmClockRound(ctx, "auction1")
+	assert.ErrorContains(t, err, "anonymous bidders cannot take part in clock auctions")
+}
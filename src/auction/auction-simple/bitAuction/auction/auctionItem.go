@@ -0,0 +1,208 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const itemKeyType = "item"
+
+// Item is an asset registered with the auction chaincode. It mirrors the
+// Asset of the asset-transfer-basic chaincode, with the owner being the
+// identity that registered or bought it. Keeping the registry in the auction
+// chaincode lets EndAuction transfer the item in the same transaction.
+// An item listed on an auction is locked by it.
+// Fields are kept in alphabetic order like Asset for determinism across languages
+type Item struct {
+	AppraisedValue int    `json:"AppraisedValue"`
+	Color          string `json:"Color"`
+	ID             string `json:"ID"`
+	LockedBy       string `json:"LockedBy"`
+	Owner          string `json:"Owner"`
+	Size           int    `json:"Size"`
+}
+
+// RegisterItem registers a new item owned by the submitting client
+func (s *SmartContract) RegisterItem(ctx contractapi.TransactionContextInterface, id string, color string, size int, appraisedValue int) error {
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	existing, err := getItem(ctx, id)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("the item %s already exists", id)
+	}
+
+	return putItem(ctx, &Item{
+		AppraisedValue: appraisedValue,
+		Color:          color,
+		ID:             id,
+		Owner:          clientID,
+		Size:           size,
+	})
+}
+
+// ReadItem returns the item stored in the world state with given id
+func (s *SmartContract) ReadItem(ctx contractapi.TransactionContextInterface, id string) (*Item, error) {
+	item, err := getItem(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, fmt.Errorf("the item %s does not exist", id)
+	}
+	return item, nil
+}
+
+// TransferItem lets the owner of an item that is not listed on an auction
+// give it to newOwner, and returns the old owner
+func (s *SmartContract) TransferItem(ctx contractapi.TransactionContextInterface, id string, newOwner string) (string, error) {
+	item, err := s.ReadItem(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get client identity %v", err)
+	}
+	if item.Owner != clientID {
+		return "", fmt.Errorf("item can only be transferred by its owner")
+	}
+	if item.LockedBy != "" {
+		return "", fmt.Errorf("item is locked by auction %v", item.LockedBy)
+	}
+
+	oldOwner := item.Owner
+	item.Owner = newOwner
+	err = putItem(ctx, item)
+	if err != nil {
+		return "", err
+	}
+	return oldOwner, nil
+}
+
+// CreateItemAuction creates an auction for a registered item. Only the owner
+// of the item can list it, and it is locked while the auction is open.
+// EndAuction transfers the item to the winner, who can only pass it on once
+// the settlement completes so that an unpaid item can go back to the seller.
+func (s *SmartContract) CreateItemAuction(ctx contractapi.TransactionContextInterface, auctionID string, itemID string, timelimit string, description string, pictureUrl string) error {
+	t, err := time.Parse(time.RFC3339Nano, timelimit)
+	if err != nil {
+		return fmt.Errorf("invalid datetime format: %v", err)
+	}
+
+	auction, err := s.createAuction(ctx, auctionID, itemID, t, description, pictureUrl, directionForward)
+	if err != nil {
+		return err
+	}
+	item, err := getItem(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return fmt.Errorf("the item %s does not exist", itemID)
+	}
+	err = listItem(ctx, auction, item)
+	if err != nil {
+		return err
+	}
+	return emitEvents(ctx, auctionCreatedEvent(auction))
+}
+
+// listItem locks an item of the seller for the auction and links them
+func listItem(ctx contractapi.TransactionContextInterface, auction *Auction, item *Item) error {
+	if item.Owner != auction.Seller {
+		return fmt.Errorf("item can only be listed by its owner")
+	}
+	if item.LockedBy != "" {
+		return fmt.Errorf("item is already listed on auction %v", item.LockedBy)
+	}
+
+	item.LockedBy = auction.AuctionID
+	err := putItem(ctx, item)
+	if err != nil {
+		return err
+	}
+	auction.ItemID = item.ID
+	return putAuction(ctx, auction)
+}
+
+// moveItem gives the item of an auction to owner, keeping it locked by the
+// auction when locked is set. Auctions without a registered item are skipped.
+func moveItem(ctx contractapi.TransactionContextInterface, auction *Auction, owner string, locked bool) error {
+	if auction.ItemID == "" {
+		return nil
+	}
+	item, err := lockedItem(ctx, auction)
+	if err != nil {
+		return err
+	}
+
+	item.Owner = owner
+	if !locked {
+		item.LockedBy = ""
+	}
+	return putItem(ctx, item)
+}
+
+// lockedItem returns the item of an auction, which must be locked by it
+func lockedItem(ctx contractapi.TransactionContextInterface, auction *Auction) (*Item, error) {
+	item, err := getItem(ctx, auction.ItemID)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, fmt.Errorf("the item %s does not exist", auction.ItemID)
+	}
+	if item.LockedBy != auction.AuctionID {
+		return nil, fmt.Errorf("item %v is not locked by auction %v", item.ID, auction.AuctionID)
+	}
+	return item, nil
+}
+
+func getItem(ctx contractapi.TransactionContextInterface, id string) (*Item, error) {
+	itemKey, err := ctx.GetStub().CreateCompositeKey(itemKeyType, []string{id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	itemJSON, err := ctx.GetStub().GetState(itemKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if itemJSON == nil {
+		return nil, nil
+	}
+
+	var item *Item
+	err = json.Unmarshal(itemJSON, &item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func putItem(ctx contractapi.TransactionContextInterface, item *Item) error {
+	itemKey, err := ctx.GetStub().CreateCompositeKey(itemKeyType, []string{item.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	itemJSON, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(itemKey, itemJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put item in world state: %v", err)
+	}
+	return nil
+}
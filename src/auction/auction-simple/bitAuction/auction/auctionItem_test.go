@@ -0,0 +1,94 @@
+package auction_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItemAuctionTransfersItem(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	timelimit := now.Add(1 * time.Hour).Format(time.RFC3339Nano)
+
+	err := contract.RegisterItem(ctx, "item1", "blue", 5, 300)
+	assert.NoError(t, err)
+	err = contract.RegisterItem(ctx, "item1", "red", 5, 300)
+	assert.Error(t, err)
+
+	// only the owner can list the item
+	actAs(ctx, "user2")
+	err = contract.CreateItemAuction(ctx, "auction1", "item1", timelimit, "Desc", "http://img")
+	assert.Error(t, err)
+	actAs(ctx, "user1")
+	err = contract.CreateItemAuction(ctx, "auction1", "item1", timelimit, "Desc", "http://img")
+	assert.NoError(t, err)
+
+	item, err := contract.ReadItem(ctx, "item1")
+	assert.NoError(t, err)
+	assert.Equal(t, "auction1", item.LockedBy)
+	_, err = contract.TransferItem(ctx, "item1", "user2")
+	assert.Error(t, err)
+
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 100)
+
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-1 * time.Minute)
+	putAuctionState(ctx, a)
+	actAs(ctx, "user1")
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+
+	item, err = contract.ReadItem(ctx, "item1")
+	assert.NoError(t, err)
+	assert.Equal(t, "bidder1", item.Owner)
+	assert.Equal(t, "auction1", item.LockedBy)
+
+	err = contract.ConfirmPayment(ctx, "auction1")
+	assert.NoError(t, err)
+	err = contract.ConfirmDelivery(ctx, "auction1")
+	assert.NoError(t, err)
+	actAs(ctx, "bidder1")
+	err = contract.ConfirmReceipt(ctx, "auction1")
+	assert.NoError(t, err)
+
+	old, err := contract.TransferItem(ctx, "item1", "user3")
+	assert.NoError(t, err)
+	assert.Equal(t, "bidder1", old)
+}
+
+func TestItemReturnsToSellerOnDefault(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	err := contract.RegisterItem(ctx, "item1", "blue", 5, 300)
+	assert.NoError(t, err)
+	err = contract.CreateItemAuction(ctx, "auction1", "item1", now.Add(1*time.Hour).Format(time.RFC3339Nano), "Desc", "http://img")
+	assert.NoError(t, err)
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 100)
+
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-1 * time.Minute)
+	putAuctionState(ctx, a)
+	actAs(ctx, "user1")
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+
+	ctx.Stub.OracleTime = formatOracleTime(now.Add(73 * time.Hour))
+	err = contract.DeclareDefault(ctx, "auction1")
+	assert.NoError(t, err)
+	// relisting works on committed state only, as on a peer
+	ctx.Stub.Writes = map[string][]byte{}
+	err = contract.Relist(ctx, "auction1", "relist1", now.Add(100*time.Hour).Format(time.RFC3339Nano))
+	assert.NoError(t, err)
+	ctx.Stub.Commit()
+
+	item, err := contract.ReadItem(ctx, "item1")
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", item.Owner)
+	assert.Equal(t, "relist1", item.LockedBy)
+}
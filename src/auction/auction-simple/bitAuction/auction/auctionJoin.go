@@ -0,0 +1,125 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"fmt"
+
+	"bitAuction/events"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SetParticipation lets the seller decide who can take part in the auction.
+// A restricted auction only accepts bids from participating organizations, and
+// with joinApproval organizations need the seller's approval to join.
+// Restricted auctions always need join approval, otherwise any organization
+// could join on its own. The setting can only change before the first bid.
+func (s *SmartContract) SetParticipation(ctx contractapi.TransactionContextInterface, auctionID string, restricted bool, joinApproval bool) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = s.checkSellerBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+	if restricted && !joinApproval {
+		return fmt.Errorf("restricted auctions need join approval")
+	}
+
+	auction.Restricted = restricted
+	auction.JoinApproval = joinApproval
+	return putAuction(ctx, auction)
+}
+
+// JoinAuction registers the organization of the submitting client as a
+// participant of the auction. The organization is added to the auction and
+// to its endorsement policy, or waits for the seller when joins need approval.
+func (s *SmartContract) JoinAuction(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = isAuctionOpenForBidding(auction); err != nil {
+		return err
+	}
+
+	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if contains(auction.Orgs, clientOrgID) {
+		return fmt.Errorf("organization %v already participates in the auction", clientOrgID)
+	}
+
+	if auction.JoinApproval || auction.Restricted {
+		if contains(auction.PendingOrgs, clientOrgID) {
+			return fmt.Errorf("organization %v is already waiting for approval", clientOrgID)
+		}
+		auction.PendingOrgs = append(auction.PendingOrgs, clientOrgID)
+		err = putAuction(ctx, auction)
+		if err != nil {
+			return err
+		}
+		return emitAuctionEvents(ctx, auction, events.OrgJoined{AuctionID: auctionID, Org: clientOrgID, Status: "pending"})
+	}
+
+	return addAuctionOrg(ctx, auction, clientOrgID)
+}
+
+// ApproveJoin lets the seller admit an organization that asked to join the auction
+func (s *SmartContract) ApproveJoin(ctx contractapi.TransactionContextInterface, auctionID string, orgID string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = isAuctionOpenForBidding(auction); err != nil {
+		return err
+	}
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if auction.Seller != clientID {
+		return fmt.Errorf("joins can only be approved by the seller")
+	}
+	if !contains(auction.PendingOrgs, orgID) {
+		return fmt.Errorf("organization %v has not asked to join the auction", orgID)
+	}
+
+	pending := []string{}
+	for _, org := range auction.PendingOrgs {
+		if org != orgID {
+			pending = append(pending, org)
+		}
+	}
+	auction.PendingOrgs = pending
+
+	return addAuctionOrg(ctx, auction, orgID)
+}
+
+// addAuctionOrg adds an organization to the auction and its endorsement policy
+func addAuctionOrg(ctx contractapi.TransactionContextInterface, auction *Auction, orgID string) error {
+	auction.Orgs = append(auction.Orgs, orgID)
+	err := putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+
+	err = addAssetStateBasedEndorsement(ctx, auction.AuctionID, orgID)
+	if err != nil {
+		return fmt.Errorf("failed setting state based endorsement for new organization: %v", err)
+	}
+	return emitAuctionEvents(ctx, auction, events.OrgJoined{AuctionID: auction.AuctionID, Org: orgID, Status: "joined"})
+}
+
+// checkBidderOrg refuses bidders from organizations that do not participate
+// in a restricted auction
+func checkBidderOrg(auction *Auction, org string) error {
+	if auction.Restricted && !contains(auction.Orgs, org) {
+		return fmt.Errorf("organization %v does not participate in the restricted auction", org)
+	}
+	return nil
+}
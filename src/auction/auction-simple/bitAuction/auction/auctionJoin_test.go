@@ -0,0 +1,85 @@
+package auction_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoinAuction(t *testing.T) {
+	contract, ctx := setup()
+	putOpenAuction(ctx, time.Now().Add(1*time.Hour))
+
+	ctx.Identity.MSPID = "Org2MSP"
+	err := contract.JoinAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Org1MSP", "Org2MSP"}, getAuction(ctx).Orgs)
+	assert.Equal(t, "OrgJoined", ctx.Stub.EventName)
+
+	err = contract.JoinAuction(ctx, "auction1")
+	assert.Error(t, err)
+}
+
+func TestJoinAuctionWithApproval(t *testing.T) {
+	contract, ctx := setup()
+	putOpenAuction(ctx, time.Now().Add(1*time.Hour))
+	err := contract.SetParticipation(ctx, "auction1", true, true)
+	assert.NoError(t, err)
+
+	ctx.Identity.MSPID = "Org2MSP"
+	actAs(ctx, "user2")
+	err = contract.JoinAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Org1MSP"}, getAuction(ctx).Orgs)
+	assert.Equal(t, []string{"Org2MSP"}, getAuction(ctx).PendingOrgs)
+
+	// only the seller approves joins
+	err = contract.ApproveJoin(ctx, "auction1", "Org2MSP")
+	assert.Error(t, err)
+
+	ctx.Identity.MSPID = "Org1MSP"
+	actAs(ctx, "user1")
+	err = contract.ApproveJoin(ctx, "auction1", "Org2MSP")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Org1MSP", "Org2MSP"}, getAuction(ctx).Orgs)
+	assert.Empty(t, getAuction(ctx).PendingOrgs)
+}
+
+func TestRestrictedAuctionRefusesOtherOrgs(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	putOpenAuction(ctx, now.Add(1*time.Hour))
+	err := contract.SetParticipation(ctx, "auction1", true, false)
+	assert.Error(t, err)
+	err = contract.SetParticipation(ctx, "auction1", true, true)
+	assert.NoError(t, err)
+
+	ctx.Identity.MSPID = "Org3MSP"
+	actAs(ctx, "user3")
+	_, err = contract.Bid(ctx, "auction1", "100")
+	assert.Error(t, err)
+
+	priceJSON, _ := json.Marshal(100)
+	ctx.Stub.State["bid:auction1:tx1"] = priceJSON
+	err = contract.SubmitBid(ctx, "auction1", "tx1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "does not participate")
+
+	// joining on its own does not let the organization in
+	err = contract.JoinAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	err = contract.SubmitBid(ctx, "auction1", "tx1")
+	assert.Error(t, err)
+
+	ctx.Identity.MSPID = "Org1MSP"
+	actAs(ctx, "user1")
+	err = contract.ApproveJoin(ctx, "auction1", "Org3MSP")
+	assert.NoError(t, err)
+	ctx.Identity.MSPID = "Org3MSP"
+	actAs(ctx, "user3")
+	err = contract.SubmitBid(ctx, "auction1", "tx1")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,308 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"bitAuction/events"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// The leader of an auction is tracked without making concurrent bids conflict.
+// SubmitBid never writes a key that another bid reads: every accepted bid is
+// recorded under its own leaderdelta key, and the deltas are folded into the
+// leader record lazily by ConsolidateLeader and EndAuction. Auctions created
+// before leader tracking have no leader record and fall back to scanning all bids.
+const (
+	leaderKeyType      = "leader"
+	leaderDeltaKeyType = "leaderdelta"
+)
+
+// Leader is the consolidated highest bid of an auction
+type Leader struct {
+	AuctionID string   `json:"auctionID"`
+	Bid       *FullBid `json:"bid"`
+}
+
+// GetCurrentLeader returns the current winning valid bid of an auction, or nil
+// if there is none. It reads the leader record and the bids not consolidated yet.
+func (s *SmartContract) GetCurrentLeader(ctx contractapi.TransactionContextInterface, auctionID string) (*FullBid, error) {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	return s.currentLeader(ctx, auction)
+}
+
+// currentLeader returns the winning bid of the auction including the bids
+// not consolidated yet
+func (s *SmartContract) currentLeader(ctx contractapi.TransactionContextInterface, auction *Auction) (*FullBid, error) {
+	auctionID := auction.AuctionID
+	leader, err := getLeaderRecord(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	if leader == nil {
+		return s.GetHb(ctx, auctionID)
+	}
+
+	deltas, err := getLeaderDeltas(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	highest := leader.Bid
+	for _, delta := range deltas {
+		better, err := s.isBetterBid(auction.Direction, delta.bid, highest)
+		if err != nil {
+			return nil, err
+		}
+		if better {
+			highest = delta.bid
+		}
+	}
+	return highest, nil
+}
+
+// ConsolidateLeader folds the bids submitted since the last consolidation into
+// the leader record of the auction. Anyone can submit it; running it regularly
+// keeps GetCurrentLeader and EndAuction cheap on busy auctions.
+func (s *SmartContract) ConsolidateLeader(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+	previous, err := getLeaderRecord(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+
+	highest, err := s.consolidateLeader(ctx, auctionID, auction.Direction)
+	if err != nil {
+		return err
+	}
+	if highest == nil || (previous != nil && previous.Bid != nil && previous.Bid.TxID == highest.TxID) {
+		return nil
+	}
+
+	newLeader := events.NewLeader{AuctionID: auctionID, TxID: highest.TxID, Bidder: highest.Bidder, Price: highest.Price}
+	if previous != nil && previous.Bid != nil {
+		newLeader.PreviousPrice = previous.Bid.Price
+	}
+	return emitAuctionEvents(ctx, auction, newLeader)
+}
+
+// consolidateLeader folds all leader deltas into the leader record, deletes
+// them and returns the winning bid of an auction of the given direction
+func (s *SmartContract) consolidateLeader(ctx contractapi.TransactionContextInterface, auctionID string, direction string) (*FullBid, error) {
+	collection, err := auctionCollection(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	leader, err := getLeaderRecord(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	if leader == nil {
+		// migrate an auction from before leader tracking, the scan includes every delta
+		return s.recomputeLeader(ctx, auctionID, "")
+	}
+
+	deltas, err := getLeaderDeltas(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	for _, delta := range deltas {
+		better, err := s.isBetterBid(direction, delta.bid, leader.Bid)
+		if err != nil {
+			return nil, err
+		}
+		if better {
+			leader.Bid = delta.bid
+		}
+		err = delAuctionData(ctx, collection, delta.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete leader delta: %v", err)
+		}
+	}
+
+	err = putLeaderRecord(ctx, collection, leader)
+	if err != nil {
+		return nil, err
+	}
+	return leader.Bid, nil
+}
+
+// recomputeLeader rebuilds the leader record from all valid bids but the bid
+// excluded. It is used when the leading bid becomes invalid, which the
+// transaction invalidating it has to exclude because it cannot read its own
+// write of the bid.
+func (s *SmartContract) recomputeLeader(ctx contractapi.TransactionContextInterface, auctionID string, excluded string) (*FullBid, error) {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	highest, err := s.highestValidBid(ctx, auction, excluded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get highest bid: %v", err)
+	}
+
+	deltas, err := getLeaderDeltas(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	for _, delta := range deltas {
+		err = delAuctionData(ctx, auction.Collection, delta.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete leader delta: %v", err)
+		}
+	}
+
+	err = putLeaderRecord(ctx, auction.Collection, &Leader{AuctionID: auctionID, Bid: highest})
+	if err != nil {
+		return nil, err
+	}
+	return highest, nil
+}
+
+// leaderAfterInvalidation keeps leader tracking correct after a bid was made
+// invalid. The delta of the bid is dropped, and if the bid was the
+// consolidated leader the record is recomputed from all bids.
+func (s *SmartContract) leaderAfterInvalidation(ctx contractapi.TransactionContextInterface, auctionID string, txID string) error {
+	collection, err := auctionCollection(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+	deltaKey, err := ctx.GetStub().CreateCompositeKey(leaderDeltaKeyType, []string{auctionID, txID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	err = delAuctionData(ctx, collection, deltaKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete leader delta: %v", err)
+	}
+
+	leader, err := getLeaderRecord(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+	if leader == nil || leader.Bid == nil || leader.Bid.TxID != txID {
+		return nil
+	}
+	_, err = s.recomputeLeader(ctx, auctionID, txID)
+	return err
+}
+
+// consolidatedLeader returns the highest bid as of the last consolidation,
+// which is what SubmitBid compares new bids against
+func (s *SmartContract) consolidatedLeader(ctx contractapi.TransactionContextInterface, auctionID string) (*FullBid, error) {
+	leader, err := getLeaderRecord(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	if leader == nil {
+		return s.GetHb(ctx, auctionID)
+	}
+	return leader.Bid, nil
+}
+
+func getLeaderRecord(ctx contractapi.TransactionContextInterface, auctionID string) (*Leader, error) {
+	collection, err := auctionCollection(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	leaderKey, err := ctx.GetStub().CreateCompositeKey(leaderKeyType, []string{auctionID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	leaderJSON, err := getAuctionData(ctx, collection, leaderKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get leader of auction %v: %v", auctionID, err)
+	}
+	if leaderJSON == nil {
+		return nil, nil
+	}
+
+	var leader *Leader
+	err = json.Unmarshal(leaderJSON, &leader)
+	if err != nil {
+		return nil, err
+	}
+	return leader, nil
+}
+
+// putLeaderRecord writes the leader of an auction to public state, or to the
+// collection of a private auction
+func putLeaderRecord(ctx contractapi.TransactionContextInterface, collection string, leader *Leader) error {
+	leaderKey, err := ctx.GetStub().CreateCompositeKey(leaderKeyType, []string{leader.AuctionID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	leaderJSON, err := json.Marshal(leader)
+	if err != nil {
+		return err
+	}
+	err = putAuctionData(ctx, collection, leaderKey, leaderJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put leader in state: %v", err)
+	}
+	return nil
+}
+
+// putLeaderDelta records an accepted bid for the next consolidation
+func putLeaderDelta(ctx contractapi.TransactionContextInterface, auctionID string, bid *FullBid) error {
+	collection, err := auctionCollection(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+	deltaKey, err := ctx.GetStub().CreateCompositeKey(leaderDeltaKeyType, []string{auctionID, bid.TxID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	bidJSON, err := json.Marshal(bid)
+	if err != nil {
+		return err
+	}
+	err = putAuctionData(ctx, collection, deltaKey, bidJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put leader delta in state: %v", err)
+	}
+	return nil
+}
+
+type leaderDelta struct {
+	key string
+	bid *FullBid
+}
+
+func getLeaderDeltas(ctx contractapi.TransactionContextInterface, auctionID string) ([]leaderDelta, error) {
+	collection, err := auctionCollection(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := getAuctionDataByPartialKey(ctx, collection, leaderDeltaKeyType, []string{auctionID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get leader deltas for auction %s: %v", auctionID, err)
+	}
+	defer iter.Close()
+
+	var deltas []leaderDelta
+	for iter.HasNext() {
+		queryResponse, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var bid FullBid
+		err = json.Unmarshal(queryResponse.Value, &bid)
+		if err != nil {
+			return nil, err
+		}
+		deltas = append(deltas, leaderDelta{key: queryResponse.Key, bid: &bid})
+	}
+	return deltas, nil
+}
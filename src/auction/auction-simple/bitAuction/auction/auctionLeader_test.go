@@ -0,0 +1,182 @@
+package auction_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func submitBid(t *testing.T, contract *auction.SmartContract, ctx *MockContext, txID string, price int) {
+	ctx.Stub.TxID = txID
+	priceJSON, _ := json.Marshal(price)
+	ctx.Stub.State["bid:auction1:"+txID] = priceJSON
+	err := contract.SubmitBid(ctx, "auction1", txID)
+	assert.NoError(t, err)
+}
+
+func createAuction(t *testing.T, contract *auction.SmartContract, ctx *MockContext, timelimit time.Time) {
+	err := contract.CreateAuction(ctx, "auction1", "Laptop", timelimit.Format(time.RFC3339Nano), "Desc", "http://img")
+	assert.NoError(t, err)
+}
+
+func TestGetCurrentLeader(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	actAs(ctx, "bidder1")
+
+	leader, err := contract.GetCurrentLeader(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Nil(t, leader)
+
+	submitBid(t, contract, ctx, "tx1", 100)
+	submitBid(t, contract, ctx, "tx2", 300)
+	submitBid(t, contract, ctx, "tx3", 200)
+
+	leader, err = contract.GetCurrentLeader(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "tx2", leader.TxID)
+	assert.Equal(t, usd(300), leader.Price)
+
+	err = contract.ConsolidateLeader(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Nil(t, ctx.Stub.State["leaderdelta:auction1:tx1"])
+	assert.Equal(t, "NewLeader", ctx.Stub.EventName)
+
+	leader, err = contract.GetCurrentLeader(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "tx2", leader.TxID)
+}
+
+func TestRetractLeaderRecomputesLeader(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 100)
+	submitBid(t, contract, ctx, "tx2", 10000)
+
+	err := contract.ConsolidateLeader(ctx, "auction1")
+	assert.NoError(t, err)
+
+	ctx.Stub.TxID = "tx3"
+	err = contract.RetractBid(ctx, "auction1", "tx2", "typo")
+	assert.NoError(t, err)
+
+	leader, err := contract.GetCurrentLeader(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "tx1", leader.TxID)
+}
+
+// populateAuction stores an auction that has ended with n bids. With tracked
+// set, the bids are consolidated in a leader record except for the last 100.
+func populateAuction(stub *MockStub, n int, tracked bool) {
+	start := time.Now().Add(-2 * time.Hour)
+	var highest *auction.FullBid
+	for i := 0; i < n; i++ {
+		txID := fmt.Sprintf("tx%06d", i)
+		bid := auction.FullBid{
+			Type:      "bid",
+			TxID:      txID,
+			Price:     usd(int64((i * 7919) % 100003)),
+			Org:       "Org1MSP",
+			Bidder:    fmt.Sprintf("user%d", i%50),
+			Valid:     true,
+			Timestamp: start.Add(time.Duration(i) * time.Millisecond),
+		}
+		bidJSON, _ := json.Marshal(bid)
+		stub.State["fullbid:auction1:"+txID] = bidJSON
+		if !tracked {
+			continue
+		}
+		if i >= n-100 {
+			stub.State["leaderdelta:auction1:"+txID] = bidJSON
+		} else if highest == nil || bid.Price.Amount > highest.Price.Amount {
+			b := bid
+			highest = &b
+		}
+	}
+	if tracked {
+		leaderJSON, _ := json.Marshal(auction.Leader{AuctionID: "auction1", Bid: highest})
+		stub.State["leader:auction1"] = leaderJSON
+	}
+}
+
+// BenchmarkEndAuction compares ending an auction with 100k bids by scanning
+// every bid against ending it from the leader record
+func BenchmarkEndAuction(b *testing.B) {
+	auctionJSON, _ := json.Marshal(auction.Auction{
+		AuctionID: "auction1",
+		Seller:    "user1",
+		Status:    "open",
+		Timelimit: time.Now().Add(-1 * time.Hour),
+	})
+
+	for _, tracked := range []bool{false, true} {
+		name := "FullScan"
+		if tracked {
+			name = "LeaderRecord"
+		}
+		b.Run(name, func(b *testing.B) {
+			contract, ctx := setup()
+			populateAuction(ctx.Stub, 100000, tracked)
+			leaderJSON := ctx.Stub.State["leader:auction1"]
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				ctx.Stub.State["auction1"] = auctionJSON
+				if tracked {
+					ctx.Stub.State["leader:auction1"] = leaderJSON
+					for j := 100000 - 100; j < 100000; j++ {
+						txID := fmt.Sprintf("tx%06d", j)
+						ctx.Stub.State["leaderdelta:auction1:"+txID] = ctx.Stub.State["fullbid:auction1:"+txID]
+					}
+				}
+				b.StartTimer()
+				if err := contract.EndAuction(ctx, "auction1"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// a peer does not read the writes of the running transaction, so the
+// retracted bid must not be read back as the leader
+func TestRetractLeaderWithoutReadYourWrites(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 100)
+	submitBid(t, contract, ctx, "tx2", 10000)
+	err := contract.ConsolidateLeader(ctx, "auction1")
+	assert.NoError(t, err)
+
+	ctx.Stub.Writes = map[string][]byte{}
+	ctx.Stub.TxID = "tx3"
+	err = contract.RetractBid(ctx, "auction1", "tx2", "typo")
+	assert.NoError(t, err)
+	ctx.Stub.Commit()
+
+	leader, err := contract.GetCurrentLeader(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "tx1", leader.TxID)
+
+	ctx.Stub.TxID = "tx4"
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "auctioneer"}
+	err = contract.InvalidateBid(ctx, "auction1", "tx1", "duplicate", "")
+	assert.NoError(t, err)
+	ctx.Stub.Commit()
+	leader, err = contract.GetCurrentLeader(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Nil(t, leader)
+}
@@ -0,0 +1,209 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"fmt"
+	"sort"
+
+	"bitAuction/events"
+	"bitAuction/money"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// pricing rules of multi-unit auctions
+const (
+	pricingUniform  = "uniform"
+	pricingPayAsBid = "pay-as-bid"
+)
+
+// Allocation is the number of units a bid won in a multi-unit auction and
+// what the bidder pays for them
+type Allocation struct {
+	Bidder    string      `json:"bidder"`
+	BidTxID   string      `json:"bidTxID"`
+	Quantity  int         `json:"quantity"`
+	UnitPrice money.Money `json:"unitPrice"`
+	Total     money.Money `json:"total"`
+}
+
+// SetMultiUnit lets the seller sell quantity identical units. With "uniform"
+// pricing every winner pays the lowest accepted unit price, with "pay-as-bid"
+// the price of their own bid. A quantity of 1 sells a single item again.
+// Multi-unit auctions are settled off-chain, so they cannot sell a registered
+// item, use escrow or have a buy-now price. The setting can only change
+// before the first bid.
+func (s *SmartContract) SetMultiUnit(ctx contractapi.TransactionContextInterface, auctionID string, quantity int, pricing string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = s.checkSellerBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+	if quantity < 1 {
+		return fmt.Errorf("an auction sells at least one unit")
+	}
+	if quantity == 1 {
+		auction.Quantity = 0
+		auction.Pricing = ""
+		return putAuction(ctx, auction)
+	}
+	if pricing != pricingUniform && pricing != pricingPayAsBid {
+		return fmt.Errorf("unknown pricing rule %v", pricing)
+	}
+	if auction.ItemID != "" {
+		return fmt.Errorf("auction sells the registered item %v, which is a single unit", auction.ItemID)
+	}
+	if len(auction.Lots) > 0 {
+		return fmt.Errorf("package auctions sell single lots")
+	}
+	if auction.Clock != nil {
+		return fmt.Errorf("clock auctions sell a single unit")
+	}
+	if auction.EscrowMode == escrowDeposit || auction.EscrowMode == escrowFull {
+		return fmt.Errorf("multi-unit auctions are settled off-chain, disable escrow first")
+	}
+	if auction.BuyNowPrice.IsPositive() {
+		return fmt.Errorf("multi-unit auctions have no buy-now price, remove it first")
+	}
+
+	auction.Quantity = quantity
+	auction.Pricing = pricing
+	return putAuction(ctx, auction)
+}
+
+// PlaceMultiUnitBid places a bid for quantity units of a multi-unit auction
+// at price per unit in a single transaction, like PlaceBid. The function
+// returns the transaction ID that identifies the bid.
+func (s *SmartContract) PlaceMultiUnitBid(ctx contractapi.TransactionContextInterface, auctionID string, quantity int, price string) (string, error) {
+	return s.placeBid(ctx, auctionID, price, quantity, nil)
+}
+
+// allocateUnits ends a multi-unit auction by allocating its units to the
+// best valid bids
+func (s *SmartContract) allocateUnits(ctx contractapi.TransactionContextInterface, auction *Auction) error {
+	// fold the leader deltas so that none are left behind the ended auction
+	_, err := s.consolidateLeader(ctx, auction.AuctionID, auction.Direction)
+	if err != nil {
+		return fmt.Errorf("failed to get highest bid: %v", err)
+	}
+	bids, err := s.QueryBids(ctx, auction.AuctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get bids: %v", err)
+	}
+	allocations, clearingPrice, err := s.clearUnits(auction, bids)
+	if err != nil {
+		return err
+	}
+
+	outcome := outcomeBidding
+	if len(allocations) == 0 {
+		outcome = outcomeNoSale
+	}
+	auction.Winner = ""
+	auction.Price = clearingPrice
+	auction.Allocations = allocations
+	auction.Status = "ended"
+	auction.Outcome = outcome
+	err = putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+
+	allocated := events.UnitsAllocated{
+		AuctionID:     auction.AuctionID,
+		Pricing:       auction.Pricing,
+		ClearingPrice: clearingPrice,
+		Allocations:   []events.UnitAllocation{},
+	}
+	for _, allocation := range allocations {
+		allocated.Allocations = append(allocated.Allocations, events.UnitAllocation(*allocation))
+	}
+	moved, err := s.lotClosed(ctx, auction)
+	if err != nil {
+		return err
+	}
+	evts := []events.Event{
+		events.AuctionEnded{AuctionID: auction.AuctionID, Winner: auction.Winner, Price: auction.Price, Outcome: outcome},
+		allocated,
+	}
+	return emitAuctionEvents(ctx, auction, append(evts, moved...)...)
+}
+
+// clearUnits fills the best valid bids until the units run out, the last one
+// possibly in part. Bids at the same price are filled in the order they were
+// timestamped. It returns the allocations and the clearing price, the unit
+// price of the last bid filled.
+func (s *SmartContract) clearUnits(auction *Auction, bids []*FullBid) ([]*Allocation, money.Money, error) {
+	ranked := []*FullBid{}
+	for _, bid := range bids {
+		if bid.Valid {
+			ranked = append(ranked, bid)
+		}
+	}
+	var err error
+	sort.SliceStable(ranked, func(i, j int) bool {
+		better, betterErr := s.isBetterBid(auction.Direction, ranked[i], ranked[j])
+		if betterErr != nil {
+			err = betterErr
+		}
+		return better
+	})
+	if err != nil {
+		return nil, money.Money{}, err
+	}
+
+	allocations := []*Allocation{}
+	clearingPrice := money.Zero(auction.Currency)
+	remaining := auctionUnits(auction)
+	for _, bid := range ranked {
+		if remaining == 0 {
+			break
+		}
+		quantity := bidQuantity(bid)
+		if quantity > remaining {
+			quantity = remaining
+		}
+		remaining -= quantity
+		clearingPrice = bid.Price
+		allocations = append(allocations, &Allocation{Bidder: bid.Bidder, BidTxID: bid.TxID, Quantity: quantity, UnitPrice: bid.Price})
+	}
+
+	for _, allocation := range allocations {
+		if auction.Pricing == pricingUniform {
+			allocation.UnitPrice = clearingPrice
+		}
+		total, err := allocation.UnitPrice.Times(int64(allocation.Quantity))
+		if err != nil {
+			return nil, money.Money{}, err
+		}
+		allocation.Total = total
+	}
+	return allocations, clearingPrice, nil
+}
+
+// auctionUnits returns the number of units an auction sells
+func auctionUnits(auction *Auction) int {
+	if auction.Quantity < 1 {
+		return 1
+	}
+	return auction.Quantity
+}
+
+// settledOffChain reports whether the auction ends without a settlement
+// between a single buyer and the seller
+func settledOffChain(auction *Auction) bool {
+	return auction.Direction == directionReverse || auctionUnits(auction) > 1 || len(auction.Lots) > 0
+}
+
+// bidQuantity returns the number of units a bid is for
+func bidQuantity(bid *FullBid) int {
+	if bid.Quantity < 1 {
+		return 1
+	}
+	return bid.Quantity
+}
@@ -0,0 +1,100 @@
+package auction_test
+
+import (
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// multiUnitAuction creates an auction of 6 units and places bids for 2 at 300,
+// 3 at 200 by two bidders, and 2 at 100, then ends it
+func multiUnitAuction(t *testing.T, contract *auction.SmartContract, ctx *MockContext, pricing string) auction.Auction {
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	err := contract.SetMultiUnit(ctx, "auction1", 6, pricing)
+	assert.NoError(t, err)
+
+	for _, bid := range []struct {
+		bidder   string
+		txID     string
+		quantity int
+		price    string
+		at       time.Duration
+	}{
+		{"bidder1", "tx1", 2, "300", 0},
+		{"bidder2", "tx2", 3, "200", 2 * time.Minute},
+		{"bidder3", "tx3", 3, "200", 1 * time.Minute},
+		{"bidder4", "tx4", 2, "100", 0},
+	} {
+		actAs(ctx, bid.bidder)
+		ctx.Stub.TxID = bid.txID
+		ctx.Stub.OracleTime = formatOracleTime(now.Add(bid.at))
+		_, err = contract.PlaceMultiUnitBid(ctx, "auction1", bid.quantity, bid.price)
+		assert.NoError(t, err)
+	}
+
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-1 * time.Minute)
+	putAuctionState(ctx, a)
+	actAs(ctx, "user1")
+	ctx.Stub.TxID = "end"
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	return getAuction(ctx)
+}
+
+func TestMultiUnitUniformPricing(t *testing.T) {
+	contract, ctx := setup()
+	a := multiUnitAuction(t, contract, ctx, "uniform")
+
+	// the earlier bid at the margin is filled first, the later one in part
+	assert.Equal(t, "ended", a.Status)
+	assert.Equal(t, "", a.Winner)
+	assert.Equal(t, usd(200), a.Price)
+	assert.Equal(t, []*auction.Allocation{
+		{Bidder: "bidder1", BidTxID: "tx1", Quantity: 2, UnitPrice: usd(200), Total: usd(400)},
+		{Bidder: "bidder3", BidTxID: "tx3", Quantity: 3, UnitPrice: usd(200), Total: usd(600)},
+		{Bidder: "bidder2", BidTxID: "tx2", Quantity: 1, UnitPrice: usd(200), Total: usd(200)},
+	}, a.Allocations)
+	assert.Equal(t, "AuctionEnded", ctx.Stub.EventName)
+}
+
+func TestMultiUnitPayAsBid(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	err := contract.SetMultiUnit(ctx, "auction1", 4, "second-price")
+	assert.Error(t, err)
+	err = contract.SetMultiUnit(ctx, "auction1", 4, "pay-as-bid")
+	assert.NoError(t, err)
+	err = contract.SetEscrow(ctx, "auction1", "full", "")
+	assert.Error(t, err)
+
+	actAs(ctx, "bidder1")
+	ctx.Stub.TxID = "tx1"
+	_, err = contract.PlaceMultiUnitBid(ctx, "auction1", 5, "100")
+	assert.Error(t, err)
+	_, err = contract.PlaceMultiUnitBid(ctx, "auction1", 3, "300")
+	assert.NoError(t, err)
+	actAs(ctx, "bidder2")
+	ctx.Stub.TxID = "tx2"
+	_, err = contract.PlaceMultiUnitBid(ctx, "auction1", 2, "250")
+	assert.NoError(t, err)
+
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-1 * time.Minute)
+	putAuctionState(ctx, a)
+	actAs(ctx, "user1")
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []*auction.Allocation{
+		{Bidder: "bidder1", BidTxID: "tx1", Quantity: 3, UnitPrice: usd(300), Total: usd(900)},
+		{Bidder: "bidder2", BidTxID: "tx2", Quantity: 1, UnitPrice: usd(250), Total: usd(250)},
+	}, getAuction(ctx).Allocations)
+}
@@ -0,0 +1,311 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"bitAuction/events"
+	"bitAuction/money"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxPackageSearchNodes bounds the winner determination of package auctions.
+// When the search visits this many nodes the best allocation found so far
+// wins. The search order is fixed, so every endorser stops at the same node.
+const maxPackageSearchNodes = 100000
+
+// LotAward is the package bid that won a lot. Lots nobody won have an empty
+// bidder and BidTxID.
+type LotAward struct {
+	Lot          string      `json:"lot"`
+	Bidder       string      `json:"bidder"`
+	BidTxID      string      `json:"bidTxID"`
+	Package      []string    `json:"package"`
+	PackagePrice money.Money `json:"packagePrice"`
+}
+
+// SetPackageLots lets the seller split the auction into lots that bidders
+// bid on in packages with PlacePackageBid, for example lots 3, 4 and 7
+// together for 900. An empty list makes it a single item auction again.
+// Package auctions are settled off-chain. The lots can only change before the
+// first bid.
+func (s *SmartContract) SetPackageLots(ctx contractapi.TransactionContextInterface, auctionID string, lots []string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = s.checkSellerBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+	if len(lots) == 0 {
+		auction.Lots = nil
+		return putAuction(ctx, auction)
+	}
+	for i, lot := range lots {
+		if lot == "" {
+			return fmt.Errorf("lot IDs cannot be empty")
+		}
+		if contains(lots[:i], lot) {
+			return fmt.Errorf("lot %v is listed twice", lot)
+		}
+	}
+	if auction.ItemID != "" {
+		return fmt.Errorf("auction sells the registered item %v, which cannot be split into lots", auction.ItemID)
+	}
+	if auction.Direction == directionReverse || auctionUnits(auction) > 1 {
+		return fmt.Errorf("reverse and multi-unit auctions cannot have lots")
+	}
+	if auction.Clock != nil {
+		return fmt.Errorf("clock auctions cannot have lots")
+	}
+	if auction.EscrowMode == escrowDeposit || auction.EscrowMode == escrowFull {
+		return fmt.Errorf("package auctions are settled off-chain, disable escrow first")
+	}
+	if auction.BuyNowPrice.IsPositive() {
+		return fmt.Errorf("package auctions have no buy-now price, remove it first")
+	}
+
+	auction.Lots = lots
+	return putAuction(ctx, auction)
+}
+
+// PlacePackageBid places a bid of price for all of the given lots together in
+// a single transaction, like PlaceBid. A bidder can place several packages
+// and win any of them that do not overlap. The function returns the
+// transaction ID that identifies the bid.
+func (s *SmartContract) PlacePackageBid(ctx contractapi.TransactionContextInterface, auctionID string, lots []string, price string) (string, error) {
+	return s.placeBid(ctx, auctionID, price, 1, lots)
+}
+
+// checkBidLots checks that a bid names lots exactly when the auction has
+// them, and returns the lots of the bid sorted
+func checkBidLots(auction *Auction, lots []string) ([]string, error) {
+	if len(auction.Lots) == 0 {
+		if len(lots) > 0 {
+			return nil, fmt.Errorf("auction %v has no lots", auction.AuctionID)
+		}
+		return nil, nil
+	}
+	if len(lots) == 0 {
+		return nil, fmt.Errorf("bids on a package auction must name the lots they are for")
+	}
+
+	sorted := append([]string{}, lots...)
+	sort.Strings(sorted)
+	for i, lot := range sorted {
+		if !contains(auction.Lots, lot) {
+			return nil, fmt.Errorf("auction %v has no lot %v", auction.AuctionID, lot)
+		}
+		if i > 0 && sorted[i-1] == lot {
+			return nil, fmt.Errorf("lot %v is named twice", lot)
+		}
+	}
+	return sorted, nil
+}
+
+// awardLots ends a package auction with the non-overlapping packages that
+// bring the most revenue
+func (s *SmartContract) awardLots(ctx contractapi.TransactionContextInterface, auction *Auction) error {
+	// fold the leader deltas so that none are left behind the ended auction
+	_, err := s.consolidateLeader(ctx, auction.AuctionID, auction.Direction)
+	if err != nil {
+		return fmt.Errorf("failed to get highest bid: %v", err)
+	}
+	bids, err := s.QueryBids(ctx, auction.AuctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get bids: %v", err)
+	}
+	winners, revenue, err := determinePackageWinners(auction, bids)
+	if err != nil {
+		return err
+	}
+
+	awarded := events.LotsAwarded{AuctionID: auction.AuctionID, Revenue: revenue, Awards: []events.LotAward{}}
+	auction.LotAwards = []*LotAward{}
+	for _, lot := range auction.Lots {
+		award := &LotAward{Lot: lot, Package: []string{}, PackagePrice: money.Zero(auction.Currency)}
+		for _, bid := range winners {
+			if contains(bid.Lots, lot) {
+				award.Bidder = bid.Bidder
+				award.BidTxID = bid.TxID
+				award.Package = bid.Lots
+				award.PackagePrice = bid.Price
+			}
+		}
+		auction.LotAwards = append(auction.LotAwards, award)
+		awarded.Awards = append(awarded.Awards, events.LotAward(*award))
+	}
+
+	outcome := outcomeBidding
+	if len(winners) == 0 {
+		outcome = outcomeNoSale
+	}
+	auction.Winner = ""
+	auction.Price = revenue
+	auction.Status = "ended"
+	auction.Outcome = outcome
+	err = putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+	moved, err := s.lotClosed(ctx, auction)
+	if err != nil {
+		return err
+	}
+	evts := []events.Event{
+		events.AuctionEnded{AuctionID: auction.AuctionID, Winner: auction.Winner, Price: auction.Price, Outcome: outcome},
+		awarded,
+	}
+	return emitAuctionEvents(ctx, auction, append(evts, moved...)...)
+}
+
+// determinePackageWinners returns the valid package bids with pairwise
+// disjoint lots whose prices add up to the most revenue, and the revenue.
+//
+// Bids are ranked by price, highest first, then by timestamp and transaction
+// ID. A depth-first branch and bound search decides for each bid in rank order
+// whether to take it, trying to take it first, and prunes branches that
+// cannot beat the best revenue found. Only strictly better allocations replace
+// the best one, so of allocations with equal revenue the one taking the first
+// bid in rank order on which they differ wins.
+func determinePackageWinners(auction *Auction, bids []*FullBid) ([]*FullBid, money.Money, error) {
+	ranked := []*FullBid{}
+	for _, bid := range bids {
+		if bid.Valid && len(bid.Lots) > 0 {
+			ranked = append(ranked, bid)
+		}
+	}
+	var err error
+	sort.SliceStable(ranked, func(i, j int) bool {
+		cmp, cmpErr := ranked[i].Price.Cmp(ranked[j].Price)
+		if cmpErr != nil {
+			err = cmpErr
+			return false
+		}
+		if cmp != 0 {
+			return cmp > 0
+		}
+		if !ranked[i].Timestamp.Equal(ranked[j].Timestamp) {
+			return ranked[i].Timestamp.Before(ranked[j].Timestamp)
+		}
+		return ranked[i].TxID < ranked[j].TxID
+	})
+	if err != nil {
+		return nil, money.Money{}, err
+	}
+
+	// remaining[i] is the revenue of all bids from i on, ignoring overlaps
+	remaining := make([]money.Money, len(ranked)+1)
+	remaining[len(ranked)] = money.Zero(auction.Currency)
+	for i := len(ranked) - 1; i >= 0; i-- {
+		sum, err := addBound(remaining[i+1], ranked[i].Price)
+		if err != nil {
+			return nil, money.Money{}, err
+		}
+		remaining[i] = sum
+	}
+
+	solver := &packageSolver{
+		bids:        ranked,
+		remaining:   remaining,
+		sold:        map[string]bool{},
+		bestRevenue: money.Zero(auction.Currency),
+	}
+	err = solver.search(0, money.Zero(auction.Currency))
+	if err != nil {
+		return nil, money.Money{}, err
+	}
+
+	winners := []*FullBid{}
+	for _, i := range solver.best {
+		winners = append(winners, ranked[i])
+	}
+	return winners, solver.bestRevenue, nil
+}
+
+// packageSolver holds the state of the winner determination search
+type packageSolver struct {
+	bids        []*FullBid
+	remaining   []money.Money
+	sold        map[string]bool
+	taken       []int
+	best        []int
+	bestRevenue money.Money
+	nodes       int
+}
+
+// search decides whether to take bids[i] and the bids after it, given the
+// bids taken so far bring revenue
+func (p *packageSolver) search(i int, revenue money.Money) error {
+	p.nodes++
+	cmp, err := revenue.Cmp(p.bestRevenue)
+	if err != nil {
+		return err
+	}
+	if cmp > 0 {
+		p.bestRevenue = revenue
+		p.best = append([]int{}, p.taken...)
+	}
+	if i == len(p.bids) || p.nodes >= maxPackageSearchNodes {
+		return nil
+	}
+	bound, err := addBound(revenue, p.remaining[i])
+	if err != nil {
+		return err
+	}
+	if cmp, err = bound.Cmp(p.bestRevenue); err != nil {
+		return err
+	}
+	if cmp <= 0 {
+		return nil
+	}
+
+	bid := p.bids[i]
+	if p.fits(bid) {
+		with, err := revenue.Add(bid.Price)
+		if err != nil {
+			return err
+		}
+		p.mark(bid, true)
+		p.taken = append(p.taken, i)
+		err = p.search(i+1, with)
+		p.taken = p.taken[:len(p.taken)-1]
+		p.mark(bid, false)
+		if err != nil {
+			return err
+		}
+	}
+	return p.search(i+1, revenue)
+}
+
+// addBound adds b to the optimistic revenue bound a. The bound saturates at the
+// largest amount instead of overflowing, so that the prices of many
+// overlapping bids only loosen it and cannot keep the auction from closing.
+func addBound(a money.Money, b money.Money) (money.Money, error) {
+	if a.Currency == b.Currency && b.IsPositive() && a.Amount > math.MaxInt64-b.Amount {
+		return money.Money{Amount: math.MaxInt64, Currency: a.Currency}, nil
+	}
+	return a.Add(b)
+}
+
+// fits reports whether none of the lots of the bid are sold yet
+func (p *packageSolver) fits(bid *FullBid) bool {
+	for _, lot := range bid.Lots {
+		if p.sold[lot] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *packageSolver) mark(bid *FullBid, sold bool) {
+	for _, lot := range bid.Lots {
+		p.sold[lot] = sold
+	}
+}
@@ -0,0 +1,119 @@
+package auction_test
+
+import (
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type packageBid struct {
+	bidder string
+	txID   string
+	lots   []string
+	price  string
+}
+
+// packageAuction creates an auction of lots A, B and C, places the package
+// bids in order and ends it
+func packageAuction(t *testing.T, contract *auction.SmartContract, ctx *MockContext, bids []packageBid) auction.Auction {
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	err := contract.SetPackageLots(ctx, "auction1", []string{"A", "B", "C"})
+	assert.NoError(t, err)
+
+	for i, bid := range bids {
+		actAs(ctx, bid.bidder)
+		ctx.Stub.TxID = bid.txID
+		ctx.Stub.OracleTime = formatOracleTime(now.Add(time.Duration(i) * time.Second))
+		_, err = contract.PlacePackageBid(ctx, "auction1", bid.lots, bid.price)
+		assert.NoError(t, err)
+	}
+
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-1 * time.Minute)
+	putAuctionState(ctx, a)
+	actAs(ctx, "user1")
+	ctx.Stub.TxID = "end"
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	return getAuction(ctx)
+}
+
+func TestPackageAuctionMaximisesRevenue(t *testing.T) {
+	contract, ctx := setup()
+	a := packageAuction(t, contract, ctx, []packageBid{
+		{"bidder1", "tx1", []string{"B", "A"}, "900"},
+		{"bidder2", "tx2", []string{"A"}, "500"},
+		{"bidder3", "tx3", []string{"B"}, "500"},
+		{"bidder4", "tx4", []string{"C"}, "100"},
+		{"bidder5", "tx5", []string{"B", "C"}, "550"},
+	})
+
+	assert.Equal(t, "ended", a.Status)
+	assert.Equal(t, usd(1100), a.Price)
+	assert.Equal(t, []*auction.LotAward{
+		{Lot: "A", Bidder: "bidder2", BidTxID: "tx2", Package: []string{"A"}, PackagePrice: usd(500)},
+		{Lot: "B", Bidder: "bidder3", BidTxID: "tx3", Package: []string{"B"}, PackagePrice: usd(500)},
+		{Lot: "C", Bidder: "bidder4", BidTxID: "tx4", Package: []string{"C"}, PackagePrice: usd(100)},
+	}, a.LotAwards)
+	assert.Equal(t, "AuctionEnded", ctx.Stub.EventName)
+}
+
+func TestPackageAuctionTieBreak(t *testing.T) {
+	contract, ctx := setup()
+	a := packageAuction(t, contract, ctx, []packageBid{
+		{"bidder2", "tx1", []string{"A"}, "500"},
+		{"bidder3", "tx2", []string{"B"}, "500"},
+		{"bidder1", "tx3", []string{"A", "B"}, "1000"},
+	})
+
+	// the single bid of 1000 ranks first and wins the tie, lot C is unsold
+	assert.Equal(t, usd(1000), a.Price)
+	assert.Equal(t, "bidder1", a.LotAwards[0].Bidder)
+	assert.Equal(t, "bidder1", a.LotAwards[1].Bidder)
+	assert.Equal(t, "", a.LotAwards[2].Bidder)
+}
+
+func TestPackageAuctionWithHugeOverlappingBids(t *testing.T) {
+	contract, ctx := setup()
+	// the bids add up to more than an amount can hold, but only one can win
+	a := packageAuction(t, contract, ctx, []packageBid{
+		{"bidder1", "tx1", []string{"A", "B"}, "60000000000000000"},
+		{"bidder2", "tx2", []string{"A"}, "50000000000000000"},
+		{"bidder3", "tx3", []string{"B", "C"}, "40000000000000000"},
+	})
+
+	assert.Equal(t, "ended", a.Status)
+	assert.Equal(t, usd(90000000000000000), a.Price)
+	assert.Equal(t, "bidder2", a.LotAwards[0].Bidder)
+	assert.Equal(t, "bidder3", a.LotAwards[1].Bidder)
+	assert.Equal(t, "bidder3", a.LotAwards[2].Bidder)
+}
+
+func TestPackageBidsMustNameLots(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	err := contract.SetPackageLots(ctx, "auction1", []string{"A", "A"})
+	assert.Error(t, err)
+	err = contract.SetPackageLots(ctx, "auction1", []string{"A", "B"})
+	assert.NoError(t, err)
+	err = contract.SetMultiUnit(ctx, "auction1", 3, "uniform")
+	assert.Error(t, err)
+
+	actAs(ctx, "bidder1")
+	ctx.Stub.TxID = "tx1"
+	_, err = contract.PlaceBid(ctx, "auction1", "100")
+	assert.Error(t, err)
+	_, err = contract.PlacePackageBid(ctx, "auction1", []string{"A", "D"}, "100")
+	assert.Error(t, err)
+	_, err = contract.PlacePackageBid(ctx, "auction1", []string{"A", "A"}, "100")
+	assert.Error(t, err)
+	_, err = contract.PlacePackageBid(ctx, "auction1", []string{"B", "A"}, "100")
+	assert.NoError(t, err)
+}
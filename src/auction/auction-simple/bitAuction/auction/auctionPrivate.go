@@ -0,0 +1,526 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"bitAuction/events"
+	"bitAuction/money"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A private auction keeps its details in a private data collection shared by
+// the organization of the seller and the organizations it invites. The public
+// state only holds a stub under the auction ID with the status of the auction,
+// the members of the collection and the hash of the details, which anyone can
+// check the details against once they are disclosed. Collections are part of
+// the chaincode definition, so there is one for every set of organizations,
+// generated by CollectionConfig, and inviting or revoking an organization
+// moves the details to the collection of the new set. The bids, the leader,
+// the bid history and the fee revenue of a private auction are kept in its
+// collection as well, and its events only carry the auction ID once they
+// would reveal a bidder or a price. Live sessions, clocks, sealed bids and
+// escrow keep their records in public state, so private auctions refuse them.
+const (
+	privateAuctionType      = "privateAuction"
+	privateCollectionPrefix = "private"
+
+	// CollectionConfig defines a collection for every set of organizations,
+	// which grows quickly with the number of organizations
+	maxPrivateCollectionOrgs = 8
+)
+
+// PrivateAuctionStub is the public record of a private auction
+type PrivateAuctionStub struct {
+	AuctionID  string   `json:"auctionID"`
+	Type       string   `json:"objectType"`
+	Collection string   `json:"collection"`
+	Members    []string `json:"members"`
+	Hash       string   `json:"hash"`
+	Status     string   `json:"status"`
+}
+
+// CollectionDefinition is an entry of the collections configuration file
+// passed to the chaincode definition
+type CollectionDefinition struct {
+	Name              string `json:"name"`
+	Policy            string `json:"policy"`
+	RequiredPeerCount int    `json:"requiredPeerCount"`
+	MaxPeerCount      int    `json:"maxPeerCount"`
+	BlockToLive       int    `json:"blockToLive"`
+	MemberOnlyRead    bool   `json:"memberOnlyRead"`
+	MemberOnlyWrite   bool   `json:"memberOnlyWrite"`
+}
+
+// CreatePrivateAuction creates an auction only the organization of the seller
+// and the invited organizations can see and bid on
+func (s *SmartContract) CreatePrivateAuction(ctx contractapi.TransactionContextInterface, auctionID string, itemsold string, timelimit string, description string, pictureUrl string, invited []string) error {
+	t, err := time.Parse(time.RFC3339Nano, timelimit)
+	if err != nil {
+		return fmt.Errorf("invalid datetime format: %v", err)
+	}
+	existing, err := ctx.GetStub().GetState(auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction object %v: %v", auctionID, err)
+	}
+	if existing != nil {
+		return fmt.Errorf("auction %v already exists", auctionID)
+	}
+
+	// the write set only keeps the last value of a key, so the public
+	// details written by createAuction never reach the ledger
+	auction, err := s.createAuction(ctx, auctionID, itemsold, t, description, pictureUrl, directionForward)
+	if err != nil {
+		return err
+	}
+	auction.Invited = []string{}
+	for _, org := range invited {
+		if org != sellerOrg(auction) && !contains(auction.Invited, org) {
+			auction.Invited = append(auction.Invited, org)
+		}
+	}
+	if len(privateMembers(auction)) > maxPrivateCollectionOrgs {
+		return fmt.Errorf("private auctions are shared by at most %d organizations", maxPrivateCollectionOrgs)
+	}
+	if err = putPrivateAuction(ctx, auction); err != nil {
+		return err
+	}
+	// createAuction started tracking the leader in public state
+	leaderKey, err := ctx.GetStub().CreateCompositeKey(leaderKeyType, []string{auctionID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	if err = ctx.GetStub().DelState(leaderKey); err != nil {
+		return fmt.Errorf("failed to delete public leader: %v", err)
+	}
+	if err = putLeaderRecord(ctx, auction.Collection, &Leader{AuctionID: auctionID}); err != nil {
+		return err
+	}
+	return emitEvents(ctx, events.PrivateAuctionChanged{AuctionID: auctionID, Action: "created", Collection: auction.Collection})
+}
+
+// InviteOrg lets the seller of an open private auction invite an organization
+func (s *SmartContract) InviteOrg(ctx contractapi.TransactionContextInterface, auctionID string, org string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if auction.Collection == "" {
+		return fmt.Errorf("auction %v is not private", auctionID)
+	}
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if auction.Seller != clientID {
+		return fmt.Errorf("organizations can only be invited by the seller")
+	}
+	if auction.Status != "open" {
+		return fmt.Errorf("auction is not open, status is %v", auction.Status)
+	}
+	if contains(privateMembers(auction), org) {
+		return fmt.Errorf("organization %v is already invited", org)
+	}
+	if len(privateMembers(auction)) >= maxPrivateCollectionOrgs {
+		return fmt.Errorf("private auctions are shared by at most %d organizations", maxPrivateCollectionOrgs)
+	}
+
+	auction.Invited = append(auction.Invited, org)
+	if err = putPrivateAuction(ctx, auction); err != nil {
+		return err
+	}
+	return emitEvents(ctx, events.PrivateAuctionChanged{AuctionID: auctionID, Action: "invited", Org: org, Collection: auction.Collection})
+}
+
+// RevokeInvitation lets the seller withdraw the invitation of an organization
+// before the first bid
+func (s *SmartContract) RevokeInvitation(ctx contractapi.TransactionContextInterface, auctionID string, org string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if auction.Collection == "" {
+		return fmt.Errorf("auction %v is not private", auctionID)
+	}
+	if err = s.checkSellerBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+	if !contains(auction.Invited, org) {
+		return fmt.Errorf("organization %v is not invited", org)
+	}
+
+	invited := []string{}
+	for _, o := range auction.Invited {
+		if o != org {
+			invited = append(invited, o)
+		}
+	}
+	auction.Invited = invited
+	if err = putPrivateAuction(ctx, auction); err != nil {
+		return err
+	}
+	return emitEvents(ctx, events.PrivateAuctionChanged{AuctionID: auctionID, Action: "revoked", Org: org, Collection: auction.Collection})
+}
+
+// QueryPrivateAuctionStub returns the public record of a private auction
+func (s *SmartContract) QueryPrivateAuctionStub(ctx contractapi.TransactionContextInterface, auctionID string) (*PrivateAuctionStub, error) {
+	stubJSON, err := ctx.GetStub().GetState(auctionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auction object %v: %v", auctionID, err)
+	}
+	if stubJSON == nil {
+		return nil, fmt.Errorf("auction does not exist")
+	}
+	var stub PrivateAuctionStub
+	err = json.Unmarshal(stubJSON, &stub)
+	if err != nil {
+		return nil, err
+	}
+	if stub.Type != privateAuctionType {
+		return nil, fmt.Errorf("auction %v is not private", auctionID)
+	}
+	return &stub, nil
+}
+
+// VerifyPrivateAuction checks disclosed details of a private auction against
+// the hash in its public stub, and against the hash of the collection data
+func (s *SmartContract) VerifyPrivateAuction(ctx contractapi.TransactionContextInterface, auctionID string, details string) error {
+	stub, err := s.QueryPrivateAuctionStub(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+	if privateHash([]byte(details)) != stub.Hash {
+		return fmt.Errorf("details do not match the hash of private auction %v", auctionID)
+	}
+	// peers outside the collection only hold the hash of its data
+	collectionHash, err := ctx.GetStub().GetPrivateDataHash(stub.Collection, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get private data hash: %v", err)
+	}
+	if hex.EncodeToString(collectionHash) != stub.Hash {
+		return fmt.Errorf("private auction %v does not match its collection", auctionID)
+	}
+	return nil
+}
+
+// CollectionConfig returns the private data collections of private auctions
+// between the given organizations, one for every set of them
+func CollectionConfig(orgs []string) ([]*CollectionDefinition, error) {
+	members := uniqueSorted(orgs)
+	if len(members) > maxPrivateCollectionOrgs {
+		return nil, fmt.Errorf("private auctions are shared by at most %d organizations", maxPrivateCollectionOrgs)
+	}
+
+	config := []*CollectionDefinition{}
+	for set := 1; set < 1<<len(members); set++ {
+		subset := []string{}
+		policy := []string{}
+		for i, org := range members {
+			if set&(1<<i) != 0 {
+				subset = append(subset, org)
+				policy = append(policy, fmt.Sprintf("'%s.member'", org))
+			}
+		}
+		config = append(config, &CollectionDefinition{
+			Name:            privateCollectionName(subset),
+			Policy:          fmt.Sprintf("OR(%s)", strings.Join(policy, ",")),
+			MaxPeerCount:    len(members),
+			MemberOnlyRead:  true,
+			MemberOnlyWrite: true,
+		})
+	}
+	sort.Slice(config, func(i, j int) bool { return config[i].Name < config[j].Name })
+	return config, nil
+}
+
+// putPrivateAuction writes the details of a private auction to the collection
+// of its members, moving them and the bids from the previous collection when
+// the members changed, and updates its public stub
+func putPrivateAuction(ctx contractapi.TransactionContextInterface, auction *Auction) error {
+	members := privateMembers(auction)
+	collection := privateCollectionName(members)
+	if auction.Collection != "" && auction.Collection != collection {
+		err := ctx.GetStub().DelPrivateData(auction.Collection, auction.AuctionID)
+		if err != nil {
+			return fmt.Errorf("failed to delete auction from collection %v: %v", auction.Collection, err)
+		}
+		err = moveAuctionData(ctx, auction.AuctionID, auction.Collection, collection)
+		if err != nil {
+			return err
+		}
+	}
+	auction.Collection = collection
+
+	auctionJSON, err := json.Marshal(auction)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(collection, auction.AuctionID, auctionJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put auction in collection %v: %v", collection, err)
+	}
+
+	stubJSON, err := json.Marshal(PrivateAuctionStub{
+		AuctionID:  auction.AuctionID,
+		Type:       privateAuctionType,
+		Collection: collection,
+		Members:    members,
+		Hash:       privateHash(auctionJSON),
+		Status:     auction.Status,
+	})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(auction.AuctionID, stubJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put auction in public data: %v", err)
+	}
+	return nil
+}
+
+// getPrivateAuction reads the details of the private auction of a public stub.
+// Only members of its collection can read them.
+func getPrivateAuction(ctx contractapi.TransactionContextInterface, stubJSON []byte) (*Auction, error) {
+	var stub PrivateAuctionStub
+	err := json.Unmarshal(stubJSON, &stub)
+	if err != nil {
+		return nil, err
+	}
+	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client identity %v", err)
+	}
+	if !contains(stub.Members, clientOrgID) {
+		return nil, fmt.Errorf("auction %v is private to the invited organizations", stub.AuctionID)
+	}
+
+	auctionJSON, err := ctx.GetStub().GetPrivateData(stub.Collection, stub.AuctionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auction from collection %v: %v", stub.Collection, err)
+	}
+	if auctionJSON == nil {
+		return nil, fmt.Errorf("details of private auction %v are missing from collection %v", stub.AuctionID, stub.Collection)
+	}
+	if privateHash(auctionJSON) != stub.Hash {
+		return nil, fmt.Errorf("details of private auction %v do not match its public hash", stub.AuctionID)
+	}
+
+	var auction *Auction
+	err = json.Unmarshal(auctionJSON, &auction)
+	if err != nil {
+		return nil, err
+	}
+	if auction.Currency == "" {
+		auction.Currency = money.DefaultCurrency
+	}
+	return auction, nil
+}
+
+// canSeePrivateAuction tells whether the organization of the submitting client
+// is a member of the collection of a private auction stub
+func canSeePrivateAuction(ctx contractapi.TransactionContextInterface, stubJSON []byte) (bool, error) {
+	var stub PrivateAuctionStub
+	err := json.Unmarshal(stubJSON, &stub)
+	if err != nil {
+		return false, err
+	}
+	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return false, fmt.Errorf("failed to get client identity %v", err)
+	}
+	return contains(stub.Members, clientOrgID), nil
+}
+
+// auctionCollection returns the collection holding the bids and the leader of
+// a private auction, or an empty string for public auctions, which keep them in
+// public state. Only members of the collection can read them.
+func auctionCollection(ctx contractapi.TransactionContextInterface, auctionID string) (string, error) {
+	auctionJSON, err := ctx.GetStub().GetState(auctionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get auction object %v: %v", auctionID, err)
+	}
+	if auctionJSON == nil {
+		return "", nil
+	}
+	var stub PrivateAuctionStub
+	err = json.Unmarshal(auctionJSON, &stub)
+	if err != nil {
+		return "", err
+	}
+	if stub.Type != privateAuctionType {
+		return "", nil
+	}
+	visible, err := canSeePrivateAuction(ctx, auctionJSON)
+	if err != nil {
+		return "", err
+	}
+	if !visible {
+		return "", fmt.Errorf("auction %v is private to the invited organizations", auctionID)
+	}
+	return stub.Collection, nil
+}
+
+// getAuctionData reads a key of an auction from public state, or from the
+// collection of a private auction
+func getAuctionData(ctx contractapi.TransactionContextInterface, collection string, key string) ([]byte, error) {
+	if collection == "" {
+		return ctx.GetStub().GetState(key)
+	}
+	return ctx.GetStub().GetPrivateData(collection, key)
+}
+
+// putAuctionData writes a key of an auction to public state, or to the
+// collection of a private auction
+func putAuctionData(ctx contractapi.TransactionContextInterface, collection string, key string, value []byte) error {
+	if collection == "" {
+		return ctx.GetStub().PutState(key, value)
+	}
+	return ctx.GetStub().PutPrivateData(collection, key, value)
+}
+
+// delAuctionData deletes a key of an auction from public state, or from the
+// collection of a private auction
+func delAuctionData(ctx contractapi.TransactionContextInterface, collection string, key string) error {
+	if collection == "" {
+		return ctx.GetStub().DelState(key)
+	}
+	return ctx.GetStub().DelPrivateData(collection, key)
+}
+
+// getAuctionDataByPartialKey iterates over the keys of an auction in public
+// state, or in the collection of a private auction
+func getAuctionDataByPartialKey(ctx contractapi.TransactionContextInterface, collection string, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	if collection == "" {
+		return ctx.GetStub().GetStateByPartialCompositeKey(objectType, attributes)
+	}
+	return ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, objectType, attributes)
+}
+
+// moveAuctionData moves the bids, the leader and the bid history of a private
+// auction to the collection of its new members
+func moveAuctionData(ctx contractapi.TransactionContextInterface, auctionID string, from string, to string) error {
+	for _, objectType := range []string{bidKeyType, fullBidKeyType, leaderKeyType, leaderDeltaKeyType, bidAuditKeyType} {
+		iter, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(from, objectType, []string{auctionID})
+		if err != nil {
+			return fmt.Errorf("failed to get %v keys of auction %v: %v", objectType, auctionID, err)
+		}
+		for iter.HasNext() {
+			kv, err := iter.Next()
+			if err != nil {
+				iter.Close()
+				return err
+			}
+			if err = ctx.GetStub().PutPrivateData(to, kv.Key, kv.Value); err != nil {
+				iter.Close()
+				return fmt.Errorf("failed to put %v in collection %v: %v", kv.Key, to, err)
+			}
+			if err = ctx.GetStub().DelPrivateData(from, kv.Key); err != nil {
+				iter.Close()
+				return fmt.Errorf("failed to delete %v from collection %v: %v", kv.Key, from, err)
+			}
+		}
+		iter.Close()
+	}
+	return nil
+}
+
+// requirePublicAuction refuses a feature that keeps bidders or prices in
+// public state on a private auction
+func requirePublicAuction(auction *Auction, feature string) error {
+	if auction.Collection != "" {
+		return fmt.Errorf("%v keep bidders and prices in public state and are not available on private auctions", feature)
+	}
+	return nil
+}
+
+// emitAuctionEvents emits the events of an auction. Every peer of the channel
+// receives chaincode events, so the events of a private auction only carry the
+// auction ID and at most its status.
+func emitAuctionEvents(ctx contractapi.TransactionContextInterface, auction *Auction, evts ...events.Event) error {
+	if auction.Collection != "" {
+		for i, evt := range evts {
+			evts[i] = privateEvent(evt)
+		}
+	}
+	return emitEvents(ctx, evts...)
+}
+
+func privateEvent(evt events.Event) events.Event {
+	switch e := evt.(type) {
+	case events.AuctionCreated:
+		return events.AuctionCreated{AuctionID: e.AuctionID}
+	case events.BidPlaced:
+		return events.BidPlaced{AuctionID: e.AuctionID}
+	case events.BidSubmitted:
+		return events.BidSubmitted{AuctionID: e.AuctionID}
+	case events.NewLeader:
+		return events.NewLeader{AuctionID: e.AuctionID}
+	case events.DeadlineExtended:
+		return events.DeadlineExtended{AuctionID: e.AuctionID}
+	case events.AuctionEnded:
+		return events.AuctionEnded{AuctionID: e.AuctionID}
+	case events.AuctionCancelled:
+		return events.AuctionCancelled{AuctionID: e.AuctionID, Status: e.Status}
+	case events.BidRetracted:
+		return events.BidRetracted{AuctionID: e.AuctionID}
+	case events.OrgJoined:
+		return events.OrgJoined{AuctionID: e.AuctionID, Status: e.Status}
+	case events.BidInvalidated:
+		return events.BidInvalidated{AuctionID: e.AuctionID}
+	case events.SettlementChanged:
+		return events.SettlementChanged{AuctionID: e.AuctionID, State: e.State}
+	case events.UnitsAllocated:
+		return events.UnitsAllocated{AuctionID: e.AuctionID}
+	case events.LotsAwarded:
+		return events.LotsAwarded{AuctionID: e.AuctionID}
+	case events.ClockConfirmed:
+		return events.ClockConfirmed{AuctionID: e.AuctionID}
+	case events.ClockRoundClosed:
+		return events.ClockRoundClosed{AuctionID: e.AuctionID}
+	case events.SessionCalled:
+		return events.SessionCalled{AuctionID: e.AuctionID}
+	case events.WinnerRevealed:
+		return events.WinnerRevealed{AuctionID: e.AuctionID, Status: e.Status}
+	case events.SealedBidCommitted:
+		return events.SealedBidCommitted{AuctionID: e.AuctionID}
+	}
+	return evt
+}
+
+// privateMembers returns the organizations sharing a private auction: the
+// organization of the seller and the invited ones
+func privateMembers(auction *Auction) []string {
+	return uniqueSorted(append([]string{sellerOrg(auction)}, auction.Invited...))
+}
+
+// privateCollectionName names the collection shared by a set of organizations
+func privateCollectionName(orgs []string) string {
+	return privateCollectionPrefix + "-" + strings.Join(uniqueSorted(orgs), "-")
+}
+
+func privateHash(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func uniqueSorted(values []string) []string {
+	result := []string{}
+	for _, value := range values {
+		if value != "" && !contains(result, value) {
+			result = append(result, value)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
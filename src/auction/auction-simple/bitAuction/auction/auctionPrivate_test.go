@@ -0,0 +1,304 @@
+package auction_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+	"bitAuction/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createPrivateAuction(t *testing.T, contract *auction.SmartContract, ctx *MockContext, invited []string) {
+	timelimit := time.Now().Add(1 * time.Hour).Format(time.RFC3339Nano)
+	err := contract.CreatePrivateAuction(ctx, "auction1", "Manuscript", timelimit, "Desc", "http://img", invited)
+	assert.NoError(t, err)
+}
+
+func openAuctionIDs(t *testing.T, contract *auction.SmartContract, ctx *MockContext) []string {
+	auctions, err := contract.GetAllOpenAuctions(ctx)
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, a := range auctions {
+		ids = append(ids, a.AuctionID)
+	}
+	return ids
+}
+
+func TestPrivateAuctionVisibility(t *testing.T) {
+	contract, ctx := setup()
+	createPrivateAuction(t, contract, ctx, []string{"Org2MSP", "Org1MSP"})
+	evts := decodeEvents(t, ctx)
+	created, ok := evts[0].(*events.PrivateAuctionChanged)
+	assert.True(t, ok)
+	assert.Equal(t, "created", created.Action)
+	assert.Equal(t, "private-Org1MSP-Org2MSP", created.Collection)
+	err := contract.CreateAuction(ctx, "auction2", "Laptop", time.Now().Add(1*time.Hour).Format(time.RFC3339Nano), "Desc", "http://img")
+	assert.NoError(t, err)
+
+	// the public state only holds the stub
+	assert.NotContains(t, string(ctx.Stub.State["auction1"]), "Manuscript")
+	stub, err := contract.QueryPrivateAuctionStub(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Org1MSP", "Org2MSP"}, stub.Members)
+	assert.Equal(t, "open", stub.Status)
+	_, err = contract.QueryPrivateAuctionStub(ctx, "auction2")
+	assert.Error(t, err)
+
+	a, err := contract.QueryAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Manuscript", a.ItemSold)
+	assert.Equal(t, []string{"Org2MSP"}, a.Invited)
+	assert.Equal(t, []string{"auction1", "auction2"}, openAuctionIDs(t, contract, ctx))
+
+	ctx.Identity.MSPID = "Org2MSP"
+	assert.Equal(t, []string{"auction1", "auction2"}, openAuctionIDs(t, contract, ctx))
+
+	ctx.Identity.MSPID = "Org3MSP"
+	_, err = contract.QueryAuction(ctx, "auction1")
+	assert.Error(t, err)
+	_, err = contract.Bid(ctx, "auction1", "100")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"auction2"}, openAuctionIDs(t, contract, ctx))
+	bySeller, err := contract.GetAllAuctionsBySeller(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Len(t, bySeller, 1)
+}
+
+func TestPrivateAuctionInvitations(t *testing.T) {
+	contract, ctx := setup()
+	ctx.Stub.OracleTime = formatOracleTime(time.Now())
+	createPrivateAuction(t, contract, ctx, []string{"Org2MSP"})
+
+	actAs(ctx, "user2")
+	err := contract.InviteOrg(ctx, "auction1", "Org3MSP")
+	assert.Error(t, err)
+	actAs(ctx, "user1")
+	err = contract.InviteOrg(ctx, "auction1", "Org3MSP")
+	assert.NoError(t, err)
+	err = contract.InviteOrg(ctx, "auction1", "Org3MSP")
+	assert.Error(t, err)
+
+	// the details moved to the collection of the new set of organizations
+	assert.Empty(t, ctx.Stub.Private["private-Org1MSP-Org2MSP"])
+	assert.NotNil(t, ctx.Stub.Private["private-Org1MSP-Org2MSP-Org3MSP"]["auction1"])
+
+	err = contract.RevokeInvitation(ctx, "auction1", "Org2MSP")
+	assert.NoError(t, err)
+	evts := decodeEvents(t, ctx)
+	revoked, ok := evts[0].(*events.PrivateAuctionChanged)
+	assert.True(t, ok)
+	assert.Equal(t, "Org2MSP", revoked.Org)
+	assert.Equal(t, "private-Org1MSP-Org3MSP", revoked.Collection)
+	ctx.Identity.MSPID = "Org2MSP"
+	assert.Empty(t, openAuctionIDs(t, contract, ctx))
+
+	// bids on a private auction keep its details private
+	ctx.Identity.MSPID = "Org3MSP"
+	actAs(ctx, "user3")
+	ctx.Stub.TxID = "tx2"
+	_, err = contract.PlaceBid(ctx, "auction1", "100")
+	assert.NoError(t, err)
+	assert.NotContains(t, string(ctx.Stub.State["auction1"]), "Manuscript")
+	bids, err := contract.QueryBids(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Len(t, bids, 1)
+
+	ctx.Identity.MSPID = "Org1MSP"
+	actAs(ctx, "user1")
+	err = contract.RevokeInvitation(ctx, "auction1", "Org3MSP")
+	assert.Error(t, err)
+
+	// an organization invited later reads the earlier bids from the new collection
+	err = contract.InviteOrg(ctx, "auction1", "Org4MSP")
+	assert.NoError(t, err)
+	assert.Empty(t, ctx.Stub.Private["private-Org1MSP-Org3MSP"])
+	ctx.Identity.MSPID = "Org4MSP"
+	bids, err = contract.QueryBids(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Len(t, bids, 1)
+	leader, err := contract.GetCurrentLeader(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "tx2", leader.TxID)
+}
+
+func TestPrivateAuctionBidsStayPrivate(t *testing.T) {
+	contract, ctx := setup()
+	ctx.Stub.OracleTime = formatOracleTime(time.Now())
+	createPrivateAuction(t, contract, ctx, []string{"Org2MSP"})
+
+	ctx.Identity.MSPID = "Org2MSP"
+	actAs(ctx, "user2")
+	ctx.Stub.TxID = "tx1"
+	_, err := contract.PlaceBid(ctx, "auction1", "100")
+	assert.NoError(t, err)
+
+	// neither the bid nor the leader reach the public state
+	for key, value := range ctx.Stub.State {
+		assert.NotContains(t, key, "fullbid:")
+		assert.NotContains(t, key, "leader")
+		assert.NotContains(t, string(value), "user2")
+	}
+	collection := ctx.Stub.Private["private-Org1MSP-Org2MSP"]
+	assert.NotNil(t, collection["fullbid:auction1:tx1"])
+	assert.NotNil(t, collection["leader:auction1"])
+	assert.NotNil(t, collection["leaderdelta:auction1:tx1"])
+
+	// events only carry the auction ID
+	evts := decodeEvents(t, ctx)
+	assert.Len(t, evts, 2)
+	submitted, ok := evts[0].(*events.BidSubmitted)
+	assert.True(t, ok)
+	assert.Equal(t, events.BidSubmitted{AuctionID: "auction1"}, *submitted)
+	newLeader, ok := evts[1].(*events.NewLeader)
+	assert.True(t, ok)
+	assert.Equal(t, events.NewLeader{AuctionID: "auction1"}, *newLeader)
+
+	// organizations that are not invited cannot read the bids
+	ctx.Identity.MSPID = "Org3MSP"
+	_, err = contract.QueryBids(ctx, "auction1")
+	assert.Error(t, err)
+	_, err = contract.GetCurrentLeader(ctx, "auction1")
+	assert.Error(t, err)
+
+	ctx.Identity.MSPID = "Org1MSP"
+	actAs(ctx, "user1")
+	err = contract.ConsolidateLeader(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Nil(t, ctx.Stub.Private["private-Org1MSP-Org2MSP"]["leaderdelta:auction1:tx1"])
+	newLeader, ok = decodeEvents(t, ctx)[0].(*events.NewLeader)
+	assert.True(t, ok)
+	assert.Empty(t, newLeader.Bidder)
+}
+
+// putPrivateAuctionState replaces the details of a private auction and the
+// hash in its public stub
+func putPrivateAuctionState(ctx *MockContext, a *auction.Auction) {
+	auctionJSON, _ := json.Marshal(a)
+	ctx.Stub.Private[a.Collection][a.AuctionID] = auctionJSON
+	var stub auction.PrivateAuctionStub
+	_ = json.Unmarshal(ctx.Stub.State[a.AuctionID], &stub)
+	hash := sha256.Sum256(auctionJSON)
+	stub.Hash = hex.EncodeToString(hash[:])
+	ctx.Stub.State[a.AuctionID], _ = json.Marshal(stub)
+}
+
+func TestPrivateAuctionLeaksNoBidsOrPrices(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createPrivateAuction(t, contract, ctx, []string{"Org2MSP"})
+
+	// features keeping bidders and prices in public state are refused
+	ctx.Identity.Attrs["bitauction.role"] = "seller,bidder,auctioneer,admin"
+	err := contract.OpenSession(ctx, "auction1", "100", 0)
+	assert.ErrorContains(t, err, "private auctions")
+	err = contract.SetClock(ctx, "auction1", now.Format(time.RFC3339Nano), "100", "10", 60)
+	assert.ErrorContains(t, err, "private auctions")
+	err = contract.SetEscrow(ctx, "auction1", "full", "")
+	assert.ErrorContains(t, err, "private auctions")
+	err = contract.SetSealedBidding(ctx, "auction1", "100", 600)
+	assert.ErrorContains(t, err, "private auctions")
+
+	// every public write and event of the auction is checked for bidders and prices
+	payloads := []string{}
+	emitted := func() {
+		payloads = append(payloads, string(ctx.Stub.EventPayload))
+		ctx.Stub.EventPayload = nil
+	}
+	ctx.Identity.MSPID = "Org2MSP"
+	actAs(ctx, "user2")
+	ctx.Stub.TxID = "tx1"
+	_, err = contract.PlaceBid(ctx, "auction1", "123")
+	assert.NoError(t, err)
+	emitted()
+	actAs(ctx, "user3")
+	ctx.Stub.TxID = "tx2"
+	_, err = contract.PlaceBid(ctx, "auction1", "456")
+	assert.NoError(t, err)
+	emitted()
+
+	ctx.Identity.MSPID = "Org1MSP"
+	actAs(ctx, "auctioneer1")
+	ctx.Identity.Attrs["bitauction.role"] = "auctioneer"
+	ctx.Stub.TxID = "dq"
+	err = contract.InvalidateBid(ctx, "auction1", "tx2", "kyc-failed", "")
+	assert.NoError(t, err)
+	emitted()
+	history, err := contract.GetBidHistory(ctx, "auction1", "tx2")
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	actAs(ctx, "user1")
+	a, err := contract.QueryAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	a.Timelimit = now.Add(-1 * time.Minute)
+	putPrivateAuctionState(ctx, a)
+	ctx.Stub.TxID = "end"
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	emitted()
+	a, err = contract.QueryAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "user2", a.Winner)
+
+	for key, value := range ctx.Stub.State {
+		for _, secret := range []string{"user2", "user3", "12300", "45600", "kyc-failed"} {
+			assert.NotContains(t, key, secret)
+			assert.NotContains(t, string(value), secret, key)
+		}
+	}
+	for _, payload := range payloads {
+		assert.NotEmpty(t, payload)
+		for _, secret := range []string{"user2", "user3", "12300", "45600", "kyc-failed"} {
+			assert.NotContains(t, payload, secret)
+		}
+	}
+	assert.NotNil(t, ctx.Stub.Private["private-Org1MSP-Org2MSP"]["bidaudit:auction1:tx2:dq"])
+}
+
+func TestVisibleAuctionsSkipUnreadableCollections(t *testing.T) {
+	contract, ctx := setup()
+	createPrivateAuction(t, contract, ctx, []string{"Org2MSP"})
+
+	// a peer of a member organization that does not hold the collection
+	delete(ctx.Stub.Private["private-Org1MSP-Org2MSP"], "auction1")
+	ctx.Identity.MSPID = "Org2MSP"
+	assert.Empty(t, openAuctionIDs(t, contract, ctx))
+}
+
+func TestVerifyPrivateAuction(t *testing.T) {
+	contract, ctx := setup()
+	createPrivateAuction(t, contract, ctx, []string{"Org2MSP"})
+	details := ctx.Stub.Private["private-Org1MSP-Org2MSP"]["auction1"]
+
+	// any organization can check disclosed details
+	ctx.Identity.MSPID = "Org3MSP"
+	err := contract.VerifyPrivateAuction(ctx, "auction1", string(details))
+	assert.NoError(t, err)
+
+	var a auction.Auction
+	_ = json.Unmarshal(details, &a)
+	a.ItemSold = "Forgery"
+	tampered, _ := json.Marshal(a)
+	err = contract.VerifyPrivateAuction(ctx, "auction1", string(tampered))
+	assert.Error(t, err)
+}
+
+func TestCollectionConfig(t *testing.T) {
+	config, err := auction.CollectionConfig([]string{"Org2MSP", "Org1MSP", "Org3MSP", "Org1MSP"})
+	assert.NoError(t, err)
+	assert.Len(t, config, 7)
+	assert.Equal(t, "private-Org1MSP", config[0].Name)
+	assert.Equal(t, "OR('Org1MSP.member')", config[0].Policy)
+	assert.Equal(t, "private-Org1MSP-Org2MSP-Org3MSP", config[2].Name)
+	assert.Equal(t, "OR('Org1MSP.member','Org2MSP.member','Org3MSP.member')", config[2].Policy)
+	assert.True(t, config[2].MemberOnlyRead)
+
+	_, err = auction.CollectionConfig([]string{"A", "B", "C", "D", "E", "F", "G", "H", "I"})
+	assert.Error(t, err)
+}
@@ -9,10 +9,13 @@ import (
 	"fmt"
 	"time"
 
+	"bitAuction/money"
+
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
-// QueryAuction allows all members of the channel to read a public auction
+// QueryAuction allows all members of the channel to read a public auction,
+// and the invited organizations to read a private one
 func (s *SmartContract) QueryAuction(ctx contractapi.TransactionContextInterface, auctionID string) (*Auction, error) {
 
 	auctionJSON, err := ctx.GetStub().GetState(auctionID)
@@ -28,6 +31,13 @@ func (s *SmartContract) QueryAuction(ctx contractapi.TransactionContextInterface
 	if err != nil {
 		return nil, err
 	}
+	if auction.Type == privateAuctionType {
+		return getPrivateAuction(ctx, auctionJSON)
+	}
+	// auctions created before prices had a currency use the default currency
+	if auction.Currency == "" {
+		auction.Currency = money.DefaultCurrency
+	}
 
 	return auction, nil
 }
@@ -78,8 +88,12 @@ func (s *SmartContract) QueryBid(ctx contractapi.TransactionContextInterface, au
 }
 
 func (s *SmartContract) QueryBids(ctx contractapi.TransactionContextInterface, auctionID string) ([]*FullBid, error) {
+	collection, err := auctionCollection(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
 	// Build partial composite key
-	iter, err := ctx.GetStub().GetStateByPartialCompositeKey("fullbid", []string{auctionID})
+	iter, err := getAuctionDataByPartialKey(ctx, collection, fullBidKeyType, []string{auctionID})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get bids for auction %s: %v", auctionID, err)
 	}
@@ -98,6 +112,14 @@ func (s *SmartContract) QueryBids(ctx contractapi.TransactionContextInterface, a
 		if err != nil {
 			return nil, err
 		}
+		// bids from before the txID was stored get it from their key
+		if bid.TxID == "" {
+			_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+			if err != nil {
+				return nil, err
+			}
+			bid.TxID = keyParts[len(keyParts)-1]
+		}
 
 		bids = append(bids, &bid)
 	}
@@ -105,37 +127,147 @@ func (s *SmartContract) QueryBids(ctx contractapi.TransactionContextInterface, a
 	return bids, nil
 }
 
+// OrphanedBid is a price stored with Bid that was never submitted with SubmitBid
+type OrphanedBid struct {
+	TxID  string      `json:"txID"`
+	Price money.Money `json:"price"`
+}
+
+// GetOrphanedBids returns the bids of an auction that were placed with Bid but
+// never turned into a full bid with SubmitBid
+func (s *SmartContract) GetOrphanedBids(ctx contractapi.TransactionContextInterface, auctionID string) ([]*OrphanedBid, error) {
+	collection, err := auctionCollection(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := getAuctionDataByPartialKey(ctx, collection, bidKeyType, []string{auctionID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bids for auction %s: %v", auctionID, err)
+	}
+	defer iter.Close()
+
+	orphans := []*OrphanedBid{}
+	for iter.HasNext() {
+		queryResponse, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		txID := keyParts[len(keyParts)-1]
+
+		fullBidKey, err := ctx.GetStub().CreateCompositeKey(fullBidKeyType, []string{auctionID, txID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create full bid key: %v", err)
+		}
+		fullBidJSON, err := getAuctionData(ctx, collection, fullBidKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get full bid: %v", err)
+		}
+		if fullBidJSON != nil {
+			continue
+		}
+
+		var price money.Money
+		err = json.Unmarshal(queryResponse.Value, &price)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal bid: %v", err)
+		}
+		orphans = append(orphans, &OrphanedBid{TxID: txID, Price: price})
+	}
+	return orphans, nil
+}
+
+// getFullBid reads a single full bid of an auction from public state, or from
+// the collection of a private auction
+func (s *SmartContract) getFullBid(ctx contractapi.TransactionContextInterface, auctionID string, txID string) (*FullBid, error) {
+	collection, err := auctionCollection(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	fullBidKey, err := ctx.GetStub().CreateCompositeKey(fullBidKeyType, []string{auctionID, txID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create full bid key: %v", err)
+	}
+	bidJSON, err := getAuctionData(ctx, collection, fullBidKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bid %v: %v", fullBidKey, err)
+	}
+	if bidJSON == nil {
+		return nil, fmt.Errorf("bid %v does not exist", fullBidKey)
+	}
 
-// function used to get highest bid and bidder
+	var bid *FullBid
+	err = json.Unmarshal(bidJSON, &bid)
+	if err != nil {
+		return nil, err
+	}
+	bid.TxID = txID
+	return bid, nil
+}
+
+// function used to get the winning bid and bidder, the highest bid of a
+// forward auction and the lowest of a reverse auction
 func (s *SmartContract) GetHb(ctx contractapi.TransactionContextInterface, auctionID string) (*FullBid, error) {
-	bids, err := s.QueryBids(ctx, auctionID)
+	auction, err := s.QueryAuction(ctx, auctionID)
 	if err != nil {
 		return nil, err
 	}
+	return s.highestValidBid(ctx, auction, "")
+}
 
-	if len(bids) == 0 {
-		return nil, nil
+// highestValidBid returns the winning valid bid of the auction, skipping the
+// bid excluded. Reads do not see the writes of the running transaction, so a
+// transaction that invalidates a bid excludes it to leave it out of the scan.
+func (s *SmartContract) highestValidBid(ctx contractapi.TransactionContextInterface, auction *Auction, excluded string) (*FullBid, error) {
+	bids, err := s.QueryBids(ctx, auction.AuctionID)
+	if err != nil {
+		return nil, err
 	}
 
-	highest := bids[0]
+	// retracted or disqualified bids never win
+	var highest *FullBid
 	for _, bid := range bids {
-		if s.isHigherBid(bid, highest, highest.Timestamp) {
+		if !bid.Valid || (excluded != "" && bid.TxID == excluded) {
+			continue
+		}
+		better, err := s.isBetterBid(auction.Direction, bid, highest)
+		if err != nil {
+			return nil, err
+		}
+		if better {
 			highest = bid
 		}
 	}
 	return highest, nil
 }
 
-func (s *SmartContract) isHigherBid(bid *FullBid, highest *FullBid, winnerTime time.Time) bool {
-	// Check if the new bid is higher than the current highest bid
-	if highest == nil || bid.Price > highest.Price {
-		return true
+// isBetterBid reports whether bid beats best in an auction of the given
+// direction. Of two bids at the same price the earlier one wins. Bids in
+// different currencies cannot be compared.
+func (s *SmartContract) isBetterBid(direction string, bid *FullBid, best *FullBid) (bool, error) {
+	if best == nil {
+		return true, nil
+	}
+	// Check if the new bid is higher than the current highest bid, or lower
+	// in a reverse auction
+	cmp, err := bid.Price.Cmp(best.Price)
+	if err != nil {
+		return false, fmt.Errorf("failed to compare bids %v and %v: %v", bid.TxID, best.TxID, err)
+	}
+	if direction == directionReverse {
+		cmp = -cmp
+	}
+	if cmp > 0 {
+		return true, nil
 	}
 	// If the price is the same, check the timestamp
-	if bid.Price == highest.Price && bid.Timestamp.Before(winnerTime) {
-		return true
+	if cmp == 0 && bid.Timestamp.Before(best.Timestamp) {
+		return true, nil
 	}
-	return false
+	return false, nil
 }
 
 func isAuctionOpenForBidding(auction *Auction) error {
@@ -148,42 +280,52 @@ func isAuctionOpenForBidding(auction *Auction) error {
 	return nil
 }
 
-// GetAllOpenAuctions retrieves all auctions with status 'open'
+// GetAllOpenAuctions retrieves all auctions with status 'open' that the
+// organization of the caller can see
 func (s *SmartContract) GetAllOpenAuctions(ctx contractapi.TransactionContextInterface) ([]*Auction, error) {
 	results := []*Auction{}
 
-	// Get all keys in the ledger
-	iterator, err := ctx.GetStub().GetStateByRange("", "")
+	auctions, err := s.visibleAuctions(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get state by range: %v", err)
+		return nil, err
 	}
-	defer iterator.Close()
-
-	for iterator.HasNext() {
-		kv, err := iterator.Next()
-		if err != nil {
-			return nil, fmt.Errorf("failed to iterate: %v", err)
+	for _, auction := range auctions {
+		if auction.Status == "open" {
+			results = append(results, auction)
 		}
+	}
 
-		var auction Auction
-		err = json.Unmarshal(kv.Value, &auction)
+	return results, nil
+}
+
+// GetAllAuctionsBySeller retrieves all auctions created by a specific seller
+func (s *SmartContract) GetAllAuctionsBySeller(ctx contractapi.TransactionContextInterface, sellerID string) ([]*Auction, error) {
+	results := []*Auction{}
+
+	auctions, err := s.visibleAuctions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, auction := range auctions {
+		auctionSeller, err := s.ParseClientID(auction.Seller)
 		if err != nil {
-			// Not an auction object, skip
-			continue
+			return nil, fmt.Errorf("failed to parse auction seller: %v", err)
 		}
 
-		if auction.Status == "open" {
-			results = append(results, &auction)
+		if auctionSeller == sellerID {
+			results = append(results, auction)
 		}
 	}
 
 	return results, nil
 }
 
-// GetAllAuctionsBySeller retrieves all auctions created by a specific seller
-func (s *SmartContract) GetAllAuctionsBySeller(ctx contractapi.TransactionContextInterface, sellerID string) ([]*Auction, error) {
+// visibleAuctions returns the public auctions and the private auctions the
+// organization of the caller is invited to and can read
+func (s *SmartContract) visibleAuctions(ctx contractapi.TransactionContextInterface) ([]*Auction, error) {
 	results := []*Auction{}
 
+	// Get all keys in the ledger
 	iterator, err := ctx.GetStub().GetStateByRange("", "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get state by range: %v", err)
@@ -196,20 +338,28 @@ func (s *SmartContract) GetAllAuctionsBySeller(ctx contractapi.TransactionContex
 			return nil, fmt.Errorf("failed to iterate: %v", err)
 		}
 
-		var auction Auction
+		var auction *Auction
 		err = json.Unmarshal(kv.Value, &auction)
-		if err != nil {
+		if err != nil || auction == nil {
+			// Not an auction object, skip
 			continue
 		}
 
-		auctionSeller, err := s.ParseClientID(auction.Seller)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse auction seller: %v", err)
-		}
-
-		if auctionSeller == sellerID {
-			results = append(results, &auction)
+		if auction.Type == privateAuctionType {
+			visible, err := canSeePrivateAuction(ctx, kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			if !visible {
+				continue
+			}
+			auction, err = getPrivateAuction(ctx, kv.Value)
+			if err != nil {
+				// the peer does not hold the collection of the auction
+				continue
+			}
 		}
+		results = append(results, auction)
 	}
 
 	return results, nil
@@ -0,0 +1,127 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"fmt"
+	"time"
+
+	"bitAuction/money"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// directions of an auction
+const (
+	directionForward = "forward"
+	directionReverse = "reverse"
+)
+
+// CreateReverseAuction creates a procurement auction in which suppliers bid
+// down and the lowest bid wins. Only identities with the procurement role can
+// create one, and they are recorded as the seller of the auction. Bids above
+// ceiling are refused, an empty ceiling accepts any price.
+func (s *SmartContract) CreateReverseAuction(ctx contractapi.TransactionContextInterface, auctionID string, itemsold string, timelimit string, description string, pictureUrl string, ceiling string) error {
+	t, err := time.Parse(time.RFC3339Nano, timelimit)
+	if err != nil {
+		return fmt.Errorf("invalid datetime format: %v", err)
+	}
+	ceilingPrice := money.Zero(money.DefaultCurrency)
+	if ceiling != "" {
+		ceilingPrice, err = money.Parse(ceiling, money.DefaultCurrency)
+		if err != nil {
+			return err
+		}
+	}
+
+	auction, err := s.createAuction(ctx, auctionID, itemsold, t, description, pictureUrl, directionReverse)
+	if err != nil {
+		return err
+	}
+	if ceiling != "" {
+		auction.Currency = ceilingPrice.Currency
+		auction.Price = money.Zero(ceilingPrice.Currency)
+		auction.Ceiling = ceilingPrice
+		if err = putAuction(ctx, auction); err != nil {
+			return err
+		}
+	}
+	return emitEvents(ctx, auctionCreatedEvent(auction))
+}
+
+// SetDecrementRule lets the buyer of a reverse auction require every bid to
+// undercut the leading bid by at least amount and basisPoints of the leading
+// price, whichever is more. An empty amount and 0 basis points accept any
+// bid. The rule can only change before the first bid.
+func (s *SmartContract) SetDecrementRule(ctx contractapi.TransactionContextInterface, auctionID string, amount string, basisPoints int) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = s.checkSellerBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+	if auction.Direction != directionReverse {
+		return fmt.Errorf("decrement rules only apply to reverse auctions")
+	}
+	if err = checkBasisPoints(int64(basisPoints)); err != nil {
+		return err
+	}
+	decrement := money.Zero(auction.Currency)
+	if amount != "" {
+		if decrement, err = parseFeeAmount(amount, auction.Currency); err != nil {
+			return err
+		}
+	}
+
+	auction.MinDecrement = decrement
+	auction.DecrementBasisPoints = int64(basisPoints)
+	return putAuction(ctx, auction)
+}
+
+// checkDecrement refuses a bid on a reverse auction that does not undercut
+// the leading bid by the decrement the auction requires. Unlike other bids
+// these read every bid not consolidated yet, so bids on an auction with a
+// decrement rule conflict when submitted concurrently.
+func (s *SmartContract) checkDecrement(ctx contractapi.TransactionContextInterface, auction *Auction, price money.Money) error {
+	if auction.Direction != directionReverse || (!auction.MinDecrement.IsPositive() && auction.DecrementBasisPoints == 0) {
+		return nil
+	}
+	leader, err := s.currentLeader(ctx, auction)
+	if err != nil {
+		return fmt.Errorf("failed to get leading bid: %v", err)
+	}
+	if leader == nil {
+		return nil
+	}
+	step, err := leader.Price.BasisPoints(auction.DecrementBasisPoints)
+	if err != nil {
+		return err
+	}
+	if auction.MinDecrement.IsPositive() {
+		cmp, err := auction.MinDecrement.Cmp(step)
+		if err != nil {
+			return err
+		}
+		if cmp > 0 {
+			step = auction.MinDecrement
+		}
+	}
+	if !step.IsPositive() {
+		return nil
+	}
+	limit, err := leader.Price.Sub(step)
+	if err != nil {
+		return err
+	}
+	cmp, err := price.Cmp(limit)
+	if err != nil {
+		return err
+	}
+	if cmp > 0 {
+		return fmt.Errorf("bids must undercut the leading bid of %v by at least %v", leader.Price, step)
+	}
+	return nil
+}
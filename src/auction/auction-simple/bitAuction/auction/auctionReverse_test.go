@@ -0,0 +1,116 @@
+package auction_test
+
+import (
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createReverseAuction(t *testing.T, contract *auction.SmartContract, ctx *MockContext, timelimit time.Time, ceiling string) {
+	ctx.Identity.Attrs["bitauction.role"] = "procurement"
+	err := contract.CreateReverseAuction(ctx, "auction1", "Steel", timelimit.Format(time.RFC3339Nano), "Desc", "http://img", ceiling)
+	assert.NoError(t, err)
+	ctx.Identity.Attrs["bitauction.role"] = "seller,bidder,auctioneer"
+}
+
+func TestReverseAuctionLowestBidWins(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+
+	// sellers cannot create procurement auctions
+	err := contract.CreateReverseAuction(ctx, "auction1", "Steel", now.Add(1*time.Hour).Format(time.RFC3339Nano), "Desc", "http://img", "1000")
+	assert.Error(t, err)
+	createReverseAuction(t, contract, ctx, now.Add(1*time.Hour), "1000")
+	assert.Equal(t, "reverse", getAuction(ctx).Direction)
+
+	actAs(ctx, "bidder1")
+	ctx.Stub.TxID = "tx0"
+	_, err = contract.PlaceBid(ctx, "auction1", "1200")
+	assert.Error(t, err)
+	submitBid(t, contract, ctx, "tx1", 800)
+	actAs(ctx, "bidder2")
+	submitBid(t, contract, ctx, "tx2", 600)
+	// the same price later does not take the lead
+	ctx.Stub.OracleTime = formatOracleTime(now.Add(1 * time.Minute))
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx3", 600)
+
+	leader, err := contract.GetCurrentLeader(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "tx2", leader.TxID)
+
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-1 * time.Minute)
+	putAuctionState(ctx, a)
+	actAs(ctx, "user1")
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+
+	// reverse auctions are settled off-chain
+	a = getAuction(ctx)
+	assert.Equal(t, "bidder2", a.Winner)
+	assert.Equal(t, usd(600), a.Price)
+	assert.Nil(t, a.Settlement)
+}
+
+func TestReverseAuctionDecrementRule(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createReverseAuction(t, contract, ctx, now.Add(1*time.Hour), "")
+
+	err := contract.SetDecrementRule(ctx, "auction1", "10", 500)
+	assert.NoError(t, err)
+	err = contract.SetBuyNow(ctx, "auction1", "100", "")
+	assert.Error(t, err)
+
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 800)
+	// 5% of 800 is more than the minimum decrement of 10
+	actAs(ctx, "bidder2")
+	ctx.Stub.TxID = "tx2"
+	_, err = contract.PlaceBid(ctx, "auction1", "770")
+	assert.Error(t, err)
+	submitBid(t, contract, ctx, "tx3", 760)
+
+	leader, err := contract.GetCurrentLeader(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "tx3", leader.TxID)
+}
+
+func TestReverseAuctionCurrency(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createReverseAuction(t, contract, ctx, now.Add(1*time.Hour), "")
+
+	// the decrement rule has to be changed before the currency
+	err := contract.SetDecrementRule(ctx, "auction1", "10", 0)
+	assert.NoError(t, err)
+	err = contract.SetCurrency(ctx, "auction1", "EUR")
+	assert.Error(t, err)
+	err = contract.SetDecrementRule(ctx, "auction1", "", 500)
+	assert.NoError(t, err)
+	err = contract.SetCurrency(ctx, "auction1", "EUR")
+	assert.NoError(t, err)
+	a := getAuction(ctx)
+	assert.Equal(t, "EUR", a.Ceiling.Currency)
+	assert.Equal(t, "EUR", a.MinDecrement.Currency)
+
+	actAs(ctx, "bidder1")
+	ctx.Stub.TxID = "tx1"
+	_, err = contract.PlaceBid(ctx, "auction1", "800")
+	assert.NoError(t, err)
+
+	// a ceiling is set when the auction is created and keeps its currency
+	ctx.Stub.State = map[string][]byte{}
+	actAs(ctx, "user1")
+	createReverseAuction(t, contract, ctx, now.Add(1*time.Hour), "1000")
+	err = contract.SetCurrency(ctx, "auction1", "EUR")
+	assert.Error(t, err)
+	assert.Equal(t, usd(1000), getAuction(ctx).Ceiling)
+}
@@ -0,0 +1,505 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"bitAuction/events"
+	"bitAuction/money"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A sale groups the auctions of a catalogue, its lots, which close one after
+// another Interval seconds apart. In a staggered sale the time limits are set
+// when the lots are added. In a sequential sale every lot closes Interval
+// seconds after the lot before it actually closed, so when a lot closes later
+// or earlier than planned the time limits of the open lots after it move with it.
+const saleKeyType = "sale"
+
+// closing rules of a sale
+const (
+	closingStaggered  = "staggered"
+	closingSequential = "sequential"
+)
+
+// states of a sale
+const (
+	saleOpen   = "open"
+	salePaused = "paused"
+	saleClosed = "closed"
+)
+
+// Sale is a catalogue of lots sold by one seller. Lots lists the auction IDs
+// of the lots in closing order.
+type Sale struct {
+	SaleID     string    `json:"saleID"`
+	Title      string    `json:"title"`
+	Seller     string    `json:"seller"`
+	Closing    string    `json:"closing"`
+	FirstClose time.Time `json:"firstClose"`
+	Interval   int       `json:"interval"`
+	Lots       []string  `json:"lots"`
+	Status     string    `json:"status"`
+	PausedAt   time.Time `json:"pausedAt"`
+}
+
+// SaleLot describes a lot to add to a sale
+type SaleLot struct {
+	AuctionID   string `json:"auctionID"`
+	Item        string `json:"item"`
+	Description string `json:"description"`
+	PictureURL  string `json:"pictureUrl"`
+}
+
+// SaleProgress summarizes the lots of a sale. Totals holds the hammer prices
+// of the sold lots per currency, NextLot the open lot that closes next.
+type SaleProgress struct {
+	SaleID    string        `json:"saleID"`
+	Status    string        `json:"status"`
+	Lots      int           `json:"lots"`
+	Open      int           `json:"open"`
+	Sold      int           `json:"sold"`
+	Unsold    int           `json:"unsold"`
+	Cancelled int           `json:"cancelled"`
+	NextLot   string        `json:"nextLot"`
+	NextClose time.Time     `json:"nextClose"`
+	Totals    []money.Money `json:"totals"`
+}
+
+// CreateSale lets a seller create a sale whose first lot closes at
+// firstClose, followed by a lot every interval seconds. closing is
+// "staggered" or "sequential".
+func (s *SmartContract) CreateSale(ctx contractapi.TransactionContextInterface, saleID string, title string, closing string, firstClose string, interval int) error {
+	if err := requireRole(ctx, roleSeller); err != nil {
+		return err
+	}
+	if closing != closingStaggered && closing != closingSequential {
+		return fmt.Errorf("unknown closing rule %v", closing)
+	}
+	t, err := time.Parse(time.RFC3339Nano, firstClose)
+	if err != nil {
+		return fmt.Errorf("invalid datetime format: %v", err)
+	}
+	if interval < 0 {
+		return fmt.Errorf("the interval between lots cannot be negative")
+	}
+	existing, err := getSale(ctx, saleID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("sale %v already exists", saleID)
+	}
+	seller, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	return putSale(ctx, &Sale{
+		SaleID:     saleID,
+		Title:      title,
+		Seller:     seller,
+		Closing:    closing,
+		FirstClose: t,
+		Interval:   interval,
+		Lots:       []string{},
+		Status:     saleOpen,
+	})
+}
+
+// AddSaleLots creates an auction for each of the lots and adds them to the
+// end of the sale. Each lot closes the interval of the sale after the lot
+// before it. The auction IDs must be new and distinct.
+func (s *SmartContract) AddSaleLots(ctx contractapi.TransactionContextInterface, saleID string, lots []SaleLot) error {
+	sale, err := s.sellerSale(ctx, saleID)
+	if err != nil {
+		return err
+	}
+	if sale.Status != saleOpen {
+		return fmt.Errorf("lots cannot be added to a %v sale", sale.Status)
+	}
+	if len(lots) == 0 {
+		return fmt.Errorf("no lots to add")
+	}
+
+	interval := time.Duration(sale.Interval) * time.Second
+	timelimit := sale.FirstClose.Add(-interval)
+	if len(sale.Lots) > 0 {
+		last, err := s.QueryAuction(ctx, sale.Lots[len(sale.Lots)-1])
+		if err != nil {
+			return fmt.Errorf("failed to get auction: %v", err)
+		}
+		timelimit = last.Timelimit
+	}
+
+	// the transaction does not read back the lots it creates, so duplicates
+	// within the batch are caught here
+	added := map[string]bool{}
+	evts := []events.Event{}
+	for _, lot := range lots {
+		existing, err := ctx.GetStub().GetState(lot.AuctionID)
+		if err != nil {
+			return fmt.Errorf("failed to get auction %v: %v", lot.AuctionID, err)
+		}
+		if lot.AuctionID == "" || existing != nil || added[lot.AuctionID] {
+			return fmt.Errorf("auction ID %q is not available", lot.AuctionID)
+		}
+		added[lot.AuctionID] = true
+		timelimit = timelimit.Add(interval)
+		auction, err := s.createAuction(ctx, lot.AuctionID, lot.Item, timelimit, lot.Description, lot.PictureURL, directionForward)
+		if err != nil {
+			return err
+		}
+		auction.SaleID = saleID
+		if err = putAuction(ctx, auction); err != nil {
+			return err
+		}
+		sale.Lots = append(sale.Lots, lot.AuctionID)
+		evts = append(evts, auctionCreatedEvent(auction))
+	}
+
+	if err = putSale(ctx, sale); err != nil {
+		return err
+	}
+	return emitEvents(ctx, evts...)
+}
+
+// PauseSale lets the seller pause a sale. No bids are accepted and no lots
+// can be ended until the sale is resumed.
+func (s *SmartContract) PauseSale(ctx contractapi.TransactionContextInterface, saleID string) error {
+	sale, err := s.sellerSale(ctx, saleID)
+	if err != nil {
+		return err
+	}
+	if sale.Status != saleOpen {
+		return fmt.Errorf("sale %v is not open, status is %v", saleID, sale.Status)
+	}
+	now, err := s.oracleTimestamp(ctx, ctx.GetStub().GetTxID())
+	if err != nil {
+		return err
+	}
+
+	sale.Status = salePaused
+	sale.PausedAt = now
+	if err = putSale(ctx, sale); err != nil {
+		return err
+	}
+	return emitEvents(ctx, events.SaleChanged{SaleID: saleID, Status: salePaused, Timestamp: now})
+}
+
+// ResumeSale lets the seller resume a paused sale. The time limits of the
+// open lots that had not passed when the sale was paused move by the length
+// of the pause.
+func (s *SmartContract) ResumeSale(ctx contractapi.TransactionContextInterface, saleID string) error {
+	sale, err := s.sellerSale(ctx, saleID)
+	if err != nil {
+		return err
+	}
+	if sale.Status != salePaused {
+		return fmt.Errorf("sale %v is not paused", saleID)
+	}
+	now, err := s.oracleTimestamp(ctx, ctx.GetStub().GetTxID())
+	if err != nil {
+		return err
+	}
+
+	pause := now.Sub(sale.PausedAt)
+	evts := []events.Event{events.SaleChanged{SaleID: saleID, Status: saleOpen, Timestamp: now}}
+	for _, auctionID := range sale.Lots {
+		auction, err := s.QueryAuction(ctx, auctionID)
+		if err != nil {
+			return fmt.Errorf("failed to get auction: %v", err)
+		}
+		if auction.Status != "open" || !auction.Timelimit.After(sale.PausedAt) {
+			continue
+		}
+		evt, err := moveLotDeadline(ctx, auction, auction.Timelimit.Add(pause), "sale-resumed")
+		if err != nil {
+			return err
+		}
+		evts = append(evts, evt)
+	}
+
+	sale.Status = saleOpen
+	sale.PausedAt = time.Time{}
+	if err = putSale(ctx, sale); err != nil {
+		return err
+	}
+	return emitEvents(ctx, evts...)
+}
+
+// QuerySale returns a sale
+func (s *SmartContract) QuerySale(ctx contractapi.TransactionContextInterface, saleID string) (*Sale, error) {
+	sale, err := getSale(ctx, saleID)
+	if err != nil {
+		return nil, err
+	}
+	if sale == nil {
+		return nil, fmt.Errorf("sale %v does not exist", saleID)
+	}
+	return sale, nil
+}
+
+// QuerySaleLots returns the auctions of the lots of a sale in closing order
+func (s *SmartContract) QuerySaleLots(ctx contractapi.TransactionContextInterface, saleID string) ([]*Auction, error) {
+	sale, err := s.QuerySale(ctx, saleID)
+	if err != nil {
+		return nil, err
+	}
+	lots := []*Auction{}
+	for _, auctionID := range sale.Lots {
+		auction, err := s.QueryAuction(ctx, auctionID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get auction: %v", err)
+		}
+		lots = append(lots, auction)
+	}
+	return lots, nil
+}
+
+// GetSaleProgress returns how many lots of a sale are open, sold, unsold and
+// cancelled, which lot closes next and the hammer totals of the sold lots
+func (s *SmartContract) GetSaleProgress(ctx contractapi.TransactionContextInterface, saleID string) (*SaleProgress, error) {
+	sale, err := s.QuerySale(ctx, saleID)
+	if err != nil {
+		return nil, err
+	}
+	lots, err := s.QuerySaleLots(ctx, saleID)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &SaleProgress{SaleID: saleID, Status: sale.Status, Lots: len(lots), Totals: []money.Money{}}
+	totals := map[string]money.Money{}
+	for _, auction := range lots {
+		switch {
+		case auction.Status == "open":
+			progress.Open++
+			if progress.NextLot == "" || auction.Timelimit.Before(progress.NextClose) {
+				progress.NextLot = auction.AuctionID
+				progress.NextClose = auction.Timelimit
+			}
+			continue
+		case auction.Status == "cancelled":
+			progress.Cancelled++
+			continue
+		case auction.Outcome == outcomeNoSale || !auction.Price.IsPositive():
+			progress.Unsold++
+			continue
+		}
+		progress.Sold++
+		revenue, err := lotRevenue(auction)
+		if err != nil {
+			return nil, err
+		}
+		total, ok := totals[revenue.Currency]
+		if !ok {
+			total = money.Zero(revenue.Currency)
+		}
+		totals[revenue.Currency], err = total.Add(revenue)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if progress.Open == 0 && progress.Lots > 0 {
+		progress.Status = saleClosed
+	}
+
+	for _, total := range totals {
+		progress.Totals = append(progress.Totals, total)
+	}
+	sort.Slice(progress.Totals, func(i, j int) bool {
+		return progress.Totals[i].Currency < progress.Totals[j].Currency
+	})
+	return progress, nil
+}
+
+// lotRevenue returns what the buyers of an ended lot pay before fees
+func lotRevenue(auction *Auction) (money.Money, error) {
+	if len(auction.Allocations) == 0 {
+		return auction.Price, nil
+	}
+	revenue := money.Zero(auction.Currency)
+	for _, allocation := range auction.Allocations {
+		var err error
+		if revenue, err = revenue.Add(allocation.Total); err != nil {
+			return money.Money{}, err
+		}
+	}
+	return revenue, nil
+}
+
+// checkSaleRunning refuses bids and endings on lots of a paused sale
+func checkSaleRunning(ctx contractapi.TransactionContextInterface, auction *Auction) error {
+	if auction.SaleID == "" {
+		return nil
+	}
+	sale, err := getSale(ctx, auction.SaleID)
+	if err != nil {
+		return err
+	}
+	if sale != nil && sale.Status == salePaused {
+		return fmt.Errorf("sale %v is paused", sale.SaleID)
+	}
+	return nil
+}
+
+// checkSaleOrder refuses to end a lot of a sequential sale before the lots
+// before it have closed
+func (s *SmartContract) checkSaleOrder(ctx contractapi.TransactionContextInterface, auction *Auction) error {
+	if auction.SaleID == "" {
+		return nil
+	}
+	sale, err := getSale(ctx, auction.SaleID)
+	if err != nil {
+		return err
+	}
+	if sale == nil || sale.Closing != closingSequential {
+		return nil
+	}
+	for _, auctionID := range sale.Lots {
+		if auctionID == auction.AuctionID {
+			return nil
+		}
+		lot, err := s.QueryAuction(ctx, auctionID)
+		if err != nil {
+			return fmt.Errorf("failed to get auction: %v", err)
+		}
+		if lot.Status == "open" {
+			return fmt.Errorf("lot %v closes before %v", auctionID, auction.AuctionID)
+		}
+	}
+	return nil
+}
+
+// lotClosed moves the open lots after a lot of a sequential sale that just
+// closed, so that the next one closes the interval of the sale after the lot
+// actually closed. A next lot whose time limit has already passed is not
+// reopened, it moves the lots after it once it closes itself. It returns the
+// events of the moved deadlines.
+func (s *SmartContract) lotClosed(ctx contractapi.TransactionContextInterface, auction *Auction) ([]events.Event, error) {
+	evts := []events.Event{}
+	if auction.SaleID == "" {
+		return evts, nil
+	}
+	sale, err := getSale(ctx, auction.SaleID)
+	if err != nil {
+		return nil, err
+	}
+	if sale == nil || sale.Closing != closingSequential {
+		return evts, nil
+	}
+
+	closedAt := auction.ClosedAt
+	if closedAt.IsZero() {
+		closedAt = auction.Timelimit
+	}
+	var shift time.Duration
+	after := false
+	for _, auctionID := range sale.Lots {
+		if auctionID == auction.AuctionID {
+			after = true
+			continue
+		}
+		if !after {
+			continue
+		}
+		lot, err := s.QueryAuction(ctx, auctionID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get auction: %v", err)
+		}
+		if lot.Status != "open" {
+			continue
+		}
+		if shift == 0 {
+			if !lot.Timelimit.After(closedAt) {
+				break
+			}
+			shift = closedAt.Add(time.Duration(sale.Interval) * time.Second).Sub(lot.Timelimit)
+			if shift == 0 {
+				break
+			}
+		}
+		evt, err := moveLotDeadline(ctx, lot, lot.Timelimit.Add(shift), "sale-sequence")
+		if err != nil {
+			return nil, err
+		}
+		evts = append(evts, evt)
+	}
+	return evts, nil
+}
+
+// moveLotDeadline moves the time limit of a lot and returns the event of the move
+func moveLotDeadline(ctx contractapi.TransactionContextInterface, auction *Auction, timelimit time.Time, reason string) (events.Event, error) {
+	evt := events.DeadlineExtended{
+		AuctionID: auction.AuctionID,
+		Previous:  auction.Timelimit,
+		Timelimit: timelimit,
+		Reason:    reason,
+	}
+	auction.Timelimit = timelimit
+	if err := putAuction(ctx, auction); err != nil {
+		return nil, err
+	}
+	return evt, nil
+}
+
+// sellerSale returns a sale for a change by its seller
+func (s *SmartContract) sellerSale(ctx contractapi.TransactionContextInterface, saleID string) (*Sale, error) {
+	sale, err := s.QuerySale(ctx, saleID)
+	if err != nil {
+		return nil, err
+	}
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if sale.Seller != clientID {
+		return nil, fmt.Errorf("sales can only be changed by their seller")
+	}
+	return sale, nil
+}
+
+func putSale(ctx contractapi.TransactionContextInterface, sale *Sale) error {
+	key, err := ctx.GetStub().CreateCompositeKey(saleKeyType, []string{sale.SaleID})
+	if err != nil {
+		return fmt.Errorf("failed to create sale key: %v", err)
+	}
+	saleJSON, err := json.Marshal(sale)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sale: %v", err)
+	}
+	err = ctx.GetStub().PutState(key, saleJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put sale in state: %v", err)
+	}
+	return nil
+}
+
+// getSale returns a sale, nil when it does not exist
+func getSale(ctx contractapi.TransactionContextInterface, saleID string) (*Sale, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(saleKeyType, []string{saleID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sale key: %v", err)
+	}
+	saleJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sale %v: %v", saleID, err)
+	}
+	if saleJSON == nil {
+		return nil, nil
+	}
+	var sale Sale
+	err = json.Unmarshal(saleJSON, &sale)
+	if err != nil {
+		return nil, err
+	}
+	return &sale, nil
+}
@@ -0,0 +1,123 @@
+package auction_test
+
+import (
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+	"bitAuction/events"
+	"bitAuction/money"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createSale(t *testing.T, contract *auction.SmartContract, ctx *MockContext, closing string, firstClose time.Time) {
+	err := contract.CreateSale(ctx, "sale1", "Spring catalogue", closing, firstClose.Format(time.RFC3339Nano), 60)
+	assert.NoError(t, err)
+	err = contract.AddSaleLots(ctx, "sale1", []auction.SaleLot{
+		{AuctionID: "lot1", Item: "Vase"},
+		{AuctionID: "lot2", Item: "Clock"},
+		{AuctionID: "lot3", Item: "Painting"},
+	})
+	assert.NoError(t, err)
+}
+
+func getLot(t *testing.T, contract *auction.SmartContract, ctx *MockContext, auctionID string) *auction.Auction {
+	lot, err := contract.QueryAuction(ctx, auctionID)
+	assert.NoError(t, err)
+	return lot
+}
+
+func TestSequentialSale(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+	first := now.Add(-10 * time.Minute)
+	createSale(t, contract, ctx, "sequential", first)
+	assert.Equal(t, "AuctionCreated", ctx.Stub.EventName)
+	assert.Len(t, decodeEvents(t, ctx), 3)
+	assert.True(t, getLot(t, contract, ctx, "lot3").Timelimit.Equal(first.Add(2*time.Minute)))
+	err := contract.AddSaleLots(ctx, "sale1", []auction.SaleLot{{AuctionID: "lot1", Item: "Chair"}})
+	assert.Error(t, err)
+	// the same ID twice in one batch is refused even though the transaction
+	// does not see the first of them
+	ctx.Stub.Writes = map[string][]byte{}
+	err = contract.AddSaleLots(ctx, "sale1", []auction.SaleLot{{AuctionID: "lot4", Item: "Chair"}, {AuctionID: "lot4", Item: "Lamp"}})
+	assert.Error(t, err)
+	ctx.Stub.Writes = nil
+
+	// lots close in order
+	err = contract.EndAuction(ctx, "lot2")
+	assert.Error(t, err)
+
+	// lot1 closed 30 seconds late, the lots after it move with it
+	ctx.Stub.OracleTime = formatOracleTime(first.Add(30 * time.Second))
+	err = contract.EndAuction(ctx, "lot1")
+	assert.NoError(t, err)
+	assert.True(t, getLot(t, contract, ctx, "lot1").ClosedAt.Equal(first.Add(30*time.Second)))
+	assert.True(t, getLot(t, contract, ctx, "lot2").Timelimit.Equal(first.Add(90*time.Second)))
+	assert.True(t, getLot(t, contract, ctx, "lot3").Timelimit.Equal(first.Add(150*time.Second)))
+	evts := decodeEvents(t, ctx)
+	moved, ok := evts[1].(*events.DeadlineExtended)
+	assert.True(t, ok)
+	assert.Equal(t, "lot2", moved.AuctionID)
+	assert.Equal(t, "sale-sequence", moved.Reason)
+
+	progress, err := contract.GetSaleProgress(ctx, "sale1")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, progress.Lots)
+	assert.Equal(t, 2, progress.Open)
+	assert.Equal(t, 1, progress.Unsold)
+	assert.Equal(t, "lot2", progress.NextLot)
+	assert.Equal(t, "open", progress.Status)
+
+	// lot3 expired before lot2 closed and is not reopened
+	ctx.Stub.OracleTime = formatOracleTime(first.Add(5 * time.Minute))
+	err = contract.EndAuction(ctx, "lot2")
+	assert.NoError(t, err)
+	assert.Len(t, decodeEvents(t, ctx), 1)
+	assert.True(t, getLot(t, contract, ctx, "lot3").Timelimit.Equal(first.Add(150*time.Second)))
+}
+
+func TestStaggeredSalePauseResume(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	first := now.Add(1 * time.Hour)
+	createSale(t, contract, ctx, "staggered", first)
+
+	actAs(ctx, "bidder1")
+	ctx.Stub.TxID = "tx1"
+	_, err := contract.PlaceBid(ctx, "lot1", "100")
+	assert.NoError(t, err)
+	err = contract.PauseSale(ctx, "sale1")
+	assert.Error(t, err)
+
+	actAs(ctx, "user1")
+	err = contract.PauseSale(ctx, "sale1")
+	assert.NoError(t, err)
+	assert.Equal(t, "SaleChanged", ctx.Stub.EventName)
+	actAs(ctx, "bidder2")
+	ctx.Stub.TxID = "tx2"
+	_, err = contract.PlaceBid(ctx, "lot1", "200")
+	assert.Error(t, err)
+
+	// resuming five minutes later moves every pending deadline by five minutes
+	actAs(ctx, "user1")
+	ctx.Stub.OracleTime = formatOracleTime(now.Add(5 * time.Minute))
+	err = contract.ResumeSale(ctx, "sale1")
+	assert.NoError(t, err)
+	assert.Len(t, decodeEvents(t, ctx), 4)
+	assert.True(t, getLot(t, contract, ctx, "lot1").Timelimit.Equal(first.Add(5*time.Minute)))
+	assert.True(t, getLot(t, contract, ctx, "lot3").Timelimit.Equal(first.Add(7*time.Minute)))
+
+	lot := getLot(t, contract, ctx, "lot1")
+	lot.Timelimit = now.Add(-1 * time.Minute)
+	putAuctionState(ctx, *lot)
+	err = contract.EndAuction(ctx, "lot1")
+	assert.NoError(t, err)
+	progress, err := contract.GetSaleProgress(ctx, "sale1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, progress.Sold)
+	assert.Equal(t, 2, progress.Open)
+	assert.Equal(t, []money.Money{usd(100)}, progress.Totals)
+}
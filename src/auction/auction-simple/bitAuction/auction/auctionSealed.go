@@ -0,0 +1,420 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"bitAuction/events"
+	"bitAuction/money"
+	"bitAuction/zkp"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// In a sealed-bid auction bidders submit a Pedersen commitment to their bid
+// amount instead of the amount, with a zero-knowledge range proof that the
+// amount is between the reserve and the available escrow balance of the
+// bidder. Escrow balances are public, so every bidder locks their whole
+// available balance with their commitment whatever they bid. Commitments are accepted until the time limit
+// and opened during the reveal period after it. An opened commitment becomes
+// an ordinary full bid, so the auction ends like any other once the reveal
+// period is over. Commitments that are never opened do not take part, and
+// their bidders forfeit the reserve to the seller so that withholding a
+// commitment after seeing the others is not free.
+// Clients build commitments and proofs with the bitAuction/zkp package.
+const sealedBidKeyType = "sealedbid"
+
+// sealedBidType is the object type of the full bid recording an opened
+// commitment
+const sealedBidType = "sealed"
+
+// SealedBidding holds the settings of a sealed-bid auction. Bids are at least
+// Reserve, and RevealPeriod is how many seconds after the time limit
+// commitments can be opened.
+type SealedBidding struct {
+	Reserve      money.Money `json:"reserve"`
+	RevealPeriod int         `json:"revealPeriod"`
+}
+
+// SealedBid is the commitment of a bidder to a hidden bid amount, together
+// with the escrow locked to back it, which is the available balance of the
+// bidder when they committed
+type SealedBid struct {
+	AuctionID   string      `json:"auctionID"`
+	TxID        string      `json:"txID"`
+	Bidder      string      `json:"bidder"`
+	Org         string      `json:"org"`
+	Attestation string      `json:"attestation,omitempty"`
+	Commitment  []byte      `json:"commitment"`
+	Escrow      money.Money `json:"escrow"`
+	Timestamp   time.Time   `json:"timestamp"`
+	Opened      bool        `json:"opened"`
+}
+
+// SetSealedBidding lets the seller of a full escrow auction take sealed bids
+// of at least reserve, opened during revealPeriod seconds after the time
+// limit. An empty reserve takes open bids again. The setting can only change
+// before the first bid.
+func (s *SmartContract) SetSealedBidding(ctx contractapi.TransactionContextInterface, auctionID string, reserve string, revealPeriod int) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = s.checkSellerBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+	if reserve == "" {
+		auction.Sealed = nil
+		return putAuction(ctx, auction)
+	}
+	if err = requirePublicAuction(auction, "sealed bids"); err != nil {
+		return err
+	}
+
+	reservePrice, err := parseBidPrice(auction, reserve)
+	if err != nil {
+		return err
+	}
+	if revealPeriod < 1 {
+		return fmt.Errorf("the reveal period lasts at least one second")
+	}
+	if settledOffChain(auction) {
+		return fmt.Errorf("reverse, multi-unit and package auctions cannot take sealed bids")
+	}
+	if auction.Clock != nil {
+		return fmt.Errorf("clock auctions cannot take sealed bids")
+	}
+	if auction.EscrowMode != escrowFull {
+		return fmt.Errorf("sealed bids are proven against the escrow of the bidder, enable full escrow first")
+	}
+	if auction.BuyNowPrice.IsPositive() {
+		return fmt.Errorf("sealed-bid auctions have no buy-now price, remove it first")
+	}
+
+	auction.Sealed = &SealedBidding{Reserve: reservePrice, RevealPeriod: revealPeriod}
+	return putAuction(ctx, auction)
+}
+
+// SubmitSealedBid commits the client to a hidden bid amount. commitment is
+// the base64 encoded Pedersen commitment and proof the JSON encoded range
+// proof that the amount is between the reserve and the available escrow
+// balance of the client, both in minor units. The proof has to be made for
+// the auction and the client, so that it cannot be replayed by another
+// bidder. The available balance is locked until the auction ends. Every bidder submits a single
+// commitment. The function returns the transaction ID that identifies it.
+func (s *SmartContract) SubmitSealedBid(ctx contractapi.TransactionContextInterface, auctionID string, commitment string, proof string) (string, error) {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get auction: %v", err)
+	}
+	if auction.Sealed == nil {
+		return "", fmt.Errorf("auction %v does not take sealed bids", auctionID)
+	}
+	if err = isAuctionOpenForBidding(auction); err != nil {
+		return "", err
+	}
+
+	bidder, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get client identity: %v", err)
+	}
+	org, err := clientOrg(ctx)
+	if err != nil {
+		return "", err
+	}
+	attestation, err := bidderAttestation(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err = s.checkBidder(ctx, auction); err != nil {
+		return "", err
+	}
+	if err = checkShillRule(auction, bidder, org); err != nil {
+		return "", err
+	}
+	if err = checkSaleRunning(ctx, auction); err != nil {
+		return "", err
+	}
+	existing, err := getSealedBid(ctx, auctionID, bidder)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		return "", fmt.Errorf("the client has already submitted a sealed bid in transaction %v", existing.TxID)
+	}
+
+	balance, err := getBalance(ctx, bidder, auction.Sealed.Reserve.Currency)
+	if err != nil {
+		return "", err
+	}
+	escrowAmount := balance.Available
+	if escrowAmount.Amount < auction.Sealed.Reserve.Amount {
+		return "", fmt.Errorf("insufficient escrow balance, the reserve is %v but only %v is available", auction.Sealed.Reserve, escrowAmount)
+	}
+	commitmentBytes, err := base64.StdEncoding.DecodeString(commitment)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode commitment: %v", err)
+	}
+	var rangeProof zkp.RangeProof
+	err = json.Unmarshal([]byte(proof), &rangeProof)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal range proof: %v", err)
+	}
+	err = zkp.VerifyRange(commitmentBytes, auction.Sealed.Reserve.Amount, escrowAmount.Amount, &rangeProof, zkp.BidContext(auctionID, bidder))
+	if err != nil {
+		return "", fmt.Errorf("the sealed bid is not between the reserve %v and the available balance %v: %v", auction.Sealed.Reserve, escrowAmount, err)
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	Timestamp, err := s.oracleTimestamp(ctx, txID)
+	if err != nil {
+		return "", err
+	}
+	if !Timestamp.Before(auction.Timelimit) {
+		return "", fmt.Errorf("auction has already ended")
+	}
+	if err = lockBidFunds(ctx, auction, bidder, escrowAmount); err != nil {
+		return "", err
+	}
+
+	sealedBid := SealedBid{
+		AuctionID:   auctionID,
+		TxID:        txID,
+		Bidder:      bidder,
+		Org:         org,
+		Attestation: attestation,
+		Commitment:  commitmentBytes,
+		Escrow:      escrowAmount,
+		Timestamp:   Timestamp,
+	}
+	if err = putSealedBid(ctx, &sealedBid); err != nil {
+		return "", err
+	}
+	err = emitEvents(ctx, events.SealedBidCommitted{
+		AuctionID: auctionID,
+		TxID:      txID,
+		Bidder:    bidder,
+		Escrow:    escrowAmount,
+		Timestamp: Timestamp,
+	})
+	if err != nil {
+		return "", err
+	}
+	return txID, nil
+}
+
+// OpenSealedBid opens the sealed bid of the client during the reveal period
+// with the amount and the base64 encoded blinding of its commitment. The bid
+// is recorded as a full bid of type "sealed" with the transaction ID and
+// timestamp of the commitment, so earlier commitments win ties.
+func (s *SmartContract) OpenSealedBid(ctx contractapi.TransactionContextInterface, auctionID string, amount string, blinding string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if auction.Sealed == nil {
+		return fmt.Errorf("auction %v does not take sealed bids", auctionID)
+	}
+	if auction.Status != "open" {
+		return fmt.Errorf("auction is not open, status is %v", auction.Status)
+	}
+	bidder, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	sealedBid, err := getSealedBid(ctx, auctionID, bidder)
+	if err != nil {
+		return err
+	}
+	if sealedBid == nil {
+		return fmt.Errorf("the client has no sealed bid on auction %v", auctionID)
+	}
+	if sealedBid.Opened {
+		return fmt.Errorf("sealed bid %v has already been opened", sealedBid.TxID)
+	}
+
+	now, err := s.oracleTimestamp(ctx, ctx.GetStub().GetTxID())
+	if err != nil {
+		return err
+	}
+	revealEnd := sealedRevealEnd(auction)
+	if now.Before(auction.Timelimit) {
+		return fmt.Errorf("sealed bids can be opened from %v", auction.Timelimit)
+	}
+	if !now.Before(revealEnd) {
+		return fmt.Errorf("the reveal period ended at %v", revealEnd)
+	}
+
+	price, err := parseBidPrice(auction, amount)
+	if err != nil {
+		return err
+	}
+	blindingBytes, err := base64.StdEncoding.DecodeString(blinding)
+	if err != nil {
+		return fmt.Errorf("failed to decode blinding: %v", err)
+	}
+	err = zkp.VerifyOpening(sealedBid.Commitment, &zkp.Opening{Value: price.Amount, Blinding: blindingBytes})
+	if err != nil {
+		return fmt.Errorf("the opening does not match sealed bid %v: %v", sealedBid.TxID, err)
+	}
+
+	fullBid := FullBid{
+		Type:        sealedBidType,
+		TxID:        sealedBid.TxID,
+		Price:       price,
+		Org:         sealedBid.Org,
+		Bidder:      bidder,
+		Valid:       true,
+		Timestamp:   sealedBid.Timestamp,
+		Attestation: sealedBid.Attestation,
+	}
+	leader, err := s.consolidatedLeader(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get highest bid: %v", err)
+	}
+	err = putFullBid(ctx, auctionID, sealedBid.TxID, &fullBid)
+	if err != nil {
+		return err
+	}
+	err = putLeaderDelta(ctx, auctionID, &fullBid)
+	if err != nil {
+		return err
+	}
+	sealedBid.Opened = true
+	if err = putSealedBid(ctx, sealedBid); err != nil {
+		return err
+	}
+
+	evts := []events.Event{events.BidSubmitted{
+		AuctionID: auctionID,
+		TxID:      sealedBid.TxID,
+		Bidder:    bidder,
+		Org:       sealedBid.Org,
+		Price:     price,
+		Timestamp: sealedBid.Timestamp,
+	}}
+	better, err := s.isBetterBid(auction.Direction, &fullBid, leader)
+	if err != nil {
+		return err
+	}
+	if better {
+		newLeader := events.NewLeader{AuctionID: auctionID, TxID: sealedBid.TxID, Bidder: bidder, Price: price}
+		if leader != nil {
+			newLeader.PreviousPrice = leader.Price
+		}
+		evts = append(evts, newLeader)
+	}
+	return emitAuctionEvents(ctx, auction, evts...)
+}
+
+// QuerySealedBids returns the commitments submitted to an auction ordered by
+// bidder
+func (s *SmartContract) QuerySealedBids(ctx contractapi.TransactionContextInterface, auctionID string) ([]*SealedBid, error) {
+	if _, err := s.QueryAuction(ctx, auctionID); err != nil {
+		return nil, fmt.Errorf("failed to get auction: %v", err)
+	}
+	return getSealedBids(ctx, auctionID)
+}
+
+// unopenedForfeits returns what the bidders of a sealed-bid auction who did not
+// open their commitment forfeit to the seller
+func unopenedForfeits(ctx contractapi.TransactionContextInterface, auction *Auction) (map[string]money.Money, error) {
+	forfeits := map[string]money.Money{}
+	if auction.Sealed == nil {
+		return forfeits, nil
+	}
+	sealedBids, err := getSealedBids(ctx, auction.AuctionID)
+	if err != nil {
+		return nil, err
+	}
+	for _, sealedBid := range sealedBids {
+		if !sealedBid.Opened {
+			forfeits[sealedBid.Bidder] = auction.Sealed.Reserve
+		}
+	}
+	return forfeits, nil
+}
+
+// sealedRevealEnd returns when the reveal period of a sealed-bid auction
+// ends, the time limit for other auctions
+func sealedRevealEnd(auction *Auction) time.Time {
+	if auction.Sealed == nil {
+		return auction.Timelimit
+	}
+	return auction.Timelimit.Add(time.Duration(auction.Sealed.RevealPeriod) * time.Second)
+}
+
+func sealedBidKey(ctx contractapi.TransactionContextInterface, auctionID string, bidder string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(sealedBidKeyType, []string{auctionID, bidder})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return key, nil
+}
+
+func putSealedBid(ctx contractapi.TransactionContextInterface, sealedBid *SealedBid) error {
+	key, err := sealedBidKey(ctx, sealedBid.AuctionID, sealedBid.Bidder)
+	if err != nil {
+		return err
+	}
+	sealedBidJSON, err := json.Marshal(sealedBid)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sealed bid: %v", err)
+	}
+	err = ctx.GetStub().PutState(key, sealedBidJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put sealed bid in state: %v", err)
+	}
+	return nil
+}
+
+// getSealedBid returns the commitment of the bidder, nil when they did not
+// submit one
+func getSealedBid(ctx contractapi.TransactionContextInterface, auctionID string, bidder string) (*SealedBid, error) {
+	key, err := sealedBidKey(ctx, auctionID, bidder)
+	if err != nil {
+		return nil, err
+	}
+	sealedBidJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sealed bid: %v", err)
+	}
+	if sealedBidJSON == nil {
+		return nil, nil
+	}
+	var sealedBid SealedBid
+	err = json.Unmarshal(sealedBidJSON, &sealedBid)
+	if err != nil {
+		return nil, err
+	}
+	return &sealedBid, nil
+}
+
+func getSealedBids(ctx contractapi.TransactionContextInterface, auctionID string) ([]*SealedBid, error) {
+	iter, err := ctx.GetStub().GetStateByPartialCompositeKey(sealedBidKeyType, []string{auctionID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sealed bids: %v", err)
+	}
+	defer iter.Close()
+
+	sealedBids := []*SealedBid{}
+	for iter.HasNext() {
+		queryResponse, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		var sealedBid SealedBid
+		err = json.Unmarshal(queryResponse.Value, &sealedBid)
+		if err != nil {
+			return nil, err
+		}
+		sealedBids = append(sealedBids, &sealedBid)
+	}
+	return sealedBids, nil
+}
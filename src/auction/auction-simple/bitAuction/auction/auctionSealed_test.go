@@ -0,0 +1,240 @@
+package auction_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+	"bitAuction/events"
+	"bitAuction/zkp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sealBid commits to amount cents and proves it is between min and max for
+// the bidder, returning the arguments of SubmitSealedBid and the opening
+func sealBid(t *testing.T, amount int64, min int64, max int64, bidder string) (string, string, *zkp.Opening) {
+	commitment, opening, err := zkp.Commit(amount)
+	assert.NoError(t, err)
+	proof, err := zkp.ProveRange(opening, min, max, zkp.BidContext("auction1", bidder))
+	assert.NoError(t, err)
+	proofJSON, err := json.Marshal(proof)
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(commitment), string(proofJSON), opening
+}
+
+func createSealedAuction(t *testing.T, contract *auction.SmartContract, ctx *MockContext, now time.Time) {
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	err := contract.SetSealedBidding(ctx, "auction1", "100", 3600)
+	assert.Error(t, err)
+	err = contract.SetEscrow(ctx, "auction1", "full", "")
+	assert.NoError(t, err)
+	err = contract.SetSealedBidding(ctx, "auction1", "100", 3600)
+	assert.NoError(t, err)
+
+	ctx.Identity.Attrs = map[string]string{"bitauction.role": "seller,bidder,admin"}
+	ctx.Stub.TxID = "credit"
+	err = contract.CreditBalance(ctx, "bidder1", "500")
+	assert.NoError(t, err)
+	err = contract.CreditBalance(ctx, "bidder2", "300")
+	assert.NoError(t, err)
+}
+
+func TestSubmitSealedBid(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createSealedAuction(t, contract, ctx, now)
+
+	actAs(ctx, "bidder1")
+	ctx.Stub.TxID = "tx1"
+	_, err := contract.PlaceBid(ctx, "auction1", "250")
+	assert.Error(t, err)
+	commitment, proof, _ := sealBid(t, 25000, 10000, 50000, "bidder1")
+	txID, err := contract.SubmitSealedBid(ctx, "auction1", commitment, proof)
+	assert.NoError(t, err)
+	assert.Equal(t, "tx1", txID)
+	evts := decodeEvents(t, ctx)
+	committed, ok := evts[0].(*events.SealedBidCommitted)
+	assert.True(t, ok)
+	assert.Equal(t, usd(500), committed.Escrow)
+	assert.Equal(t, auction.Balance{Owner: "bidder1", Available: usd(0), Locked: usd(500)}, balance(t, contract, ctx, "bidder1"))
+
+	ctx.Stub.TxID = "tx2"
+	_, err = contract.SubmitSealedBid(ctx, "auction1", commitment, proof)
+	assert.Error(t, err)
+
+	// the proof only holds for bidder1, the reserve and the available balance
+	actAs(ctx, "bidder2")
+	ctx.Stub.TxID = "tx3"
+	_, err = contract.SubmitSealedBid(ctx, "auction1", commitment, proof)
+	assert.Error(t, err)
+	commitment, proof, _ = sealBid(t, 15000, 10000, 20000, "bidder2")
+	_, err = contract.SubmitSealedBid(ctx, "auction1", commitment, proof)
+	assert.Error(t, err)
+	commitment, proof, _ = sealBid(t, 5000, 0, 30000, "bidder2")
+	_, err = contract.SubmitSealedBid(ctx, "auction1", commitment, proof)
+	assert.Error(t, err)
+
+	// bidders who cannot cover the reserve cannot commit
+	actAs(ctx, "bidder3")
+	ctx.Stub.TxID = "credit3"
+	err = contract.CreditBalance(ctx, "bidder3", "50")
+	assert.NoError(t, err)
+	ctx.Stub.TxID = "tx4"
+	commitment, proof, _ = sealBid(t, 5000, 0, 5000, "bidder3")
+	_, err = contract.SubmitSealedBid(ctx, "auction1", commitment, proof)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "insufficient escrow balance")
+
+	sealedBids, err := contract.QuerySealedBids(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Len(t, sealedBids, 1)
+	assert.Equal(t, "bidder1", sealedBids[0].Bidder)
+	assert.False(t, sealedBids[0].Opened)
+
+	// commitments count as bids for the settings of the auction
+	actAs(ctx, "user1")
+	err = contract.SetSealedBidding(ctx, "auction1", "", 0)
+	assert.Error(t, err)
+
+	actAs(ctx, "bidder2")
+	ctx.Stub.OracleTime = formatOracleTime(now.Add(1 * time.Hour))
+	commitment, proof, _ = sealBid(t, 15000, 10000, 30000, "bidder2")
+	_, err = contract.SubmitSealedBid(ctx, "auction1", commitment, proof)
+	assert.Error(t, err)
+}
+
+func TestOpenSealedBids(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createSealedAuction(t, contract, ctx, now)
+
+	actAs(ctx, "bidder1")
+	ctx.Stub.TxID = "tx1"
+	commitment, proof, opening1 := sealBid(t, 25000, 10000, 50000, "bidder1")
+	_, err := contract.SubmitSealedBid(ctx, "auction1", commitment, proof)
+	assert.NoError(t, err)
+	actAs(ctx, "bidder2")
+	ctx.Stub.TxID = "tx2"
+	commitment, proof, opening2 := sealBid(t, 20000, 10000, 30000, "bidder2")
+	_, err = contract.SubmitSealedBid(ctx, "auction1", commitment, proof)
+	assert.NoError(t, err)
+
+	// commitments are opened after the time limit
+	blinding2 := base64.StdEncoding.EncodeToString(opening2.Blinding)
+	ctx.Stub.TxID = "open2"
+	err = contract.OpenSealedBid(ctx, "auction1", "200", blinding2)
+	assert.Error(t, err)
+	ctx.Stub.OracleTime = formatOracleTime(now.Add(90 * time.Minute))
+	err = contract.OpenSealedBid(ctx, "auction1", "210", blinding2)
+	assert.Error(t, err)
+	err = contract.OpenSealedBid(ctx, "auction1", "200", blinding2)
+	assert.NoError(t, err)
+	err = contract.OpenSealedBid(ctx, "auction1", "200", blinding2)
+	assert.Error(t, err)
+
+	actAs(ctx, "bidder1")
+	ctx.Stub.TxID = "open1"
+	err = contract.OpenSealedBid(ctx, "auction1", "250", base64.StdEncoding.EncodeToString(opening1.Blinding))
+	assert.NoError(t, err)
+	evts := decodeEvents(t, ctx)
+	submitted, ok := evts[0].(*events.BidSubmitted)
+	assert.True(t, ok)
+	assert.Equal(t, "tx1", submitted.TxID)
+	assert.Equal(t, usd(250), submitted.Price)
+	_, ok = evts[1].(*events.NewLeader)
+	assert.True(t, ok)
+	bid, err := contract.GetHb(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "sealed", bid.Type)
+
+	// the auction ends once the reveal period is over by the oracle time
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-2 * time.Hour)
+	putAuctionState(ctx, a)
+	actAs(ctx, "user1")
+	ctx.Stub.TxID = "end"
+	ctx.Stub.OracleTime = formatOracleTime(now.Add(-90 * time.Minute))
+	err = contract.EndAuction(ctx, "auction1")
+	assert.Error(t, err)
+	ctx.Stub.OracleTime = formatOracleTime(now.Add(-30 * time.Minute))
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+
+	a = getAuction(ctx)
+	assert.Equal(t, "bidder1", a.Winner)
+	assert.Equal(t, usd(250), a.Price)
+	assert.Equal(t, auction.Balance{Owner: "bidder1", Available: usd(250), Locked: usd(0)}, balance(t, contract, ctx, "bidder1"))
+	assert.Equal(t, auction.Balance{Owner: "bidder2", Available: usd(300), Locked: usd(0)}, balance(t, contract, ctx, "bidder2"))
+}
+
+func TestUnopenedSealedBidForfeitsReserve(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createSealedAuction(t, contract, ctx, now)
+
+	actAs(ctx, "bidder1")
+	ctx.Stub.TxID = "tx1"
+	commitment, proof, _ := sealBid(t, 25000, 10000, 50000, "bidder1")
+	_, err := contract.SubmitSealedBid(ctx, "auction1", commitment, proof)
+	assert.NoError(t, err)
+	actAs(ctx, "bidder2")
+	ctx.Stub.TxID = "tx2"
+	commitment, proof, opening2 := sealBid(t, 20000, 10000, 30000, "bidder2")
+	_, err = contract.SubmitSealedBid(ctx, "auction1", commitment, proof)
+	assert.NoError(t, err)
+
+	ctx.Stub.OracleTime = formatOracleTime(now.Add(90 * time.Minute))
+	ctx.Stub.TxID = "open2"
+	err = contract.OpenSealedBid(ctx, "auction1", "200", base64.StdEncoding.EncodeToString(opening2.Blinding))
+	assert.NoError(t, err)
+
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-2 * time.Hour)
+	putAuctionState(ctx, a)
+	actAs(ctx, "user1")
+	ctx.Stub.TxID = "end"
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+
+	// bidder1 kept its commitment closed and pays the reserve to the seller
+	a = getAuction(ctx)
+	assert.Equal(t, "bidder2", a.Winner)
+	assert.Equal(t, auction.Balance{Owner: "bidder1", Available: usd(400), Locked: usd(0)}, balance(t, contract, ctx, "bidder1"))
+	assert.Equal(t, auction.Balance{Owner: "bidder2", Available: usd(100), Locked: usd(0)}, balance(t, contract, ctx, "bidder2"))
+	// the seller is paid the price of 200 and the forfeited reserve
+	assert.Equal(t, usd(300), balance(t, contract, ctx, "user1").Available)
+}
+
+func TestSealedBiddingSettings(t *testing.T) {
+	contract, ctx := setup()
+	createAuction(t, contract, ctx, time.Now().Add(1*time.Hour))
+	err := contract.SetEscrow(ctx, "auction1", "full", "")
+	assert.NoError(t, err)
+	err = contract.SetSealedBidding(ctx, "auction1", "100", 0)
+	assert.Error(t, err)
+	err = contract.SetSealedBidding(ctx, "auction1", "abc", 600)
+	assert.Error(t, err)
+	err = contract.SetSealedBidding(ctx, "auction1", "100", 600)
+	assert.NoError(t, err)
+
+	err = contract.SetEscrow(ctx, "auction1", "deposit", "10")
+	assert.Error(t, err)
+	err = contract.SetBuyNow(ctx, "auction1", "500", "")
+	assert.Error(t, err)
+	err = contract.SetCurrency(ctx, "auction1", "EUR")
+	assert.Error(t, err)
+	err = contract.OpenSession(ctx, "auction1", "100", 0)
+	assert.Error(t, err)
+
+	err = contract.SetSealedBidding(ctx, "auction1", "", 0)
+	assert.NoError(t, err)
+	assert.Nil(t, getAuction(ctx).Sealed)
+	err = contract.SetBuyNow(ctx, "auction1", "500", "")
+	assert.NoError(t, err)
+}
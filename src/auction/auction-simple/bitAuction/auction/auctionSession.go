@@ -0,0 +1,538 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"bitAuction/events"
+	"bitAuction/money"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A live session sells an auction by open outcry. The auctioneer who opens it
+// announces asking prices, records the bids of registered bidders from the
+// floor or the phone and calls "going once", "going twice" and "sold". Every
+// call is timestamped through the Time Oracle and recorded under a
+// sessioncall key numbered in order, and the session state is the result of
+// applying the calls in that order, so ReplaySession can rebuild it exactly.
+const (
+	sessionCallKeyType = "sessioncall"
+	paddleKeyType      = "paddle"
+)
+
+// types of session calls
+const (
+	callOpen       = "open"
+	callAsk        = "ask"
+	callBid        = "bid"
+	callGoingOnce  = "going-once"
+	callGoingTwice = "going-twice"
+	callSold       = "sold"
+)
+
+// phases of a session
+const (
+	phaseBidding    = "bidding"
+	phaseGoingOnce  = "going-once"
+	phaseGoingTwice = "going-twice"
+	phaseSold       = "sold"
+)
+
+// channels through which a bid reaches the auctioneer
+const (
+	channelFloor = "floor"
+	channelPhone = "phone"
+)
+
+// Session is the state of a live session. PhaseTimeout is how many seconds
+// without a call have to pass before the auctioneer can move to the next of
+// "going once", "going twice" and "sold".
+type Session struct {
+	Auctioneer   string      `json:"auctioneer"`
+	PhaseTimeout int         `json:"phaseTimeout"`
+	Phase        string      `json:"phase"`
+	Ask          money.Money `json:"ask"`
+	Paddle       string      `json:"paddle"`
+	Price        money.Money `json:"price"`
+	BidTxID      string      `json:"bidTxID"`
+	Calls        int         `json:"calls"`
+	LastCall     time.Time   `json:"lastCall"`
+}
+
+// SessionCall is a call of the auctioneer in a live session. Price is the
+// asking price of open and ask calls and the bid of bid calls.
+type SessionCall struct {
+	AuctionID    string      `json:"auctionID"`
+	Seq          int         `json:"seq"`
+	Type         string      `json:"type"`
+	Auctioneer   string      `json:"auctioneer"`
+	Price        money.Money `json:"price"`
+	Paddle       string      `json:"paddle,omitempty"`
+	Bidder       string      `json:"bidder,omitempty"`
+	Org          string      `json:"org,omitempty"`
+	Channel      string      `json:"channel,omitempty"`
+	PhaseTimeout int         `json:"phaseTimeout,omitempty"`
+	TxID         string      `json:"txID"`
+	Timestamp    time.Time   `json:"timestamp"`
+}
+
+// Paddle registers a bidder for the live session of an auction. The
+// auctioneer records bids by paddle.
+type Paddle struct {
+	AuctionID string `json:"auctionID"`
+	Paddle    string `json:"paddle"`
+	Bidder    string `json:"bidder"`
+	Org       string `json:"org"`
+	// Member is the organization the bidder takes part for, the vouching
+	// organization of an Idemix bidder. Verified records whether the bidder
+	// was verified when they registered.
+	Member   string `json:"member"`
+	Verified bool   `json:"verified"`
+}
+
+// RegisterPaddle registers the client as a bidder of the live session of an
+// auction under the paddle number of their choice, which lets the auctioneer
+// bid on their behalf
+func (s *SmartContract) RegisterPaddle(ctx contractapi.TransactionContextInterface, auctionID string, paddle string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if auction.Status != "open" {
+		return fmt.Errorf("auction is not open for bidding")
+	}
+	if paddle == "" {
+		return fmt.Errorf("paddle numbers cannot be empty")
+	}
+	bidder, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	org, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get org: %v", err)
+	}
+	if err = s.checkBidder(ctx, auction); err != nil {
+		return err
+	}
+	if err = checkShillRule(auction, bidder, org); err != nil {
+		return err
+	}
+	member, err := clientOrg(ctx)
+	if err != nil {
+		return err
+	}
+	verified, err := clientVerified(ctx)
+	if err != nil {
+		return err
+	}
+	existing, err := getPaddle(ctx, auctionID, paddle)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("paddle %v is already taken", paddle)
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(paddleKeyType, []string{auctionID, paddle})
+	if err != nil {
+		return fmt.Errorf("failed to create paddle key: %v", err)
+	}
+	paddleJSON, err := json.Marshal(Paddle{AuctionID: auctionID, Paddle: paddle, Bidder: bidder, Org: org, Member: member, Verified: verified})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, paddleJSON)
+}
+
+// OpenSession lets an auctioneer sell an auction with no bids in a live
+// session, starting at the asking price ask. While the session runs, bids can
+// only be recorded by the auctioneer and the auction ends when they call
+// sold, not at its time limit.
+func (s *SmartContract) OpenSession(ctx contractapi.TransactionContextInterface, auctionID string, ask string, phaseTimeout int) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = requireRole(ctx, roleAuctioneer); err != nil {
+		return err
+	}
+	if err = isAuctionOpenForBidding(auction); err != nil {
+		return err
+	}
+	if auction.Session != nil {
+		return fmt.Errorf("auction %v already has a session", auctionID)
+	}
+	if err = requirePublicAuction(auction, "live sessions"); err != nil {
+		return err
+	}
+	if settledOffChain(auction) || auction.Clock != nil || auction.Sealed != nil {
+		return fmt.Errorf("live sessions sell single items to the highest bidder")
+	}
+	bids, err := s.QueryBids(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get bids: %v", err)
+	}
+	if len(bids) > 0 {
+		return fmt.Errorf("live sessions can only open before the first bid")
+	}
+	if phaseTimeout < 0 {
+		return fmt.Errorf("the phase timeout cannot be negative")
+	}
+	askPrice, err := parseBidPrice(auction, ask)
+	if err != nil {
+		return err
+	}
+
+	auction.Session = &Session{}
+	_, err = s.recordSessionCall(ctx, auction, &SessionCall{Type: callOpen, Price: askPrice, PhaseTimeout: phaseTimeout})
+	return err
+}
+
+// AnnounceAsk announces the next asking price of a live session, which has to
+// be above the leading bid
+func (s *SmartContract) AnnounceAsk(ctx contractapi.TransactionContextInterface, auctionID string, ask string) error {
+	auction, err := s.sessionAuction(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+	askPrice, err := parseBidPrice(auction, ask)
+	if err != nil {
+		return err
+	}
+	_, err = s.recordSessionCall(ctx, auction, &SessionCall{Type: callAsk, Price: askPrice})
+	return err
+}
+
+// RecordSessionBid records a bid at the asking price for the bidder holding
+// paddle, received on the floor or by phone. It is stored as a full bid of the
+// bidder of type "floor" or "phone". The function returns the transaction ID
+// that identifies the bid.
+func (s *SmartContract) RecordSessionBid(ctx contractapi.TransactionContextInterface, auctionID string, paddle string, channel string) (string, error) {
+	auction, err := s.sessionAuction(ctx, auctionID)
+	if err != nil {
+		return "", err
+	}
+	if channel != channelFloor && channel != channelPhone {
+		return "", fmt.Errorf("unknown bid channel %v", channel)
+	}
+	registered, err := getPaddle(ctx, auctionID, paddle)
+	if err != nil {
+		return "", err
+	}
+	if registered == nil {
+		return "", fmt.Errorf("paddle %v is not registered", paddle)
+	}
+	// the bidder may have lost the right to bid since they registered
+	member := registered.Member
+	if member == "" {
+		member = registered.Org
+	}
+	if err = s.checkBidderStanding(auction, registered.Bidder, member, registered.Verified); err != nil {
+		return "", err
+	}
+	if err = checkShillRule(auction, registered.Bidder, registered.Org); err != nil {
+		return "", err
+	}
+	if err = lockBidFunds(ctx, auction, registered.Bidder, auction.Session.Ask); err != nil {
+		return "", err
+	}
+
+	call, err := s.recordSessionCall(ctx, auction, &SessionCall{
+		Type:    callBid,
+		Price:   auction.Session.Ask,
+		Paddle:  paddle,
+		Bidder:  registered.Bidder,
+		Org:     registered.Org,
+		Channel: channel,
+	})
+	if err != nil {
+		return "", err
+	}
+	return call.TxID, nil
+}
+
+// CallGoing makes the next call of a live session after the leading bid:
+// "going once", "going twice" and finally "sold", which ends the auction with
+// the leading bidder as the winner, or without a sale when nobody bid. Each
+// call needs the phase timeout to pass without another call.
+func (s *SmartContract) CallGoing(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, err := s.sessionAuction(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+	callType := callGoingOnce
+	switch auction.Session.Phase {
+	case phaseGoingOnce:
+		callType = callGoingTwice
+	case phaseGoingTwice:
+		callType = callSold
+	}
+	_, err = s.recordSessionCall(ctx, auction, &SessionCall{Type: callType, Price: auction.Session.Price})
+	return err
+}
+
+// QuerySessionCalls returns the calls of the live session of an auction in order
+func (s *SmartContract) QuerySessionCalls(ctx contractapi.TransactionContextInterface, auctionID string) ([]*SessionCall, error) {
+	iter, err := ctx.GetStub().GetStateByPartialCompositeKey(sessionCallKeyType, []string{auctionID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session calls of auction %v: %v", auctionID, err)
+	}
+	defer iter.Close()
+
+	calls := []*SessionCall{}
+	for iter.HasNext() {
+		queryResponse, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		var call SessionCall
+		err = json.Unmarshal(queryResponse.Value, &call)
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, &call)
+	}
+	return calls, nil
+}
+
+// ReplaySession rebuilds the state of the live session of an auction from its
+// recorded calls. It fails when the calls do not add up to the session state
+// stored with the auction.
+func (s *SmartContract) ReplaySession(ctx contractapi.TransactionContextInterface, auctionID string) (*Session, error) {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auction: %v", err)
+	}
+	if auction.Session == nil {
+		return nil, fmt.Errorf("auction %v has no session", auctionID)
+	}
+	calls, err := s.QuerySessionCalls(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{}
+	for _, call := range calls {
+		if err = applySessionCall(session, call); err != nil {
+			return nil, fmt.Errorf("failed to replay call %d: %v", call.Seq, err)
+		}
+	}
+	replayed, _ := json.Marshal(session)
+	stored, _ := json.Marshal(auction.Session)
+	if string(replayed) != string(stored) {
+		return nil, fmt.Errorf("the calls of auction %v do not replay to its session", auctionID)
+	}
+	return session, nil
+}
+
+// sessionAuction returns an auction with a running session for a call of its auctioneer
+func (s *SmartContract) sessionAuction(ctx contractapi.TransactionContextInterface, auctionID string) (*Auction, error) {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auction: %v", err)
+	}
+	if auction.Session == nil {
+		return nil, fmt.Errorf("auction %v has no session", auctionID)
+	}
+	if auction.Status != "open" {
+		return nil, fmt.Errorf("auction is not open, status is %v", auction.Status)
+	}
+	if err = requireRole(ctx, roleAuctioneer); err != nil {
+		return nil, err
+	}
+	return auction, nil
+}
+
+// recordSessionCall timestamps a call of the client, applies it to the session
+// and records it. Bids are stored as full bids and sold ends the auction.
+func (s *SmartContract) recordSessionCall(ctx contractapi.TransactionContextInterface, auction *Auction, call *SessionCall) (*SessionCall, error) {
+	auctioneer, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client identity: %v", err)
+	}
+	txID := ctx.GetStub().GetTxID()
+	Timestamp, err := s.oracleTimestamp(ctx, txID)
+	if err != nil {
+		return nil, err
+	}
+	call.AuctionID = auction.AuctionID
+	call.Seq = auction.Session.Calls
+	call.Auctioneer = auctioneer
+	call.TxID = txID
+	call.Timestamp = Timestamp
+	leader := auction.Session.Price
+	if err = applySessionCall(auction.Session, call); err != nil {
+		return nil, err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(sessionCallKeyType, []string{auction.AuctionID, fmt.Sprintf("%010d", call.Seq)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session call key: %v", err)
+	}
+	callJSON, err := json.Marshal(call)
+	if err != nil {
+		return nil, err
+	}
+	if err = ctx.GetStub().PutState(key, callJSON); err != nil {
+		return nil, fmt.Errorf("failed to put session call in state: %v", err)
+	}
+
+	evts := []events.Event{events.SessionCalled{
+		AuctionID: auction.AuctionID,
+		Seq:       call.Seq,
+		Type:      call.Type,
+		Price:     call.Price,
+		Paddle:    call.Paddle,
+		Timestamp: Timestamp,
+	}}
+	if call.Type == callBid {
+		bid := FullBid{
+			Type:      call.Channel,
+			TxID:      txID,
+			Price:     call.Price,
+			Org:       call.Org,
+			Bidder:    call.Bidder,
+			Valid:     true,
+			Timestamp: Timestamp,
+		}
+		if err = putFullBid(ctx, auction.AuctionID, txID, &bid); err != nil {
+			return nil, err
+		}
+		if err = putLeaderDelta(ctx, auction.AuctionID, &bid); err != nil {
+			return nil, err
+		}
+		newLeader := events.NewLeader{AuctionID: auction.AuctionID, TxID: txID, Bidder: bid.Bidder, Price: bid.Price}
+		if leader.IsPositive() {
+			newLeader.PreviousPrice = leader
+		}
+		evts = append(evts,
+			events.BidSubmitted{AuctionID: auction.AuctionID, TxID: txID, Bidder: bid.Bidder, Org: bid.Org, Price: bid.Price, Timestamp: Timestamp},
+			newLeader,
+		)
+	}
+
+	if call.Type == callSold {
+		// the auction ends at the time of the hammer, which starts the settlement
+		auction.Timelimit = Timestamp
+		winning, err := s.consolidateLeader(ctx, auction.AuctionID, auction.Direction)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get highest bid: %v", err)
+		}
+		outcome := outcomeBidding
+		if winning == nil {
+			outcome = outcomeNoSale
+		}
+		return call, s.closeAuction(ctx, auction, winning, outcome, auctioneer, evts...)
+	}
+	if err = putAuction(ctx, auction); err != nil {
+		return nil, err
+	}
+	return call, emitAuctionEvents(ctx, auction, evts...)
+}
+
+// applySessionCall moves the session on by a call, or refuses a call that the
+// session does not allow
+func applySessionCall(session *Session, call *SessionCall) error {
+	if call.Seq != session.Calls {
+		return fmt.Errorf("call %d is out of order, the session is at call %d", call.Seq, session.Calls)
+	}
+	if call.Type == callOpen {
+		if session.Calls > 0 {
+			return fmt.Errorf("the session is already open")
+		}
+		session.Auctioneer = call.Auctioneer
+		session.PhaseTimeout = call.PhaseTimeout
+		session.Phase = phaseBidding
+		session.Ask = call.Price
+		session.Price = money.Zero(call.Price.Currency)
+	} else {
+		if session.Calls == 0 {
+			return fmt.Errorf("the session is not open")
+		}
+		if session.Phase == phaseSold {
+			return fmt.Errorf("the session is over")
+		}
+		if call.Auctioneer != session.Auctioneer {
+			return fmt.Errorf("only the auctioneer who opened the session can make calls")
+		}
+		if call.Timestamp.Before(session.LastCall) {
+			return fmt.Errorf("call %d is timestamped before the call before it", call.Seq)
+		}
+	}
+
+	silence := call.Timestamp.Sub(session.LastCall)
+	timeout := time.Duration(session.PhaseTimeout) * time.Second
+	switch call.Type {
+	case callOpen:
+	case callAsk:
+		if session.BidTxID != "" {
+			cmp, err := call.Price.Cmp(session.Price)
+			if err != nil {
+				return err
+			}
+			if cmp <= 0 {
+				return fmt.Errorf("the asking price must be above the leading bid of %v", session.Price)
+			}
+		}
+		session.Ask = call.Price
+		session.Phase = phaseBidding
+	case callBid:
+		if session.BidTxID != "" {
+			cmp, err := session.Ask.Cmp(session.Price)
+			if err != nil {
+				return err
+			}
+			if cmp <= 0 {
+				return fmt.Errorf("the asking price of %v has already been bid, announce the next one", session.Ask)
+			}
+		}
+		if call.Price != session.Ask {
+			return fmt.Errorf("bids are at the asking price of %v", session.Ask)
+		}
+		session.Paddle = call.Paddle
+		session.Price = call.Price
+		session.BidTxID = call.TxID
+		session.Phase = phaseBidding
+	case callGoingOnce, callGoingTwice, callSold:
+		previous := map[string]string{callGoingOnce: phaseBidding, callGoingTwice: phaseGoingOnce, callSold: phaseGoingTwice}[call.Type]
+		if session.Phase != previous {
+			return fmt.Errorf("%v cannot follow %v", call.Type, session.Phase)
+		}
+		if silence < timeout {
+			return fmt.Errorf("%v can only be called %v after the last call", call.Type, timeout)
+		}
+		session.Phase = call.Type
+	default:
+		return fmt.Errorf("unknown session call %v", call.Type)
+	}
+	session.Calls++
+	session.LastCall = call.Timestamp
+	return nil
+}
+
+// getPaddle returns the registration of a paddle, nil when nobody holds it
+func getPaddle(ctx contractapi.TransactionContextInterface, auctionID string, paddle string) (*Paddle, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(paddleKeyType, []string{auctionID, paddle})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create paddle key: %v", err)
+	}
+	paddleJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get paddle %v: %v", paddle, err)
+	}
+	if paddleJSON == nil {
+		return nil, nil
+	}
+	var registered Paddle
+	err = json.Unmarshal(paddleJSON, &registered)
+	if err != nil {
+		return nil, err
+	}
+	return &registered, nil
+}
@@ -0,0 +1,165 @@
+package auction_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sessionCall makes a call as auctioneer1 at the given oracle time
+func sessionCall(ctx *MockContext, txID string, at time.Time, call func() error) error {
+	actAs(ctx, "auctioneer1")
+	ctx.Stub.TxID = txID
+	ctx.Stub.OracleTime = formatOracleTime(at)
+	return call()
+}
+
+// liveSession opens a session on auction1 at an ask of 100 with a phase
+// timeout of 10 seconds, with bidder1 holding paddle 101 and bidder2 paddle 102
+func liveSession(t *testing.T, contract *auction.SmartContract, ctx *MockContext, now time.Time) {
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	actAs(ctx, "bidder1")
+	assert.NoError(t, contract.RegisterPaddle(ctx, "auction1", "101"))
+	actAs(ctx, "bidder2")
+	assert.Error(t, contract.RegisterPaddle(ctx, "auction1", "101"))
+	assert.NoError(t, contract.RegisterPaddle(ctx, "auction1", "102"))
+
+	err := sessionCall(ctx, "open", now, func() error { return contract.OpenSession(ctx, "auction1", "100", 10) })
+	assert.NoError(t, err)
+}
+
+func TestLiveSessionSold(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	liveSession(t, contract, ctx, now)
+
+	actAs(ctx, "bidder1")
+	_, err := contract.PlaceBid(ctx, "auction1", "500")
+	assert.Error(t, err)
+
+	bid := func(paddle string, channel string) func() error {
+		return func() error {
+			_, err := contract.RecordSessionBid(ctx, "auction1", paddle, channel)
+			return err
+		}
+	}
+	going := func() error { return contract.CallGoing(ctx, "auction1") }
+	assert.NoError(t, sessionCall(ctx, "tx1", now.Add(1*time.Second), bid("101", "floor")))
+	assert.Error(t, sessionCall(ctx, "tx2", now.Add(2*time.Second), bid("102", "floor")))
+	assert.Error(t, sessionCall(ctx, "tx2", now.Add(2*time.Second), bid("103", "floor")))
+	// the phase timeout has not passed since the bid
+	assert.Error(t, sessionCall(ctx, "tx3", now.Add(5*time.Second), going))
+	assert.NoError(t, sessionCall(ctx, "tx3", now.Add(6*time.Second), func() error { return contract.AnnounceAsk(ctx, "auction1", "120") }))
+	assert.NoError(t, sessionCall(ctx, "tx4", now.Add(7*time.Second), bid("102", "phone")))
+
+	assert.NoError(t, sessionCall(ctx, "tx5", now.Add(18*time.Second), going))
+	assert.NoError(t, sessionCall(ctx, "tx6", now.Add(29*time.Second), going))
+	actAs(ctx, "user1")
+	assert.Error(t, contract.EndAuction(ctx, "auction1"))
+	actAs(ctx, "auctioneer2")
+	ctx.Stub.OracleTime = formatOracleTime(now.Add(40 * time.Second))
+	assert.Error(t, contract.CallGoing(ctx, "auction1"))
+	assert.NoError(t, sessionCall(ctx, "tx7", now.Add(40*time.Second), going))
+	assert.Equal(t, "AuctionEnded", ctx.Stub.EventName)
+
+	a := getAuction(ctx)
+	assert.Equal(t, "ended", a.Status)
+	assert.Equal(t, "bidder2", a.Winner)
+	assert.Equal(t, usd(120), a.Price)
+	assert.NotNil(t, a.Settlement)
+	assert.True(t, a.Timelimit.Equal(now.Add(40*time.Second).UTC()))
+
+	bids, err := contract.QueryBids(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Len(t, bids, 2)
+
+	calls, err := contract.QuerySessionCalls(ctx, "auction1")
+	assert.NoError(t, err)
+	types := []string{}
+	for _, call := range calls {
+		types = append(types, call.Type)
+	}
+	assert.Equal(t, []string{"open", "bid", "ask", "bid", "going-once", "going-twice", "sold"}, types)
+	session, err := contract.ReplaySession(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "sold", session.Phase)
+	assert.Equal(t, "102", session.Paddle)
+	assert.Equal(t, 7, session.Calls)
+}
+
+func TestLiveSessionBidResetsGoing(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	liveSession(t, contract, ctx, now)
+
+	bid := func(paddle string) func() error {
+		return func() error {
+			_, err := contract.RecordSessionBid(ctx, "auction1", paddle, "floor")
+			return err
+		}
+	}
+	going := func() error { return contract.CallGoing(ctx, "auction1") }
+	assert.NoError(t, sessionCall(ctx, "tx1", now.Add(1*time.Second), bid("101")))
+	assert.NoError(t, sessionCall(ctx, "tx2", now.Add(12*time.Second), going))
+	assert.NoError(t, sessionCall(ctx, "tx3", now.Add(13*time.Second), func() error { return contract.AnnounceAsk(ctx, "auction1", "110") }))
+	assert.NoError(t, sessionCall(ctx, "tx4", now.Add(14*time.Second), bid("102")))
+	assert.Equal(t, "bidding", getAuction(ctx).Session.Phase)
+	assert.Error(t, sessionCall(ctx, "tx5", now.Add(15*time.Second), func() error { return contract.AnnounceAsk(ctx, "auction1", "110") }))
+}
+
+func TestSessionBidChecksPaddleHolder(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	actAs(ctx, "bidder1")
+	assert.NoError(t, contract.RegisterPaddle(ctx, "auction1", "101"))
+	ctx.Identity.Attrs["bitauction.verified"] = "true"
+	actAs(ctx, "bidder2")
+	assert.NoError(t, contract.RegisterPaddle(ctx, "auction1", "102"))
+	delete(ctx.Identity.Attrs, "bitauction.verified")
+
+	// the seller only accepts verified bidders after bidder1 registered
+	actAs(ctx, "user1")
+	assert.NoError(t, contract.SetBidderRequirements(ctx, "auction1", true, nil))
+	err := sessionCall(ctx, "open", now, func() error { return contract.OpenSession(ctx, "auction1", "100", 10) })
+	assert.NoError(t, err)
+
+	bid := func(paddle string) func() error {
+		return func() error {
+			_, err := contract.RecordSessionBid(ctx, "auction1", paddle, "floor")
+			return err
+		}
+	}
+	err = sessionCall(ctx, "tx1", now.Add(1*time.Second), bid("101"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "verified")
+	assert.NoError(t, sessionCall(ctx, "tx1", now.Add(1*time.Second), bid("102")))
+}
+
+func TestReplaySessionDetectsTampering(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	liveSession(t, contract, ctx, now)
+	err := sessionCall(ctx, "tx1", now.Add(1*time.Second), func() error {
+		_, err := contract.RecordSessionBid(ctx, "auction1", "101", "floor")
+		return err
+	})
+	assert.NoError(t, err)
+	_, err = contract.ReplaySession(ctx, "auction1")
+	assert.NoError(t, err)
+
+	calls, err := contract.QuerySessionCalls(ctx, "auction1")
+	assert.NoError(t, err)
+	calls[1].Paddle = "102"
+	callJSON, _ := json.Marshal(calls[1])
+	key, _ := ctx.Stub.CreateCompositeKey("sessioncall", []string{"auction1", "0000000001"})
+	ctx.Stub.State[key] = callJSON
+	_, err = contract.ReplaySession(ctx, "auction1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,469 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"fmt"
+	"time"
+
+	"bitAuction/events"
+	"bitAuction/money"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// default settlement periods of new auctions, in seconds
+const (
+	defaultPaymentPeriod  = 3 * 24 * 60 * 60
+	defaultDeliveryPeriod = 14 * 24 * 60 * 60
+)
+
+// states of the settlement of an ended auction
+const (
+	settlementAwaitingPayment = "awaiting-payment"
+	settlementPaid            = "paid"
+	settlementDelivered       = "delivered"
+	settlementCompleted       = "completed"
+	settlementDefaulted       = "defaulted"
+	settlementOffered         = "offered"
+	settlementRelisted        = "relisted"
+)
+
+// Settlement tracks the payment and delivery of an auction that ended with
+// a winner. Buyer and Price start out as the winner and the winning bid and
+// change when the item is offered to a runner-up.
+type Settlement struct {
+	State   string      `json:"state"`
+	Buyer   string      `json:"buyer"`
+	BidTxID string      `json:"bidTxID"`
+	Price   money.Money `json:"price"`
+	// Fees breaks Price down into what the buyer pays and every party receives
+	Fees *FeeBreakdown `json:"fees,omitempty"`
+	// Deadline is when the current step is due, zero when it has none
+	Deadline time.Time `json:"deadline"`
+	// DefaultedBy is "buyer" or "seller" once a party defaulted
+	DefaultedBy string `json:"defaultedBy,omitempty"`
+	// Defaulters lists the bidders that defaulted or let an offer lapse
+	Defaulters []string          `json:"defaulters"`
+	RelistedAs string            `json:"relistedAs,omitempty"`
+	History    []*SettlementStep `json:"history"`
+	// Pseudonym is the Idemix pseudonym an anonymous buyer bid with, once
+	// they revealed themselves as Buyer. LinkedTo is the identity they named
+	// until it confirms.
+	Pseudonym string `json:"pseudonym,omitempty"`
+	LinkedTo  string `json:"linkedTo,omitempty"`
+}
+
+// SettlementStep records who moved a settlement to a state and when
+type SettlementStep struct {
+	State string    `json:"state"`
+	By    string    `json:"by"`
+	At    time.Time `json:"at"`
+}
+
+// SetSettlementPeriods lets the seller change how many seconds the winner has
+// to pay after the time limit and the seller has to deliver after payment.
+// A period of 0 sets no deadline. The periods can only change before the first bid.
+func (s *SmartContract) SetSettlementPeriods(ctx contractapi.TransactionContextInterface, auctionID string, payment int, delivery int) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = s.checkSellerBeforeFirstBid(ctx, auction); err != nil {
+		return err
+	}
+	if payment < 0 || delivery < 0 {
+		return fmt.Errorf("settlement periods cannot be negative")
+	}
+
+	auction.PaymentPeriod = payment
+	auction.DeliveryPeriod = delivery
+	return putAuction(ctx, auction)
+}
+
+// newSettlement starts the settlement of an auction won by the winning bid.
+// Payment is due PaymentPeriod seconds after the time limit.
+func newSettlement(auction *Auction, winning *FullBid) *Settlement {
+	return &Settlement{
+		State:      settlementAwaitingPayment,
+		Buyer:      winning.Bidder,
+		BidTxID:    winning.TxID,
+		Price:      winning.Price,
+		Deadline:   deadlineAfter(auction.Timelimit, auction.PaymentPeriod),
+		Defaulters: []string{},
+		History:    []*SettlementStep{},
+	}
+}
+
+// ConfirmPayment is submitted by the seller once the buyer has paid
+func (s *SmartContract) ConfirmPayment(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, clientID, now, err := s.settlementStep(ctx, auctionID, settlementAwaitingPayment)
+	if err != nil {
+		return err
+	}
+	if clientID != auction.Seller {
+		return fmt.Errorf("payment can only be confirmed by the seller")
+	}
+	if isPseudonym(auction.Settlement.Buyer) {
+		return fmt.Errorf("the buyer has to reveal their identity with LinkWinner first")
+	}
+
+	if err = recordFeeRevenue(ctx, auction, now); err != nil {
+		return err
+	}
+	auction.Settlement.Deadline = deadlineAfter(now, auction.DeliveryPeriod)
+	return s.advanceSettlement(ctx, auction, settlementPaid, clientID, now)
+}
+
+// ConfirmDelivery is submitted by the seller once the item was delivered
+func (s *SmartContract) ConfirmDelivery(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, clientID, now, err := s.settlementStep(ctx, auctionID, settlementPaid)
+	if err != nil {
+		return err
+	}
+	if clientID != auction.Seller {
+		return fmt.Errorf("delivery can only be confirmed by the seller")
+	}
+
+	auction.Settlement.Deadline = time.Time{}
+	return s.advanceSettlement(ctx, auction, settlementDelivered, clientID, now)
+}
+
+// ConfirmReceipt is submitted by the buyer once they received the item and
+// completes the settlement
+func (s *SmartContract) ConfirmReceipt(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, clientID, now, err := s.settlementStep(ctx, auctionID, settlementDelivered)
+	if err != nil {
+		return err
+	}
+	if clientID != auction.Settlement.Buyer {
+		return fmt.Errorf("receipt can only be confirmed by the buyer")
+	}
+	if err = moveItem(ctx, auction, clientID, false); err != nil {
+		return err
+	}
+
+	return s.advanceSettlement(ctx, auction, settlementCompleted, clientID, now)
+}
+
+// DeclareDefault records that the other party missed its deadline. The seller
+// can declare a default when the buyer did not pay or a runner-up did not
+// accept an offer in time, the buyer when a paid item was not delivered in
+// time. Steps confirmed late are accepted as long as no default was declared,
+// and a step without a deadline can never be declared in default.
+func (s *SmartContract) DeclareDefault(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, clientID, now, err := s.settlementStep(ctx, auctionID, "")
+	if err != nil {
+		return err
+	}
+	settlement := auction.Settlement
+
+	switch settlement.State {
+	case settlementAwaitingPayment, settlementOffered:
+		if clientID != auction.Seller {
+			return fmt.Errorf("only the seller can declare that the buyer defaulted")
+		}
+		settlement.DefaultedBy = "buyer"
+		settlement.Defaulters = append(settlement.Defaulters, settlement.Buyer)
+	case settlementPaid:
+		if clientID != settlement.Buyer {
+			return fmt.Errorf("only the buyer can declare that the seller defaulted")
+		}
+		settlement.DefaultedBy = "seller"
+	default:
+		return fmt.Errorf("no default can be declared, settlement is %v", settlement.State)
+	}
+	if !deadlinePassed(settlement.Deadline, now) {
+		return fmt.Errorf("the deadline %v has not passed yet", settlement.Deadline)
+	}
+	// a buyer who walks away loses the funds they locked and the item goes
+	// back to the seller
+	if settlement.DefaultedBy == "buyer" {
+		if err = forfeitEscrowLock(ctx, auction, settlement.Buyer); err != nil {
+			return err
+		}
+		err = moveItem(ctx, auction, auction.Seller, true)
+	} else {
+		err = moveItem(ctx, auction, settlement.Buyer, false)
+	}
+	if err != nil {
+		return err
+	}
+
+	settlement.Deadline = time.Time{}
+	return s.advanceSettlement(ctx, auction, settlementDefaulted, clientID, now)
+}
+
+// OfferToRunnerUp lets the seller offer the item of an auction whose buyer
+// defaulted to the highest remaining bidder at the price of their bid. The
+// runner-up has PaymentPeriod seconds to accept with AcceptOffer.
+func (s *SmartContract) OfferToRunnerUp(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, clientID, now, err := s.settlementStep(ctx, auctionID, settlementDefaulted)
+	if err != nil {
+		return err
+	}
+	if clientID != auction.Seller {
+		return fmt.Errorf("only the seller can make an offer")
+	}
+	settlement := auction.Settlement
+	if settlement.DefaultedBy != "buyer" {
+		return fmt.Errorf("an offer can only be made when the buyer defaulted")
+	}
+
+	bids, err := s.QueryBids(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get bids: %v", err)
+	}
+	var runnerUp *FullBid
+	for _, bid := range bids {
+		if !bid.Valid || contains(settlement.Defaulters, bid.Bidder) {
+			continue
+		}
+		better, err := s.isBetterBid(auction.Direction, bid, runnerUp)
+		if err != nil {
+			return err
+		}
+		if better {
+			runnerUp = bid
+		}
+	}
+	if runnerUp == nil {
+		return fmt.Errorf("auction has no runner-up bid, the item can only be relisted")
+	}
+
+	settlement.Buyer = runnerUp.Bidder
+	settlement.BidTxID = runnerUp.TxID
+	settlement.Price = runnerUp.Price
+	settlement.Fees, err = feeBreakdown(ctx, auction, runnerUp.Price)
+	if err != nil {
+		return fmt.Errorf("failed to compute fees: %v", err)
+	}
+	settlement.DefaultedBy = ""
+	settlement.Deadline = deadlineAfter(now, auction.PaymentPeriod)
+	return s.advanceSettlement(ctx, auction, settlementOffered, clientID, now)
+}
+
+// AcceptOffer is submitted by the runner-up to buy the item at the offered
+// price. Payment is then due PaymentPeriod seconds later.
+func (s *SmartContract) AcceptOffer(ctx contractapi.TransactionContextInterface, auctionID string) error {
+	auction, clientID, now, err := s.settlementStep(ctx, auctionID, settlementOffered)
+	if err != nil {
+		return err
+	}
+	settlement := auction.Settlement
+	if clientID != settlement.Buyer {
+		return fmt.Errorf("the offer was not made to the client")
+	}
+	if !settlement.Deadline.IsZero() && now.After(settlement.Deadline) {
+		return fmt.Errorf("the offer expired at %v", settlement.Deadline)
+	}
+	if err = moveItem(ctx, auction, clientID, true); err != nil {
+		return err
+	}
+
+	settlement.Deadline = deadlineAfter(now, auction.PaymentPeriod)
+	return s.advanceSettlement(ctx, auction, settlementAwaitingPayment, clientID, now)
+}
+
+// Relist lets the seller put the item of an auction whose buyer defaulted up
+// for sale again as the new auction newAuctionID
+func (s *SmartContract) Relist(ctx contractapi.TransactionContextInterface, auctionID string, newAuctionID string, timelimit string) error {
+	auction, clientID, now, err := s.settlementStep(ctx, auctionID, settlementDefaulted)
+	if err != nil {
+		return err
+	}
+	if clientID != auction.Seller {
+		return fmt.Errorf("only the seller can relist the item")
+	}
+	if auction.Settlement.DefaultedBy != "buyer" {
+		return fmt.Errorf("the item can only be relisted when the buyer defaulted")
+	}
+	t, err := time.Parse(time.RFC3339Nano, timelimit)
+	if err != nil {
+		return fmt.Errorf("invalid datetime format: %v", err)
+	}
+	existing, err := ctx.GetStub().GetState(newAuctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction %v: %v", newAuctionID, err)
+	}
+	if existing != nil {
+		return fmt.Errorf("auction %v already exists", newAuctionID)
+	}
+
+	relisted, err := s.createAuction(ctx, newAuctionID, auction.ItemSold, t, auction.Description, auction.PictureURL, directionForward)
+	if err != nil {
+		return err
+	}
+	// the item moves to the new auction in one write, as the transaction
+	// would not read back its release from the old one
+	if auction.ItemID != "" {
+		item, err := lockedItem(ctx, auction)
+		if err != nil {
+			return err
+		}
+		item.Owner = auction.Seller
+		item.LockedBy = ""
+		if err = listItem(ctx, relisted, item); err != nil {
+			return err
+		}
+	}
+
+	auction.Settlement.RelistedAs = newAuctionID
+	auction.Settlement.Deadline = time.Time{}
+	recordSettlementStep(auction, settlementRelisted, clientID, now)
+	err = putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+	return emitAuctionEvents(ctx, auction, settlementEvent(auction, clientID), auctionCreatedEvent(relisted))
+}
+
+// awaitingBuyer reports whether an ended auction still waits for its buyer to
+// accept or pay, so that the buyer can still be replaced
+func awaitingBuyer(auction *Auction) bool {
+	return auction.Status == "ended" && auction.Settlement != nil &&
+		(auction.Settlement.State == settlementAwaitingPayment || auction.Settlement.State == settlementOffered)
+}
+
+// reselectBuyer hands the item of an ended auction whose buyer's bid was
+// invalidated to the best remaining bid that did not default. excluded is the
+// invalidated bid, which the transaction still reads as valid. A winner has to
+// pay PaymentPeriod seconds later, while an offer to a runner-up goes to the
+// next bidder who has as long to accept it. Without a remaining bid the item
+// goes back to the seller and the auction ends without a sale.
+func (s *SmartContract) reselectBuyer(ctx contractapi.TransactionContextInterface, auction *Auction, excluded string, by string, now time.Time) (events.Event, error) {
+	settlement := auction.Settlement
+	// after the close only the funds of the buyer can still be locked
+	err := releaseEscrowLocks(ctx, auction, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	bids, err := s.QueryBids(ctx, auction.AuctionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bids: %v", err)
+	}
+	var next *FullBid
+	for _, bid := range bids {
+		if !bid.Valid || bid.TxID == excluded || contains(settlement.Defaulters, bid.Bidder) {
+			continue
+		}
+		better, err := s.isBetterBid(auction.Direction, bid, next)
+		if err != nil {
+			return nil, err
+		}
+		if better {
+			next = bid
+		}
+	}
+
+	if next == nil {
+		if err = moveItem(ctx, auction, auction.Seller, false); err != nil {
+			return nil, err
+		}
+		auction.Winner = ""
+		auction.Price = money.Zero(auction.Currency)
+		auction.Outcome = outcomeNoSale
+		auction.Settlement = nil
+		if err = putAuction(ctx, auction); err != nil {
+			return nil, err
+		}
+		return events.AuctionEnded{AuctionID: auction.AuctionID, Winner: auction.Winner, Price: auction.Price, Outcome: auction.Outcome}, nil
+	}
+
+	if settlement.State == settlementAwaitingPayment {
+		if err = moveItem(ctx, auction, next.Bidder, true); err != nil {
+			return nil, err
+		}
+	}
+	if auction.Winner == settlement.Buyer {
+		auction.Winner = next.Bidder
+		auction.Price = next.Price
+	}
+	settlement.Buyer = next.Bidder
+	settlement.BidTxID = next.TxID
+	settlement.Price = next.Price
+	settlement.Fees, err = feeBreakdown(ctx, auction, next.Price)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute fees: %v", err)
+	}
+	settlement.Deadline = deadlineAfter(now, auction.PaymentPeriod)
+	settlement.Pseudonym = ""
+	settlement.LinkedTo = ""
+	recordSettlementStep(auction, settlement.State, by, now)
+	if err = putAuction(ctx, auction); err != nil {
+		return nil, err
+	}
+	return settlementEvent(auction, by), nil
+}
+
+// settlementStep reads an auction whose settlement is in the given state, any
+// state when state is empty, and returns it with the submitting client and
+// the oracle time of the transaction
+func (s *SmartContract) settlementStep(ctx contractapi.TransactionContextInterface, auctionID string, state string) (*Auction, string, time.Time, error) {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return nil, "", time.Time{}, fmt.Errorf("failed to get auction: %v", err)
+	}
+	if auction.Settlement == nil {
+		return nil, "", time.Time{}, fmt.Errorf("auction %v has no settlement", auctionID)
+	}
+	if state != "" && auction.Settlement.State != state {
+		return nil, "", time.Time{}, fmt.Errorf("settlement is %v, expected %v", auction.Settlement.State, state)
+	}
+
+	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return nil, "", time.Time{}, fmt.Errorf("failed to get client identity %v", err)
+	}
+	now, err := s.oracleTimestamp(ctx, ctx.GetStub().GetTxID())
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	return auction, clientID, now, nil
+}
+
+// advanceSettlement moves the settlement to state, stores the auction and
+// emits the change
+func (s *SmartContract) advanceSettlement(ctx contractapi.TransactionContextInterface, auction *Auction, state string, by string, now time.Time) error {
+	recordSettlementStep(auction, state, by, now)
+	err := putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+	return emitAuctionEvents(ctx, auction, settlementEvent(auction, by))
+}
+
+func recordSettlementStep(auction *Auction, state string, by string, now time.Time) {
+	auction.Settlement.State = state
+	auction.Settlement.History = append(auction.Settlement.History, &SettlementStep{State: state, By: by, At: now})
+}
+
+// settlementEvent describes the current state of the settlement of an auction
+func settlementEvent(auction *Auction, by string) events.SettlementChanged {
+	return events.SettlementChanged{
+		AuctionID: auction.AuctionID,
+		State:     auction.Settlement.State,
+		Buyer:     auction.Settlement.Buyer,
+		Price:     auction.Settlement.Price,
+		Deadline:  auction.Settlement.Deadline,
+		By:        by,
+	}
+}
+
+// deadlineAfter returns the time period seconds after start, no deadline when
+// period is 0
+func deadlineAfter(start time.Time, period int) time.Time {
+	if period == 0 {
+		return time.Time{}
+	}
+	return start.Add(time.Duration(period) * time.Second)
+}
+
+// deadlinePassed reports whether a deadline has passed at now. A step without
+// a deadline is never overdue, so it cannot be declared in default.
+func deadlinePassed(deadline time.Time, now time.Time) bool {
+	return !deadline.IsZero() && now.After(deadline)
+}
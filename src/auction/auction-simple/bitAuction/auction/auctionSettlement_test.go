@@ -0,0 +1,146 @@
+package auction_test
+
+import (
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// endedAuction creates an auction with a bid of 100 by bidder1 and 300 by
+// bidder2 and ends it an hour before now
+func endedAuction(t *testing.T, contract *auction.SmartContract, ctx *MockContext, now time.Time) {
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 100)
+	actAs(ctx, "bidder2")
+	submitBid(t, contract, ctx, "tx2", 300)
+
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-1 * time.Hour)
+	putAuctionState(ctx, a)
+	actAs(ctx, "user1")
+	err := contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+}
+
+func TestSettlement(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+	endedAuction(t, contract, ctx, now)
+
+	settlement := getAuction(ctx).Settlement
+	assert.Equal(t, "awaiting-payment", settlement.State)
+	assert.Equal(t, "bidder2", settlement.Buyer)
+	assert.Equal(t, usd(300), settlement.Price)
+	// payment is due three days after the time limit
+	assert.WithinDuration(t, now.Add(71*time.Hour), settlement.Deadline, time.Second)
+
+	// only the seller confirms payment, and only the buyer receipt
+	actAs(ctx, "bidder2")
+	err := contract.ConfirmPayment(ctx, "auction1")
+	assert.Error(t, err)
+	actAs(ctx, "user1")
+	err = contract.ConfirmPayment(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "SettlementChanged", ctx.Stub.EventName)
+	err = contract.ConfirmDelivery(ctx, "auction1")
+	assert.NoError(t, err)
+	err = contract.ConfirmReceipt(ctx, "auction1")
+	assert.Error(t, err)
+	actAs(ctx, "bidder2")
+	err = contract.ConfirmReceipt(ctx, "auction1")
+	assert.NoError(t, err)
+
+	settlement = getAuction(ctx).Settlement
+	assert.Equal(t, "completed", settlement.State)
+	assert.Len(t, settlement.History, 3)
+	assert.Equal(t, "user1", settlement.History[0].By)
+}
+
+func TestSettlementDefaultOfferToRunnerUp(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+	endedAuction(t, contract, ctx, now)
+
+	// the winner still has time to pay
+	err := contract.DeclareDefault(ctx, "auction1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "has not passed")
+
+	ctx.Stub.OracleTime = formatOracleTime(now.Add(73 * time.Hour))
+	err = contract.DeclareDefault(ctx, "auction1")
+	assert.NoError(t, err)
+	err = contract.OfferToRunnerUp(ctx, "auction1")
+	assert.NoError(t, err)
+
+	settlement := getAuction(ctx).Settlement
+	assert.Equal(t, "offered", settlement.State)
+	assert.Equal(t, "bidder1", settlement.Buyer)
+	assert.Equal(t, usd(100), settlement.Price)
+	assert.Equal(t, []string{"bidder2"}, settlement.Defaulters)
+
+	actAs(ctx, "bidder2")
+	err = contract.AcceptOffer(ctx, "auction1")
+	assert.Error(t, err)
+	actAs(ctx, "bidder1")
+	err = contract.AcceptOffer(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "awaiting-payment", getAuction(ctx).Settlement.State)
+}
+
+func TestSettlementWithoutDeadline(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	err := contract.SetSettlementPeriods(ctx, "auction1", 0, 0)
+	assert.NoError(t, err)
+	actAs(ctx, "bidder1")
+	submitBid(t, contract, ctx, "tx1", 100)
+	a := getAuction(ctx)
+	a.Timelimit = now.Add(-1 * time.Hour)
+	putAuctionState(ctx, a)
+	actAs(ctx, "user1")
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.True(t, getAuction(ctx).Settlement.Deadline.IsZero())
+
+	// a period of 0 sets no deadline, so the buyer is never in default
+	ctx.Stub.OracleTime = formatOracleTime(now.Add(365 * 24 * time.Hour))
+	err = contract.DeclareDefault(ctx, "auction1")
+	assert.Error(t, err)
+	assert.Equal(t, "awaiting-payment", getAuction(ctx).Settlement.State)
+}
+
+func TestSettlementRelist(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now().UTC()
+	endedAuction(t, contract, ctx, now)
+
+	ctx.Stub.OracleTime = formatOracleTime(now.Add(73 * time.Hour))
+	err := contract.DeclareDefault(ctx, "auction1")
+	assert.NoError(t, err)
+
+	timelimit := now.Add(100 * time.Hour).Format(time.RFC3339Nano)
+	err = contract.Relist(ctx, "auction1", "auction1", timelimit)
+	assert.Error(t, err)
+	err = contract.Relist(ctx, "auction1", "relist1", timelimit)
+	assert.NoError(t, err)
+
+	settlement := getAuction(ctx).Settlement
+	assert.Equal(t, "relisted", settlement.State)
+	assert.Equal(t, "relist1", settlement.RelistedAs)
+
+	relisted, err := contract.QueryAuction(ctx, "relist1")
+	assert.NoError(t, err)
+	assert.Equal(t, "open", relisted.Status)
+	assert.Equal(t, "Laptop", relisted.ItemSold)
+
+	events := decodeEvents(t, ctx)
+	assert.Equal(t, "SettlementChanged", events[0].EventType())
+	assert.Equal(t, "AuctionCreated", events[1].EventType())
+}
@@ -0,0 +1,246 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// shill rules of an auction. Auctions created before the rule existed have
+// an empty rule and accept every bidder.
+const (
+	shillRuleNone      = "none"
+	shillRuleSeller    = "seller"
+	shillRuleSellerOrg = "seller-org"
+)
+
+// thresholds of the shill analysis
+const (
+	repeatLoserAuctions = 3
+	justBelowPercent    = 5
+	justBelowAuctions   = 2
+)
+
+// ShillSuspect is a bidder whose bidding on a seller's auctions looks suspicious
+type ShillSuspect struct {
+	Bidder   string   `json:"bidder"`
+	Org      string   `json:"org"`
+	Auctions int      `json:"auctions"`
+	Bids     int      `json:"bids"`
+	Wins     int      `json:"wins"`
+	Flags    []string `json:"flags"`
+}
+
+// ShillReport lists the suspicious bidders on the auctions of a seller
+type ShillReport struct {
+	Seller   string          `json:"seller"`
+	Auctions int             `json:"auctions"`
+	Suspects []*ShillSuspect `json:"suspects"`
+}
+
+// SetShillRule lets an auctioneer of a participating organization choose which
+// bidders are refused as too close to the seller: "seller" refuses the seller
+// identity, "seller-org" every identity of the seller's organization and
+// "none" nobody. The rule can only change before the first bid.
+func (s *SmartContract) SetShillRule(ctx contractapi.TransactionContextInterface, auctionID string, rule string) error {
+	auction, err := s.QueryAuction(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get auction: %v", err)
+	}
+	if err = requireRole(ctx, roleAuctioneer); err != nil {
+		return err
+	}
+	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity %v", err)
+	}
+	if !contains(auction.Orgs, clientOrgID) {
+		return fmt.Errorf("organization %v does not participate in the auction", clientOrgID)
+	}
+	if rule != shillRuleNone && rule != shillRuleSeller && rule != shillRuleSellerOrg {
+		return fmt.Errorf("unknown shill rule %v", rule)
+	}
+	if auction.Status != "open" {
+		return fmt.Errorf("auction is not open, status is %v", auction.Status)
+	}
+	bids, err := s.QueryBids(ctx, auctionID)
+	if err != nil {
+		return fmt.Errorf("failed to get bids: %v", err)
+	}
+	if len(bids) > 0 {
+		return fmt.Errorf("auction settings cannot change after the first bid")
+	}
+
+	auction.ShillRule = rule
+	return putAuction(ctx, auction)
+}
+
+// checkShillRule refuses a bid from a bidder the shill rule of the auction excludes
+func checkShillRule(auction *Auction, bidder string, org string) error {
+	switch auction.ShillRule {
+	case shillRuleSellerOrg:
+		if org == sellerOrg(auction) {
+			return fmt.Errorf("bidders from the seller's organization cannot bid on this auction")
+		}
+		fallthrough
+	case shillRuleSeller:
+		if bidder == auction.Seller {
+			return fmt.Errorf("the seller cannot bid on their own auction")
+		}
+	}
+	return nil
+}
+
+// sellerOrg returns the organization of the seller, which creates the auction
+// as its first organization
+func sellerOrg(auction *Auction) string {
+	if len(auction.Orgs) == 0 {
+		return ""
+	}
+	return auction.Orgs[0]
+}
+
+// GetShillReport analyses the bids on all auctions of a seller and flags
+// bidders that repeatedly bid without ever winning ("repeat-loser"), whose
+// best bid always ends just below the winning price ("just-below-leader"), or
+// who are linked to the seller by organization ("seller-org") or certificate
+// issuer ("seller-issuer"). Retracted and disqualified bids are left out.
+func (s *SmartContract) GetShillReport(ctx contractapi.TransactionContextInterface, sellerID string) (*ShillReport, error) {
+	iterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state by range: %v", err)
+	}
+	defer iterator.Close()
+
+	report := &ShillReport{Seller: sellerID, Suspects: []*ShillSuspect{}}
+	type bidderStats struct {
+		suspect   *ShillSuspect
+		lost      int
+		justBelow int
+		linked    []string
+	}
+	stats := map[string]*bidderStats{}
+
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate: %v", err)
+		}
+
+		var auction Auction
+		err = json.Unmarshal(kv.Value, &auction)
+		if err != nil || auction.Seller == "" {
+			continue
+		}
+		auctionSeller, err := s.ParseClientID(auction.Seller)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse auction seller: %v", err)
+		}
+		if auctionSeller != sellerID {
+			continue
+		}
+		report.Auctions++
+
+		bids, err := s.QueryBids(ctx, auction.AuctionID)
+		if err != nil {
+			return nil, err
+		}
+
+		// the best bid of every bidder in this auction
+		best := map[string]*FullBid{}
+		for _, bid := range bids {
+			if !bid.Valid {
+				continue
+			}
+			st, ok := stats[bid.Bidder]
+			if !ok {
+				st = &bidderStats{suspect: &ShillSuspect{Bidder: bid.Bidder, Org: bid.Org, Flags: []string{}}}
+				stats[bid.Bidder] = st
+			}
+			st.suspect.Bids++
+			if bid.Org == sellerOrg(&auction) && !contains(st.linked, "seller-org") {
+				st.linked = append(st.linked, "seller-org")
+			}
+			issuer := parseClientIssuer(bid.Bidder)
+			if issuer != "" && issuer == parseClientIssuer(auction.Seller) && !contains(st.linked, "seller-issuer") {
+				st.linked = append(st.linked, "seller-issuer")
+			}
+			better, err := s.isBetterBid(auction.Direction, bid, best[bid.Bidder])
+			if err != nil {
+				return nil, err
+			}
+			if better {
+				best[bid.Bidder] = bid
+			}
+		}
+
+		for bidder, bid := range best {
+			st := stats[bidder]
+			st.suspect.Auctions++
+			if auction.Status != "ended" {
+				continue
+			}
+			if auction.Winner == bidder {
+				st.suspect.Wins++
+				continue
+			}
+			st.lost++
+			if auction.Winner == "" {
+				continue
+			}
+			// in a reverse auction the bid that loses narrowly is just above the winner
+			if auction.Direction == directionReverse {
+				threshold, err := auction.Price.Percent(100 + justBelowPercent)
+				if err != nil {
+					return nil, err
+				}
+				cmp, err := bid.Price.Cmp(threshold)
+				if err != nil {
+					return nil, err
+				}
+				if cmp <= 0 {
+					st.justBelow++
+				}
+				continue
+			}
+			threshold, err := auction.Price.Percent(100 - justBelowPercent)
+			if err != nil {
+				return nil, err
+			}
+			cmp, err := bid.Price.Cmp(threshold)
+			if err != nil {
+				return nil, err
+			}
+			if cmp >= 0 {
+				st.justBelow++
+			}
+		}
+	}
+
+	for _, st := range stats {
+		flags := []string{}
+		if st.lost >= repeatLoserAuctions && st.suspect.Wins == 0 {
+			flags = append(flags, "repeat-loser")
+		}
+		if st.lost >= justBelowAuctions && st.justBelow == st.lost {
+			flags = append(flags, "just-below-leader")
+		}
+		flags = append(flags, st.linked...)
+		if len(flags) == 0 {
+			continue
+		}
+		st.suspect.Flags = flags
+		report.Suspects = append(report.Suspects, st.suspect)
+	}
+	sort.Slice(report.Suspects, func(i, j int) bool {
+		return report.Suspects[i].Bidder < report.Suspects[j].Bidder
+	})
+
+	return report, nil
+}
@@ -0,0 +1,84 @@
+package auction_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSellerCannotBidOnOwnAuction(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+
+	_, err := contract.PlaceBid(ctx, "auction1", "100")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "seller cannot bid")
+
+	// other identities of the seller's organization may bid by default
+	actAs(ctx, "user2")
+	_, err = contract.PlaceBid(ctx, "auction1", "100")
+	assert.NoError(t, err)
+}
+
+func TestShillRuleSellerOrg(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+
+	err := contract.SetShillRule(ctx, "auction1", "everyone")
+	assert.Error(t, err)
+	err = contract.SetShillRule(ctx, "auction1", "seller-org")
+	assert.NoError(t, err)
+
+	actAs(ctx, "user2")
+	_, err = contract.PlaceBid(ctx, "auction1", "100")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "seller's organization")
+
+	ctx.Identity.MSPID = "Org2MSP"
+	_, err = contract.PlaceBid(ctx, "auction1", "100")
+	assert.NoError(t, err)
+}
+
+func TestGetShillReport(t *testing.T) {
+	contract, ctx := setup()
+	seller := "x509::CN=seller,OU=client::CN=ca.org1.example.com"
+	shill := "x509::CN=shill,OU=client::CN=ca.org1.example.com"
+	buyer := "x509::CN=buyer,OU=client::CN=ca.org2.example.com"
+
+	for i, id := range []string{"a1", "a2", "a3"} {
+		auctionJSON, _ := json.Marshal(auction.Auction{
+			AuctionID: id,
+			Seller:    seller,
+			Orgs:      []string{"Org1MSP"},
+			Status:    "ended",
+			Winner:    buyer,
+			Price:     usd(1000),
+		})
+		ctx.Stub.State[id] = auctionJSON
+
+		shillBid, _ := json.Marshal(auction.FullBid{Price: usd(int64(960 + i)), Org: "Org3MSP", Bidder: shill, Valid: true})
+		buyerBid, _ := json.Marshal(auction.FullBid{Price: usd(1000), Org: "Org2MSP", Bidder: buyer, Valid: true})
+		ctx.Stub.State["fullbid:"+id+":tx1"] = shillBid
+		ctx.Stub.State["fullbid:"+id+":tx2"] = buyerBid
+		// a retracted bid does not count, nor does it link its bidder to the seller
+		retracted, _ := json.Marshal(auction.FullBid{Price: usd(990), Org: "Org1MSP", Bidder: shill, Valid: false})
+		ctx.Stub.State["fullbid:"+id+":tx3"] = retracted
+	}
+
+	report, err := contract.GetShillReport(ctx, "seller")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Auctions)
+	assert.Len(t, report.Suspects, 1)
+	assert.Equal(t, shill, report.Suspects[0].Bidder)
+	assert.Equal(t, 0, report.Suspects[0].Wins)
+	assert.Equal(t, 3, report.Suspects[0].Bids)
+	assert.Equal(t, []string{"repeat-loser", "just-below-leader", "seller-issuer"}, report.Suspects[0].Flags)
+}
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"bitAuction/auction"
+	"bitAuction/events"
+	"bitAuction/money"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -14,7 +16,7 @@ func setup() (*auction.SmartContract, *MockContext) {
 	contract := new(auction.SmartContract)
 	stub := &MockStub{State: map[string][]byte{}, TxID: "tx1"}
 	// Use base64-encoded string for ID ("user1" -> "dXNlcjE=")
-	id := &MockClientIdentity{MSPID: "Org1MSP", ID: "dXNlcjE="}
+	id := &MockClientIdentity{MSPID: "Org1MSP", ID: "dXNlcjE=", Attrs: map[string]string{"bitauction.role": "seller,bidder,auctioneer"}}
 	ctx := &MockContext{Stub: stub, Identity: id}
 	return contract, ctx
 }
@@ -40,7 +42,7 @@ func TestBid(t *testing.T) {
 		Bids:      []auction.FullBid{},
 	})
 	ctx.Stub.State["auction1"] = auctionJSON
-	txID, err := contract.Bid(ctx, "auction1", 100)
+	txID, err := contract.Bid(ctx, "auction1", "100")
 	assert.NoError(t, err)
 	assert.Equal(t, "tx1", txID)
 }
@@ -63,7 +65,7 @@ func TestBidAfterAuctionTimelimit(t *testing.T) {
 	ctx.Stub.State["auction1"] = auctionJSON
 
 	// Attempt to submit a bid
-	_, err := contract.Bid(ctx, "auction1", 150)
+	_, err := contract.Bid(ctx, "auction1", "150")
 
 	// The bid should be rejected
 	assert.Error(t, err)
@@ -113,7 +115,7 @@ func TestEndAuction(t *testing.T) {
 	// Create and store full bids using composite keys
 	bid1 := auction.FullBid{
 		Type:      "bid",
-		Price:     100,
+		Price:     usd(100),
 		Org:       "Org1MSP",
 		Bidder:    "userA",
 		Valid:     true,
@@ -121,7 +123,7 @@ func TestEndAuction(t *testing.T) {
 	}
 	bid2 := auction.FullBid{
 		Type:      "bid",
-		Price:     300,
+		Price:     usd(300),
 		Org:       "Org1MSP",
 		Bidder:    "userB",
 		Valid:     true,
@@ -149,7 +151,8 @@ func TestEndAuction(t *testing.T) {
 
 	assert.Equal(t, "ended", endedAuction.Status)
 	assert.Equal(t, "userB", endedAuction.Winner)
-	assert.Equal(t, 300, endedAuction.Price)
+	assert.Equal(t, usd(300), endedAuction.Price)
+	assert.Equal(t, "bidding", endedAuction.Outcome)
 }
 
 func TestRecordTimeFromOracle(t *testing.T) {
@@ -202,10 +205,10 @@ func TestBidAfterAuctionHasEnded(t *testing.T) {
 		Orgs:      []string{"Org1MSP"},
 		Status:    "ended", // Auction has ended with winner
 		Winner:    "userB",
-		Price:     300,
+		Price:     usd(300),
 		Timelimit: time.Now().Add(1 * time.Hour),
 		Bids: []auction.FullBid{
-			{Price: 300, Bidder: "userB"},
+			{Price: usd(300), Bidder: "userB"},
 		},
 	})
 	ctx.Stub.State["auction1"] = auctionJSON
@@ -232,10 +235,10 @@ func TestEndAlreadyEndedAuction(t *testing.T) {
 		Seller:    "user1",
 		Status:    "ended", // Already ended
 		Winner:    "userB",
-		Price:     300,
+		Price:     usd(300),
 		Bids: []auction.FullBid{
-			{Price: 100, Bidder: "userA"},
-			{Price: 300, Bidder: "userB"},
+			{Price: usd(100), Bidder: "userA"},
+			{Price: usd(300), Bidder: "userB"},
 		},
 	}
 	auctionJSON, _ := json.Marshal(auctionObj)
@@ -295,7 +298,7 @@ func TestTimestampTieBreaking(t *testing.T) {
 	// Create bids with same price but different timestamps
 	bid1 := auction.FullBid{
 		Type:      "bid",
-		Price:     300,
+		Price:     usd(300),
 		Org:       "Org1MSP",
 		Bidder:    "userA",
 		Valid:     true,
@@ -303,7 +306,7 @@ func TestTimestampTieBreaking(t *testing.T) {
 	}
 	bid2 := auction.FullBid{
 		Type:      "bid",
-		Price:     300,
+		Price:     usd(300),
 		Org:       "Org1MSP",
 		Bidder:    "userB",
 		Valid:     true,
@@ -311,7 +314,7 @@ func TestTimestampTieBreaking(t *testing.T) {
 	}
 	bid3 := auction.FullBid{
 		Type:      "bid",
-		Price:     200,
+		Price:     usd(200),
 		Org:       "Org1MSP",
 		Bidder:    "userC",
 		Valid:     true,
@@ -343,7 +346,7 @@ func TestTimestampTieBreaking(t *testing.T) {
 	// The winner should be userB who bid first with the highest price
 	assert.Equal(t, "ended", endedAuction.Status)
 	assert.Equal(t, "userB", endedAuction.Winner)
-	assert.Equal(t, 300, endedAuction.Price)
+	assert.Equal(t, usd(300), endedAuction.Price)
 }
 
 // TestEndAuctionWithNoBids tests that an auction with no bids has no winner when ended
@@ -378,5 +381,176 @@ func TestEndAuctionWithNoBids(t *testing.T) {
 	// The auction should be ended with no winner and zero price
 	assert.Equal(t, "ended", endedAuction.Status)
 	assert.Equal(t, "", endedAuction.Winner)
-	assert.Equal(t, 0, endedAuction.Price)
+	assert.Equal(t, usd(0), endedAuction.Price)
+}
+
+// usd returns whole US dollars
+func usd(units int64) money.Money {
+	m, _ := money.FromUnits(units, "USD")
+	return m
+}
+
+func decodeEvents(t *testing.T, ctx *MockContext) []events.Event {
+	envelope, err := events.Decode(ctx.Stub.EventPayload)
+	assert.NoError(t, err)
+	var evts []events.Event
+	for _, record := range envelope.Events {
+		evt, err := record.Decode()
+		assert.NoError(t, err)
+		evts = append(evts, evt)
+	}
+	return evts
+}
+
+// TestSubmitBidEvents tests that a new highest bid emits BidSubmitted and NewLeader
+func TestSubmitBidEvents(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	putOpenAuction(ctx, now.Add(1*time.Hour))
+	putFullBid(ctx, "tx0", auction.FullBid{Type: "bid", Price: usd(50), Bidder: "userA", Valid: true, Timestamp: now.Add(-1 * time.Minute)})
+	priceJSON, _ := json.Marshal(100)
+	ctx.Stub.State["bid:auction1:tx1"] = priceJSON
+
+	err := contract.SubmitBid(ctx, "auction1", "tx1")
+	assert.NoError(t, err)
+	assert.Equal(t, "BidSubmitted", ctx.Stub.EventName)
+
+	evts := decodeEvents(t, ctx)
+	assert.Len(t, evts, 2)
+	assert.Equal(t, usd(100), evts[0].(*events.BidSubmitted).Price)
+	assert.Equal(t, &events.NewLeader{AuctionID: "auction1", TxID: "tx1", Bidder: "user1", Price: usd(100), PreviousPrice: usd(50)}, evts[1])
+}
+
+// TestSubmitBidSoftClose tests that a late bid extends the time limit when soft close is enabled
+func TestSubmitBidSoftClose(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	putOpenAuction(ctx, now.Add(30*time.Second))
+
+	err := contract.SetSoftClose(ctx, "auction1", 60, 120)
+	assert.NoError(t, err)
+
+	priceJSON, _ := json.Marshal(100)
+	ctx.Stub.State["bid:auction1:tx1"] = priceJSON
+	err = contract.SubmitBid(ctx, "auction1", "tx1")
+	assert.NoError(t, err)
+
+	assert.True(t, getAuction(ctx).Timelimit.Equal(now.Add(120*time.Second)))
+	evts := decodeEvents(t, ctx)
+	assert.Len(t, evts, 3)
+	assert.Equal(t, "soft-close", evts[2].(*events.DeadlineExtended).Reason)
+}
+
+// TestPlaceBid tests that a bid is recorded with a single transaction
+func TestPlaceBid(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	actAs(ctx, "bidder1")
+
+	ctx.Stub.TxID = "tx2"
+	txID, err := contract.PlaceBid(ctx, "auction1", "250")
+	assert.NoError(t, err)
+	assert.Equal(t, "tx2", txID)
+	assert.Nil(t, ctx.Stub.State["bid:auction1:tx2"])
+
+	var bid auction.FullBid
+	_ = json.Unmarshal(ctx.Stub.State["fullbid:auction1:tx2"], &bid)
+	assert.Equal(t, usd(250), bid.Price)
+	assert.True(t, bid.Valid)
+
+	leader, err := contract.GetCurrentLeader(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, "tx2", leader.TxID)
+
+	ctx.Stub.TxID = "tx3"
+	_, err = contract.PlaceBid(ctx, "auction1", "0")
+	assert.Error(t, err)
+}
+
+// TestSubmitBidTwice tests that the same bid cannot be submitted twice
+func TestSubmitBidTwice(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	putOpenAuction(ctx, now.Add(1*time.Hour))
+	priceJSON, _ := json.Marshal(100)
+	ctx.Stub.State["bid:auction1:tx1"] = priceJSON
+
+	err := contract.SubmitBid(ctx, "auction1", "tx1")
+	assert.NoError(t, err)
+	err = contract.SubmitBid(ctx, "auction1", "tx1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "already been submitted")
+}
+
+// TestOrphanedBids tests that bids never submitted can be found and deleted after the auction
+func TestOrphanedBids(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	putOpenAuction(ctx, now.Add(1*time.Hour))
+	priceJSON, _ := json.Marshal(100)
+	ctx.Stub.State["bid:auction1:tx1"] = priceJSON
+	orphanJSON, _ := json.Marshal(400)
+	ctx.Stub.State["bid:auction1:tx2"] = orphanJSON
+	err := contract.SubmitBid(ctx, "auction1", "tx1")
+	assert.NoError(t, err)
+
+	orphans, err := contract.GetOrphanedBids(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, []*auction.OrphanedBid{{TxID: "tx2", Price: usd(400)}}, orphans)
+
+	_, err = contract.DeleteOrphanedBids(ctx, "auction1")
+	assert.Error(t, err)
+
+	putOpenAuction(ctx, now.Add(-1*time.Hour))
+	err = contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	deleted, err := contract.DeleteOrphanedBids(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.Nil(t, ctx.Stub.State["bid:auction1:tx2"])
+	assert.NotNil(t, ctx.Stub.State["bid:auction1:tx1"])
+}
+
+// TestBidCurrency tests that bids are parsed strictly in the currency of the auction
+func TestBidCurrency(t *testing.T) {
+	contract, ctx := setup()
+	now := time.Now()
+	ctx.Stub.OracleTime = formatOracleTime(now)
+	createAuction(t, contract, ctx, now.Add(1*time.Hour))
+	err := contract.SetCurrency(ctx, "auction1", "XYZ")
+	assert.Error(t, err)
+	err = contract.SetCurrency(ctx, "auction1", "EUR")
+	assert.NoError(t, err)
+
+	actAs(ctx, "bidder1")
+	for _, price := range []string{"10 USD", "10.505", "-10", "0", "1e3"} {
+		_, err = contract.PlaceBid(ctx, "auction1", price)
+		assert.Error(t, err, price)
+	}
+	_, err = contract.PlaceBid(ctx, "auction1", "10.50")
+	assert.NoError(t, err)
+
+	bids, err := contract.QueryBids(ctx, "auction1")
+	assert.NoError(t, err)
+	assert.Equal(t, money.Money{Amount: 1050, Currency: "EUR"}, bids[0].Price)
+}
+
+// TestLegacyIntegerPrices tests that prices stored as plain integers end in the default currency
+func TestLegacyIntegerPrices(t *testing.T) {
+	contract, ctx := setup()
+	ctx.Stub.State["auction1"] = []byte(`{"auctionID":"auction1","objectType":"auction","seller":"user1",` +
+		`"organizations":["Org1MSP"],"price":0,"status":"open","timelimit":"2025-01-01T00:00:00Z"}`)
+	ctx.Stub.State["fullbid:auction1:tx1"] = []byte(`{"objectType":"bid","price":300,"bidder":"userB","valid":true}`)
+
+	err := contract.EndAuction(ctx, "auction1")
+	assert.NoError(t, err)
+	ended := getAuction(ctx)
+	assert.Equal(t, "USD", ended.Currency)
+	assert.Equal(t, money.Money{Amount: 30000, Currency: "USD"}, ended.Price)
 }
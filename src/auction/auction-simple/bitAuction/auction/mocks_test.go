@@ -1,8 +1,10 @@
 package auction_test
 
 import (
+	"crypto/sha256"
 	"crypto/x509"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
@@ -18,14 +20,44 @@ import (
 type MockStub struct {
 	mock.Mock
 	State map[string][]byte
-	TxID  string
+	// Private holds the private data of every collection
+	Private map[string]map[string][]byte
+	TxID    string
+	// OracleTime overrides the timestamp returned by the timeoracle chaincode
+	OracleTime   string
+	EventName    string
+	EventPayload []byte
+	// Creator overrides the serialized identity of the submitting client
+	Creator []byte
+	// Transient is the transient data passed with the transaction
+	Transient map[string][]byte
+	// Writes buffers the writes of a transaction when set, so that reads only
+	// see committed state like on a peer. Commit applies them to State.
+	Writes map[string][]byte
 }
 
 func (m *MockStub) PutState(key string, value []byte) error {
+	if m.Writes != nil {
+		m.Writes[key] = value
+		return nil
+	}
 	m.State[key] = value
 	return nil
 }
 
+// Commit applies the buffered writes of a transaction, a nil value deletes
+// the key
+func (m *MockStub) Commit() {
+	for key, value := range m.Writes {
+		if value == nil {
+			delete(m.State, key)
+		} else {
+			m.State[key] = value
+		}
+	}
+	m.Writes = map[string][]byte{}
+}
+
 func (m *MockStub) GetState(key string) ([]byte, error) {
 	return m.State[key], nil
 }
@@ -43,19 +75,43 @@ func (m *MockStub) CreateCompositeKey(objectType string, attributes []string) (s
 }
 
 // Implement all required methods for shim.ChaincodeStubInterface as needed for your tests
-func (m *MockStub) DelPrivateData(collection, key string) error             { return nil }
-func (m *MockStub) DelState(key string) error                               { return nil }
+func (m *MockStub) DelPrivateData(collection, key string) error {
+	delete(m.Private[collection], key)
+	return nil
+}
+func (m *MockStub) DelState(key string) error {
+	if m.Writes != nil {
+		m.Writes[key] = nil
+		return nil
+	}
+	delete(m.State, key)
+	return nil
+}
 func (m *MockStub) GetArgs() [][]byte                                       { return [][]byte{} }
 func (m *MockStub) GetArgsSlice() ([]byte, error)                           { return []byte{}, nil }
 func (m *MockStub) GetBinding() ([]byte, error)                             { return []byte{}, nil }
 func (m *MockStub) GetChannelID() string                                    { return "testchannel" }
-func (m *MockStub) GetCreator() ([]byte, error)                             { return []byte("creator"), nil }
+func (m *MockStub) GetCreator() ([]byte, error) {
+	if m.Creator != nil {
+		return m.Creator, nil
+	}
+	return []byte("creator"), nil
+}
 func (m *MockStub) GetDecorations() map[string][]byte                       { return map[string][]byte{} }
 func (m *MockStub) GetFunctionAndParameters() (string, []string)            { return "", []string{} }
 func (m *MockStub) SetStateValidationParameter(key string, ep []byte) error { return nil }
 func (m *MockStub) GetStateValidationParameter(key string) ([]byte, error)  { return nil, nil }
 func (m *MockStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
-	return nil, nil
+	var items []queryresult.KV
+	for k, v := range m.State {
+		// composite keys are not part of range queries on the ledger
+		if strings.Contains(k, ":") || k < startKey || (endKey != "" && k >= endKey) {
+			continue
+		}
+		items = append(items, queryresult.KV{Key: k, Value: v})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return &MockStateQueryIterator{Items: items, Index: 0}, nil
 }
 func (m *MockStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
 	return nil, nil, nil
@@ -64,7 +120,8 @@ func (m *MockStub) GetStateByPartialCompositeKeyWithPagination(objectType string
 	return nil, nil, nil
 }
 func (m *MockStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
-	return "", nil, nil
+	parts := strings.Split(compositeKey, ":")
+	return parts[0], parts[1:], nil
 }
 func (m *MockStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
 	return nil, nil
@@ -75,9 +132,27 @@ func (m *MockStub) GetQueryResultWithPagination(query string, pageSize int32, bo
 func (m *MockStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
 	return nil, nil
 }
-func (m *MockStub) GetPrivateData(collection, key string) ([]byte, error)            { return nil, nil }
-func (m *MockStub) GetPrivateDataHash(collection, key string) ([]byte, error)        { return nil, nil }
-func (m *MockStub) PutPrivateData(collection string, key string, value []byte) error { return nil }
+func (m *MockStub) GetPrivateData(collection, key string) ([]byte, error) {
+	return m.Private[collection][key], nil
+}
+func (m *MockStub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	value, ok := m.Private[collection][key]
+	if !ok {
+		return nil, nil
+	}
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+func (m *MockStub) PutPrivateData(collection string, key string, value []byte) error {
+	if m.Private == nil {
+		m.Private = map[string]map[string][]byte{}
+	}
+	if m.Private[collection] == nil {
+		m.Private[collection] = map[string][]byte{}
+	}
+	m.Private[collection][key] = value
+	return nil
+}
 func (m *MockStub) PurgePrivateData(collection, key string) error                    { return nil }
 func (m *MockStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
 	return nil
@@ -89,22 +164,41 @@ func (m *MockStub) GetPrivateDataByRange(collection, startKey, endKey string) (s
 	return nil, nil
 }
 func (m *MockStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
-	return nil, nil
+	prefix := objectType
+	for _, key := range keys {
+		prefix += ":" + key
+	}
+	var items []queryresult.KV
+	for k, v := range m.Private[collection] {
+		if strings.HasPrefix(k, prefix) {
+			items = append(items, queryresult.KV{Key: k, Value: v})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return &MockStateQueryIterator{Items: items, Index: 0}, nil
 }
 func (m *MockStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
 	return nil, nil
 }
 func (m *MockStub) GetSignedProposal() (*pb.SignedProposal, error)  { return nil, nil }
 func (m *MockStub) GetTxTimestamp() (*timestamppb.Timestamp, error) { return nil, nil }
-func (m *MockStub) SetEvent(name string, payload []byte) error      { return nil }
+func (m *MockStub) SetEvent(name string, payload []byte) error {
+	m.EventName = name
+	m.EventPayload = payload
+	return nil
+}
 func (m *MockStub) GetStringArgs() []string                         { return []string{} }
-func (m *MockStub) GetTransient() (map[string][]byte, error)        { return map[string][]byte{}, nil }
+func (m *MockStub) GetTransient() (map[string][]byte, error)        { return m.Transient, nil }
 func (m *MockStub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) pb.Response {
 	if chaincodeName == "timeoracle" {
+		oracleTime := m.OracleTime
+		if oracleTime == "" {
+			oracleTime = "2025-06-22 12:50:03.792349213 +0000 UTC"
+		}
 		return pb.Response{
 			Status:  200,
 			Message: "OK",
-			Payload: []byte("2025-06-22 12:50:03.792349213 +0000 UTC"),
+			Payload: []byte(oracleTime),
 		}
 	}
 	return pb.Response{}
@@ -148,6 +242,8 @@ func (m *MockStub) GetStateByPartialCompositeKey(objectType string, keys []strin
 			})
 		}
 	}
+	// the ledger returns keys in order, so the mock does too
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
 
 	return &MockStateQueryIterator{Items: items, Index: 0}, nil
 }
@@ -160,6 +256,7 @@ type MockClientIdentity struct {
 	mock.Mock
 	MSPID string
 	ID    string
+	Attrs map[string]string
 }
 
 func (ci *MockClientIdentity) GetMSPID() (string, error) {
@@ -167,13 +264,27 @@ func (ci *MockClientIdentity) GetMSPID() (string, error) {
 }
 
 func (ci *MockClientIdentity) GetID() (string, error) {
+	// like Idemix identities, identities without an ID cannot be determined
+	if ci.ID == "" {
+		return "", fmt.Errorf("cannot determine identity")
+	}
 	return ci.ID, nil
 }
 
 func (ci *MockClientIdentity) GetAttributeValue(attrName string) (string, bool, error) {
-	return "", false, nil
+	value, found := ci.Attrs[attrName]
+	return value, found, nil
+}
+func (ci *MockClientIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	value, found := ci.Attrs[attrName]
+	if !found {
+		return fmt.Errorf("attribute '%s' was not found", attrName)
+	}
+	if value != attrValue {
+		return fmt.Errorf("attribute '%s' equals '%s' instead of '%s'", attrName, value, attrValue)
+	}
+	return nil
 }
-func (ci *MockClientIdentity) AssertAttributeValue(attrName, attrValue string) error { return nil }
 func (ci *MockClientIdentity) GetX509Certificate() (*x509.Certificate, error)        { return nil, nil }
 
 // MockContext implements contractapi.TransactionContextInterface
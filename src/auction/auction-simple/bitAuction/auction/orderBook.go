@@ -0,0 +1,605 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"bitAuction/events"
+	"bitAuction/money"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// The order book runs a continuous double auction of a fungible item, for
+// example energy, in markets of many buyers and sellers. Placing an order only
+// writes the order's own key, so concurrent orders never conflict. Orders are
+// matched by MatchOrders, which takes the orders placed since the last match
+// in the order of their Time Oracle timestamps and matches each against the
+// book with price-time priority, as if it had been matched on entry. Live
+// orders are kept under order keys and move to orderdone keys once filled or
+// cancelled, so that matching only reads the live book.
+const (
+	marketKeyType    = "market"
+	orderKeyType     = "order"
+	orderDoneKeyType = "orderdone"
+	tradeKeyType     = "trade"
+)
+
+// modes of a market
+const (
+	marketContinuous = "continuous"
+	marketCall       = "call"
+)
+
+// sides of an order
+const (
+	sideBuy  = "buy"
+	sideSell = "sell"
+)
+
+// states of an order. Pending orders have not been matched yet, open orders
+// rest in the book.
+const (
+	orderPending   = "pending"
+	orderOpen      = "open"
+	orderFilled    = "filled"
+	orderCancelled = "cancelled"
+)
+
+// OrderBook is the contract of the continuous double auction markets
+type OrderBook struct {
+	contractapi.Contract
+	// auctions provides the Time Oracle and client identities of the auction contract
+	auctions SmartContract
+}
+
+// Market is a market of the order book. All its prices are in Currency.
+type Market struct {
+	MarketID string `json:"marketID"`
+	Item     string `json:"item"`
+	Currency string `json:"currency"`
+	Creator  string `json:"creator"`
+	// Mode is "continuous" or "call". Call markets clear their orders in
+	// batches of Interval seconds from Start instead of matching continuously.
+	Mode     string    `json:"mode"`
+	Start    time.Time `json:"start"`
+	Interval int       `json:"interval"`
+}
+
+// Order is a limit order to buy or sell Quantity units at Price per unit or
+// better. Remaining is the part not filled yet.
+type Order struct {
+	MarketID  string      `json:"marketID"`
+	OrderID   string      `json:"orderID"`
+	Side      string      `json:"side"`
+	Owner     string      `json:"owner"`
+	Org       string      `json:"org"`
+	Price     money.Money `json:"price"`
+	Quantity  int         `json:"quantity"`
+	Remaining int         `json:"remaining"`
+	Status    string      `json:"status"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Trade records units changing hands between a buy and a sell order at the
+// price of the order that rested in the book
+type Trade struct {
+	MarketID    string      `json:"marketID"`
+	TradeID     string      `json:"tradeID"`
+	BuyOrderID  string      `json:"buyOrderID"`
+	SellOrderID string      `json:"sellOrderID"`
+	Buyer       string      `json:"buyer"`
+	Seller      string      `json:"seller"`
+	Price       money.Money `json:"price"`
+	Quantity    int         `json:"quantity"`
+	Timestamp   time.Time   `json:"timestamp"`
+}
+
+// CreateMarket lets an auctioneer open a market for item priced in currency
+// whose orders are matched continuously
+func (b *OrderBook) CreateMarket(ctx contractapi.TransactionContextInterface, marketID string, item string, currency string) error {
+	return b.createMarket(ctx, &Market{MarketID: marketID, Item: item, Currency: currency, Mode: marketContinuous})
+}
+
+// createMarket stores a new market created by the client
+func (b *OrderBook) createMarket(ctx contractapi.TransactionContextInterface, market *Market) error {
+	if err := requireRole(ctx, roleAuctioneer); err != nil {
+		return err
+	}
+	if _, err := money.Exponent(market.Currency); err != nil {
+		return err
+	}
+	existing, err := getMarket(ctx, market.MarketID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("market %v already exists", market.MarketID)
+	}
+	market.Creator, err = b.auctions.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(marketKeyType, []string{market.MarketID})
+	if err != nil {
+		return fmt.Errorf("failed to create market key: %v", err)
+	}
+	marketJSON, err := json.Marshal(market)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, marketJSON)
+}
+
+// PlaceOrder places a limit order to buy or sell quantity units at price per
+// unit. Buy orders need the bidder role and sell orders the seller role. The
+// order is timestamped through the Time Oracle and waits for the next
+// MatchOrders, or ClearBatch in a call market. The function returns the
+// transaction ID, which is the order ID.
+func (b *OrderBook) PlaceOrder(ctx contractapi.TransactionContextInterface, marketID string, side string, price string, quantity int) (string, error) {
+	switch side {
+	case sideBuy:
+		if err := requireRole(ctx, roleBidder); err != nil {
+			return "", err
+		}
+	case sideSell:
+		if err := requireRole(ctx, roleSeller); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown order side %v", side)
+	}
+	market, err := getMarket(ctx, marketID)
+	if err != nil {
+		return "", err
+	}
+	if market == nil {
+		return "", fmt.Errorf("market %v does not exist", marketID)
+	}
+	orderPrice, err := money.Parse(price, market.Currency)
+	if err != nil {
+		return "", err
+	}
+	if orderPrice.Currency != market.Currency {
+		return "", fmt.Errorf("orders in market %v must be in %v, not %v", marketID, market.Currency, orderPrice.Currency)
+	}
+	if !orderPrice.IsPositive() {
+		return "", fmt.Errorf("invalid order price: %v", orderPrice)
+	}
+	if quantity < 1 {
+		return "", fmt.Errorf("orders are for at least one unit")
+	}
+
+	owner, err := b.auctions.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get client identity: %v", err)
+	}
+	org, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get org: %v", err)
+	}
+	txID := ctx.GetStub().GetTxID()
+	existing, err := getOrder(ctx, marketID, txID)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		return "", fmt.Errorf("order %v has already been placed", txID)
+	}
+	Timestamp, err := b.auctions.oracleTimestamp(ctx, txID)
+	if err != nil {
+		return "", err
+	}
+
+	order := Order{
+		MarketID:  marketID,
+		OrderID:   txID,
+		Side:      side,
+		Owner:     owner,
+		Org:       org,
+		Price:     orderPrice,
+		Quantity:  quantity,
+		Remaining: quantity,
+		Status:    orderPending,
+		Timestamp: Timestamp,
+	}
+	if err = putOrder(ctx, &order); err != nil {
+		return "", err
+	}
+	err = emitEvents(ctx, events.OrderPlaced{
+		MarketID:  marketID,
+		OrderID:   txID,
+		Side:      side,
+		Owner:     owner,
+		Price:     orderPrice,
+		Quantity:  quantity,
+		Timestamp: Timestamp,
+	})
+	if err != nil {
+		return "", err
+	}
+	return txID, nil
+}
+
+// CancelOrder lets the owner of an order cancel the part not filled yet
+func (b *OrderBook) CancelOrder(ctx contractapi.TransactionContextInterface, marketID string, orderID string) error {
+	order, err := getOrder(ctx, marketID, orderID)
+	if err != nil {
+		return err
+	}
+	if order == nil {
+		return fmt.Errorf("order %v does not exist", orderID)
+	}
+	clientID, err := b.auctions.GetSubmittingClientIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if order.Owner != clientID {
+		return fmt.Errorf("orders can only be cancelled by their owner")
+	}
+	if order.Status != orderPending && order.Status != orderOpen {
+		return fmt.Errorf("order %v is already %v", orderID, order.Status)
+	}
+
+	order.Status = orderCancelled
+	if err = putOrder(ctx, order); err != nil {
+		return err
+	}
+	return emitEvents(ctx, events.OrderCancelled{MarketID: marketID, OrderID: orderID, Remaining: order.Remaining})
+}
+
+// MatchOrders matches the orders of a continuous market placed since the
+// last match, oldest first, against the book. A buy order trades with the
+// cheapest sell orders at or below its price, a sell order with the highest
+// buy orders at or above it, earlier orders first at the same price. Trades
+// are at the price of the order in the book, and what is left of an order
+// rests in the book. Anyone can submit it. It reads the whole live book, so it
+// has to be retried when an order is placed while it is endorsed.
+func (b *OrderBook) MatchOrders(ctx contractapi.TransactionContextInterface, marketID string) error {
+	market, err := getMarket(ctx, marketID)
+	if err != nil {
+		return err
+	}
+	if market == nil {
+		return fmt.Errorf("market %v does not exist", marketID)
+	}
+	if market.Mode == marketCall {
+		return fmt.Errorf("market %v is a call market, its orders are cleared with ClearBatch", marketID)
+	}
+	orders, err := getLiveOrders(ctx, marketID)
+	if err != nil {
+		return err
+	}
+	txID := ctx.GetStub().GetTxID()
+	now, err := b.auctions.oracleTimestamp(ctx, txID)
+	if err != nil {
+		return err
+	}
+
+	incoming := []*Order{}
+	bids := []*Order{}
+	asks := []*Order{}
+	for _, order := range orders {
+		switch {
+		case order.Status == orderPending:
+			incoming = append(incoming, order)
+		case order.Side == sideBuy:
+			bids = append(bids, order)
+		default:
+			asks = append(asks, order)
+		}
+	}
+	sort.SliceStable(incoming, func(i, j int) bool { return isEarlierOrder(incoming[i], incoming[j]) })
+	if err = sortBook(bids); err != nil {
+		return err
+	}
+	if err = sortBook(asks); err != nil {
+		return err
+	}
+
+	trades := []*Trade{}
+	touched := map[string]bool{}
+	for _, order := range incoming {
+		touched[order.OrderID] = true
+		book := &asks
+		if order.Side == sideSell {
+			book = &bids
+		}
+		for order.Remaining > 0 && len(*book) > 0 {
+			crossed, err := crosses(order, (*book)[0])
+			if err != nil {
+				return err
+			}
+			if !crossed {
+				break
+			}
+			resting := (*book)[0]
+			quantity := order.Remaining
+			if resting.Remaining < quantity {
+				quantity = resting.Remaining
+			}
+			trade := &Trade{
+				MarketID:  marketID,
+				TradeID:   fmt.Sprintf("%s-%04d", txID, len(trades)),
+				Price:     resting.Price,
+				Quantity:  quantity,
+				Timestamp: now,
+			}
+			trade.BuyOrderID, trade.Buyer, trade.SellOrderID, trade.Seller = order.OrderID, order.Owner, resting.OrderID, resting.Owner
+			if order.Side == sideSell {
+				trade.BuyOrderID, trade.Buyer, trade.SellOrderID, trade.Seller = resting.OrderID, resting.Owner, order.OrderID, order.Owner
+			}
+			trades = append(trades, trade)
+
+			order.Remaining -= quantity
+			resting.Remaining -= quantity
+			touched[resting.OrderID] = true
+			if resting.Remaining == 0 {
+				resting.Status = orderFilled
+				*book = (*book)[1:]
+			}
+		}
+		if order.Remaining == 0 {
+			order.Status = orderFilled
+			continue
+		}
+		order.Status = orderOpen
+		if order.Side == sideBuy {
+			bids = append(bids, order)
+			err = sortBook(bids)
+		} else {
+			asks = append(asks, order)
+			err = sortBook(asks)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// only the matched orders and the resting orders they traded with are written
+	for _, order := range orders {
+		if !touched[order.OrderID] {
+			continue
+		}
+		if err = putOrder(ctx, order); err != nil {
+			return err
+		}
+	}
+	evts := []events.Event{}
+	for _, trade := range trades {
+		if err = putTrade(ctx, trade); err != nil {
+			return err
+		}
+		evts = append(evts, events.TradeExecuted{
+			MarketID:    marketID,
+			TradeID:     trade.TradeID,
+			BuyOrderID:  trade.BuyOrderID,
+			SellOrderID: trade.SellOrderID,
+			Price:       trade.Price,
+			Quantity:    trade.Quantity,
+		})
+	}
+	if len(evts) == 0 {
+		return nil
+	}
+	return emitEvents(ctx, evts...)
+}
+
+// QueryMarket returns a market of the order book
+func (b *OrderBook) QueryMarket(ctx contractapi.TransactionContextInterface, marketID string) (*Market, error) {
+	market, err := getMarket(ctx, marketID)
+	if err != nil {
+		return nil, err
+	}
+	if market == nil {
+		return nil, fmt.Errorf("market %v does not exist", marketID)
+	}
+	return market, nil
+}
+
+// QueryOrder returns an order, live or done
+func (b *OrderBook) QueryOrder(ctx contractapi.TransactionContextInterface, marketID string, orderID string) (*Order, error) {
+	order, err := getOrder(ctx, marketID, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil {
+		return nil, fmt.Errorf("order %v does not exist", orderID)
+	}
+	return order, nil
+}
+
+// QueryOrderBook returns the live orders of a market, pending and open
+func (b *OrderBook) QueryOrderBook(ctx contractapi.TransactionContextInterface, marketID string) ([]*Order, error) {
+	return getLiveOrders(ctx, marketID)
+}
+
+// QueryTrades returns the trades of a market in the order they were made
+func (b *OrderBook) QueryTrades(ctx contractapi.TransactionContextInterface, marketID string) ([]*Trade, error) {
+	iter, err := ctx.GetStub().GetStateByPartialCompositeKey(tradeKeyType, []string{marketID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trades of market %v: %v", marketID, err)
+	}
+	defer iter.Close()
+
+	trades := []*Trade{}
+	for iter.HasNext() {
+		queryResponse, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		var trade Trade
+		err = json.Unmarshal(queryResponse.Value, &trade)
+		if err != nil {
+			return nil, err
+		}
+		trades = append(trades, &trade)
+	}
+	sort.SliceStable(trades, func(i, j int) bool {
+		if !trades[i].Timestamp.Equal(trades[j].Timestamp) {
+			return trades[i].Timestamp.Before(trades[j].Timestamp)
+		}
+		return trades[i].TradeID < trades[j].TradeID
+	})
+	return trades, nil
+}
+
+// crosses reports whether an incoming order trades with a resting order of
+// the other side
+func crosses(order *Order, resting *Order) (bool, error) {
+	cmp, err := resting.Price.Cmp(order.Price)
+	if err != nil {
+		return false, err
+	}
+	if order.Side == sideBuy {
+		return cmp <= 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+// sortBook sorts one side of the book by price-time priority, best first. It
+// fails when the orders are not all in the same currency.
+func sortBook(book []*Order) error {
+	var err error
+	sort.SliceStable(book, func(i, j int) bool {
+		cmp, cmpErr := book[i].Price.Cmp(book[j].Price)
+		if cmpErr != nil {
+			err = cmpErr
+			return false
+		}
+		if cmp != 0 {
+			if book[i].Side == sideBuy {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return isEarlierOrder(book[i], book[j])
+	})
+	return err
+}
+
+// isEarlierOrder orders orders by timestamp, then by order ID
+func isEarlierOrder(a *Order, b *Order) bool {
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.Before(b.Timestamp)
+	}
+	return a.OrderID < b.OrderID
+}
+
+func getMarket(ctx contractapi.TransactionContextInterface, marketID string) (*Market, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(marketKeyType, []string{marketID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create market key: %v", err)
+	}
+	marketJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get market %v: %v", marketID, err)
+	}
+	if marketJSON == nil {
+		return nil, nil
+	}
+	var market Market
+	err = json.Unmarshal(marketJSON, &market)
+	if err != nil {
+		return nil, err
+	}
+	return &market, nil
+}
+
+// putOrder writes an order under the key of its state, live or done
+func putOrder(ctx contractapi.TransactionContextInterface, order *Order) error {
+	liveKey, err := ctx.GetStub().CreateCompositeKey(orderKeyType, []string{order.MarketID, order.OrderID})
+	if err != nil {
+		return fmt.Errorf("failed to create order key: %v", err)
+	}
+	key := liveKey
+	if order.Status == orderFilled || order.Status == orderCancelled {
+		key, err = ctx.GetStub().CreateCompositeKey(orderDoneKeyType, []string{order.MarketID, order.OrderID})
+		if err != nil {
+			return fmt.Errorf("failed to create order key: %v", err)
+		}
+		if err = ctx.GetStub().DelState(liveKey); err != nil {
+			return fmt.Errorf("failed to delete live order: %v", err)
+		}
+	}
+	orderJSON, err := json.Marshal(order)
+	if err != nil {
+		return fmt.Errorf("failed to marshal order: %v", err)
+	}
+	err = ctx.GetStub().PutState(key, orderJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put order in state: %v", err)
+	}
+	return nil
+}
+
+// getOrder returns an order, live or done, nil when there is none
+func getOrder(ctx contractapi.TransactionContextInterface, marketID string, orderID string) (*Order, error) {
+	for _, keyType := range []string{orderKeyType, orderDoneKeyType} {
+		key, err := ctx.GetStub().CreateCompositeKey(keyType, []string{marketID, orderID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create order key: %v", err)
+		}
+		orderJSON, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get order %v: %v", orderID, err)
+		}
+		if orderJSON == nil {
+			continue
+		}
+		var order Order
+		err = json.Unmarshal(orderJSON, &order)
+		if err != nil {
+			return nil, err
+		}
+		return &order, nil
+	}
+	return nil, nil
+}
+
+// getLiveOrders returns the pending and open orders of a market
+func getLiveOrders(ctx contractapi.TransactionContextInterface, marketID string) ([]*Order, error) {
+	iter, err := ctx.GetStub().GetStateByPartialCompositeKey(orderKeyType, []string{marketID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get orders of market %v: %v", marketID, err)
+	}
+	defer iter.Close()
+
+	orders := []*Order{}
+	for iter.HasNext() {
+		queryResponse, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		var order Order
+		err = json.Unmarshal(queryResponse.Value, &order)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, &order)
+	}
+	return orders, nil
+}
+
+func putTrade(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+	key, err := ctx.GetStub().CreateCompositeKey(tradeKeyType, []string{trade.MarketID, trade.TradeID})
+	if err != nil {
+		return fmt.Errorf("failed to create trade key: %v", err)
+	}
+	tradeJSON, err := json.Marshal(trade)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trade: %v", err)
+	}
+	err = ctx.GetStub().PutState(key, tradeJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put trade in state: %v", err)
+	}
+	return nil
+}
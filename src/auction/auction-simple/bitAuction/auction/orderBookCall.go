@@ -0,0 +1,335 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"bitAuction/events"
+	"bitAuction/money"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A call market collects orders for an interval and clears them together at a
+// single price once the interval is closed, so that being first brings no
+// advantage. Batch n holds the orders timestamped before the end of interval
+// n and not filled in an earlier batch. ClearBatch writes the results of every
+// batch under a batch key, and the last one under a lastbatch key that only
+// ClearBatch reads, so order entry never reads what clearing writes. Clearing
+// does read every live order of the market through a range query, though, so
+// an order placed or cancelled while a batch clears fails the clearing
+// transaction at validation and ClearBatch has to be submitted again.
+const (
+	batchKeyType     = "batch"
+	lastBatchKeyType = "lastbatch"
+)
+
+// BatchResult records how a batch of a call market was cleared. Demand and
+// Supply are the units bid and offered at the clearing price, Volume the units
+// traded. A batch in which no orders cross has a zero clearing price.
+type BatchResult struct {
+	MarketID      string      `json:"marketID"`
+	Batch         int         `json:"batch"`
+	Close         time.Time   `json:"close"`
+	ClearingPrice money.Money `json:"clearingPrice"`
+	Volume        int         `json:"volume"`
+	Demand        int         `json:"demand"`
+	Supply        int         `json:"supply"`
+	TradeIDs      []string    `json:"tradeIDs"`
+	ClearedAt     time.Time   `json:"clearedAt"`
+}
+
+// CreateCallMarket lets an auctioneer open a call market for item priced in
+// currency. Its orders are cleared in batches of interval seconds from start.
+func (b *OrderBook) CreateCallMarket(ctx contractapi.TransactionContextInterface, marketID string, item string, currency string, start string, interval int) error {
+	t, err := time.Parse(time.RFC3339Nano, start)
+	if err != nil {
+		return fmt.Errorf("invalid datetime format: %v", err)
+	}
+	if interval < 1 {
+		return fmt.Errorf("batches last at least one second")
+	}
+	return b.createMarket(ctx, &Market{MarketID: marketID, Item: item, Currency: currency, Mode: marketCall, Start: t, Interval: interval})
+}
+
+// ClearBatch clears the last batch of a call market that is closed by the
+// Time Oracle timestamp. Intervals that ended since the last clearing are
+// cleared together with it. The clearing price is the one of the order prices
+// that trades the most units. Ties go to the price leaving the fewest units
+// unmatched on the long side, then to the lowest price. Every buy order at or
+// above the clearing price and every sell order at or below it fills at the
+// clearing price, the long side by price and then by timestamp, the last of
+// them possibly in part. What is left of the orders stays in the book for the
+// next batch. Anyone can submit it.
+func (b *OrderBook) ClearBatch(ctx contractapi.TransactionContextInterface, marketID string) error {
+	market, err := getMarket(ctx, marketID)
+	if err != nil {
+		return err
+	}
+	if market == nil {
+		return fmt.Errorf("market %v does not exist", marketID)
+	}
+	if market.Mode != marketCall {
+		return fmt.Errorf("market %v is not a call market", marketID)
+	}
+	txID := ctx.GetStub().GetTxID()
+	now, err := b.auctions.oracleTimestamp(ctx, txID)
+	if err != nil {
+		return err
+	}
+
+	interval := time.Duration(market.Interval) * time.Second
+	if now.Before(market.Start.Add(interval)) {
+		return fmt.Errorf("the first batch closes at %v", market.Start.Add(interval))
+	}
+	batch := int(now.Sub(market.Start)/interval) - 1
+	last, err := getLastBatch(ctx, marketID)
+	if err != nil {
+		return err
+	}
+	if last != nil && last.Batch >= batch {
+		return fmt.Errorf("batch %d has already been cleared, the next closes at %v", last.Batch, market.Start.Add(time.Duration(last.Batch+2)*interval))
+	}
+	closeTime := market.Start.Add(time.Duration(batch+1) * interval)
+
+	orders, err := getLiveOrders(ctx, marketID)
+	if err != nil {
+		return err
+	}
+	buys := []*Order{}
+	sells := []*Order{}
+	remaining := map[string]int{}
+	for _, order := range orders {
+		if !order.Timestamp.Before(closeTime) {
+			continue
+		}
+		remaining[order.OrderID] = order.Remaining
+		if order.Side == sideBuy {
+			buys = append(buys, order)
+		} else {
+			sells = append(sells, order)
+		}
+	}
+
+	result := &BatchResult{
+		MarketID:  marketID,
+		Batch:     batch,
+		Close:     closeTime,
+		TradeIDs:  []string{},
+		ClearedAt: now,
+	}
+	result.ClearingPrice, result.Volume, result.Demand, result.Supply, err = clearingPrice(market.Currency, buys, sells)
+	if err != nil {
+		return err
+	}
+
+	// the short side fills completely, the long side in priority order
+	if err = sortBook(buys); err != nil {
+		return err
+	}
+	if err = sortBook(sells); err != nil {
+		return err
+	}
+	evts := []events.Event{}
+	trades := []*Trade{}
+	i, j := 0, 0
+	for result.Volume > 0 && i < len(buys) && j < len(sells) {
+		buy, sell := buys[i], sells[j]
+		buyCmp, err := buy.Price.Cmp(result.ClearingPrice)
+		if err != nil {
+			return err
+		}
+		sellCmp, err := sell.Price.Cmp(result.ClearingPrice)
+		if err != nil {
+			return err
+		}
+		if buyCmp < 0 || sellCmp > 0 {
+			break
+		}
+		quantity := buy.Remaining
+		if sell.Remaining < quantity {
+			quantity = sell.Remaining
+		}
+		trade := &Trade{
+			MarketID:    marketID,
+			TradeID:     fmt.Sprintf("%s-%04d", txID, len(trades)),
+			BuyOrderID:  buy.OrderID,
+			SellOrderID: sell.OrderID,
+			Buyer:       buy.Owner,
+			Seller:      sell.Owner,
+			Price:       result.ClearingPrice,
+			Quantity:    quantity,
+			Timestamp:   now,
+		}
+		trades = append(trades, trade)
+		result.TradeIDs = append(result.TradeIDs, trade.TradeID)
+		buy.Remaining -= quantity
+		sell.Remaining -= quantity
+		if buy.Remaining == 0 {
+			i++
+		}
+		if sell.Remaining == 0 {
+			j++
+		}
+	}
+
+	// orders that rest in the book without trading are not written again
+	for _, order := range append(buys, sells...) {
+		if order.Status == orderOpen && order.Remaining == remaining[order.OrderID] {
+			continue
+		}
+		if order.Remaining == 0 {
+			order.Status = orderFilled
+		} else {
+			order.Status = orderOpen
+		}
+		if err = putOrder(ctx, order); err != nil {
+			return err
+		}
+	}
+	for _, trade := range trades {
+		if err = putTrade(ctx, trade); err != nil {
+			return err
+		}
+		evts = append(evts, events.TradeExecuted{
+			MarketID:    marketID,
+			TradeID:     trade.TradeID,
+			BuyOrderID:  trade.BuyOrderID,
+			SellOrderID: trade.SellOrderID,
+			Price:       trade.Price,
+			Quantity:    trade.Quantity,
+		})
+	}
+	if err = putBatchResult(ctx, result); err != nil {
+		return err
+	}
+	evts = append([]events.Event{events.BatchCleared{
+		MarketID:      marketID,
+		Batch:         batch,
+		ClearingPrice: result.ClearingPrice,
+		Volume:        result.Volume,
+	}}, evts...)
+	return emitEvents(ctx, evts...)
+}
+
+// QueryBatch returns the results of a cleared batch of a call market
+func (b *OrderBook) QueryBatch(ctx contractapi.TransactionContextInterface, marketID string, batch int) (*BatchResult, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(batchKeyType, []string{marketID, fmt.Sprintf("%010d", batch)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create batch key: %v", err)
+	}
+	resultJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get batch %d: %v", batch, err)
+	}
+	if resultJSON == nil {
+		return nil, fmt.Errorf("batch %d of market %v has not been cleared", batch, marketID)
+	}
+	var result BatchResult
+	err = json.Unmarshal(resultJSON, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// clearingPrice returns the order price that trades the most units, with the
+// units traded, bid and offered at it. Of prices trading the same units the
+// one with the smallest difference between units bid and offered wins, then
+// the lowest. It returns a zero price when no orders cross, and an error when
+// an order is not in the currency of the market.
+func clearingPrice(currency string, buys []*Order, sells []*Order) (money.Money, int, int, int, error) {
+	candidates := []money.Money{}
+	for _, order := range append(append([]*Order{}, buys...), sells...) {
+		if order.Price.Currency != currency {
+			return money.Money{}, 0, 0, 0, fmt.Errorf("order %v is in %v, the market in %v", order.OrderID, order.Price.Currency, currency)
+		}
+		candidates = append(candidates, order.Price)
+	}
+	// all prices are in the currency of the market, so their amounts compare
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Amount < candidates[j].Amount })
+
+	best, bestVolume, bestDemand, bestSupply := money.Zero(currency), 0, 0, 0
+	for _, price := range candidates {
+		demand, supply := 0, 0
+		for _, buy := range buys {
+			if buy.Price.Amount >= price.Amount {
+				demand += buy.Remaining
+			}
+		}
+		for _, sell := range sells {
+			if sell.Price.Amount <= price.Amount {
+				supply += sell.Remaining
+			}
+		}
+		volume := demand
+		if supply < volume {
+			volume = supply
+		}
+		if volume == 0 {
+			continue
+		}
+		if volume > bestVolume || (volume == bestVolume && imbalance(demand, supply) < imbalance(bestDemand, bestSupply)) {
+			best, bestVolume, bestDemand, bestSupply = price, volume, demand, supply
+		}
+	}
+	return best, bestVolume, bestDemand, bestSupply, nil
+}
+
+// imbalance returns the units left unmatched between demand and supply
+func imbalance(demand int, supply int) int {
+	if demand > supply {
+		return demand - supply
+	}
+	return supply - demand
+}
+
+// putBatchResult writes the results of a batch and marks it as the last one cleared
+func putBatchResult(ctx contractapi.TransactionContextInterface, result *BatchResult) error {
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch result: %v", err)
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(batchKeyType, []string{result.MarketID, fmt.Sprintf("%010d", result.Batch)})
+	if err != nil {
+		return fmt.Errorf("failed to create batch key: %v", err)
+	}
+	lastKey, err := ctx.GetStub().CreateCompositeKey(lastBatchKeyType, []string{result.MarketID})
+	if err != nil {
+		return fmt.Errorf("failed to create batch key: %v", err)
+	}
+	for _, k := range []string{key, lastKey} {
+		err = ctx.GetStub().PutState(k, resultJSON)
+		if err != nil {
+			return fmt.Errorf("failed to put batch result in state: %v", err)
+		}
+	}
+	return nil
+}
+
+// getLastBatch returns the results of the last batch cleared, nil before the first
+func getLastBatch(ctx contractapi.TransactionContextInterface, marketID string) (*BatchResult, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(lastBatchKeyType, []string{marketID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create batch key: %v", err)
+	}
+	resultJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last batch: %v", err)
+	}
+	if resultJSON == nil {
+		return nil, nil
+	}
+	var result BatchResult
+	err = json.Unmarshal(resultJSON, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
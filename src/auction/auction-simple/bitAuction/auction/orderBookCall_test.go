@@ -0,0 +1,99 @@
+package auction_test
+
+import (
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+	"bitAuction/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func clearBatch(book *auction.OrderBook, ctx *MockContext, txID string, at time.Time) error {
+	ctx.Stub.TxID = txID
+	ctx.Stub.OracleTime = formatOracleTime(at)
+	return book.ClearBatch(ctx, "power")
+}
+
+func TestCallMarketUniformClearingPrice(t *testing.T) {
+	_, ctx := setup()
+	book := &auction.OrderBook{}
+	start := time.Now()
+	err := book.CreateCallMarket(ctx, "power", "kWh", "USD", start.Format(time.RFC3339Nano), 60)
+	assert.NoError(t, err)
+
+	placeOrder(t, book, ctx, "buyer1", "b1", start.Add(1*time.Second), "buy", "52", 10)
+	placeOrder(t, book, ctx, "buyer2", "b2", start.Add(2*time.Second), "buy", "50", 5)
+	placeOrder(t, book, ctx, "seller1", "s1", start.Add(3*time.Second), "sell", "49", 8)
+	placeOrder(t, book, ctx, "seller2", "s2", start.Add(4*time.Second), "sell", "51", 6)
+	// placed after the first batch closed
+	placeOrder(t, book, ctx, "buyer3", "b3", start.Add(61*time.Second), "buy", "60", 3)
+
+	assert.Error(t, book.MatchOrders(ctx, "power"))
+	assert.Error(t, clearBatch(book, ctx, "clear0", start.Add(30*time.Second)))
+
+	// 51 and 52 both trade 10 units with 4 left over, the lower price wins
+	assert.NoError(t, clearBatch(book, ctx, "clear1", start.Add(65*time.Second)))
+	assert.Equal(t, "BatchCleared", ctx.Stub.EventName)
+	cleared, ok := decodeEvents(t, ctx)[0].(*events.BatchCleared)
+	assert.True(t, ok)
+	assert.Equal(t, 0, cleared.Batch)
+	assert.Error(t, clearBatch(book, ctx, "clear2", start.Add(70*time.Second)))
+
+	result, err := book.QueryBatch(ctx, "power", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, usd(51), result.ClearingPrice)
+	assert.Equal(t, 10, result.Volume)
+	assert.Equal(t, 10, result.Demand)
+	assert.Equal(t, 14, result.Supply)
+	assert.Len(t, result.TradeIDs, 2)
+
+	trades, err := book.QueryTrades(ctx, "power")
+	assert.NoError(t, err)
+	assert.Len(t, trades, 2)
+	for _, trade := range trades {
+		assert.Equal(t, "b1", trade.BuyOrderID)
+		assert.Equal(t, usd(51), trade.Price)
+	}
+	assert.Equal(t, "s1", trades[0].SellOrderID)
+	assert.Equal(t, 8, trades[0].Quantity)
+	status, remaining := orderStatus(t, book, ctx, "s2")
+	assert.Equal(t, "open", status)
+	assert.Equal(t, 4, remaining)
+	status, _ = orderStatus(t, book, ctx, "b2")
+	assert.Equal(t, "open", status)
+	status, _ = orderStatus(t, book, ctx, "b3")
+	assert.Equal(t, "pending", status)
+
+	// the next batch carries the rest of the book over, b3 pays 51, not 60
+	assert.NoError(t, clearBatch(book, ctx, "clear3", start.Add(125*time.Second)))
+	result, err = book.QueryBatch(ctx, "power", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, usd(51), result.ClearingPrice)
+	assert.Equal(t, 3, result.Volume)
+	trades, err = book.QueryTrades(ctx, "power")
+	assert.NoError(t, err)
+	assert.Len(t, trades, 3)
+	assert.Equal(t, "b3", trades[2].BuyOrderID)
+	assert.Equal(t, usd(51), trades[2].Price)
+}
+
+func TestCallMarketNoCross(t *testing.T) {
+	_, ctx := setup()
+	book := &auction.OrderBook{}
+	start := time.Now()
+	err := book.CreateCallMarket(ctx, "power", "kWh", "USD", start.Format(time.RFC3339Nano), 60)
+	assert.NoError(t, err)
+	placeOrder(t, book, ctx, "buyer1", "b1", start.Add(1*time.Second), "buy", "40", 10)
+	placeOrder(t, book, ctx, "seller1", "s1", start.Add(2*time.Second), "sell", "45", 10)
+
+	assert.NoError(t, clearBatch(book, ctx, "clear1", start.Add(61*time.Second)))
+	result, err := book.QueryBatch(ctx, "power", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Volume)
+	assert.False(t, result.ClearingPrice.IsPositive())
+	live, err := book.QueryOrderBook(ctx, "power")
+	assert.NoError(t, err)
+	assert.Len(t, live, 2)
+}
@@ -0,0 +1,121 @@
+package auction_test
+
+import (
+	"testing"
+	"time"
+
+	"bitAuction/auction"
+	"bitAuction/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func placeOrder(t *testing.T, book *auction.OrderBook, ctx *MockContext, owner string, txID string, at time.Time, side string, price string, quantity int) {
+	actAs(ctx, owner)
+	ctx.Stub.TxID = txID
+	ctx.Stub.OracleTime = formatOracleTime(at)
+	_, err := book.PlaceOrder(ctx, "power", side, price, quantity)
+	assert.NoError(t, err)
+}
+
+func orderStatus(t *testing.T, book *auction.OrderBook, ctx *MockContext, orderID string) (string, int) {
+	order, err := book.QueryOrder(ctx, "power", orderID)
+	assert.NoError(t, err)
+	return order.Status, order.Remaining
+}
+
+func TestOrderBookPriceTimePriority(t *testing.T) {
+	_, ctx := setup()
+	book := &auction.OrderBook{}
+	now := time.Now()
+	err := book.CreateMarket(ctx, "power", "kWh", "USD")
+	assert.NoError(t, err)
+	assert.Error(t, book.CreateMarket(ctx, "power", "kWh", "USD"))
+
+	placeOrder(t, book, ctx, "seller1", "o1", now.Add(1*time.Second), "sell", "50", 10)
+	placeOrder(t, book, ctx, "seller2", "o2", now.Add(2*time.Second), "sell", "48", 5)
+	placeOrder(t, book, ctx, "buyer1", "o3", now.Add(3*time.Second), "buy", "50", 12)
+	assert.Equal(t, "OrderPlaced", ctx.Stub.EventName)
+	status, _ := orderStatus(t, book, ctx, "o3")
+	assert.Equal(t, "pending", status)
+
+	// the buy order takes the cheaper sell order first, at the prices in the book
+	ctx.Stub.TxID = "match1"
+	err = book.MatchOrders(ctx, "power")
+	assert.NoError(t, err)
+	trades, err := book.QueryTrades(ctx, "power")
+	assert.NoError(t, err)
+	assert.Len(t, trades, 2)
+	assert.Equal(t, "o2", trades[0].SellOrderID)
+	assert.Equal(t, usd(48), trades[0].Price)
+	assert.Equal(t, 5, trades[0].Quantity)
+	assert.Equal(t, "o1", trades[1].SellOrderID)
+	assert.Equal(t, usd(50), trades[1].Price)
+	assert.Equal(t, 7, trades[1].Quantity)
+	evts := decodeEvents(t, ctx)
+	assert.Len(t, evts, 2)
+	_, ok := evts[0].(*events.TradeExecuted)
+	assert.True(t, ok)
+
+	status, remaining := orderStatus(t, book, ctx, "o1")
+	assert.Equal(t, "open", status)
+	assert.Equal(t, 3, remaining)
+	status, _ = orderStatus(t, book, ctx, "o3")
+	assert.Equal(t, "filled", status)
+	live, err := book.QueryOrderBook(ctx, "power")
+	assert.NoError(t, err)
+	assert.Len(t, live, 1)
+
+	// orders at the same price fill in timestamp order, not in the order placed
+	placeOrder(t, book, ctx, "buyer2", "o5", now.Add(5*time.Second), "buy", "49", 2)
+	placeOrder(t, book, ctx, "buyer3", "o4", now.Add(4*time.Second), "buy", "49", 2)
+	placeOrder(t, book, ctx, "seller3", "o6", now.Add(6*time.Second), "sell", "45", 3)
+	ctx.Stub.TxID = "match2"
+	err = book.MatchOrders(ctx, "power")
+	assert.NoError(t, err)
+	trades, err = book.QueryTrades(ctx, "power")
+	assert.NoError(t, err)
+	assert.Len(t, trades, 4)
+	assert.Equal(t, "o4", trades[2].BuyOrderID)
+	assert.Equal(t, 2, trades[2].Quantity)
+	assert.Equal(t, "o5", trades[3].BuyOrderID)
+	assert.Equal(t, 1, trades[3].Quantity)
+	assert.Equal(t, usd(49), trades[3].Price)
+}
+
+func TestOrderBookCancelOrder(t *testing.T) {
+	_, ctx := setup()
+	book := &auction.OrderBook{}
+	now := time.Now()
+	err := book.CreateMarket(ctx, "power", "kWh", "USD")
+	assert.NoError(t, err)
+
+	actAs(ctx, "buyer1")
+	_, err = book.PlaceOrder(ctx, "power", "hold", "50", 1)
+	assert.Error(t, err)
+	_, err = book.PlaceOrder(ctx, "power", "buy", "50", 0)
+	assert.Error(t, err)
+	placeOrder(t, book, ctx, "buyer1", "o1", now, "buy", "50", 4)
+
+	actAs(ctx, "buyer2")
+	err = book.CancelOrder(ctx, "power", "o1")
+	assert.Error(t, err)
+	actAs(ctx, "buyer1")
+	err = book.CancelOrder(ctx, "power", "o1")
+	assert.NoError(t, err)
+	assert.Equal(t, "OrderCancelled", ctx.Stub.EventName)
+	err = book.CancelOrder(ctx, "power", "o1")
+	assert.Error(t, err)
+
+	// cancelled orders leave the book
+	placeOrder(t, book, ctx, "seller1", "o2", now.Add(1*time.Second), "sell", "40", 4)
+	ctx.Stub.TxID = "match1"
+	err = book.MatchOrders(ctx, "power")
+	assert.NoError(t, err)
+	trades, err := book.QueryTrades(ctx, "power")
+	assert.NoError(t, err)
+	assert.Len(t, trades, 0)
+	status, remaining := orderStatus(t, book, ctx, "o1")
+	assert.Equal(t, "cancelled", status)
+	assert.Equal(t, 4, remaining)
+}
@@ -11,6 +11,8 @@ import (
 	"math/rand"
 	"strings"
 
+	"bitAuction/events"
+
 	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -19,7 +21,12 @@ import (
 func (s *SmartContract) GetSubmittingClientIdentity(ctx contractapi.TransactionContextInterface) (string, error) {
 	b64ID, err := ctx.GetClientIdentity().GetID()
 	if err != nil {
-		return "", fmt.Errorf("failed to read clientID: %v", err)
+		// Idemix identities have no certificate and are known by their pseudonym
+		nym, nymErr := idemixPseudonym(ctx)
+		if nymErr != nil || nym == "" {
+			return "", fmt.Errorf("failed to read clientID: %v", err)
+		}
+		return nym, nil
 	}
 	decodeID, err := base64.StdEncoding.DecodeString(b64ID)
 	if err != nil {
@@ -40,10 +47,28 @@ func (s *SmartContract) ParseClientID(idStr string) (string, error) {
             return cnParts[0], nil
         }
     }
+	// Idemix clients are only known by their pseudonym
+	if strings.HasPrefix(idStr, idemixIDPrefix) {
+		parts := strings.Split(idStr, "::")
+		return "nym-" + parts[len(parts)-1], nil
+	}
 
 	return idStr, nil
 }
 
+// parseClientIssuer returns the issuer of an X.509 client ID, or an empty
+// string for other identities
+func parseClientIssuer(idStr string) string {
+	if !strings.HasPrefix(idStr, "x509::") {
+		return ""
+	}
+	parts := strings.Split(strings.TrimPrefix(idStr, "x509::"), "::")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
 // setAssetStateBasedEndorsement sets the endorsement policy of a new auction
 func setAssetStateBasedEndorsement(ctx contractapi.TransactionContextInterface, auctionID string, orgToEndorse string) error {
 
@@ -129,6 +154,20 @@ func verifyClientOrgMatchesPeerOrg(ctx contractapi.TransactionContextInterface)
 	return nil
 }
 
+// emitEvents sets the chaincode event of the transaction. All events of a
+// transaction have to be emitted with a single call.
+func emitEvents(ctx contractapi.TransactionContextInterface, evts ...events.Event) error {
+	name, payload, err := events.Encode(ctx.GetStub().GetTxID(), evts...)
+	if err != nil {
+		return fmt.Errorf("failed to encode events: %v", err)
+	}
+	err = ctx.GetStub().SetEvent(name, payload)
+	if err != nil {
+		return fmt.Errorf("failed to set %s event: %v", name, err)
+	}
+	return nil
+}
+
 func contains(sli []string, str string) bool {
 	for _, a := range sli {
 		if a == str {
@@ -0,0 +1,429 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package events holds the chaincode events emitted by the auction chaincode.
+// Listeners using the fabric-gateway ChaincodeEvents API decode the payload of
+// a received event with Decode:
+//
+//	for event := range chaincodeEvents {
+//		envelope, err := events.Decode(event.Payload)
+//		...
+//		for _, record := range envelope.Events {
+//			evt, err := record.Decode()
+//			switch e := evt.(type) {
+//			case *events.NewLeader:
+//				...
+//			}
+//		}
+//	}
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"bitAuction/money"
+)
+
+// Version of the event payload format. It is increased whenever a change to the
+// payloads is not backwards compatible. Version 2 carries prices as amounts
+// with a currency instead of plain integers.
+const Version = 2
+
+// Event is implemented by every event payload
+type Event interface {
+	EventType() string
+}
+
+// Envelope is the payload of every chaincode event of the auction chaincode.
+// Fabric keeps a single event per transaction, so all events of a transaction
+// travel together and the chaincode event name is the type of the first one.
+type Envelope struct {
+	Version int      `json:"version"`
+	TxID    string   `json:"txID"`
+	Events  []Record `json:"events"`
+}
+
+// Record is a single typed event inside an envelope
+type Record struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// AuctionCreated is emitted when a seller creates an auction, or a buyer a
+// reverse auction
+type AuctionCreated struct {
+	AuctionID string    `json:"auctionID"`
+	Seller    string    `json:"seller"`
+	Org       string    `json:"org"`
+	Item      string    `json:"item"`
+	Timelimit time.Time `json:"timelimit"`
+	Direction string    `json:"direction"`
+}
+
+// BidPlaced is emitted when a bid price is stored with Bid
+type BidPlaced struct {
+	AuctionID string      `json:"auctionID"`
+	TxID      string      `json:"txID"`
+	Price     money.Money `json:"price"`
+}
+
+// BidSubmitted is emitted when a bid is timestamped and added to the auction
+type BidSubmitted struct {
+	AuctionID string      `json:"auctionID"`
+	TxID      string      `json:"txID"`
+	Bidder    string      `json:"bidder"`
+	Org       string      `json:"org"`
+	Price     money.Money `json:"price"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// NewLeader is emitted when a submitted bid becomes the highest bid
+type NewLeader struct {
+	AuctionID     string      `json:"auctionID"`
+	TxID          string      `json:"txID"`
+	Bidder        string      `json:"bidder"`
+	Price         money.Money `json:"price"`
+	PreviousPrice money.Money `json:"previousPrice"`
+}
+
+// DeadlineExtended is emitted when the time limit of an auction moves. Reason
+// is "soft-close", "sale-resumed" or "sale-sequence".
+type DeadlineExtended struct {
+	AuctionID string    `json:"auctionID"`
+	Previous  time.Time `json:"previous"`
+	Timelimit time.Time `json:"timelimit"`
+	Reason    string    `json:"reason"`
+}
+
+// AuctionEnded is emitted when an auction is closed and the winner determined.
+// Outcome is "bidding", "buy-now" or "no-sale".
+type AuctionEnded struct {
+	AuctionID string      `json:"auctionID"`
+	Winner    string      `json:"winner"`
+	Price     money.Money `json:"price"`
+	Outcome   string      `json:"outcome"`
+}
+
+// AuctionCancelled is emitted when an auction is cancelled or a cancellation
+// is waiting for approval, and with Status "open" when a pending
+// cancellation is withdrawn or rejected
+type AuctionCancelled struct {
+	AuctionID string   `json:"auctionID"`
+	Status    string   `json:"status"`
+	Reason    string   `json:"reason"`
+	Approvals []string `json:"approvals"`
+}
+
+// BidRetracted is emitted when a bidder retracts a bid
+type BidRetracted struct {
+	AuctionID string `json:"auctionID"`
+	TxID      string `json:"txID"`
+	Reason    string `json:"reason"`
+}
+
+// OrgJoined is emitted when an organization joins an auction or asks the
+// seller to approve joining
+type OrgJoined struct {
+	AuctionID string `json:"auctionID"`
+	Org       string `json:"org"`
+	Status    string `json:"status"`
+}
+
+// BidInvalidated is emitted when an auctioneer or auditor disqualifies a bid
+type BidInvalidated struct {
+	AuctionID  string `json:"auctionID"`
+	TxID       string `json:"txID"`
+	ReasonCode string `json:"reasonCode"`
+	By         string `json:"by"`
+}
+
+// SettlementChanged is emitted when the settlement of an ended auction moves
+// to a new state. Deadline is when the next step is due, zero when it has none.
+type SettlementChanged struct {
+	AuctionID string      `json:"auctionID"`
+	State     string      `json:"state"`
+	Buyer     string      `json:"buyer"`
+	Price     money.Money `json:"price"`
+	Deadline  time.Time   `json:"deadline"`
+	By        string      `json:"by"`
+}
+
+// UnitsAllocated is emitted when a multi-unit auction ends, with the units
+// every winning bid receives. ClearingPrice is the lowest accepted unit price,
+// the highest in a reverse auction.
+type UnitsAllocated struct {
+	AuctionID     string           `json:"auctionID"`
+	Pricing       string           `json:"pricing"`
+	ClearingPrice money.Money      `json:"clearingPrice"`
+	Allocations   []UnitAllocation `json:"allocations"`
+}
+
+// UnitAllocation is the number of units a bid wins and what it pays for them
+type UnitAllocation struct {
+	Bidder    string      `json:"bidder"`
+	BidTxID   string      `json:"bidTxID"`
+	Quantity  int         `json:"quantity"`
+	UnitPrice money.Money `json:"unitPrice"`
+	Total     money.Money `json:"total"`
+}
+
+// LotsAwarded is emitted when a package auction ends, with the winning bid of
+// every lot. Lots nobody won have an empty bidder.
+type LotsAwarded struct {
+	AuctionID string      `json:"auctionID"`
+	Revenue   money.Money `json:"revenue"`
+	Awards    []LotAward  `json:"awards"`
+}
+
+// LotAward is the package bid that won a lot
+type LotAward struct {
+	Lot          string      `json:"lot"`
+	Bidder       string      `json:"bidder"`
+	BidTxID      string      `json:"bidTxID"`
+	Package      []string    `json:"package"`
+	PackagePrice money.Money `json:"packagePrice"`
+}
+
+// ClockConfirmed is emitted when a bidder stays in a round of a clock auction
+type ClockConfirmed struct {
+	AuctionID string      `json:"auctionID"`
+	Round     int         `json:"round"`
+	TxID      string      `json:"txID"`
+	Bidder    string      `json:"bidder"`
+	Price     money.Money `json:"price"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// ClockRoundClosed is emitted when a round of a clock auction is closed, with
+// the number of bidders who stayed in and those who dropped out. TieBreak is
+// "timestamp" or "random" when every remaining bidder dropped out at once.
+type ClockRoundClosed struct {
+	AuctionID string      `json:"auctionID"`
+	Round     int         `json:"round"`
+	Price     money.Money `json:"price"`
+	Remaining int         `json:"remaining"`
+	Dropped   []string    `json:"dropped"`
+	TieBreak  string      `json:"tieBreak,omitempty"`
+}
+
+// OrderPlaced is emitted when a limit order is placed in an order book market
+type OrderPlaced struct {
+	MarketID  string      `json:"marketID"`
+	OrderID   string      `json:"orderID"`
+	Side      string      `json:"side"`
+	Owner     string      `json:"owner"`
+	Price     money.Money `json:"price"`
+	Quantity  int         `json:"quantity"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// OrderCancelled is emitted when the owner cancels an order, with the units
+// that were not filled
+type OrderCancelled struct {
+	MarketID  string `json:"marketID"`
+	OrderID   string `json:"orderID"`
+	Remaining int    `json:"remaining"`
+}
+
+// TradeExecuted is emitted for every trade made when orders are matched
+type TradeExecuted struct {
+	MarketID    string      `json:"marketID"`
+	TradeID     string      `json:"tradeID"`
+	BuyOrderID  string      `json:"buyOrderID"`
+	SellOrderID string      `json:"sellOrderID"`
+	Price       money.Money `json:"price"`
+	Quantity    int         `json:"quantity"`
+}
+
+// BatchCleared is emitted when a batch of a call market is cleared, followed
+// by its trades
+type BatchCleared struct {
+	MarketID      string      `json:"marketID"`
+	Batch         int         `json:"batch"`
+	ClearingPrice money.Money `json:"clearingPrice"`
+	Volume        int         `json:"volume"`
+}
+
+// SessionCalled is emitted for every call of the auctioneer in a live session.
+// Type is "open", "ask", "bid", "going-once", "going-twice" or "sold".
+type SessionCalled struct {
+	AuctionID string      `json:"auctionID"`
+	Seq       int         `json:"seq"`
+	Type      string      `json:"type"`
+	Price     money.Money `json:"price"`
+	Paddle    string      `json:"paddle,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// SaleChanged is emitted when a sale is paused or resumed. Status is "paused"
+// or "open".
+type SaleChanged struct {
+	SaleID    string    `json:"saleID"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// PrivateAuctionChanged is emitted for private auctions instead of
+// AuctionCreated, and when the seller invites or revokes an organization.
+// Action is "created", "invited" or "revoked", Collection the private data
+// collection now holding the details. Events of a private auction that would
+// reveal bids or prices, such as BidSubmitted, NewLeader and AuctionEnded,
+// only carry its auction ID.
+type PrivateAuctionChanged struct {
+	AuctionID  string `json:"auctionID"`
+	Action     string `json:"action"`
+	Org        string `json:"org,omitempty"`
+	Collection string `json:"collection"`
+}
+
+// WinnerRevealed is emitted when an anonymous winner links their pseudonym to
+// an identity, with Status "pending", and when that identity confirms the
+// link and becomes the buyer, with Status "confirmed"
+type WinnerRevealed struct {
+	AuctionID string `json:"auctionID"`
+	Pseudonym string `json:"pseudonym"`
+	Buyer     string `json:"buyer"`
+	Status    string `json:"status"`
+}
+
+// SealedBidCommitted is emitted when a bidder commits to a sealed bid. The
+// amount stays hidden until the bid is opened and BidSubmitted is emitted.
+type SealedBidCommitted struct {
+	AuctionID string      `json:"auctionID"`
+	TxID      string      `json:"txID"`
+	Bidder    string      `json:"bidder"`
+	Escrow    money.Money `json:"escrow"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+func (AuctionCreated) EventType() string        { return "AuctionCreated" }
+func (BidPlaced) EventType() string             { return "BidPlaced" }
+func (BidSubmitted) EventType() string          { return "BidSubmitted" }
+func (NewLeader) EventType() string             { return "NewLeader" }
+func (DeadlineExtended) EventType() string      { return "DeadlineExtended" }
+func (AuctionEnded) EventType() string          { return "AuctionEnded" }
+func (AuctionCancelled) EventType() string      { return "AuctionCancelled" }
+func (BidRetracted) EventType() string          { return "BidRetracted" }
+func (OrgJoined) EventType() string             { return "OrgJoined" }
+func (BidInvalidated) EventType() string        { return "BidInvalidated" }
+func (SettlementChanged) EventType() string     { return "SettlementChanged" }
+func (UnitsAllocated) EventType() string        { return "UnitsAllocated" }
+func (LotsAwarded) EventType() string           { return "LotsAwarded" }
+func (ClockConfirmed) EventType() string        { return "ClockConfirmed" }
+func (ClockRoundClosed) EventType() string      { return "ClockRoundClosed" }
+func (OrderPlaced) EventType() string           { return "OrderPlaced" }
+func (OrderCancelled) EventType() string        { return "OrderCancelled" }
+func (TradeExecuted) EventType() string         { return "TradeExecuted" }
+func (BatchCleared) EventType() string          { return "BatchCleared" }
+func (SessionCalled) EventType() string         { return "SessionCalled" }
+func (SaleChanged) EventType() string           { return "SaleChanged" }
+func (PrivateAuctionChanged) EventType() string { return "PrivateAuctionChanged" }
+func (WinnerRevealed) EventType() string        { return "WinnerRevealed" }
+func (SealedBidCommitted) EventType() string    { return "SealedBidCommitted" }
+
+// newEvent returns an empty event of the given type to decode a payload into
+func newEvent(eventType string) (Event, error) {
+	switch eventType {
+	case "AuctionCreated":
+		return &AuctionCreated{}, nil
+	case "BidPlaced":
+		return &BidPlaced{}, nil
+	case "BidSubmitted":
+		return &BidSubmitted{}, nil
+	case "NewLeader":
+		return &NewLeader{}, nil
+	case "DeadlineExtended":
+		return &DeadlineExtended{}, nil
+	case "AuctionEnded":
+		return &AuctionEnded{}, nil
+	case "AuctionCancelled":
+		return &AuctionCancelled{}, nil
+	case "BidRetracted":
+		return &BidRetracted{}, nil
+	case "OrgJoined":
+		return &OrgJoined{}, nil
+	case "BidInvalidated":
+		return &BidInvalidated{}, nil
+	case "SettlementChanged":
+		return &SettlementChanged{}, nil
+	case "UnitsAllocated":
+		return &UnitsAllocated{}, nil
+	case "LotsAwarded":
+		return &LotsAwarded{}, nil
+	case "ClockConfirmed":
+		return &ClockConfirmed{}, nil
+	case "ClockRoundClosed":
+		return &ClockRoundClosed{}, nil
+	case "OrderPlaced":
+		return &OrderPlaced{}, nil
+	case "OrderCancelled":
+		return &OrderCancelled{}, nil
+	case "TradeExecuted":
+		return &TradeExecuted{}, nil
+	case "BatchCleared":
+		return &BatchCleared{}, nil
+	case "SessionCalled":
+		return &SessionCalled{}, nil
+	case "SaleChanged":
+		return &SaleChanged{}, nil
+	case "PrivateAuctionChanged":
+		return &PrivateAuctionChanged{}, nil
+	case "WinnerRevealed":
+		return &WinnerRevealed{}, nil
+	case "SealedBidCommitted":
+		return &SealedBidCommitted{}, nil
+	}
+	return nil, fmt.Errorf("unknown event type %v", eventType)
+}
+
+// Encode returns the chaincode event name and payload carrying the events
+// of a transaction
+func Encode(txID string, evts ...Event) (string, []byte, error) {
+	if len(evts) == 0 {
+		return "", nil, fmt.Errorf("no events to encode")
+	}
+
+	envelope := Envelope{Version: Version, TxID: txID, Events: []Record{}}
+	for _, evt := range evts {
+		payload, err := json.Marshal(evt)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to marshal %v event: %v", evt.EventType(), err)
+		}
+		envelope.Events = append(envelope.Events, Record{Type: evt.EventType(), Payload: payload})
+	}
+
+	envelopeJSON, err := json.Marshal(envelope)
+	if err != nil {
+		return "", nil, err
+	}
+	return evts[0].EventType(), envelopeJSON, nil
+}
+
+// Decode parses the payload of a chaincode event emitted by the auction chaincode
+func Decode(payload []byte) (*Envelope, error) {
+	var envelope Envelope
+	err := json.Unmarshal(payload, &envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event envelope: %v", err)
+	}
+	if envelope.Version != Version {
+		return nil, fmt.Errorf("unsupported event version %d, expected %d", envelope.Version, Version)
+	}
+	return &envelope, nil
+}
+
+// Decode returns the typed event held by the record, e.g. *NewLeader
+func (r Record) Decode() (Event, error) {
+	evt, err := newEvent(r.Type)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(r.Payload, evt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %v event: %v", r.Type, err)
+	}
+	return evt, nil
+}
@@ -0,0 +1,43 @@
+package events_test
+
+import (
+	"testing"
+	"time"
+
+	"bitAuction/events"
+	"bitAuction/money"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	timelimit := time.Date(2025, 6, 22, 12, 0, 0, 0, time.UTC)
+	name, payload, err := events.Encode("tx1",
+		events.BidSubmitted{AuctionID: "auction1", TxID: "tx1", Bidder: "userA", Org: "Org1MSP", Price: money.Money{Amount: 10000, Currency: "USD"}, Timestamp: timelimit},
+		events.DeadlineExtended{AuctionID: "auction1", Previous: timelimit, Timelimit: timelimit.Add(time.Minute), Reason: "soft-close"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "BidSubmitted", name)
+
+	envelope, err := events.Decode(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, events.Version, envelope.Version)
+	assert.Equal(t, "tx1", envelope.TxID)
+	assert.Len(t, envelope.Events, 2)
+
+	evt, err := envelope.Events[1].Decode()
+	assert.NoError(t, err)
+	extended, ok := evt.(*events.DeadlineExtended)
+	assert.True(t, ok)
+	assert.True(t, extended.Timelimit.Equal(timelimit.Add(time.Minute)))
+}
+
+func TestDecodeRejectsUnknownVersion(t *testing.T) {
+	_, err := events.Decode([]byte(`{"version":99,"events":[]}`))
+	assert.Error(t, err)
+}
+
+func TestDecodeUnknownType(t *testing.T) {
+	_, err := events.Record{Type: "Unknown", Payload: []byte(`{}`)}.Decode()
+	assert.Error(t, err)
+}
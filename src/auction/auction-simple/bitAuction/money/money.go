@@ -0,0 +1,247 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package money holds amounts as integer minor units of an ISO 4217 currency,
+// for example 1250 USD cents for 12.50 USD. Arithmetic refuses to overflow or
+// to mix currencies instead of silently producing a wrong amount.
+//
+// Prices written before amounts had a currency were plain integers of whole
+// units. They decode as that many whole units of DefaultCurrency.
+package money
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// DefaultCurrency is the currency of the channel, used for amounts given
+// without a currency and for prices stored before amounts had one
+const DefaultCurrency = "USD"
+
+// exponents holds the number of minor unit digits of the supported currencies
+var exponents = map[string]int{
+	"AUD": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CLP": 0, "CNY": 2,
+	"CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2, "INR": 2,
+	"ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3, "MXN": 2, "NOK": 2,
+	"NZD": 2, "OMR": 3, "PLN": 2, "SEK": 2, "SGD": 2, "TND": 3, "USD": 2,
+	"VND": 0, "ZAR": 2,
+}
+
+// Money is an amount of minor units of a currency
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Exponent returns the number of minor unit digits of a currency
+func Exponent(currency string) (int, error) {
+	exponent, ok := exponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("unknown currency %q", currency)
+	}
+	return exponent, nil
+}
+
+// New returns amount minor units of currency
+func New(amount int64, currency string) (Money, error) {
+	if _, err := Exponent(currency); err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// Zero returns no money of currency
+func Zero(currency string) Money {
+	return Money{Currency: currency}
+}
+
+// FromUnits returns units whole units of currency, for example 12 USD as 1200 cents
+func FromUnits(units int64, currency string) (Money, error) {
+	exponent, err := Exponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	amount := units
+	for i := 0; i < exponent; i++ {
+		if amount > math.MaxInt64/10 || amount < math.MinInt64/10 {
+			return Money{}, fmt.Errorf("amount %d %s overflows", units, currency)
+		}
+		amount *= 10
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// Parse reads an amount such as "12.50 EUR". Without a currency code the
+// amount is in currency. The amount must be a non-negative decimal number
+// with at most as many fraction digits as the currency has minor units.
+func Parse(s string, currency string) (Money, error) {
+	number := s
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		number, currency = s[:i], s[i+1:]
+	}
+	exponent, err := Exponent(currency)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %v", s, err)
+	}
+
+	whole, fraction := number, ""
+	if i := strings.IndexByte(number, '.'); i >= 0 {
+		whole, fraction = number[:i], number[i+1:]
+		if fraction == "" {
+			return Money{}, fmt.Errorf("invalid amount %q: missing fraction digits", s)
+		}
+	}
+	if whole == "" {
+		return Money{}, fmt.Errorf("invalid amount %q: missing whole units", s)
+	}
+	if len(fraction) > exponent {
+		return Money{}, fmt.Errorf("invalid amount %q: %s has %d decimals", s, currency, exponent)
+	}
+	digits := whole + fraction + strings.Repeat("0", exponent-len(fraction))
+
+	var amount int64
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return Money{}, fmt.Errorf("invalid amount %q: unexpected character %q", s, c)
+		}
+		if amount > (math.MaxInt64-int64(c-'0'))/10 {
+			return Money{}, fmt.Errorf("invalid amount %q: overflows", s)
+		}
+		amount = amount*10 + int64(c-'0')
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// String formats the amount as Parse reads it, for example "12.50 EUR"
+func (m Money) String() string {
+	exponent, err := Exponent(m.Currency)
+	if err != nil || exponent == 0 {
+		return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+	}
+	sign, amount := "", uint64(m.Amount)
+	if m.Amount < 0 {
+		sign, amount = "-", uint64(-(m.Amount+1))+1
+	}
+	digits := fmt.Sprintf("%0*d", exponent+1, amount)
+	split := len(digits) - exponent
+	return fmt.Sprintf("%s%s.%s %s", sign, digits[:split], digits[split:], m.Currency)
+}
+
+// Add returns m + o
+func (m Money) Add(o Money) (Money, error) {
+	if err := m.checkCurrency(o); err != nil {
+		return Money{}, err
+	}
+	if (o.Amount > 0 && m.Amount > math.MaxInt64-o.Amount) || (o.Amount < 0 && m.Amount < math.MinInt64-o.Amount) {
+		return Money{}, fmt.Errorf("%v + %v overflows", m, o)
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m - o
+func (m Money) Sub(o Money) (Money, error) {
+	if err := m.checkCurrency(o); err != nil {
+		return Money{}, err
+	}
+	if (o.Amount < 0 && m.Amount > math.MaxInt64+o.Amount) || (o.Amount > 0 && m.Amount < math.MinInt64+o.Amount) {
+		return Money{}, fmt.Errorf("%v - %v overflows", m, o)
+	}
+	return Money{Amount: m.Amount - o.Amount, Currency: m.Currency}, nil
+}
+
+// Neg returns -m
+func (m Money) Neg() (Money, error) {
+	if m.Amount == math.MinInt64 {
+		return Money{}, fmt.Errorf("-%v overflows", m)
+	}
+	return Money{Amount: -m.Amount, Currency: m.Currency}, nil
+}
+
+// Percent returns percent percent of m, rounded towards zero
+func (m Money) Percent(percent int64) (Money, error) {
+	if percent != 0 && (m.Amount > math.MaxInt64/abs(percent) || m.Amount < math.MinInt64/abs(percent)) {
+		return Money{}, fmt.Errorf("%d%% of %v overflows", percent, m)
+	}
+	return Money{Amount: m.Amount * percent / 100, Currency: m.Currency}, nil
+}
+
+// Times returns n times m
+func (m Money) Times(n int64) (Money, error) {
+	if n != 0 && (m.Amount > math.MaxInt64/abs(n) || m.Amount < math.MinInt64/abs(n)) {
+		return Money{}, fmt.Errorf("%d times %v overflows", n, m)
+	}
+	return Money{Amount: m.Amount * n, Currency: m.Currency}, nil
+}
+
+// BasisPoints returns basisPoints hundredths of a percent of m, rounded
+// towards zero
+func (m Money) BasisPoints(basisPoints int64) (Money, error) {
+	if basisPoints != 0 && (m.Amount > math.MaxInt64/abs(basisPoints) || m.Amount < math.MinInt64/abs(basisPoints)) {
+		return Money{}, fmt.Errorf("%d basis points of %v overflows", basisPoints, m)
+	}
+	return Money{Amount: m.Amount * basisPoints / 10000, Currency: m.Currency}, nil
+}
+
+// Cmp compares the amounts of m and o and returns -1, 0 or +1, or an error
+// when they are in different currencies
+func (m Money) Cmp(o Money) (int, error) {
+	if err := m.checkCurrency(o); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// IsPositive reports whether m is more than nothing
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// IsNegative reports whether m is less than nothing
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// UnmarshalJSON decodes an amount, or a plain integer stored before amounts
+// had a currency as whole units of DefaultCurrency
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var units int64
+	if err := json.Unmarshal(data, &units); err == nil {
+		legacy, err := FromUnits(units, DefaultCurrency)
+		if err != nil {
+			return err
+		}
+		*m = legacy
+		return nil
+	}
+
+	type plain Money
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*m = Money(p)
+	return nil
+}
+
+func (m Money) checkCurrency(o Money) error {
+	if m.Currency != o.Currency {
+		return fmt.Errorf("cannot combine %s with %s", m.Currency, o.Currency)
+	}
+	return nil
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
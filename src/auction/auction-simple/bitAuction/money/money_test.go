@@ -0,0 +1,96 @@
+package money_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"bitAuction/money"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	for input, expected := range map[string]money.Money{
+		"12.50 EUR": {Amount: 1250, Currency: "EUR"},
+		"12.5":      {Amount: 1250, Currency: "USD"},
+		"12":        {Amount: 1200, Currency: "USD"},
+		"0.01":      {Amount: 1, Currency: "USD"},
+		"1500 JPY":  {Amount: 1500, Currency: "JPY"},
+		"1.234 KWD": {Amount: 1234, Currency: "KWD"},
+	} {
+		m, err := money.Parse(input, "USD")
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, m, input)
+	}
+
+	for _, input := range []string{
+		"", "EUR", "12.", ".5", "-1", "+1", "1e3", "1,000", "12.345",
+		"12.5 JPY", "12 eur", "12 XXX", "12  EUR", "12 EUR ", "9223372036854775808",
+		"92233720368547758.08",
+	} {
+		_, err := money.Parse(input, "USD")
+		assert.Error(t, err, input)
+	}
+}
+
+func TestString(t *testing.T) {
+	assert.Equal(t, "12.50 EUR", money.Money{Amount: 1250, Currency: "EUR"}.String())
+	assert.Equal(t, "0.05 USD", money.Money{Amount: 5, Currency: "USD"}.String())
+	assert.Equal(t, "-1.234 KWD", money.Money{Amount: -1234, Currency: "KWD"}.String())
+	assert.Equal(t, "1500 JPY", money.Money{Amount: 1500, Currency: "JPY"}.String())
+}
+
+func TestArithmetic(t *testing.T) {
+	a := money.Money{Amount: 1000, Currency: "EUR"}
+	b := money.Money{Amount: 250, Currency: "EUR"}
+
+	sum, err := a.Add(b)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1250), sum.Amount)
+	diff, err := b.Sub(a)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-750), diff.Amount)
+	fee, err := a.Percent(3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(30), fee.Amount)
+	total, err := b.Times(3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(750), total.Amount)
+	commission, err := a.BasisPoints(250)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(25), commission.Amount)
+	cmp, err := a.Cmp(b)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cmp)
+	_, err = a.Cmp(money.Money{Amount: 1, Currency: "USD"})
+	assert.Error(t, err)
+
+	_, err = a.Add(money.Money{Amount: 1, Currency: "USD"})
+	assert.Error(t, err)
+	_, err = money.Money{Amount: math.MaxInt64, Currency: "EUR"}.Add(b)
+	assert.Error(t, err)
+	_, err = money.Money{Amount: math.MinInt64, Currency: "EUR"}.Sub(b)
+	assert.Error(t, err)
+	_, err = money.Money{Amount: math.MinInt64, Currency: "EUR"}.Neg()
+	assert.Error(t, err)
+	_, err = money.Money{Amount: math.MaxInt64 / 2, Currency: "EUR"}.Percent(3)
+	assert.Error(t, err)
+	_, err = money.Money{Amount: math.MaxInt64 / 2, Currency: "EUR"}.Times(3)
+	assert.Error(t, err)
+	_, err = money.FromUnits(math.MaxInt64/10, "USD")
+	assert.Error(t, err)
+}
+
+func TestUnmarshalLegacyInteger(t *testing.T) {
+	var legacy struct {
+		Price money.Money `json:"price"`
+	}
+	err := json.Unmarshal([]byte(`{"price":300}`), &legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, money.Money{Amount: 30000, Currency: money.DefaultCurrency}, legacy.Price)
+
+	err = json.Unmarshal([]byte(`{"price":{"amount":1250,"currency":"EUR"}}`), &legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, money.Money{Amount: 1250, Currency: "EUR"}, legacy.Price)
+}
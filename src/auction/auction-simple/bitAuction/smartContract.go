@@ -5,14 +5,35 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"os"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"bitAuction/auction"
 )
 
 func main() {
-	auctionSmartContract, err := contractapi.NewChaincode(&auction.SmartContract{})
+	// "collections <org>..." prints the collections configuration of
+	// private auctions between the organizations instead of starting
+	if len(os.Args) > 1 && os.Args[1] == "collections" {
+		config, err := auction.CollectionConfig(os.Args[2:])
+		if err != nil {
+			log.Fatalf("Error generating collections configuration: %v", err)
+		}
+		configJSON, err := json.MarshalIndent(config, "", "  ")
+		if err != nil {
+			log.Fatalf("Error generating collections configuration: %v", err)
+		}
+		fmt.Println(string(configJSON))
+		return
+	}
+
+	orderBook := &auction.OrderBook{}
+	orderBook.Name = "orderbook"
+
+	auctionSmartContract, err := contractapi.NewChaincode(&auction.SmartContract{}, orderBook)
 	if err != nil {
 		log.Panicf("Error creating auction chaincode: %v", err)
 	}
@@ -0,0 +1,418 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package zkp commits to bid amounts with Pedersen commitments on the P-256
+// curve and proves in zero knowledge that a committed amount lies in a range.
+// Bidders use it to seal their bids, the auction chaincode to verify them.
+//
+// A commitment to v with blinding r is C = vG + rH, where G is the base point
+// of the curve and H a second generator derived by hashing to the curve, so
+// that nobody knows its discrete logarithm. C reveals nothing about v, and
+// opening it to another amount would take that discrete logarithm.
+//
+// A range proof shows min <= v <= max. It commits to every bit of v - min and
+// of max - v and proves of every bit commitment that it commits to 0 or 1
+// with a disjunctive Schnorr proof, made non-interactive by hashing the
+// statement and a context the verifier knows as well, such as the auction and
+// the bidder. The verifier checks that the bit commitments add up to C - minG
+// and maxG - C, so both differences are sums of RangeBits bits.
+package zkp
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// RangeBits is the number of bits of the differences a range proof covers.
+// Amounts are int64 minor units, whose differences fit in 63 bits.
+const RangeBits = 63
+
+const domain = "bitAuction/zkp/v1"
+
+var (
+	curve = elliptic.P256()
+	order = curve.Params().N
+	h     = hashToCurve([]byte(domain + "/H"))
+)
+
+// Opening is what the committer keeps secret until the commitment is opened
+type Opening struct {
+	Value    int64  `json:"value"`
+	Blinding []byte `json:"blinding"`
+}
+
+// RangeProof proves that a commitment holds an amount between a minimum and
+// a maximum. Lower proves the bits of the amount less the minimum, Upper the
+// bits of the maximum less the amount.
+type RangeProof struct {
+	Lower []*BitProof `json:"lower"`
+	Upper []*BitProof `json:"upper"`
+}
+
+// BitProof is a commitment to a bit with the proof that it commits to 0 or 1
+type BitProof struct {
+	Commitment []byte `json:"commitment"`
+	E0         []byte `json:"e0"`
+	E1         []byte `json:"e1"`
+	S0         []byte `json:"s0"`
+	S1         []byte `json:"s1"`
+}
+
+type point struct {
+	x, y *big.Int
+}
+
+// BidContext is the context that binds the proof of a sealed bid to the
+// auction and the bidder, so that it cannot be replayed by someone else
+func BidContext(auctionID string, bidder string) []byte {
+	return []byte(fmt.Sprintf("sealed-bid|%d:%s|%d:%s", len(auctionID), auctionID, len(bidder), bidder))
+}
+
+// Commit commits to value with a random blinding and returns the commitment
+// with the opening to keep secret
+func Commit(value int64) ([]byte, *Opening, error) {
+	if value < 0 {
+		return nil, nil, fmt.Errorf("cannot commit to negative amount %d", value)
+	}
+	r, err := randomScalar()
+	if err != nil {
+		return nil, nil, err
+	}
+	opening := &Opening{Value: value, Blinding: scalarBytes(r)}
+	commitment, err := opening.Commitment()
+	if err != nil {
+		return nil, nil, err
+	}
+	return commitment, opening, nil
+}
+
+// Commitment returns the commitment the opening opens
+func (o *Opening) Commitment() ([]byte, error) {
+	r, err := parseScalar(o.Blinding)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blinding: %v", err)
+	}
+	c := add(mulG(big.NewInt(o.Value)), mul(h, r))
+	if isInfinity(c) {
+		return nil, fmt.Errorf("degenerate commitment")
+	}
+	return elliptic.MarshalCompressed(curve, c.x, c.y), nil
+}
+
+// VerifyOpening checks that the opening opens the commitment
+func VerifyOpening(commitment []byte, o *Opening) error {
+	if o.Value < 0 {
+		return fmt.Errorf("commitments hold non-negative amounts")
+	}
+	expected, err := o.Commitment()
+	if err != nil {
+		return err
+	}
+	if string(expected) != string(commitment) {
+		return fmt.Errorf("opening does not match the commitment")
+	}
+	return nil
+}
+
+// ProveRange proves that the amount of the opening lies between min and max
+func ProveRange(o *Opening, min int64, max int64, context []byte) (*RangeProof, error) {
+	if min < 0 || o.Value < min || o.Value > max {
+		return nil, fmt.Errorf("amount %d is not between %d and %d", o.Value, min, max)
+	}
+	commitment, err := o.Commitment()
+	if err != nil {
+		return nil, err
+	}
+	r, err := parseScalar(o.Blinding)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blinding: %v", err)
+	}
+	statement := rangeStatement(commitment, min, max, context)
+
+	lower, err := proveBits(uint64(o.Value-min), r, statement, "lower")
+	if err != nil {
+		return nil, err
+	}
+	// maxG - C commits to max - v with the negated blinding
+	upper, err := proveBits(uint64(max-o.Value), new(big.Int).Sub(order, r), statement, "upper")
+	if err != nil {
+		return nil, err
+	}
+	return &RangeProof{Lower: lower, Upper: upper}, nil
+}
+
+// VerifyRange checks that the commitment holds an amount between min and max
+func VerifyRange(commitment []byte, min int64, max int64, proof *RangeProof, context []byte) error {
+	if min < 0 || min > max {
+		return fmt.Errorf("invalid range %d to %d", min, max)
+	}
+	if proof == nil {
+		return fmt.Errorf("missing range proof")
+	}
+	c, err := parsePoint(commitment)
+	if err != nil {
+		return fmt.Errorf("invalid commitment: %v", err)
+	}
+	statement := rangeStatement(commitment, min, max, context)
+
+	lowerTarget := add(c, neg(mulG(big.NewInt(min))))
+	if err = verifyBits(lowerTarget, proof.Lower, statement, "lower"); err != nil {
+		return fmt.Errorf("amount is below %d: %v", min, err)
+	}
+	upperTarget := add(mulG(big.NewInt(max)), neg(c))
+	if err = verifyBits(upperTarget, proof.Upper, statement, "upper"); err != nil {
+		return fmt.Errorf("amount is above %d: %v", max, err)
+	}
+	return nil
+}
+
+// proveBits commits to the bits of d with blindings adding up to r, weighted
+// by the bit values, and proves every bit commitment
+func proveBits(d uint64, r *big.Int, statement []byte, label string) ([]*BitProof, error) {
+	proofs := []*BitProof{}
+	sum := new(big.Int)
+	for i := 0; i < RangeBits; i++ {
+		weight := new(big.Int).Lsh(big.NewInt(1), uint(i))
+		var ri *big.Int
+		if i < RangeBits-1 {
+			var err error
+			if ri, err = randomScalar(); err != nil {
+				return nil, err
+			}
+			sum.Add(sum, new(big.Int).Mul(weight, ri))
+		} else {
+			// the last blinding makes the weighted blindings add up to r
+			ri = new(big.Int).Sub(r, sum)
+			ri.Mul(ri, new(big.Int).ModInverse(weight, order))
+			ri.Mod(ri, order)
+		}
+		bit := int64((d >> uint(i)) & 1)
+		proof, err := proveBit(bit, ri, statement, label, i)
+		if err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, proof)
+	}
+	return proofs, nil
+}
+
+// verifyBits checks the proofs of the bit commitments and that they add up
+// to the target, weighted by the bit values
+func verifyBits(target point, proofs []*BitProof, statement []byte, label string) error {
+	if len(proofs) != RangeBits {
+		return fmt.Errorf("expected %d bit proofs, got %d", RangeBits, len(proofs))
+	}
+	sum := point{new(big.Int), new(big.Int)}
+	for i, proof := range proofs {
+		if proof == nil {
+			return fmt.Errorf("missing proof of bit %d", i)
+		}
+		b, err := parsePoint(proof.Commitment)
+		if err != nil {
+			return fmt.Errorf("invalid commitment to bit %d: %v", i, err)
+		}
+		if err = verifyBit(b, proof, statement, label, i); err != nil {
+			return err
+		}
+		sum = add(sum, mul(b, new(big.Int).Lsh(big.NewInt(1), uint(i))))
+	}
+	if sum.x.Cmp(target.x) != 0 || sum.y.Cmp(target.y) != 0 {
+		return fmt.Errorf("bit commitments do not add up to the commitment")
+	}
+	return nil
+}
+
+// proveBit commits to bit with blinding r and proves that the commitment B
+// is rH or G + rH without telling which. The branch that does not hold is
+// simulated with a chosen challenge.
+func proveBit(bit int64, r *big.Int, statement []byte, label string, index int) (*BitProof, error) {
+	b := add(mulG(big.NewInt(bit)), mul(h, r))
+	if isInfinity(b) {
+		return nil, fmt.Errorf("degenerate bit commitment")
+	}
+	targets := bitTargets(b)
+	known, simulated := int(bit), 1-int(bit)
+
+	e := make([]*big.Int, 2)
+	s := make([]*big.Int, 2)
+	a := make([]point, 2)
+	var err error
+	if e[simulated], err = randomScalar(); err != nil {
+		return nil, err
+	}
+	if s[simulated], err = randomScalar(); err != nil {
+		return nil, err
+	}
+	a[simulated] = add(mul(h, s[simulated]), neg(mul(targets[simulated], e[simulated])))
+	k, err := randomScalar()
+	if err != nil {
+		return nil, err
+	}
+	a[known] = mul(h, k)
+
+	challenge := bitChallenge(statement, label, index, b, a[0], a[1])
+	e[known] = new(big.Int).Sub(challenge, e[simulated])
+	e[known].Mod(e[known], order)
+	s[known] = new(big.Int).Mul(e[known], r)
+	s[known].Add(s[known], k)
+	s[known].Mod(s[known], order)
+
+	return &BitProof{
+		Commitment: elliptic.MarshalCompressed(curve, b.x, b.y),
+		E0:         scalarBytes(e[0]),
+		E1:         scalarBytes(e[1]),
+		S0:         scalarBytes(s[0]),
+		S1:         scalarBytes(s[1]),
+	}, nil
+}
+
+// verifyBit checks the proof that the bit commitment b commits to 0 or 1
+func verifyBit(b point, proof *BitProof, statement []byte, label string, index int) error {
+	scalars := []*big.Int{}
+	for _, encoded := range [][]byte{proof.E0, proof.E1, proof.S0, proof.S1} {
+		scalar, err := parseScalar(encoded)
+		if err != nil {
+			return fmt.Errorf("invalid proof of bit %d: %v", index, err)
+		}
+		scalars = append(scalars, scalar)
+	}
+	e0, e1, s0, s1 := scalars[0], scalars[1], scalars[2], scalars[3]
+	targets := bitTargets(b)
+	a0 := add(mul(h, s0), neg(mul(targets[0], e0)))
+	a1 := add(mul(h, s1), neg(mul(targets[1], e1)))
+
+	challenge := bitChallenge(statement, label, index, b, a0, a1)
+	sum := new(big.Int).Add(e0, e1)
+	sum.Mod(sum, order)
+	if sum.Cmp(challenge) != 0 {
+		return fmt.Errorf("proof of bit %d does not hold", index)
+	}
+	return nil
+}
+
+// bitTargets returns the points whose discrete logarithm to H the prover
+// knows when the bit is 0 and when it is 1
+func bitTargets(b point) []point {
+	return []point{b, add(b, neg(mulG(big.NewInt(1))))}
+}
+
+// rangeStatement is hashed into every challenge of a range proof so that the
+// proof only holds for its commitment, range and context
+func rangeStatement(commitment []byte, min int64, max int64, context []byte) []byte {
+	statement := []byte(domain + "/range")
+	statement = appendField(statement, commitment)
+	statement = binary.BigEndian.AppendUint64(statement, uint64(min))
+	statement = binary.BigEndian.AppendUint64(statement, uint64(max))
+	return appendField(statement, context)
+}
+
+func bitChallenge(statement []byte, label string, index int, b point, a0 point, a1 point) *big.Int {
+	transcript := appendField(nil, statement)
+	transcript = appendField(transcript, []byte(label))
+	transcript = binary.BigEndian.AppendUint32(transcript, uint32(index))
+	for _, p := range []point{b, a0, a1} {
+		transcript = appendField(transcript, elliptic.Marshal(curve, p.x, p.y))
+	}
+	digest := sha256.Sum256(transcript)
+	return new(big.Int).Mod(new(big.Int).SetBytes(digest[:]), order)
+}
+
+// appendField appends a length-prefixed field so that fields cannot run into each other
+func appendField(buf []byte, field []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(field)))
+	return append(buf, field...)
+}
+
+// hashToCurve derives a point from seed by trying counters until the hash is
+// the x coordinate of a point, taking the point with the even y coordinate
+func hashToCurve(seed []byte) point {
+	params := curve.Params()
+	three := big.NewInt(3)
+	for counter := uint32(0); ; counter++ {
+		digest := sha256.Sum256(binary.BigEndian.AppendUint32(append([]byte{}, seed...), counter))
+		x := new(big.Int).SetBytes(digest[:])
+		if x.Cmp(params.P) >= 0 {
+			continue
+		}
+		// y² = x³ - 3x + b
+		y2 := new(big.Int).Exp(x, three, params.P)
+		y2.Sub(y2, new(big.Int).Mul(three, x))
+		y2.Add(y2, params.B)
+		y2.Mod(y2, params.P)
+		y := new(big.Int).ModSqrt(y2, params.P)
+		if y == nil {
+			continue
+		}
+		if y.Bit(0) == 1 {
+			y.Sub(params.P, y)
+		}
+		return point{x, y}
+	}
+}
+
+func mulG(k *big.Int) point {
+	x, y := curve.ScalarBaseMult(scalarBytes(new(big.Int).Mod(k, order)))
+	return point{x, y}
+}
+
+func mul(p point, k *big.Int) point {
+	if isInfinity(p) {
+		return p
+	}
+	x, y := curve.ScalarMult(p.x, p.y, scalarBytes(new(big.Int).Mod(k, order)))
+	return point{x, y}
+}
+
+func add(p point, q point) point {
+	x, y := curve.Add(p.x, p.y, q.x, q.y)
+	return point{x, y}
+}
+
+func neg(p point) point {
+	if isInfinity(p) {
+		return p
+	}
+	return point{p.x, new(big.Int).Sub(curve.Params().P, p.y)}
+}
+
+// isInfinity tells whether p is the point at infinity, which the elliptic
+// package represents as (0, 0)
+func isInfinity(p point) bool {
+	return p.x.Sign() == 0 && p.y.Sign() == 0
+}
+
+func parsePoint(encoded []byte) (point, error) {
+	x, y := elliptic.UnmarshalCompressed(curve, encoded)
+	if x == nil {
+		return point{}, fmt.Errorf("not a compressed P-256 point")
+	}
+	return point{x, y}, nil
+}
+
+func randomScalar() (*big.Int, error) {
+	k, err := rand.Int(rand.Reader, order)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate randomness: %v", err)
+	}
+	return k, nil
+}
+
+func scalarBytes(k *big.Int) []byte {
+	return k.FillBytes(make([]byte, 32))
+}
+
+func parseScalar(encoded []byte) (*big.Int, error) {
+	if len(encoded) != 32 {
+		return nil, fmt.Errorf("scalars are 32 bytes, not %d", len(encoded))
+	}
+	k := new(big.Int).SetBytes(encoded)
+	if k.Cmp(order) >= 0 {
+		return nil, fmt.Errorf("scalar out of range")
+	}
+	return k, nil
+}
@@ -0,0 +1,80 @@
+package zkp_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"bitAuction/zkp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitAndOpen(t *testing.T) {
+	commitment, opening, err := zkp.Commit(1250)
+	assert.NoError(t, err)
+	assert.Len(t, commitment, 33)
+	assert.NoError(t, zkp.VerifyOpening(commitment, opening))
+
+	// the same amount commits differently every time
+	other, _, err := zkp.Commit(1250)
+	assert.NoError(t, err)
+	assert.NotEqual(t, commitment, other)
+
+	wrong := *opening
+	wrong.Value = 1251
+	assert.Error(t, zkp.VerifyOpening(commitment, &wrong))
+	_, _, err = zkp.Commit(-1)
+	assert.Error(t, err)
+}
+
+func TestRangeProof(t *testing.T) {
+	context := zkp.BidContext("auction1", "bidder1")
+	commitment, opening, err := zkp.Commit(1250)
+	assert.NoError(t, err)
+	proof, err := zkp.ProveRange(opening, 1000, 5000, context)
+	assert.NoError(t, err)
+	assert.NoError(t, zkp.VerifyRange(commitment, 1000, 5000, proof, context))
+
+	// the proof survives the round trip through JSON
+	proofJSON, err := json.Marshal(proof)
+	assert.NoError(t, err)
+	var decoded zkp.RangeProof
+	assert.NoError(t, json.Unmarshal(proofJSON, &decoded))
+	assert.NoError(t, zkp.VerifyRange(commitment, 1000, 5000, &decoded, context))
+
+	// it only holds for its commitment, range and context
+	other, _, _ := zkp.Commit(1250)
+	assert.Error(t, zkp.VerifyRange(other, 1000, 5000, proof, context))
+	assert.Error(t, zkp.VerifyRange(commitment, 1001, 5000, proof, context))
+	assert.Error(t, zkp.VerifyRange(commitment, 1000, 4999, proof, context))
+	assert.Error(t, zkp.VerifyRange(commitment, 1000, 5000, proof, zkp.BidContext("auction1", "bidder2")))
+
+	tampered := decoded
+	tampered.Lower = append([]*zkp.BitProof{}, decoded.Lower...)
+	tampered.Lower[0], tampered.Lower[1] = tampered.Lower[1], tampered.Lower[0]
+	assert.Error(t, zkp.VerifyRange(commitment, 1000, 5000, &tampered, context))
+	tampered.Lower = decoded.Lower[1:]
+	assert.Error(t, zkp.VerifyRange(commitment, 1000, 5000, &tampered, context))
+}
+
+func TestRangeBounds(t *testing.T) {
+	context := zkp.BidContext("auction1", "bidder1")
+	for _, value := range []int64{1000, 5000} {
+		commitment, opening, err := zkp.Commit(value)
+		assert.NoError(t, err)
+		proof, err := zkp.ProveRange(opening, 1000, 5000, context)
+		assert.NoError(t, err)
+		assert.NoError(t, zkp.VerifyRange(commitment, 1000, 5000, proof, context))
+	}
+
+	// amounts outside the range cannot be proven, not even by forcing the prover
+	_, opening, err := zkp.Commit(999)
+	assert.NoError(t, err)
+	_, err = zkp.ProveRange(opening, 1000, 5000, context)
+	assert.Error(t, err)
+	commitment, opening, err := zkp.Commit(999)
+	assert.NoError(t, err)
+	proof, err := zkp.ProveRange(opening, 0, 5000, context)
+	assert.NoError(t, err)
+	assert.Error(t, zkp.VerifyRange(commitment, 1000, 5000, proof, context))
+}
@@ -14,6 +14,14 @@ do
 	org=$(($org+1))
 done
 
-./network.sh deployCC -ccn auction -ccp ../auction/auction-simple/bitAuction/ -ccl go -ccep "OR(${local_MSP})"
+# private auctions keep their details in a collection per set of organizations
+orgs=""
+for org in $(seq 1 $ORGS)
+do
+	orgs="$orgs Org${org}MSP"
+done
+(cd ../auction/auction-simple/bitAuction && go run . collections $orgs) > collections_config.json
+
+./network.sh deployCC -ccn auction -ccp ../auction/auction-simple/bitAuction/ -ccl go -ccep "OR(${local_MSP})" -cccg ./collections_config.json
 
 ./network.sh deployCC -ccn timeoracle -ccp ../timeoracle -ccl go -ccep "OR(${local_MSP})"
\ No newline at end of file